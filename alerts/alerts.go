@@ -0,0 +1,303 @@
+// Package alerts tells users about new pets that match their saved searches.
+//
+// A Matcher periodically looks for pets listed since each saved search was
+// last run and hands a Digest of them to a Notifier. MailNotifier emails the
+// digest to the user and WebhookNotifier posts it as JSON to a URL. Every
+// digest carries a signed link that unsubscribes from the search in one click.
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/notification"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// Digest are the new pets that match a saved search of a user.
+type Digest struct {
+	User   *petfind.User
+	Search *petfind.SavedSearch
+	Pets   []*petfind.Pet
+	// SearchURL runs the search on the site.
+	SearchURL string
+	// UnsubscribeURL deletes the saved search without logging in.
+	UnsubscribeURL string
+}
+
+// Notifier delivers digests to users.
+type Notifier interface {
+	Notify(*Digest) error
+}
+
+// Notifiers delivers every digest through all of its notifiers. It stops at
+// the first one that fails.
+type Notifiers []Notifier
+
+// Notify delivers the digest through all the notifiers.
+func (ns Notifiers) Notify(d *Digest) error {
+	for _, n := range ns {
+		if err := n.Notify(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MailNotifier emails digests to the users, rendered with the "alert" email
+// template.
+type MailNotifier struct {
+	Sender    mail.Sender
+	Templates *notification.Templates
+}
+
+// Notify emails the digest to the user. Users without an email address, such
+// as some that logged in with a provider, are skipped.
+func (n *MailNotifier) Notify(d *Digest) error {
+	if d.User.Email == "" {
+		return nil
+	}
+	m, err := n.Templates.Render("alert", d.User.Email, d)
+	if err != nil {
+		return fmt.Errorf("error rendering alert email: %v", err)
+	}
+	return n.Sender.Send(m)
+}
+
+// WebhookNotifier posts digests as JSON to a URL, e.g. to forward them to a
+// chat service.
+type WebhookNotifier struct {
+	URL string
+	// Client is used to post the digests. If nil, a client with a 10 second
+	// timeout is used.
+	Client *http.Client
+}
+
+// webhookPayload is what WebhookNotifier posts. It leaves out the contact
+// details of the pets and the owners which only signed in users should see.
+type webhookPayload struct {
+	UserID         int64        `json:"user_id"`
+	SearchID       int64        `json:"search_id"`
+	Search         string       `json:"search"`
+	SearchURL      string       `json:"search_url"`
+	UnsubscribeURL string       `json:"unsubscribe_url"`
+	Pets           []webhookPet `json:"pets"`
+	Sent           time.Time    `json:"sent"`
+}
+
+type webhookPet struct {
+	ID     int64     `json:"id"`
+	Name   string    `json:"name"`
+	Age    string    `json:"age"`
+	Gender string    `json:"gender"`
+	Size   string    `json:"size"`
+	Type   string    `json:"type"`
+	Place  string    `json:"place"`
+	Added  time.Time `json:"added"`
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notify posts the digest to the webhook's URL. Any response status other
+// than 2xx is an error.
+func (n *WebhookNotifier) Notify(d *Digest) error {
+	payload := webhookPayload{
+		UserID:         d.User.ID,
+		SearchID:       d.Search.ID,
+		Search:         d.Search.Name,
+		SearchURL:      d.SearchURL,
+		UnsubscribeURL: d.UnsubscribeURL,
+		Pets:           make([]webhookPet, 0, len(d.Pets)),
+		Sent:           time.Now().UTC(),
+	}
+	for _, p := range d.Pets {
+		wp := webhookPet{
+			ID:     p.ID,
+			Name:   p.Name,
+			Age:    p.Age.String(),
+			Gender: p.Gender.String(),
+			Size:   p.Size.String(),
+			Type:   p.Type.String(),
+			Added:  p.Created.UTC(),
+		}
+		if p.Place != nil {
+			wp.Place = p.Place.Name
+		}
+		payload.Pets = append(payload.Pets, wp)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %v", err)
+	}
+	client := n.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error posting to webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// unsubscribeName is the securecookie name of unsubscribe tokens so that
+// other values signed with the same key cannot be used as one.
+const unsubscribeName = "unsubscribe"
+
+// unsubscribe is what an unsubscribe token carries.
+type unsubscribe struct {
+	SearchID int64
+	UserID   int64
+}
+
+// Links builds the links put in digests.
+type Links struct {
+	siteURL string
+	codec   *securecookie.SecureCookie
+}
+
+// NewLinks returns the links of the site at siteURL, e.g.
+// https://petfind.example.com. hashKey signs the unsubscribe tokens and must
+// be the same the web server uses to check them.
+func NewLinks(hashKey []byte, siteURL string) *Links {
+	// Unsubscribe links are sent by email and must keep working for as long
+	// as the search exists.
+	codec := securecookie.New(hashKey, nil).MaxAge(0)
+	return &Links{siteURL: strings.TrimRight(siteURL, "/"), codec: codec}
+}
+
+// UnsubscribeToken returns the signed token that deletes a saved search.
+func (l *Links) UnsubscribeToken(ss *petfind.SavedSearch) (string, error) {
+	return l.codec.Encode(unsubscribeName, &unsubscribe{SearchID: ss.ID, UserID: ss.UserID})
+}
+
+// ParseUnsubscribe checks the signature of an unsubscribe token and returns
+// the saved search it is for.
+func (l *Links) ParseUnsubscribe(token string) (searchID, userID int64, err error) {
+	var u unsubscribe
+	if err := l.codec.Decode(unsubscribeName, token, &u); err != nil {
+		return 0, 0, err
+	}
+	return u.SearchID, u.UserID, nil
+}
+
+// Unsubscribe returns the link that deletes a saved search.
+func (l *Links) Unsubscribe(ss *petfind.SavedSearch) (string, error) {
+	token, err := l.UnsubscribeToken(ss)
+	if err != nil {
+		return "", err
+	}
+	return l.siteURL + "/searches/unsubscribe?t=" + url.QueryEscape(token), nil
+}
+
+// SearchPath returns the path and query that run a search on the site.
+func SearchPath(s petfind.Search) string {
+	v := url.Values{}
+	v.Set("place", s.PlaceKey)
+	if s.UseType {
+		v.Set("type", strconv.FormatInt(int64(s.Type), 10))
+	}
+	if s.UseAge {
+		v.Set("age", strconv.FormatInt(int64(s.Age), 10))
+	}
+	if s.UseSize {
+		v.Set("size", strconv.FormatInt(int64(s.Size), 10))
+	}
+	if s.UseGender {
+		v.Set("gender", strconv.FormatInt(int64(s.Gender), 10))
+	}
+	return "/search/submit?" + v.Encode()
+}
+
+// Search returns the link that runs a search on the site.
+func (l *Links) Search(s petfind.Search) string {
+	return l.siteURL + SearchPath(s)
+}
+
+// Matcher finds the new pets of saved searches and notifies their users.
+type Matcher struct {
+	Store    petfind.Store
+	Notifier Notifier
+	Links    *Links
+}
+
+// Run matches every saved search against the pets listed after its last run
+// and up to now. A search is only marked as run once its digest has been
+// delivered so that failed digests are retried on the next run. Errors of
+// single searches are logged and counted; Run only returns an error if the
+// searches could not be fetched at all.
+func (m *Matcher) Run(now time.Time) (sent int, err error) {
+	searches, err := m.Store.GetSavedSearches()
+	if err != nil {
+		return 0, fmt.Errorf("error getting saved searches: %v", err)
+	}
+	for _, ss := range searches {
+		ok, err := m.match(ss, now)
+		if err != nil {
+			log.Printf("error matching saved search %d: %v", ss.ID, err)
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// match notifies the user of a saved search if there are new pets for it and
+// reports whether a digest was sent.
+func (m *Matcher) match(ss *petfind.SavedSearch, now time.Time) (bool, error) {
+	pets, err := m.Store.SearchNewPets(ss.Search, ss.LastRun, now)
+	if err != nil {
+		return false, fmt.Errorf("error searching new pets: %v", err)
+	}
+	if len(pets) != 0 {
+		unsubscribeURL, err := m.Links.Unsubscribe(ss)
+		if err != nil {
+			return false, fmt.Errorf("error creating unsubscribe link: %v", err)
+		}
+		d := &Digest{
+			User:           ss.User,
+			Search:         ss,
+			Pets:           pets,
+			SearchURL:      m.Links.Search(ss.Search),
+			UnsubscribeURL: unsubscribeURL,
+		}
+		if err := m.Notifier.Notify(d); err != nil {
+			return false, fmt.Errorf("error sending digest: %v", err)
+		}
+	}
+	if err := m.Store.MarkSavedSearchRun(ss.ID, now); err != nil {
+		return false, fmt.Errorf("error marking search as run: %v", err)
+	}
+	return len(pets) != 0, nil
+}
+
+// Start runs the matcher every interval until the program exits.
+func (m *Matcher) Start(interval time.Duration) {
+	go func() {
+		for now := range time.Tick(interval) {
+			sent, err := m.Run(now)
+			if err != nil {
+				log.Println("Saved search alerts failed:", err)
+				continue
+			}
+			if sent != 0 {
+				log.Printf("Sent %d saved search alerts", sent)
+			}
+		}
+	}()
+}
@@ -0,0 +1,227 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/notification"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+var testHashKey = []byte("0123456789abcdef0123456789abcdef")
+
+type fakeStore struct {
+	petfind.Store
+	searches []*petfind.SavedSearch
+	pets     map[petfind.PetType][]*petfind.Pet
+	runs     map[int64]time.Time
+}
+
+func (s *fakeStore) GetSavedSearches() ([]*petfind.SavedSearch, error) {
+	return s.searches, nil
+}
+
+func (s *fakeStore) SearchNewPets(search petfind.Search, since, until time.Time) ([]*petfind.Pet, error) {
+	return s.pets[search.Type], nil
+}
+
+func (s *fakeStore) MarkSavedSearchRun(searchID int64, at time.Time) error {
+	s.runs[searchID] = at
+	return nil
+}
+
+type recordingNotifier struct {
+	digests []*Digest
+	err     error
+}
+
+func (n *recordingNotifier) Notify(d *Digest) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.digests = append(n.digests, d)
+	return nil
+}
+
+func newFakeStore() *fakeStore {
+	u := &petfind.User{ID: 7, Name: "John Doe", Email: "john@example.com"}
+	return &fakeStore{
+		searches: []*petfind.SavedSearch{
+			{ID: 1, UserID: u.ID, User: u, Name: "Cat in Χαλκίδα", Search: petfind.Search{PlaceKey: "chalkida", Type: petfind.Cat, UseType: true}},
+			{ID: 2, UserID: u.ID, User: u, Name: "Dog in Χαλκίδα", Search: petfind.Search{PlaceKey: "chalkida", Type: petfind.Dog, UseType: true}},
+		},
+		pets: map[petfind.PetType][]*petfind.Pet{
+			petfind.Cat: {{ID: 3, Name: "zazzles", Type: petfind.Cat}},
+		},
+		runs: make(map[int64]time.Time),
+	}
+}
+
+func TestMatcherRun(t *testing.T) {
+	store := newFakeStore()
+	n := &recordingNotifier{}
+	m := &Matcher{Store: store, Notifier: n, Links: NewLinks(testHashKey, "https://petfind.example.com/")}
+	now := time.Now()
+
+	sent, err := m.Run(now)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if sent != 1 || len(n.digests) != 1 {
+		t.Fatalf("Run sent %d digests (%d notified), expected 1", sent, len(n.digests))
+	}
+	d := n.digests[0]
+	if d.Search.ID != 1 || len(d.Pets) != 1 || d.Pets[0].Name != "zazzles" {
+		t.Fatalf("digest = %#v", d)
+	}
+	if want := "https://petfind.example.com/search/submit?place=chalkida&type=1"; d.SearchURL != want {
+		t.Errorf("SearchURL = %q, expected %q", d.SearchURL, want)
+	}
+	if !strings.HasPrefix(d.UnsubscribeURL, "https://petfind.example.com/searches/unsubscribe?t=") {
+		t.Errorf("UnsubscribeURL = %q", d.UnsubscribeURL)
+	}
+	// Searches without new pets are marked as run too.
+	for _, id := range []int64{1, 2} {
+		if !store.runs[id].Equal(now) {
+			t.Errorf("search %d last run = %v, expected %v", id, store.runs[id], now)
+		}
+	}
+}
+
+func TestMatcherRunNotifyFails(t *testing.T) {
+	store := newFakeStore()
+	n := &recordingNotifier{err: errors.New("mail server down")}
+	m := &Matcher{Store: store, Notifier: n, Links: NewLinks(testHashKey, "https://petfind.example.com")}
+
+	sent, err := m.Run(time.Now())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if sent != 0 {
+		t.Fatalf("Run sent %d digests, expected 0", sent)
+	}
+	// The search with the undelivered digest is tried again next time.
+	if _, ok := store.runs[1]; ok {
+		t.Errorf("search with failed digest was marked as run")
+	}
+	if _, ok := store.runs[2]; !ok {
+		t.Errorf("search without new pets was not marked as run")
+	}
+}
+
+func TestUnsubscribeToken(t *testing.T) {
+	l := NewLinks(testHashKey, "https://petfind.example.com")
+	ss := &petfind.SavedSearch{ID: 4, UserID: 7}
+	link, err := l.Unsubscribe(ss)
+	if err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("unsubscribe link %q does not parse: %v", link, err)
+	}
+	token := u.Query().Get("t")
+	searchID, userID, err := l.ParseUnsubscribe(token)
+	if err != nil {
+		t.Fatalf("ParseUnsubscribe failed: %v", err)
+	}
+	if searchID != ss.ID || userID != ss.UserID {
+		t.Fatalf("ParseUnsubscribe = %d, %d, expected %d, %d", searchID, userID, ss.ID, ss.UserID)
+	}
+
+	other := NewLinks([]byte("another key of thirty two bytes!"), "https://petfind.example.com")
+	if _, _, err := other.ParseUnsubscribe(token); err == nil {
+		t.Errorf("ParseUnsubscribe accepted a token signed with another key")
+	}
+	if _, _, err := l.ParseUnsubscribe(token[:len(token)-2]); err == nil {
+		t.Errorf("ParseUnsubscribe accepted a truncated token")
+	}
+}
+
+type recordingSender struct {
+	messages []*mail.Message
+}
+
+func (s *recordingSender) Send(m *mail.Message) error {
+	s.messages = append(s.messages, m)
+	return nil
+}
+
+func testDigest() *Digest {
+	u := &petfind.User{ID: 7, Name: "John Doe", Email: "john@example.com"}
+	return &Digest{
+		User:           u,
+		Search:         &petfind.SavedSearch{ID: 4, UserID: u.ID, Name: "Cat in Χαλκίδα"},
+		Pets:           []*petfind.Pet{{ID: 3, Name: "zazzles", Type: petfind.Cat, Place: &petfind.Place{Name: "Χαλκίδα"}}},
+		SearchURL:      "https://petfind.example.com/search/submit?place=chalkida&type=1",
+		UnsubscribeURL: "https://petfind.example.com/searches/unsubscribe?t=token",
+	}
+}
+
+func TestMailNotifier(t *testing.T) {
+	sender := &recordingSender{}
+	templates, err := notification.ParseTemplates("../web/templates/email")
+	if err != nil {
+		t.Fatalf("ParseTemplates failed: %v", err)
+	}
+	n := &MailNotifier{Sender: sender, Templates: templates}
+	d := testDigest()
+	if err := n.Notify(d); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if len(sender.messages) != 1 {
+		t.Fatalf("Notify sent %d messages, expected 1", len(sender.messages))
+	}
+	m := sender.messages[0]
+	if m.To != "john@example.com" || m.Subject != "New pets for Cat in Χαλκίδα" {
+		t.Errorf("message = %#v", m)
+	}
+	for _, s := range []string{"zazzles", d.SearchURL, d.UnsubscribeURL} {
+		if !strings.Contains(m.Body, s) {
+			t.Errorf("message body does not contain %q:\n%s", s, m.Body)
+		}
+	}
+	if !strings.Contains(m.HTML, `href="https://petfind.example.com/searches/unsubscribe?t=token"`) {
+		t.Errorf("message HTML does not link to unsubscribe:\n%s", m.HTML)
+	}
+
+	d.User.Email = ""
+	if err := n.Notify(d); err != nil || len(sender.messages) != 1 {
+		t.Errorf("Notify for user without email = %v, sent %d messages", err, len(sender.messages))
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got webhookPayload
+	status := http.StatusNoContent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, expected application/json", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("error decoding payload: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	n := &WebhookNotifier{URL: ts.URL, Client: &http.Client{}}
+	if err := n.Notify(testDigest()); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if got.SearchID != 4 || got.UserID != 7 || len(got.Pets) != 1 || got.Pets[0].Type != "Cat" || got.Pets[0].Place != "Χαλκίδα" {
+		t.Errorf("webhook payload = %#v", got)
+	}
+
+	status = http.StatusInternalServerError
+	if err := n.Notify(testDigest()); err == nil {
+		t.Errorf("Notify succeeded although the webhook responded with %d", status)
+	}
+}
@@ -0,0 +1,148 @@
+// Command petfindadmin performs maintenance tasks on the petfind database
+// that should not be reachable from the web.
+//
+// Usage:
+//
+//	petfindadmin -datasource=<database URL> <command> [arguments]
+//
+// The commands are:
+//
+//	merge <primary user ID> <duplicate user ID>
+//	    moves the pets, login identities, password and two-factor settings
+//	    of the duplicate user into the primary one and deletes the
+//	    duplicate; users who both have a password or both have two-factor
+//	    authentication cannot be merged
+//
+//	grant <user ID or email> <role>
+//	    sets the role (user, moderator or admin) of a user, e.g. to create
+//	    the first admin, and logs them out everywhere
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"github.com/psimika/secure-web-app/petfind"
+	"github.com/psimika/secure-web-app/petfind/postgres"
+)
+
+func main() {
+	dataSource := flag.String("datasource", os.Getenv("DATABASE_URL"), "the database URL (defaults to $DATABASE_URL)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *dataSource == "" {
+		log.Fatal("No database datasource provided, exiting...")
+	}
+
+	store, err := postgres.NewStore(*dataSource)
+	if err != nil {
+		log.Fatal("NewStore failed:", err)
+	}
+
+	args := flag.Args()
+	switch args[0] {
+	case "merge":
+		err = merge(store, args[1:])
+	case "grant":
+		err = grant(store, args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: petfindadmin [flags] <command> [arguments]\n\n")
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	fmt.Fprintf(os.Stderr, "  merge <primary user ID> <duplicate user ID>\n")
+	fmt.Fprintf(os.Stderr, "  grant <user ID or email> <user|moderator|admin>\n\n")
+	fmt.Fprintf(os.Stderr, "flags:\n")
+	flag.PrintDefaults()
+}
+
+func merge(store petfind.Store, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("merge needs the primary and the duplicate user ID")
+	}
+	primaryID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid primary user ID: %v", err)
+	}
+	duplicateID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid duplicate user ID: %v", err)
+	}
+
+	primary, err := store.GetUser(primaryID)
+	if err != nil {
+		return fmt.Errorf("error getting primary user %d: %v", primaryID, err)
+	}
+	duplicate, err := store.GetUser(duplicateID)
+	if err != nil {
+		return fmt.Errorf("error getting duplicate user %d: %v", duplicateID, err)
+	}
+	if err := store.MergeUsers(primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error merging users: %v", err)
+	}
+	log.Printf("Merged user %d (%s) into user %d (%s).", duplicate.ID, duplicate.Name, primary.ID, primary.Name)
+	return nil
+}
+
+func grant(store petfind.Store, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("grant needs a user ID or email and a role")
+	}
+	role, err := petfind.ParseRole(args[1])
+	if err != nil {
+		return err
+	}
+
+	var user *petfind.User
+	if id, perr := strconv.ParseInt(args[0], 10, 64); perr == nil {
+		user, err = store.GetUser(id)
+	} else {
+		// Only addresses of local accounts are known to belong to the user.
+		user, err = store.GetUserByIdentity(petfind.EmailProvider, strings.ToLower(args[0]))
+	}
+	if err != nil {
+		return fmt.Errorf("error getting user %s: %v", args[0], err)
+	}
+	if err := store.SetUserRole(user.ID, role); err != nil {
+		return fmt.Errorf("error setting role: %v", err)
+	}
+	// Sessions and tokens started with the old role must not carry over to
+	// the new one.
+	if err := store.DeleteUserSessions(user.ID); err != nil {
+		return fmt.Errorf("error revoking sessions: %v", err)
+	}
+	if err := store.DeleteUserAPITokens(user.ID); err != nil {
+		return fmt.Errorf("error revoking API tokens: %v", err)
+	}
+	// Whoever runs the command is not a user of the site so the event has no
+	// actor.
+	e := &petfind.AuditEvent{
+		Kind:      petfind.AuditRole,
+		UserAgent: "petfindadmin",
+		Detail:    fmt.Sprintf("granted user %d the %v role from the command line (was %v)", user.ID, role, user.Role),
+	}
+	if err := store.AddAuditEvent(e); err != nil {
+		return fmt.Errorf("error recording audit event: %v", err)
+	}
+	log.Printf("User %d (%s) is now %v and has been logged out everywhere.", user.ID, user.Name, role)
+	if role > petfind.RoleUser {
+		log.Printf("They will be asked to turn on two-factor authentication before using it.")
+	}
+	return nil
+}
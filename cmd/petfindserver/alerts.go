@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/psimika/secure-web-app/alerts"
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/notification"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// startAlerts matches the saved searches of the users against new pets every
+// interval and emails them the matches, using the email templates under
+// tmplPath, also posting them to webhookURL if set. Alerts need the public URL
+// of the site for their links and are disabled without it or if interval is
+// 0.
+func startAlerts(store petfind.Store, hashKey []byte, siteURL, tmplPath string, mailer mail.Sender, interval time.Duration, webhookURL string) {
+	if interval <= 0 {
+		log.Println("Note: Saved search alerts are disabled.")
+		return
+	}
+	if siteURL == "" {
+		log.Println("Note: No site URL configured, saved search alerts are disabled.")
+		return
+	}
+	emails, err := notification.ParseTemplates(filepath.Join(tmplPath, "templates", "email"))
+	if err != nil {
+		log.Println("Note: Saved search alerts are disabled, error parsing email templates:", err)
+		return
+	}
+	notifiers := alerts.Notifiers{&alerts.MailNotifier{Sender: mailer, Templates: emails}}
+	if webhookURL != "" {
+		notifiers = append(notifiers, &alerts.WebhookNotifier{URL: webhookURL})
+	}
+	m := &alerts.Matcher{
+		Store:    store,
+		Notifier: notifiers,
+		Links:    alerts.NewLinks(hashKey, siteURL),
+	}
+	log.Printf("Matching saved searches every %v", interval)
+	m.Start(interval)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/notification"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// newMailSender returns an SMTP sender if an SMTP server is configured. For
+// development emails can be written to a directory, delivered to a local
+// Maildir or, by default, written to the log.
+func newMailSender(smtpAddr, smtpUser, smtpPass, from, dir, maildir string) mail.Sender {
+	switch {
+	case smtpAddr != "":
+		return &mail.SMTPSender{Addr: smtpAddr, Username: smtpUser, Password: smtpPass, From: from}
+	case dir != "":
+		log.Printf("Note: Writing emails to %s instead of sending them.", dir)
+		return &mail.FileSender{Dir: dir, From: from}
+	case maildir != "":
+		log.Printf("Note: Delivering emails to the Maildir %s instead of sending them.", maildir)
+		return &mail.MaildirSender{Dir: maildir, From: from}
+	}
+	log.Println("Note: No SMTP server configured, emails will only be logged.")
+	return mail.LogSender{}
+}
+
+// newOutbox returns the sender the application uses for its emails. Emails
+// are stored in the outbox and a worker sends them through transport every
+// interval, retrying the ones that fail.
+func newOutbox(store petfind.Store, transport mail.Sender, interval time.Duration) mail.Sender {
+	w := &notification.Worker{Store: store, Sender: transport}
+	w.Start(interval)
+	return &notification.Outbox{Store: store}
+}
@@ -40,6 +40,12 @@ func main() {
 		linkedinID       = flag.String("linkedinid", "", "LinkedIn Client ID used for Login with LinkedIn")
 		linkedinSecret   = flag.String("linkedinsecret", "", "LinkedIn Client Secret used for Login with LinkedIn")
 		linkedinURL      = flag.String("linkedinurl", "", "LinkedIn redirect URL used for Login with LinkedIn")
+		oidcName         = flag.String("oidcname", "oidc", "name of the OpenID Connect provider used in login URLs")
+		oidcTitle        = flag.String("oidctitle", "OpenID Connect", "title of the OpenID Connect provider shown on the login page")
+		oidcIssuer       = flag.String("oidcissuer", "", "issuer URL of an OpenID Connect provider used for login (e.g. https://accounts.google.com)")
+		oidcID           = flag.String("oidcid", "", "OpenID Connect Client ID")
+		oidcSecret       = flag.String("oidcsecret", "", "OpenID Connect Client Secret")
+		oidcURL          = flag.String("oidcurl", "", "OpenID Connect redirect URL, derived from the request if empty")
 		cloudinaryKey    = flag.String("cloudinarykey", "", "Cloudinary API Key used to upload photos")
 		cloudinarySecret = flag.String("cloudinarysecret", "", "Cloudinary API Secret used to upload photos")
 		cloudinaryName   = flag.String("cloudinaryname", "", "Cloudinary Cloud Name used to upload photos")
@@ -51,6 +57,20 @@ func main() {
 		redisMaxIdle     = flag.Int("redismaxidle", 10, "maximum number of idle Redis connections")
 		sessionTTL       = flag.Int("sessionttl", 1200, "`seconds` before a session expires due to inactivity (idle timeout)")
 		sessionMaxTTL    = flag.Int("sessionmaxttl", 3600, "`seconds` before a session expires regardless of activity (absolute timeout)")
+		siteURL          = flag.String("siteurl", "", "public `URL` of the site (e.g. https://petfind.example.com) used in emailed links")
+		premoderate      = flag.Bool("premoderate", false, "hold back the pets of users without approved pets until a moderator approves them")
+		breachList       = flag.String("breachlist", "", "`file` of breached passwords, in plain text or SHA-1 hashes, that are not allowed")
+		smtpAddr         = flag.String("smtp", "", "SMTP server `host:port` used to send emails")
+		smtpUser         = flag.String("smtpuser", "", "SMTP username if needed")
+		smtpPass         = flag.String("smtppass", "", "SMTP password if needed")
+		mailFrom         = flag.String("mailfrom", "petfind <noreply@localhost>", "address emails are sent from")
+		mailDir          = flag.String("maildir", "", "`directory` to write emails to instead of sending them (for development)")
+		mailbox          = flag.String("mailbox", "", "`directory` of a Maildir to deliver emails to instead of sending them (for development)")
+		mailInterval     = flag.Duration("mailinterval", 10*time.Second, "how often to send the emails waiting in the outbox")
+		alertInterval    = flag.Duration("alertinterval", time.Hour, "how often to email users new pets that match their saved searches, 0 to disable")
+		alertWebhook     = flag.String("alertwebhook", "", "`URL` to also post saved search alerts to as JSON")
+		webhookInterval  = flag.Duration("webhookinterval", 10*time.Second, "how often to post the queued pet events to partner webhooks, 0 to disable")
+		trustedProxies   = flag.String("trustedproxies", "", "comma separated `CIDRs` of reverse proxies (e.g. a local nginx 127.0.0.1/32) trusted to set X-Forwarded-* headers")
 	)
 	flag.Parse()
 	if !*insecureHTTP && *autocertHosts == "" && (*certFile == "" || *keyFile == "") {
@@ -63,6 +83,10 @@ func main() {
 	hashKey := validHashKey(*hashKeyStr)
 	blockKey := validBlockKey(*blockKeyStr)
 	csrfKey := validCSRFKey(*csrfKeyStr)
+	proxies, err := web.ParseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatal("invalid -trustedproxies: ", err)
+	}
 
 	if *dataSource == "" {
 		log.Fatal("No database datasource provided, exiting...")
@@ -103,10 +127,10 @@ func main() {
 		Secure:   true,
 		MaxAge:   *sessionTTL,
 	}
-	CSRF := csrf.Protect(csrfKey)
+	var csrfOptions []csrf.Option
 	if *insecureHTTP {
 		sessionStore.Options.Secure = false
-		CSRF = csrf.Protect(csrfKey, csrf.Secure(false))
+		csrfOptions = append(csrfOptions, csrf.Secure(false))
 	}
 
 	var photos petfind.PhotoStore
@@ -116,21 +140,44 @@ func main() {
 		photos = petfind.NewPhotoStore(*photosPath)
 	}
 
+	transport := newMailSender(*smtpAddr, *smtpUser, *smtpPass, *mailFrom, *mailDir, *mailbox)
+	mailer := newOutbox(store, transport, *mailInterval)
+
 	appHandlers, err := web.NewServer(
 		store,
 		sessionStore,
 		*sessionTTL,
 		*sessionMaxTTL,
-		CSRF,
+		hashKey,
+		csrfKey,
+		csrfOptions,
 		*tmplPath,
 		photos,
-		web.NewGitHubOAuthConfig(*githubID, *githubSecret),
-		web.NewLinkedInOAuthConfig(*linkedinID, *linkedinSecret, *linkedinURL),
+		newProviders(providerConfig{
+			githubID:       *githubID,
+			githubSecret:   *githubSecret,
+			linkedinID:     *linkedinID,
+			linkedinSecret: *linkedinSecret,
+			linkedinURL:    *linkedinURL,
+			oidcName:       *oidcName,
+			oidcTitle:      *oidcTitle,
+			oidcIssuer:     *oidcIssuer,
+			oidcID:         *oidcID,
+			oidcSecret:     *oidcSecret,
+			oidcURL:        *oidcURL,
+		}),
+		mailer,
+		newPasswordPolicy(*breachList),
+		*premoderate,
+		*siteURL,
+		proxies,
 	)
 	if err != nil {
 		log.Println("NewServer failed:", err)
 		return
 	}
+	startAlerts(store, hashKey, *siteURL, *tmplPath, mailer, *alertInterval, *alertWebhook)
+	startWebhooks(store, *webhookInterval)
 
 	if *insecureHTTP {
 		log.Printf("Serving insecure HTTP on %q", *httpAddr)
@@ -11,7 +11,6 @@ import (
 
 	"github.com/boj/redistore"
 	"github.com/garyburd/redigo/redis"
-	"github.com/gorilla/csrf"
 	"github.com/gorilla/sessions"
 	_ "github.com/lib/pq"
 
@@ -55,6 +54,19 @@ func main() {
 		linkedinID       = getenvString("", "LINKEDIN_ID")
 		linkedinSecret   = getenvString("", "LINKEDIN_SECRET")
 		linkedinURL      = getenvString("", "LINKEDIN_URL")
+		oidcName         = getenvString("oidc", "OIDC_NAME")
+		oidcTitle        = getenvString("OpenID Connect", "OIDC_TITLE")
+		oidcIssuer       = getenvString("", "OIDC_ISSUER")
+		oidcID           = getenvString("", "OIDC_ID")
+		oidcSecret       = getenvString("", "OIDC_SECRET")
+		oidcURL          = getenvString("", "OIDC_URL")
+		siteURL          = getenvString("", "SITE_URL")
+		breachList       = getenvString("", "BREACH_LIST")
+		premoderate      = getenvBool(false, "PREMODERATE")
+		smtpAddr         = getenvString("", "SMTP_ADDR")
+		smtpUser         = getenvString("", "SMTP_USER")
+		smtpPass         = getenvString("", "SMTP_PASS")
+		mailFrom         = getenvString("petfind <noreply@secure-petfind.herokuapp.com>", "MAIL_FROM")
 		sessionTTL       = getenvInt(1200, "SESSION_TTL")
 		sessionMaxTTL    = getenvInt(3600, "SESSION_MAX_TTL")
 		redisURL         = getenvString("", "REDIS_URL")
@@ -66,10 +78,22 @@ func main() {
 		cloudinaryKey    = getenvString("", "CLOUDINARY_KEY")
 		cloudinarySecret = getenvString("", "CLOUDINARY_SECRET")
 		cloudinaryName   = getenvString("petfind-photos", "CLOUDINARY_NAME")
+		mailInterval     = getenvDuration(10*time.Second, "MAIL_INTERVAL")
+		alertInterval    = getenvDuration(time.Hour, "ALERT_INTERVAL")
+		alertWebhook     = getenvString("", "ALERT_WEBHOOK")
+		webhookInterval  = getenvDuration(10*time.Second, "WEBHOOK_INTERVAL")
+		// Heroku's router connects to the app from its private network and
+		// appends the client's address to X-Forwarded-For (Heroku Dev Center
+		// 2017).
+		trustedProxies = getenvString("10.0.0.0/8", "TRUSTED_PROXIES")
 	)
 	hashKey := validHashKey(hashKeyStr)
 	blockKey := validBlockKey(blockKeyStr)
 	csrfKey := validCSRFKey(csrfKeyStr)
+	proxies, err := web.ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		log.Fatal("invalid TRUSTED_PROXIES: ", err)
+	}
 
 	if databaseURL == "" {
 		log.Fatal("No database URL provided, exiting...")
@@ -106,8 +130,6 @@ func main() {
 		MaxAge:   sessionTTL,
 	}
 
-	CSRF := csrf.Protect(csrfKey)
-
 	var photos petfind.PhotoStore
 	if cloudinaryKey != "" && cloudinarySecret != "" && cloudinaryName != "" {
 		photos = cloudinary.NewPhotoStore(cloudinaryKey, cloudinarySecret, cloudinaryName)
@@ -116,21 +138,44 @@ func main() {
 		photos = petfind.NewPhotoStore(photosPath)
 	}
 
+	transport := newMailSender(smtpAddr, smtpUser, smtpPass, mailFrom, "", "")
+	mailer := newOutbox(store, transport, mailInterval)
+
 	handlers, err := web.NewServer(
 		store,
 		sessionStore,
 		sessionTTL,
 		sessionMaxTTL,
-		CSRF,
+		hashKey,
+		csrfKey,
+		nil,
 		tmplPath,
 		photos,
-		web.NewGitHubOAuthConfig(githubID, githubSecret),
-		web.NewLinkedInOAuthConfig(linkedinID, linkedinSecret, linkedinURL),
+		newProviders(providerConfig{
+			githubID:       githubID,
+			githubSecret:   githubSecret,
+			linkedinID:     linkedinID,
+			linkedinSecret: linkedinSecret,
+			linkedinURL:    linkedinURL,
+			oidcName:       oidcName,
+			oidcTitle:      oidcTitle,
+			oidcIssuer:     oidcIssuer,
+			oidcID:         oidcID,
+			oidcSecret:     oidcSecret,
+			oidcURL:        oidcURL,
+		}),
+		mailer,
+		newPasswordPolicy(breachList),
+		premoderate,
+		siteURL,
+		proxies,
 	)
 	if err != nil {
 		log.Println("NewServer failed:", err)
 		return
 	}
+	startAlerts(store, hashKey, siteURL, tmplPath, mailer, alertInterval, alertWebhook)
+	startWebhooks(store, webhookInterval)
 
 	log.Fatal(http.ListenAndServe(":"+port, redirectHTTP(handlers)))
 }
@@ -155,6 +200,30 @@ func getenvInt(defaultValue int, envName string) int {
 	return i
 }
 
+func getenvBool(defaultValue bool, envName string) bool {
+	value := os.Getenv(envName)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return b
+}
+
+func getenvDuration(defaultValue time.Duration, envName string) time.Duration {
+	value := os.Getenv(envName)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return d
+}
+
 func redirectHTTP(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Heroku's HTTP routing passes requests to our app and uses the
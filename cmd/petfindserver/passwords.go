@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log"
+
+	"github.com/psimika/secure-web-app/password"
+)
+
+func newPasswordPolicy(breachList string) *password.Policy {
+	if breachList == "" {
+		log.Println("Note: No breached password list provided, only password length will be checked.")
+		return &password.Policy{}
+	}
+	l, err := password.LoadBreachList(breachList)
+	if err != nil {
+		log.Fatal("could not load breached password list: ", err)
+	}
+	log.Printf("Loaded %d breached passwords.", l.Len())
+	return &password.Policy{Breached: l}
+}
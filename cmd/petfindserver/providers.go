@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/psimika/secure-web-app/login"
+)
+
+// providerConfig holds the credentials of the login providers. A provider is
+// only enabled if its client ID has been set.
+type providerConfig struct {
+	githubID       string
+	githubSecret   string
+	linkedinID     string
+	linkedinSecret string
+	linkedinURL    string
+	oidcName       string
+	oidcTitle      string
+	oidcIssuer     string
+	oidcID         string
+	oidcSecret     string
+	oidcURL        string
+}
+
+func newProviders(c providerConfig) []login.Provider {
+	var providers []login.Provider
+	if c.githubID != "" {
+		providers = append(providers, login.NewGitHub(c.githubID, c.githubSecret))
+	}
+	if c.linkedinID != "" {
+		providers = append(providers, login.NewLinkedIn(c.linkedinID, c.linkedinSecret, c.linkedinURL))
+	}
+	if c.oidcID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		p, err := login.NewOIDC(ctx, c.oidcName, c.oidcTitle, c.oidcIssuer, c.oidcID, c.oidcSecret, c.oidcURL)
+		if err != nil {
+			log.Fatal("could not set up OpenID Connect login: ", err)
+		}
+		providers = append(providers, p)
+	}
+	if len(providers) == 0 {
+		log.Println("Note: No login providers configured, users will not be able to log in.")
+	}
+	return providers
+}
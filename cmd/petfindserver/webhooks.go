@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+	"github.com/psimika/secure-web-app/webhooks"
+)
+
+// startWebhooks posts the queued events to the webhooks of partners every
+// interval. The deliveries stay queued if interval is 0, e.g. when another
+// instance of the app posts them.
+func startWebhooks(store petfind.Store, interval time.Duration) {
+	if interval <= 0 {
+		log.Println("Note: Webhook deliveries are disabled.")
+		return
+	}
+	w := &webhooks.Worker{Store: store}
+	w.Start(interval)
+}
@@ -0,0 +1,81 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// GitHub implements login with GitHub (Caserta 2015):
+//
+// http://pierrecaserta.com/go-oauth-facebook-github-twitter-google-plus/
+type GitHub struct {
+	config
+	apiURL string
+}
+
+// NewGitHub returns a Provider for the GitHub OAuth application with the given
+// credentials.
+func NewGitHub(clientID, clientSecret string) *GitHub {
+	return &GitHub{
+		config: config{
+			Config: oauth2.Config{
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+				// Only requiring the user's public info and their email.
+				//
+				// Full list of scopes:
+				// https://developer.github.com/apps/building-integrations/setting-up-and-registering-oauth-apps/about-scopes-for-oauth-apps/
+				Scopes:   []string{"user:email"},
+				Endpoint: github.Endpoint,
+			},
+			name:         "github",
+			title:        "GitHub",
+			secretInBody: true,
+		},
+		apiURL: "https://api.github.com",
+	}
+}
+
+// AuthCodeURL satisfies the Provider interface.
+func (p *GitHub) AuthCodeURL(a *Attempt) string {
+	return p.authCodeURL(a, oauth2.AccessTypeOnline)
+}
+
+// githubUser holds the data that we need to retrieve from a user's GitHub
+// account with their permission.
+type githubUser struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Identity satisfies the Provider interface.
+func (p *GitHub) Identity(ctx context.Context, code string, a *Attempt) (*petfind.Identity, error) {
+	t, err := p.exchange(ctx, code, a)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the token to get the consented user's info from the GitHub API.
+	u := new(githubUser)
+	if err := get(ctx, p.apiURL+"/user", t, u); err != nil {
+		return nil, fmt.Errorf("could not get user from GitHub API: %v", err)
+	}
+	if u.ID == 0 {
+		return nil, fmt.Errorf("GitHub API returned user without ID")
+	}
+	return &petfind.Identity{
+		Provider: p.name,
+		Subject:  strconv.FormatInt(u.ID, 10),
+		Login:    u.Login,
+		Name:     u.Name,
+		Email:    u.Email,
+	}, nil
+}
@@ -0,0 +1,73 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/linkedin"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// LinkedIn implements login with LinkedIn.
+type LinkedIn struct {
+	config
+	apiURL string
+}
+
+// NewLinkedIn returns a Provider for the LinkedIn application with the given
+// credentials. LinkedIn requires the redirect URL to be sent with every
+// request so it has to be provided.
+func NewLinkedIn(clientID, clientSecret, redirectURL string) *LinkedIn {
+	return &LinkedIn{
+		config: config{
+			Config: oauth2.Config{
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+				RedirectURL:  redirectURL,
+				Scopes:       []string{},
+				Endpoint:     linkedin.Endpoint,
+			},
+			name:         "linkedin",
+			title:        "LinkedIn",
+			secretInBody: true,
+		},
+		apiURL: "https://api.linkedin.com",
+	}
+}
+
+// AuthCodeURL satisfies the Provider interface.
+func (p *LinkedIn) AuthCodeURL(a *Attempt) string {
+	return p.authCodeURL(a)
+}
+
+// linkedinUser holds the data that we need to retrieve from a user's LinkedIn
+// account with their permission.
+type linkedinUser struct {
+	ID        string `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+// Identity satisfies the Provider interface.
+func (p *LinkedIn) Identity(ctx context.Context, code string, a *Attempt) (*petfind.Identity, error) {
+	t, err := p.exchange(ctx, code, a)
+	if err != nil {
+		return nil, err
+	}
+
+	u := new(linkedinUser)
+	if err := get(ctx, p.apiURL+"/v1/people/~?format=json", t, u); err != nil {
+		return nil, fmt.Errorf("could not get user from LinkedIn API: %v", err)
+	}
+	if u.ID == "" {
+		return nil, fmt.Errorf("LinkedIn API returned user without ID")
+	}
+	return &petfind.Identity{
+		Provider: p.name,
+		Subject:  u.ID,
+		Name:     strings.TrimSpace(u.FirstName + " " + u.LastName),
+	}, nil
+}
@@ -0,0 +1,209 @@
+// Package login implements signing in with external OAuth 2.0 and OpenID
+// Connect providers such as GitHub and LinkedIn.
+//
+// Every provider satisfies the same Provider interface so the web server can
+// drive all of them with one pair of handlers: one that redirects the user to
+// the provider's consent page and one that receives the callback and turns it
+// into a petfind.Identity.
+package login
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/gorilla/securecookie"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// Provider is an external service users can sign in with.
+type Provider interface {
+	// Name identifies the provider in URLs and stored identities, e.g.
+	// "github". It must never change once users have signed in with it.
+	Name() string
+	// Title is the human readable name of the provider, e.g. "GitHub".
+	Title() string
+	// AuthCodeURL returns the URL of the provider's consent page for the
+	// login attempt a.
+	AuthCodeURL(a *Attempt) string
+	// Identity completes the login attempt a by exchanging the authorization
+	// code returned by the provider for the identity of the user.
+	Identity(ctx context.Context, code string, a *Attempt) (*petfind.Identity, error)
+}
+
+// Attempt holds the single-use secrets of a login attempt. They are created
+// before redirecting the user to the provider, kept in the user's session and
+// checked when the provider redirects back.
+type Attempt struct {
+	// State protects the callback against CSRF (RFC 6749 section 10.12).
+	State string
+	// Nonce binds the ID token to the attempt (OpenID Connect Core section
+	// 3.1.2.1). Ignored by plain OAuth 2.0 providers.
+	Nonce string
+	// Verifier is the PKCE code verifier (RFC 7636) which prevents a stolen
+	// authorization code from being redeemed by anyone else.
+	Verifier string
+	// RedirectURL is where the provider sends the user back to. It is used if
+	// the provider has not been configured with a fixed one.
+	RedirectURL string
+}
+
+const secretSize = 32
+
+// NewAttempt generates the secrets for a new login attempt.
+func NewAttempt(redirectURL string) (*Attempt, error) {
+	a := &Attempt{RedirectURL: redirectURL}
+	for _, v := range []*string{&a.State, &a.Nonce, &a.Verifier} {
+		key := securecookie.GenerateRandomKey(secretSize)
+		if key == nil {
+			return nil, fmt.Errorf("error generating random login secret")
+		}
+		*v = base64.RawURLEncoding.EncodeToString(key)
+	}
+	return a, nil
+}
+
+// CheckState compares the state returned by the provider with the one of the
+// attempt in constant time to mitigate timing attacks.
+func (a *Attempt) CheckState(state string) bool {
+	return a.State != "" && subtle.ConstantTimeCompare([]byte(state), []byte(a.State)) == 1
+}
+
+// challenge returns the S256 PKCE code challenge of the attempt's verifier.
+func (a *Attempt) challenge() string {
+	sum := sha256.Sum256([]byte(a.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// client is the HTTP client used to talk to providers.
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// config is the part shared by all the provider implementations.
+type config struct {
+	oauth2.Config
+	name  string
+	title string
+	// secretInBody sends the client credentials as form values instead of
+	// HTTP Basic authentication when redeeming the authorization code.
+	secretInBody bool
+}
+
+func (c *config) Name() string  { return c.name }
+func (c *config) Title() string { return c.title }
+
+func (c *config) redirectURL(a *Attempt) string {
+	if c.RedirectURL != "" {
+		return c.RedirectURL
+	}
+	return a.RedirectURL
+}
+
+func (c *config) authCodeURL(a *Attempt, opts ...oauth2.AuthCodeOption) string {
+	opts = append(opts,
+		oauth2.SetAuthURLParam("code_challenge", a.challenge()),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+	if u := c.redirectURL(a); u != c.RedirectURL {
+		opts = append(opts, oauth2.SetAuthURLParam("redirect_uri", u))
+	}
+	return c.AuthCodeURL(a.State, opts...)
+}
+
+// token is the response of a provider's token endpoint. GitHub reports errors
+// with status 200 so the error fields have to be checked as well.
+type token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	ErrorDesc   string `json:"error_description"`
+}
+
+// exchange redeems the authorization code together with the attempt's PKCE
+// verifier.
+func (c *config) exchange(ctx context.Context, code string, a *Attempt) (*token, error) {
+	v := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.redirectURL(a)},
+		"code_verifier": {a.Verifier},
+	}
+	if c.secretInBody {
+		v.Set("client_id", c.ClientID)
+		v.Set("client_secret", c.ClientSecret)
+	}
+	req, err := http.NewRequest("POST", c.Endpoint.TokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !c.secretInBody {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	t := new(token)
+	if err := do(ctx, req, t); err != nil {
+		return nil, fmt.Errorf("error exchanging authorization code: %v", err)
+	}
+	if t.Error != "" {
+		return nil, fmt.Errorf("error exchanging authorization code: %s: %s", t.Error, t.ErrorDesc)
+	}
+	if t.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access token")
+	}
+	return t, nil
+}
+
+// get fetches a JSON document from the provider's API using the access token.
+func get(ctx context.Context, apiURL string, t *token, v interface{}) error {
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if t != nil {
+		req.Header.Set("Authorization", "Bearer "+t.AccessToken)
+	}
+	return do(ctx, req, v)
+}
+
+// do sends the request and decodes the JSON response into v. Responses are
+// limited to 1MB to protect against misbehaving providers.
+func do(ctx context.Context, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Try to include the provider's explanation in the error.
+		var e struct {
+			Error     string `json:"error"`
+			ErrorDesc string `json:"error_description"`
+			Message   string `json:"message"`
+		}
+		_ = json.Unmarshal(body, &e)
+		return fmt.Errorf("%s %s: %s %s%s%s", req.Method, req.URL.Path, resp.Status, e.Error, e.ErrorDesc, e.Message)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("could not decode response of %s: %v", req.URL.Path, err)
+	}
+	return nil
+}
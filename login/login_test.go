@@ -0,0 +1,358 @@
+package login
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeIdP is a minimal OAuth 2.0 and OpenID Connect provider used to drive
+// the providers through a complete login without network access.
+type fakeIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// codes maps issued authorization codes to the request that created
+	// them.
+	codes map[string]url.Values
+	// claims overrides the claims of the next ID token.
+	claims func(c map[string]interface{})
+	// alg is the algorithm written to the ID token header.
+	alg string
+}
+
+const (
+	testClientID     = "client"
+	testClientSecret = "secret"
+	testRedirectURL  = "https://petfind.example/login/fake/cb"
+)
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key, codes: make(map[string]url.Values), alg: "RS256"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.serveDiscovery)
+	mux.HandleFunc("/jwks", idp.serveKeys)
+	mux.HandleFunc("/authorize", idp.serveAuthorize)
+	mux.HandleFunc("/token", idp.serveToken)
+	mux.HandleFunc("/user", idp.serveUser)
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *fakeIdP) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                 idp.URL,
+		"authorization_endpoint": idp.URL + "/authorize",
+		"token_endpoint":         idp.URL + "/token",
+		"jwks_uri":               idp.URL + "/jwks",
+	})
+}
+
+func (idp *fakeIdP) serveKeys(w http.ResponseWriter, r *http.Request) {
+	pub := idp.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// serveAuthorize plays the user giving consent: it issues a code and
+// redirects back to the client.
+func (idp *fakeIdP) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	idp.mu.Lock()
+	code := "code" + q.Get("state")
+	idp.codes[code] = q
+	idp.mu.Unlock()
+
+	u, _ := url.Parse(q.Get("redirect_uri"))
+	if u.String() == "" {
+		u, _ = url.Parse(testRedirectURL)
+	}
+	v := url.Values{"code": {code}, "state": {q.Get("state")}}
+	u.RawQuery = v.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (idp *fakeIdP) serveToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	if id != testClientID || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	idp.mu.Lock()
+	q, ok := idp.codes[r.FormValue("code")]
+	// Codes are single use.
+	delete(idp.codes, r.FormValue("code"))
+	idp.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if q.Get("code_challenge_method") != "S256" || base64.RawURLEncoding.EncodeToString(sum[:]) != q.Get("code_challenge") {
+		http.Error(w, `{"error":"invalid_grant","error_description":"PKCE verification failed"}`, http.StatusBadRequest)
+		return
+	}
+
+	resp := map[string]string{"access_token": "access" + q.Get("state"), "token_type": "Bearer"}
+	if strings.Contains(q.Get("scope"), "openid") {
+		resp["id_token"] = idp.idToken(q.Get("nonce"))
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (idp *fakeIdP) serveUser(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer access") {
+		http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":    5,
+		"login": "janedoe",
+		"name":  "Jane Doe",
+		"email": "jane@doe.com",
+	})
+}
+
+func (idp *fakeIdP) idToken(nonce string) string {
+	now := time.Now()
+	c := map[string]interface{}{
+		"iss":                idp.URL,
+		"sub":                "248289761001",
+		"aud":                testClientID,
+		"exp":                now.Add(time.Hour).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"name":               "Jane Doe",
+		"preferred_username": "janedoe",
+		"email":              "jane@doe.com",
+		"email_verified":     true,
+	}
+	if idp.claims != nil {
+		idp.claims(c)
+	}
+	return idp.sign(c)
+}
+
+func (idp *fakeIdP) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": idp.alg, "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// login drives provider p through the fake IdP the way a browser would and
+// returns the query of the callback request.
+func login(t *testing.T, p Provider) (url.Values, *Attempt) {
+	a, err := NewAttempt(testRedirectURL)
+	if err != nil {
+		t.Fatal("NewAttempt failed:", err)
+	}
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(p.AuthCodeURL(a))
+	if err != nil {
+		t.Fatal("visiting consent page failed:", err)
+	}
+	resp.Body.Close()
+	cb, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal("bad callback URL:", err)
+	}
+	if got, want := cb.Scheme+"://"+cb.Host+cb.Path, testRedirectURL; got != want {
+		t.Fatalf("provider redirected to %q, want %q", got, want)
+	}
+	return cb.Query(), a
+}
+
+func TestOIDC(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.Close()
+
+	ctx := context.Background()
+	p, err := NewOIDC(ctx, "fake", "Fake", idp.URL, testClientID, testClientSecret, "")
+	if err != nil {
+		t.Fatal("NewOIDC failed:", err)
+	}
+
+	q, a := login(t, p)
+	if !a.CheckState(q.Get("state")) {
+		t.Fatal("callback state does not match attempt")
+	}
+	id, err := p.Identity(ctx, q.Get("code"), a)
+	if err != nil {
+		t.Fatal("Identity failed:", err)
+	}
+	if id.Provider != "fake" || id.Subject != "248289761001" || id.Login != "janedoe" || id.Name != "Jane Doe" || id.Email != "jane@doe.com" {
+		t.Fatalf("Identity returned unexpected %#v", id)
+	}
+
+	// Codes are single use.
+	if _, err := p.Identity(ctx, q.Get("code"), a); err == nil {
+		t.Fatal("Identity accepted a used authorization code")
+	}
+}
+
+func TestOIDC_unverifiedEmail(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.Close()
+	idp.claims = func(c map[string]interface{}) { c["email_verified"] = false }
+
+	ctx := context.Background()
+	p, err := NewOIDC(ctx, "fake", "Fake", idp.URL, testClientID, testClientSecret, "")
+	if err != nil {
+		t.Fatal("NewOIDC failed:", err)
+	}
+	q, a := login(t, p)
+	id, err := p.Identity(ctx, q.Get("code"), a)
+	if err != nil {
+		t.Fatal("Identity failed:", err)
+	}
+	if id.Subject != "248289761001" || id.Email != "" {
+		t.Fatalf("Identity with unverified email = %#v, expected no email", id)
+	}
+}
+
+func TestOIDC_rejectsBadTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims func(c map[string]interface{})
+		alg    string
+		a      func(a *Attempt)
+	}{
+		{name: "wrong issuer", claims: func(c map[string]interface{}) { c["iss"] = "https://evil.example" }},
+		{name: "wrong audience", claims: func(c map[string]interface{}) { c["aud"] = "other" }},
+		{name: "multiple audiences without azp", claims: func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"} }},
+		{name: "expired", claims: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "issued in future", claims: func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{name: "wrong nonce", claims: func(c map[string]interface{}) { c["nonce"] = "replayed" }},
+		{name: "no subject", claims: func(c map[string]interface{}) { delete(c, "sub") }},
+		{name: "unsupported algorithm", alg: "HS256"},
+		{name: "wrong verifier", a: func(a *Attempt) { a.Verifier = "guessed" }},
+	}
+	for _, tt := range tests {
+		idp := newFakeIdP(t)
+		idp.claims = tt.claims
+		if tt.alg != "" {
+			idp.alg = tt.alg
+		}
+
+		ctx := context.Background()
+		p, err := NewOIDC(ctx, "fake", "Fake", idp.URL, testClientID, testClientSecret, "")
+		if err != nil {
+			t.Fatal("NewOIDC failed:", err)
+		}
+		q, a := login(t, p)
+		if tt.a != nil {
+			tt.a(a)
+		}
+		if _, err := p.Identity(ctx, q.Get("code"), a); err == nil {
+			t.Errorf("%s: Identity accepted bad token", tt.name)
+		}
+		idp.Close()
+	}
+}
+
+func TestOIDC_rejectsForgedSignature(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.Close()
+
+	ctx := context.Background()
+	p, err := NewOIDC(ctx, "fake", "Fake", idp.URL, testClientID, testClientSecret, "")
+	if err != nil {
+		t.Fatal("NewOIDC failed:", err)
+	}
+	token := idp.idToken("nonce")
+	// Flip a bit of the signature.
+	i := strings.LastIndex(token, ".")
+	sig, _ := base64.RawURLEncoding.DecodeString(token[i+1:])
+	sig[0] ^= 1
+	forged := token[:i+1] + base64.RawURLEncoding.EncodeToString(sig)
+	if _, err := p.verify(ctx, forged, "nonce", time.Now()); err == nil {
+		t.Fatal("verify accepted a forged signature")
+	}
+	if _, err := p.verify(ctx, token, "nonce", time.Now()); err != nil {
+		t.Fatal("verify rejected a valid token:", err)
+	}
+}
+
+func TestNewOIDC_issuerMismatch(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.Close()
+
+	if _, err := NewOIDC(context.Background(), "fake", "Fake", idp.URL+"/other", testClientID, testClientSecret, ""); err == nil {
+		t.Fatal("NewOIDC accepted a discovery document for a different issuer")
+	}
+}
+
+func TestGitHub(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.Close()
+
+	p := NewGitHub(testClientID, testClientSecret)
+	p.Endpoint = oauth2.Endpoint{AuthURL: idp.URL + "/authorize", TokenURL: idp.URL + "/token"}
+	p.apiURL = idp.URL
+
+	q, a := login(t, p)
+	if !a.CheckState(q.Get("state")) {
+		t.Fatal("callback state does not match attempt")
+	}
+	id, err := p.Identity(context.Background(), q.Get("code"), a)
+	if err != nil {
+		t.Fatal("Identity failed:", err)
+	}
+	if id.Provider != "github" || id.Subject != "5" || id.Login != "janedoe" || id.Name != "Jane Doe" {
+		t.Fatalf("Identity returned unexpected %#v", id)
+	}
+}
+
+func TestAttempt_CheckState(t *testing.T) {
+	a, err := NewAttempt(testRedirectURL)
+	if err != nil {
+		t.Fatal("NewAttempt failed:", err)
+	}
+	if a.CheckState("") || a.CheckState(a.State[1:]) {
+		t.Fatal("CheckState accepted a wrong state")
+	}
+	if (&Attempt{}).CheckState("") {
+		t.Fatal("CheckState accepted an empty state")
+	}
+	if !a.CheckState(a.State) {
+		t.Fatal("CheckState rejected the attempt's state")
+	}
+}
@@ -0,0 +1,363 @@
+package login
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// OIDC implements login with any OpenID Connect provider (Google, GitLab,
+// Keycloak, ...). The provider's endpoints and signing keys are found through
+// OpenID Connect Discovery and the ID token returned after login is verified
+// before its claims are trusted.
+//
+// https://openid.net/specs/openid-connect-core-1_0.html
+type OIDC struct {
+	config
+	issuer string
+
+	mu      sync.Mutex
+	jwksURL string
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// discovery holds the fields of the provider's metadata document we need.
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// NewOIDC discovers the OpenID Connect provider at issuer and returns a
+// Provider for it. name is used in URLs and stored identities and title is
+// shown to users.
+func NewOIDC(ctx context.Context, name, title, issuer, clientID, clientSecret, redirectURL string) (*OIDC, error) {
+	issuer = strings.TrimRight(issuer, "/")
+	d := new(discovery)
+	if err := get(ctx, issuer+"/.well-known/openid-configuration", nil, d); err != nil {
+		return nil, fmt.Errorf("error discovering %s: %v", issuer, err)
+	}
+	// The issuer in the document must exactly match the one we asked for,
+	// otherwise ID tokens from a different issuer could be accepted (OpenID
+	// Connect Discovery section 4.3).
+	if d.Issuer != issuer {
+		return nil, fmt.Errorf("discovered issuer %q does not match %q", d.Issuer, issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s is missing endpoints", issuer)
+	}
+
+	// client_secret_basic is the default when the provider does not list
+	// the methods it supports.
+	secretInBody := len(d.TokenAuthMethods) != 0
+	for _, m := range d.TokenAuthMethods {
+		if m == "client_secret_basic" {
+			secretInBody = false
+		}
+	}
+
+	p := &OIDC{
+		config: config{
+			Config: oauth2.Config{
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+				RedirectURL:  redirectURL,
+				Scopes:       []string{"openid", "profile", "email"},
+				Endpoint: oauth2.Endpoint{
+					AuthURL:  d.AuthorizationEndpoint,
+					TokenURL: d.TokenEndpoint,
+				},
+			},
+			name:         name,
+			title:        title,
+			secretInBody: secretInBody,
+		},
+		issuer:  issuer,
+		jwksURL: d.JWKSURI,
+	}
+	return p, nil
+}
+
+// AuthCodeURL satisfies the Provider interface.
+func (p *OIDC) AuthCodeURL(a *Attempt) string {
+	return p.authCodeURL(a, oauth2.SetAuthURLParam("nonce", a.Nonce))
+}
+
+// Identity satisfies the Provider interface. The user's identity is taken
+// from the verified ID token. The email address is only kept if the provider
+// says it has verified it, as any provider can claim any address.
+func (p *OIDC) Identity(ctx context.Context, code string, a *Attempt) (*petfind.Identity, error) {
+	t, err := p.exchange(ctx, code, a)
+	if err != nil {
+		return nil, err
+	}
+	if t.IDToken == "" {
+		return nil, fmt.Errorf("token response has no ID token")
+	}
+	c, err := p.verify(ctx, t.IDToken, a.Nonce, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+	id := &petfind.Identity{
+		Provider: p.name,
+		Subject:  c.Subject,
+		Login:    c.PreferredUsername,
+		Name:     c.Name,
+	}
+	if c.EmailVerified {
+		id.Email = c.Email
+	}
+	return id, nil
+}
+
+// claims are the ID token claims we check or use.
+type claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+}
+
+// audience can be either a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// clockSkew is the leeway allowed when checking the token's times.
+const clockSkew = 2 * time.Minute
+
+// verify checks the ID token's signature and claims as described by OpenID
+// Connect Core section 3.1.3.7.
+func (p *OIDC) verify(ctx context.Context, idToken, nonce string, now time.Time) (*claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	c := new(claims)
+	if err := decodeSegment(parts[1], c); err != nil {
+		return nil, fmt.Errorf("malformed claims: %v", err)
+	}
+	if c.Issuer != p.issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", c.Issuer)
+	}
+	if !c.Audience.contains(p.ClientID) {
+		return nil, fmt.Errorf("token not issued for this client")
+	}
+	if len(c.Audience) > 1 && c.AuthorizedParty != p.ClientID {
+		return nil, fmt.Errorf("token authorized for a different party")
+	}
+	if now.Add(-clockSkew).After(time.Unix(c.Expiry, 0)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if now.Add(clockSkew).Before(time.Unix(c.IssuedAt, 0)) {
+		return nil, fmt.Errorf("token issued in the future")
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("token has no subject")
+	}
+	return c, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks the JWS signature of signed. Only asymmetric
+// algorithms are accepted; "none" and the HMAC algorithms, which would let
+// anyone knowing the client secret forge tokens, are rejected.
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig); err != nil {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, sum[:], r, s) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported signing algorithm %q", alg)
+}
+
+// jwksRefresh limits how often the provider's keys are fetched when a token
+// refers to an unknown key.
+const jwksRefresh = time.Minute
+
+// key returns the provider's signing key with ID kid, fetching the provider's
+// key set if the key is not known yet, e.g. after a key rotation.
+func (p *OIDC) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(p.fetched) < jwksRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	keys, err := fetchKeys(ctx, p.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.fetched = time.Now()
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func fetchKeys(ctx context.Context, jwksURL string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := get(ctx, jwksURL, nil, &set); err != nil {
+		return nil, fmt.Errorf("error fetching signing keys: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			// Skip keys we don't understand instead of failing all logins.
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if exp.BitLen() > 31 {
+			return nil, fmt.Errorf("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("EC point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,234 @@
+// Package mail sends the emails of the application such as account
+// verification, password reset and sign in links.
+//
+// Four Senders are provided: SMTPSender for production, FileSender which
+// writes every message to a directory, MaildirSender which delivers them to a
+// local Maildir and LogSender which writes them to the log, all three meant
+// for development and tests.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email with an optional HTML alternative.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	// HTML, if set, is sent alongside Body for mail clients that prefer it.
+	HTML string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(*Message) error
+}
+
+// Validate checks that the message's headers cannot be used for header
+// injection.
+func (m *Message) Validate() error {
+	for _, h := range []string{m.To, m.Subject} {
+		for _, r := range h {
+			if r == '\r' || r == '\n' {
+				return fmt.Errorf("mail header contains a line break")
+			}
+		}
+	}
+	if m.To == "" {
+		return fmt.Errorf("mail has no recipient")
+	}
+	return nil
+}
+
+// Bytes formats the message as an RFC 5322 email sent from from. The body is
+// quoted-printable encoded so that any UTF-8 text survives 7-bit transports.
+// Messages with HTML are sent as multipart/alternative with the plain text
+// first.
+func (m *Message) Bytes(from string, now time.Time) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("error generating message ID: %v", err)
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = strings.Trim(from[i+1:], "> ")
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	if m.HTML == "" {
+		fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+		fmt.Fprintf(&b, "Content-Transfer-Encoding: quoted-printable\r\n")
+		fmt.Fprintf(&b, "\r\n")
+		if err := writeQuotedPrintable(&b, m.Body); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n", mw.Boundary())
+	fmt.Fprintf(&b, "\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Body},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", part.contentType)
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(strings.Replace(body, "\n", "\r\n", -1))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// SMTPSender delivers messages through an SMTP server. The connection is
+// upgraded with STARTTLS when the server supports it and the credentials, if
+// any, are only sent over TLS or to localhost.
+type SMTPSender struct {
+	// Addr is the host:port of the SMTP server.
+	Addr     string
+	Username string
+	Password string
+	// From is the address messages are sent from.
+	From string
+}
+
+// Send satisfies the Sender interface.
+func (s *SMTPSender) Send(m *Message) error {
+	b, err := m.Bytes(s.From, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address: %v", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, b)
+}
+
+// FileSender writes every message as a separate .eml file in Dir, where it
+// can be opened with any mail client.
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+// Send satisfies the Sender interface.
+func (s *FileSender) Send(m *Message) error {
+	now := time.Now()
+	b, err := m.Bytes(s.From, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+	return ioutil.WriteFile(filepath.Join(s.Dir, name), b, 0600)
+}
+
+// MaildirSender delivers every message to the Maildir at Dir, e.g. to read
+// them with mutt -f Dir. Messages are written to Dir/tmp and then moved to
+// Dir/new so that readers never see a partial message.
+type MaildirSender struct {
+	Dir  string
+	From string
+}
+
+// Send satisfies the Sender interface.
+func (s *MaildirSender) Send(m *Message) error {
+	now := time.Now()
+	b, err := m.Bytes(s.From, now)
+	if err != nil {
+		return err
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(s.Dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	// Unique names as recommended by the Maildir specification (Bernstein
+	// 2000), with slashes and colons in the host name escaped.
+	host = strings.NewReplacer("/", "\\057", ":", "\\072").Replace(host)
+	name := fmt.Sprintf("%d.M%dR%s.%s", now.Unix(), now.Nanosecond()/1000, hex.EncodeToString(suffix), host)
+	tmp := filepath.Join(s.Dir, "tmp", name)
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.Dir, "new", name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// LogSender writes messages to the standard logger instead of delivering them.
+// It is meant for development where no mail server is available.
+type LogSender struct{}
+
+// Send satisfies the Sender interface.
+func (LogSender) Send(m *Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	log.Printf("mail to %s: %s\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
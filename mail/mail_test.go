@@ -0,0 +1,134 @@
+package mail
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageBytes(t *testing.T) {
+	m := &Message{To: "jane@doe.com", Subject: "Καλημέρα", Body: "Follow this link:\n\nhttps://petfind.example/login?t=abc=def\n"}
+	b, err := m.Bytes("petfind <noreply@petfind.example>", time.Now())
+	if err != nil {
+		t.Fatal("Bytes failed:", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal("could not parse message:", err)
+	}
+	if got, want := msg.Header.Get("To"), "jane@doe.com"; got != want {
+		t.Errorf("To = %q, want %q", got, want)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Καλημέρα" {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, "Καλημέρα")
+	}
+	if !strings.HasSuffix(msg.Header.Get("Message-ID"), "@petfind.example>") {
+		t.Errorf("unexpected Message-ID %q", msg.Header.Get("Message-ID"))
+	}
+}
+
+func TestMessageBytesHTML(t *testing.T) {
+	m := &Message{To: "jane@doe.com", Subject: "hi", Body: "Hello Jane", HTML: "<p>Hello <b>Jane</b></p>"}
+	b, err := m.Bytes("noreply@petfind.example", time.Now())
+	if err != nil {
+		t.Fatal("Bytes failed:", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal("could not parse message:", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v), want multipart/alternative", msg.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "Hello Jane"},
+		{"text/html; charset=utf-8", "<p>Hello <b>Jane</b></p>"},
+	} {
+		// The reader decodes quoted-printable parts by itself.
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatal("could not read part:", err)
+		}
+		body, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatal("could not read part body:", err)
+		}
+		if got := p.Header.Get("Content-Type"); got != want.contentType {
+			t.Errorf("part Content-Type = %q, want %q", got, want.contentType)
+		}
+		if string(body) != want.body {
+			t.Errorf("part body = %q, want %q", body, want.body)
+		}
+	}
+}
+
+func TestMessageValidate(t *testing.T) {
+	for _, m := range []*Message{
+		{To: "jane@doe.com\r\nBcc: victim@example.com", Subject: "hi"},
+		{To: "jane@doe.com", Subject: "hi\nBcc: victim@example.com"},
+		{Subject: "hi"},
+	} {
+		if err := m.Validate(); err == nil {
+			t.Errorf("Validate accepted %#v", m)
+		}
+		if _, err := m.Bytes("noreply@petfind.example", time.Now()); err == nil {
+			t.Errorf("Bytes accepted %#v", m)
+		}
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &FileSender{Dir: filepath.Join(dir, "out"), From: "noreply@petfind.example"}
+	for i := 0; i < 2; i++ {
+		if err := s.Send(&Message{To: "jane@doe.com", Subject: "hi", Body: "hello"}); err != nil {
+			t.Fatal("Send failed:", err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "out", "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("FileSender wrote %d files, expected 2", len(files))
+	}
+}
+
+func TestMaildirSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &MaildirSender{Dir: dir, From: "noreply@petfind.example"}
+	for i := 0; i < 2; i++ {
+		if err := s.Send(&Message{To: "jane@doe.com", Subject: "hi", Body: "hello"}); err != nil {
+			t.Fatal("Send failed:", err)
+		}
+	}
+	for sub, want := range map[string]int{"new": 2, "tmp": 0, "cur": 0} {
+		files, err := ioutil.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != want {
+			t.Errorf("MaildirSender left %d files in %s, expected %d", len(files), sub, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package notification delivers the emails of the application reliably.
+//
+// Emails are not sent while handling a request. The Outbox stores them in the
+// database instead and a Worker sends them in the background through a
+// mail.Sender, retrying failed attempts with exponential backoff. Emails that
+// keep failing are dead-lettered: they stay in the outbox, marked as dead,
+// for an administrator to look into. Sent and dead emails lose their body as
+// it can hold sign in links and other secrets.
+//
+// Emails can be written as templates, a text one and optionally an HTML one,
+// which are rendered into messages by Templates.
+package notification
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// Outbox queues emails to be sent by a Worker. It satisfies mail.Sender so it
+// can be used anywhere a sender is expected.
+type Outbox struct {
+	Store petfind.Store
+}
+
+// Send validates m and puts it in the outbox.
+func (o *Outbox) Send(m *mail.Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	n := &petfind.Notification{To: m.To, Subject: m.Subject, Body: m.Body, HTML: m.HTML}
+	if err := o.Store.AddNotification(n); err != nil {
+		return fmt.Errorf("error adding email to outbox: %v", err)
+	}
+	return nil
+}
+
+const (
+	// DefaultMaxAttempts is how many times an email is tried before it is
+	// dead-lettered, spanning about a day with the default backoff.
+	DefaultMaxAttempts = 10
+	// DefaultBatch is how many emails a worker claims at a time.
+	DefaultBatch = 20
+	// lease is how long claimed emails are held by a worker before another
+	// one can try them, in case the first dies while sending.
+	lease = 5 * time.Minute
+	// firstRetry and maxRetry bound the time between attempts.
+	firstRetry = time.Minute
+	maxRetry   = 6 * time.Hour
+)
+
+// Backoff returns how long to wait before trying an email again after it
+// failed attempts times. The wait doubles after every attempt, starting at a
+// minute and up to six hours.
+func Backoff(attempts int64) time.Duration {
+	d := firstRetry
+	for i := int64(1); i < attempts; i++ {
+		d *= 2
+		if d >= maxRetry {
+			return maxRetry
+		}
+	}
+	return d
+}
+
+// Worker sends the emails of the outbox.
+type Worker struct {
+	Store  petfind.Store
+	Sender mail.Sender
+	// MaxAttempts is how many times an email is tried before it is
+	// dead-lettered. If 0, DefaultMaxAttempts is used.
+	MaxAttempts int64
+	// Batch is how many emails are claimed at a time. If 0, DefaultBatch is
+	// used.
+	Batch int
+}
+
+// Run sends the emails that are due until none is left and returns how many
+// were sent and how many failed. Failed emails are scheduled to be tried
+// again or, after too many attempts, marked as dead.
+func (w *Worker) Run(now time.Time) (sent, failed int, err error) {
+	maxAttempts := w.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	batch := w.Batch
+	if batch == 0 {
+		batch = DefaultBatch
+	}
+	for {
+		notifications, err := w.Store.ClaimNotifications(batch, lease)
+		if err != nil {
+			return sent, failed, fmt.Errorf("error claiming emails: %v", err)
+		}
+		if len(notifications) == 0 {
+			return sent, failed, nil
+		}
+		for _, n := range notifications {
+			m := &mail.Message{To: n.To, Subject: n.Subject, Body: n.Body, HTML: n.HTML}
+			n.Attempts++
+			if err := w.Sender.Send(m); err != nil {
+				failed++
+				n.LastError = err.Error()
+				n.NextAttempt = now.Add(Backoff(n.Attempts))
+				if n.Attempts >= maxAttempts {
+					n.Status = petfind.NotificationDead
+					log.Printf("email %d to %s is dead after %d attempts: %v", n.ID, n.To, n.Attempts, err)
+				}
+			} else {
+				sent++
+				n.Status = petfind.NotificationSent
+				n.LastError = ""
+			}
+			if err := w.Store.UpdateNotification(n); err != nil {
+				return sent, failed, fmt.Errorf("error updating email %d: %v", n.ID, err)
+			}
+		}
+		if len(notifications) < batch {
+			return sent, failed, nil
+		}
+	}
+}
+
+// Start runs the worker every interval until the program exits.
+func (w *Worker) Start(interval time.Duration) {
+	go func() {
+		for now := range time.Tick(interval) {
+			sent, failed, err := w.Run(now)
+			if err != nil {
+				log.Println("Sending emails failed:", err)
+			}
+			if failed != 0 {
+				log.Printf("Sent %d emails, %d failed", sent, failed)
+			}
+		}
+	}()
+}
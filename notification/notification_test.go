@@ -0,0 +1,150 @@
+package notification
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// outboxStore keeps the outbox in memory. Like the database it only hands out
+// pending emails that are due.
+type outboxStore struct {
+	petfind.Store
+	now           time.Time
+	notifications []*petfind.Notification
+}
+
+func (s *outboxStore) AddNotification(n *petfind.Notification) error {
+	n.ID = int64(len(s.notifications) + 1)
+	n.NextAttempt = s.now
+	s.notifications = append(s.notifications, n)
+	return nil
+}
+
+func (s *outboxStore) ClaimNotifications(limit int, lease time.Duration) ([]*petfind.Notification, error) {
+	var claimed []*petfind.Notification
+	for _, n := range s.notifications {
+		if len(claimed) == limit {
+			break
+		}
+		if n.Status == petfind.NotificationPending && !n.NextAttempt.After(s.now) {
+			n.NextAttempt = s.now.Add(lease)
+			c := *n
+			claimed = append(claimed, &c)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *outboxStore) UpdateNotification(n *petfind.Notification) error {
+	*s.notifications[n.ID-1] = *n
+	return nil
+}
+
+// flakySender fails for the recipients in down.
+type flakySender struct {
+	down map[string]bool
+	sent []*mail.Message
+}
+
+func (s *flakySender) Send(m *mail.Message) error {
+	if s.down[m.To] {
+		return errors.New("mailbox unavailable")
+	}
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func TestBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		attempts int64
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	} {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestWorker(t *testing.T) {
+	now := time.Now()
+	store := &outboxStore{now: now}
+	outbox := &Outbox{Store: store}
+	for _, to := range []string{"jane@doe.com", "john@doe.com", "nick@doe.com"} {
+		if err := outbox.Send(&mail.Message{To: to, Subject: "hi", Body: "hello", HTML: "<p>hello</p>"}); err != nil {
+			t.Fatalf("Outbox.Send failed: %v", err)
+		}
+	}
+	if err := outbox.Send(&mail.Message{To: "jane@doe.com\r\nBcc: victim@example.com", Subject: "hi"}); err == nil {
+		t.Fatalf("Outbox.Send accepted an invalid message")
+	}
+
+	sender := &flakySender{down: map[string]bool{"john@doe.com": true}}
+	w := &Worker{Store: store, Sender: sender, MaxAttempts: 3, Batch: 2}
+	sent, failed, err := w.Run(now)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if sent != 2 || failed != 1 || len(sender.sent) != 2 || sender.sent[0].HTML != "<p>hello</p>" {
+		t.Fatalf("Run sent %d, failed %d, delivered %#v", sent, failed, sender.sent)
+	}
+	john := store.notifications[1]
+	if john.Status != petfind.NotificationPending || john.Attempts != 1 || john.LastError != "mailbox unavailable" || !john.NextAttempt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("failed email = %#v", john)
+	}
+
+	// The failed email is not retried before its backoff is over.
+	if sent, failed, err = w.Run(now); err != nil || sent+failed != 0 {
+		t.Fatalf("Run before backoff = %d, %d, %v", sent, failed, err)
+	}
+	for attempt := 2; attempt <= 3; attempt++ {
+		store.now = john.NextAttempt
+		if _, failed, err = w.Run(store.now); err != nil || failed != 1 {
+			t.Fatalf("Run for attempt %d = %d, %v", attempt, failed, err)
+		}
+	}
+	if john.Status != petfind.NotificationDead || john.Attempts != 3 {
+		t.Fatalf("email after %d attempts = %#v, expected it to be dead", john.Attempts, john)
+	}
+	// Dead emails are not tried again.
+	store.now = john.NextAttempt.Add(time.Hour)
+	if sent, failed, err = w.Run(store.now); err != nil || sent+failed != 0 {
+		t.Fatalf("Run after dead-lettering = %d, %d, %v", sent, failed, err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	templates, err := ParseTemplates("../web/templates/email")
+	if err != nil {
+		t.Fatalf("ParseTemplates failed: %v", err)
+	}
+	data := struct{ Name, Link string }{"Jane <b>", "https://petfind.example.com/email/verify?t=a&b"}
+	m, err := templates.Render("verify", "jane@doe.com", data)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if m.To != "jane@doe.com" || m.Subject != "Verify your petfind email address" {
+		t.Errorf("Render = %#v", m)
+	}
+	if !strings.HasPrefix(m.Body, "Welcome to petfind, Jane <b>!") || !strings.Contains(m.Body, data.Link) {
+		t.Errorf("Render body = %q", m.Body)
+	}
+	// The HTML alternative is escaped.
+	if !strings.Contains(m.HTML, "Jane &lt;b&gt;") || !strings.Contains(m.HTML, `href="https://petfind.example.com/email/verify?t=a&amp;b"`) {
+		t.Errorf("Render HTML = %q", m.HTML)
+	}
+	if _, err := templates.Render("missing", "jane@doe.com", data); err == nil {
+		t.Errorf("Render of missing template succeeded")
+	}
+}
@@ -0,0 +1,86 @@
+package notification
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/psimika/secure-web-app/mail"
+)
+
+// Templates renders emails. Every email has a text template, name.txt, which
+// must define a "subject" template for the subject line, and optionally an
+// HTML template, name.html, for the HTML alternative of the body.
+type Templates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+// ParseTemplates parses the email templates in dir.
+func ParseTemplates(dir string) (*Templates, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no email templates found in %s", dir)
+	}
+	t := &Templates{
+		text: make(map[string]*texttemplate.Template),
+		html: make(map[string]*htmltemplate.Template),
+	}
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".txt")
+		text, err := texttemplate.ParseFiles(f)
+		if err != nil {
+			return nil, err
+		}
+		if text.Lookup("subject") == nil {
+			return nil, fmt.Errorf("email template %s does not define a subject", f)
+		}
+		t.text[name] = text
+
+		htmlFile := filepath.Join(dir, name+".html")
+		if _, err := os.Stat(htmlFile); os.IsNotExist(err) {
+			continue
+		}
+		html, err := htmltemplate.ParseFiles(htmlFile)
+		if err != nil {
+			return nil, err
+		}
+		t.html[name] = html
+	}
+	return t, nil
+}
+
+// Render renders the email template name with data into a message to to.
+func (t *Templates) Render(name, to string, data interface{}) (*mail.Message, error) {
+	text, ok := t.text[name]
+	if !ok {
+		return nil, fmt.Errorf("no email template named %q", name)
+	}
+	var subject, body bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := text.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	m := &mail.Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimLeft(body.String(), "\n"),
+	}
+	if html, ok := t.html[name]; ok {
+		var b bytes.Buffer
+		if err := html.Execute(&b, data); err != nil {
+			return nil, err
+		}
+		m.HTML = b.String()
+	}
+	return m, nil
+}
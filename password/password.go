@@ -0,0 +1,156 @@
+// Package password hashes and checks the passwords of local accounts.
+//
+// Passwords are hashed with bcrypt as recommended by OWASP (2017e) and are
+// checked against a policy which rejects short passwords and passwords that
+// are known to have appeared in data breaches:
+//
+// https://www.owasp.org/index.php/Password_Storage_Cheat_Sheet
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cost is the bcrypt work factor. Each increment doubles the time needed to
+// hash, for us and for an attacker who got hold of the hashes.
+const Cost = 12
+
+var (
+	ErrMismatch = errors.New("password does not match")
+	ErrTooShort = fmt.Errorf("password must be at least %d characters long", MinLength)
+	ErrTooLong  = fmt.Errorf("password must be at most %d bytes long", MaxLength)
+	ErrBreached = errors.New("password has appeared in a data breach, please choose a different one")
+	ErrContains = errors.New("password must not contain your email address")
+)
+
+const (
+	// MinLength follows NIST SP 800-63B section 5.1.1.2 which recommends at
+	// least 8 characters; we ask for a little more.
+	MinLength = 10
+	// MaxLength is the most bcrypt can use; anything longer would be silently
+	// truncated.
+	MaxLength = 72
+)
+
+// Hash returns the bcrypt hash of password.
+func Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), Cost)
+}
+
+// dummyHash is compared against when there is no user for the given login, so
+// that a login attempt takes the same time whether the account exists or not.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password for timing"), Cost)
+
+// Compare checks password against hash in constant time. A nil hash means
+// there is no such account; the comparison is still performed against a
+// dummy hash and ErrMismatch is returned.
+func Compare(hash []byte, password string) error {
+	if hash == nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return ErrMismatch
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// Policy decides which passwords are acceptable for new accounts and password
+// changes.
+type Policy struct {
+	// Breached is the list of known breached passwords. It can be nil.
+	Breached *BreachList
+}
+
+// Check returns an error explaining why password is not acceptable for the
+// account with the given email, or nil.
+func (p *Policy) Check(password, email string) error {
+	if utf8.RuneCountInString(password) < MinLength {
+		return ErrTooShort
+	}
+	if len(password) > MaxLength {
+		return ErrTooLong
+	}
+	if email != "" && strings.Contains(strings.ToLower(password), strings.ToLower(email)) {
+		return ErrContains
+	}
+	if p.Breached.Contains(password) {
+		return ErrBreached
+	}
+	return nil
+}
+
+// BreachList is a set of passwords known to have been exposed in data
+// breaches. Only their SHA-1 hashes are kept in memory.
+type BreachList struct {
+	hashes map[string]bool
+}
+
+// LoadBreachList reads a breach list from a local file. Each line is either a
+// password in plain text or the uppercase hex SHA-1 of one, optionally
+// followed by ":count" as in the Pwned Passwords downloads. Empty lines and
+// lines starting with # are ignored.
+func LoadBreachList(path string) (*BreachList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &BreachList{hashes: make(map[string]bool)}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if h := strings.SplitN(line, ":", 2)[0]; isSHA1(h) {
+			l.hashes[strings.ToUpper(h)] = true
+			continue
+		}
+		l.hashes[sum(line)] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading breach list: %v", err)
+	}
+	return l, nil
+}
+
+// Contains reports whether password is on the list. A nil list contains
+// nothing.
+func (l *BreachList) Contains(password string) bool {
+	if l == nil {
+		return false
+	}
+	return l.hashes[sum(password)]
+}
+
+// Len returns the number of passwords on the list.
+func (l *BreachList) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.hashes)
+}
+
+func sum(password string) string {
+	s := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(s[:]))
+}
+
+func isSHA1(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,69 @@
+package password
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestHashCompare(t *testing.T) {
+	hash, err := Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal("Hash failed:", err)
+	}
+	if err := Compare(hash, "correct horse battery staple"); err != nil {
+		t.Errorf("Compare with right password returned %v", err)
+	}
+	if err := Compare(hash, "wrong horse battery staple"); err != ErrMismatch {
+		t.Errorf("Compare with wrong password returned %v, expected %v", err, ErrMismatch)
+	}
+	if err := Compare(nil, "correct horse battery staple"); err != ErrMismatch {
+		t.Errorf("Compare with no hash returned %v, expected %v", err, ErrMismatch)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	f, err := ioutil.TempFile("", "breached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	// "password1234" in plain text and "qwertyuiop" as a Pwned Passwords line.
+	list := "# test list\npassword1234\n\nB0399D2029F64D445BD131FFAA399A42D2F8E7DC:3\n"
+	if _, err := f.WriteString(list); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	l, err := LoadBreachList(f.Name())
+	if err != nil {
+		t.Fatal("LoadBreachList failed:", err)
+	}
+	if got, want := l.Len(), 2; got != want {
+		t.Fatalf("breach list has %d passwords, expected %d", got, want)
+	}
+
+	p := &Policy{Breached: l}
+	tests := []struct {
+		password string
+		email    string
+		want     error
+	}{
+		{"short", "", ErrTooShort},
+		{"password1234", "", ErrBreached},
+		{"qwertyuiop", "", ErrBreached},
+		{"xjane@doe.com!", "jane@doe.com", ErrContains},
+		{string(make([]byte, 73)), "", ErrTooLong},
+		{"correct horse battery staple", "jane@doe.com", nil},
+	}
+	for _, tt := range tests {
+		if got := p.Check(tt.password, tt.email); got != tt.want {
+			t.Errorf("Check(%q, %q) = %v, expected %v", tt.password, tt.email, got, tt.want)
+		}
+	}
+
+	// A policy without a list only checks the length.
+	if err := (&Policy{}).Check("password1234", ""); err != nil {
+		t.Errorf("Check without breach list returned %v", err)
+	}
+}
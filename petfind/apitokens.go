@@ -0,0 +1,61 @@
+package petfind
+
+import "time"
+
+// APIScope is what a personal API token is allowed to do.
+type APIScope int64
+
+const (
+	// ScopeRead tokens can only make requests that do not change anything.
+	ScopeRead APIScope = iota
+	// ScopeWrite tokens can also make changes on behalf of their user.
+	ScopeWrite
+)
+
+var apiScopes = [...]string{
+	"read",
+	"write",
+}
+
+// APIScopes are all the scopes a token can have.
+var APIScopes = []APIScope{ScopeRead, ScopeWrite}
+
+// String returns the name of the scope ("read", "write").
+func (s APIScope) String() string {
+	if s < 0 || int(s) >= len(apiScopes) {
+		return "unknown"
+	}
+	return apiScopes[s]
+}
+
+// Allows reports whether a token with scope s may make a request that needs
+// scope required. Write tokens can read as well.
+func (s APIScope) Allows(required APIScope) bool {
+	return s >= required && int(s) < len(apiScopes)
+}
+
+// APIToken is a personal access token a user creates to use the API from a
+// script or another application. As with Token, only the SHA-256 hash of the
+// secret is stored.
+type APIToken struct {
+	ID     int64
+	UserID int64
+	// Name is chosen by the user to tell their tokens apart.
+	Name string
+	// Prefix is the start of the token, shown so that a token found in a
+	// script or a log can be matched to its entry in the settings.
+	Prefix  string
+	Hash    string
+	Scope   APIScope
+	Created time.Time
+	Expires time.Time
+	// LastUsed is zero if the token has never been used.
+	LastUsed time.Time
+	// LastIP is the address the token was last used from.
+	LastIP string
+}
+
+// Expired reports whether the token has expired at t.
+func (t *APIToken) Expired(at time.Time) bool {
+	return !at.Before(t.Expires)
+}
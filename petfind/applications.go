@@ -0,0 +1,224 @@
+package petfind
+
+import (
+	"errors"
+	"time"
+)
+
+// ApplicationStatus is where an adoption application stands.
+type ApplicationStatus int64
+
+const (
+	// ApplicationSubmitted applications wait for the owner to look at them.
+	ApplicationSubmitted ApplicationStatus = iota
+	// ApplicationUnderReview applications are being considered by the
+	// owner.
+	ApplicationUnderReview
+	// ApplicationApproved applications were accepted by the owner.
+	ApplicationApproved
+	// ApplicationDeclined applications were turned down by the owner.
+	ApplicationDeclined
+	// ApplicationWithdrawn applications were taken back by the applicant.
+	ApplicationWithdrawn
+)
+
+var applicationStatuses = [...]string{
+	"Submitted",
+	"Under review",
+	"Approved",
+	"Declined",
+	"Withdrawn",
+}
+
+// String returns the English name of the status ("Submitted", ...).
+func (s ApplicationStatus) String() string {
+	if s < 0 || int(s) >= len(applicationStatuses) {
+		return "Unknown"
+	}
+	return applicationStatuses[s]
+}
+
+// Open reports whether the application is still waiting for a decision.
+func (s ApplicationStatus) Open() bool {
+	return s == ApplicationSubmitted || s == ApplicationUnderReview
+}
+
+// Housing is the kind of home an applicant lives in.
+type Housing int64
+
+const (
+	HousingApartment Housing = iota + 1
+	HousingHouse
+	HousingHouseWithYard
+	HousingFarm
+	HousingOther
+)
+
+// Housings are the options applicants can choose from, in the order they are
+// shown.
+var Housings = []Housing{HousingApartment, HousingHouse, HousingHouseWithYard, HousingFarm, HousingOther}
+
+var housings = [...]string{
+	"Unknown",
+	"Apartment",
+	"House",
+	"House with a yard",
+	"Farm",
+	"Other",
+}
+
+// String returns the English description of the housing.
+func (h Housing) String() string {
+	if !h.Valid() {
+		return housings[0]
+	}
+	return housings[h]
+}
+
+// Valid reports whether h is one of Housings.
+func (h Housing) Valid() bool { return h >= HousingApartment && h <= HousingOther }
+
+// Experience is how much experience an applicant has with pets.
+type Experience int64
+
+const (
+	ExperienceNone Experience = iota + 1
+	ExperienceSome
+	ExperienceLots
+)
+
+// Experiences are the options applicants can choose from, in the order they
+// are shown.
+var Experiences = []Experience{ExperienceNone, ExperienceSome, ExperienceLots}
+
+var experiences = [...]string{
+	"Unknown",
+	"This would be my first pet",
+	"I have had pets before",
+	"I have cared for many pets",
+}
+
+// String returns the English description of the experience.
+func (e Experience) String() string {
+	if !e.Valid() {
+		return experiences[0]
+	}
+	return experiences[e]
+}
+
+// Valid reports whether e is one of Experiences.
+func (e Experience) Valid() bool { return e >= ExperienceNone && e <= ExperienceLots }
+
+// Application is a request of a user to adopt a pet, sent to the pet's owner.
+type Application struct {
+	ID            int64
+	PetID         int64
+	PetName       string
+	OwnerID       int64
+	ApplicantID   int64
+	ApplicantName string
+	Status        ApplicationStatus
+	Housing       Housing
+	// Adults and Children are how many people live in the household.
+	Adults     int64
+	Children   int64
+	Experience Experience
+	// OtherPets describes the pets the applicant already has, if any.
+	OtherPets string
+	// Message is what the applicant wants to tell the owner.
+	Message string
+	Created time.Time
+	Updated time.Time
+}
+
+// ErrApplied is returned when a user applies for a pet while they still have
+// an open application for it.
+var ErrApplied = errors.New("open application for pet already exists")
+
+// ErrApplicationTransition is returned when an action does not apply to the
+// application's current status, e.g. approving a withdrawn application.
+var ErrApplicationTransition = errors.New("action does not apply to the application's status")
+
+// ErrPetUnavailable is returned when approving an application for a pet that
+// is not listed, e.g. because another application for it was approved.
+var ErrPetUnavailable = errors.New("pet is not available for adoption")
+
+// ErrNotAllowed is returned when a user tries an action reserved for someone
+// else, e.g. an applicant approving their own application.
+var ErrNotAllowed = errors.New("action not allowed for user")
+
+// ApplicationAction is what the owner or the applicant does to an
+// application.
+type ApplicationAction string
+
+const (
+	// ApplicationReview marks a submitted application as under review.
+	ApplicationReview ApplicationAction = "review"
+	// ApplicationApprove accepts an open application.
+	ApplicationApprove ApplicationAction = "approve"
+	// ApplicationDecline turns down an open application.
+	ApplicationDecline ApplicationAction = "decline"
+	// ApplicationWithdraw takes back an open application.
+	ApplicationWithdraw ApplicationAction = "withdraw"
+)
+
+// Apply returns the status an application with status from has after the
+// action. ErrApplicationTransition is returned if the action is not allowed
+// on such an application.
+func (a ApplicationAction) Apply(from ApplicationStatus) (ApplicationStatus, error) {
+	switch {
+	case a == ApplicationReview && from == ApplicationSubmitted:
+		return ApplicationUnderReview, nil
+	case a == ApplicationApprove && from.Open():
+		return ApplicationApproved, nil
+	case a == ApplicationDecline && from.Open():
+		return ApplicationDeclined, nil
+	case a == ApplicationWithdraw && from.Open():
+		return ApplicationWithdrawn, nil
+	}
+	return from, ErrApplicationTransition
+}
+
+// AllowedFor reports whether the user with userID may take the action on app.
+// Only the owner of the pet reviews, approves and declines and only the
+// applicant withdraws.
+func (a ApplicationAction) AllowedFor(app *Application, userID int64) bool {
+	if userID == 0 {
+		return false
+	}
+	switch a {
+	case ApplicationReview, ApplicationApprove, ApplicationDecline:
+		return userID == app.OwnerID
+	case ApplicationWithdraw:
+		return userID == app.ApplicantID
+	}
+	return false
+}
+
+// Transition takes the action of the user with userID on the application and
+// updates its status. ErrNotAllowed is returned if the action is not the
+// user's to take and ErrApplicationTransition if it does not apply to the
+// application's status.
+func (app *Application) Transition(a ApplicationAction, userID int64) error {
+	if !a.AllowedFor(app, userID) {
+		return ErrNotAllowed
+	}
+	to, err := a.Apply(app.Status)
+	if err != nil {
+		return err
+	}
+	app.Status = to
+	return nil
+}
+
+// Actions returns the actions the user with userID can take on the
+// application in its current status.
+func (app *Application) Actions(userID int64) []ApplicationAction {
+	var actions []ApplicationAction
+	for _, a := range []ApplicationAction{ApplicationReview, ApplicationApprove, ApplicationDecline, ApplicationWithdraw} {
+		if _, err := a.Apply(app.Status); err == nil && a.AllowedFor(app, userID) {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
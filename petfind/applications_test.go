@@ -0,0 +1,82 @@
+package petfind
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplicationActionApply(t *testing.T) {
+	tests := []struct {
+		action  ApplicationAction
+		from    ApplicationStatus
+		want    ApplicationStatus
+		wantErr error
+	}{
+		{ApplicationReview, ApplicationSubmitted, ApplicationUnderReview, nil},
+		{ApplicationApprove, ApplicationSubmitted, ApplicationApproved, nil},
+		{ApplicationApprove, ApplicationUnderReview, ApplicationApproved, nil},
+		{ApplicationDecline, ApplicationSubmitted, ApplicationDeclined, nil},
+		{ApplicationDecline, ApplicationUnderReview, ApplicationDeclined, nil},
+		{ApplicationWithdraw, ApplicationSubmitted, ApplicationWithdrawn, nil},
+		{ApplicationWithdraw, ApplicationUnderReview, ApplicationWithdrawn, nil},
+		// Decisions are final.
+		{ApplicationReview, ApplicationUnderReview, ApplicationUnderReview, ErrApplicationTransition},
+		{ApplicationReview, ApplicationApproved, ApplicationApproved, ErrApplicationTransition},
+		{ApplicationApprove, ApplicationDeclined, ApplicationDeclined, ErrApplicationTransition},
+		{ApplicationApprove, ApplicationWithdrawn, ApplicationWithdrawn, ErrApplicationTransition},
+		{ApplicationDecline, ApplicationApproved, ApplicationApproved, ErrApplicationTransition},
+		{ApplicationWithdraw, ApplicationApproved, ApplicationApproved, ErrApplicationTransition},
+		{ApplicationWithdraw, ApplicationDeclined, ApplicationDeclined, ErrApplicationTransition},
+		{ApplicationAction("bogus"), ApplicationSubmitted, ApplicationSubmitted, ErrApplicationTransition},
+	}
+	for _, tt := range tests {
+		got, err := tt.action.Apply(tt.from)
+		if got != tt.want || err != tt.wantErr {
+			t.Errorf("%s.Apply(%v) = %v, %v; want %v, %v", tt.action, tt.from, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestApplicationTransition(t *testing.T) {
+	const owner, applicant, other = 1, 2, 3
+	tests := []struct {
+		action  ApplicationAction
+		userID  int64
+		want    ApplicationStatus
+		wantErr error
+	}{
+		{ApplicationReview, owner, ApplicationUnderReview, nil},
+		{ApplicationApprove, owner, ApplicationApproved, nil},
+		{ApplicationDecline, owner, ApplicationDeclined, nil},
+		{ApplicationWithdraw, applicant, ApplicationWithdrawn, nil},
+		// Applicants cannot decide on their own applications.
+		{ApplicationReview, applicant, ApplicationSubmitted, ErrNotAllowed},
+		{ApplicationApprove, applicant, ApplicationSubmitted, ErrNotAllowed},
+		// Owners cannot withdraw in place of the applicant.
+		{ApplicationWithdraw, owner, ApplicationSubmitted, ErrNotAllowed},
+		{ApplicationApprove, other, ApplicationSubmitted, ErrNotAllowed},
+		{ApplicationWithdraw, other, ApplicationSubmitted, ErrNotAllowed},
+		{ApplicationApprove, 0, ApplicationSubmitted, ErrNotAllowed},
+	}
+	for _, tt := range tests {
+		app := &Application{OwnerID: owner, ApplicantID: applicant, Status: ApplicationSubmitted}
+		err := app.Transition(tt.action, tt.userID)
+		if app.Status != tt.want || err != tt.wantErr {
+			t.Errorf("Transition(%s, %d) = %v, status %v; want %v, status %v", tt.action, tt.userID, err, app.Status, tt.wantErr, tt.want)
+		}
+	}
+}
+
+func TestApplicationActions(t *testing.T) {
+	app := &Application{OwnerID: 1, ApplicantID: 2, Status: ApplicationSubmitted}
+	if got, want := app.Actions(1), []ApplicationAction{ApplicationReview, ApplicationApprove, ApplicationDecline}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Actions(owner) = %v, want %v", got, want)
+	}
+	if got, want := app.Actions(2), []ApplicationAction{ApplicationWithdraw}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Actions(applicant) = %v, want %v", got, want)
+	}
+	app.Status = ApplicationDeclined
+	if got := app.Actions(1); len(got) != 0 {
+		t.Errorf("Actions(owner) of declined application = %v, want none", got)
+	}
+}
@@ -0,0 +1,109 @@
+package petfind
+
+import "time"
+
+// AuditKind is the kind of a security relevant event.
+type AuditKind string
+
+const (
+	// AuditLogin is recorded when a user logs in. The event's detail is
+	// how they logged in, e.g. "github" or "password".
+	AuditLogin AuditKind = "login"
+	// AuditLoginFailed is recorded when a login attempt fails.
+	AuditLoginFailed AuditKind = "login_failed"
+	// AuditLogout is recorded when a user logs out.
+	AuditLogout AuditKind = "logout"
+	// AuditSessionInvalid is recorded when a session is rejected, e.g.
+	// because it expired or was revoked.
+	AuditSessionInvalid AuditKind = "session_invalid"
+	// AuditCSRFFailure is recorded when a request fails the CSRF check.
+	AuditCSRFFailure AuditKind = "csrf_failure"
+	// AuditPetCreate is recorded when a user adds a pet.
+	AuditPetCreate AuditKind = "pet_create"
+	// AuditPetUpdate is recorded when the status of a pet is changed by a
+	// moderator.
+	AuditPetUpdate AuditKind = "pet_update"
+	// AuditPetDelete is recorded when a pet is removed.
+	AuditPetDelete AuditKind = "pet_delete"
+	// AuditApplicationApprove is recorded when the owner of a pet approves
+	// an adoption application and the pet is marked as adopted.
+	AuditApplicationApprove AuditKind = "application_approve"
+	// AuditAdmin is recorded for the actions of administrators.
+	AuditAdmin AuditKind = "admin"
+	// AuditAPIToken is recorded when a user creates or revokes a personal
+	// API token.
+	AuditAPIToken AuditKind = "api_token"
+	// AuditPasskey is recorded when a user adds or removes a passkey.
+	AuditPasskey AuditKind = "passkey"
+	// AuditTwoFactor is recorded when a user turns two-factor authentication
+	// on or off, replaces their recovery codes or uses one.
+	AuditTwoFactor AuditKind = "two_factor"
+	// AuditPasswordReset is recorded when a user sets a new password with a
+	// reset link.
+	AuditPasswordReset AuditKind = "password_reset"
+	// AuditIdentity is recorded when a user links or unlinks the account of a
+	// login provider.
+	AuditIdentity AuditKind = "identity"
+	// AuditSessionRevoke is recorded when a user revokes one or all of their
+	// sessions.
+	AuditSessionRevoke AuditKind = "session_revoke"
+	// AuditRole is recorded when the role of a user is changed. Roles are
+	// only granted with petfindadmin so these events have no actor.
+	AuditRole AuditKind = "role"
+)
+
+// AuditKinds are all the kinds of events, in the order they are offered when
+// filtering the audit log.
+var AuditKinds = []AuditKind{
+	AuditLogin,
+	AuditLoginFailed,
+	AuditLogout,
+	AuditSessionInvalid,
+	AuditCSRFFailure,
+	AuditPetCreate,
+	AuditPetUpdate,
+	AuditPetDelete,
+	AuditApplicationApprove,
+	AuditAdmin,
+	AuditAPIToken,
+	AuditPasskey,
+	AuditTwoFactor,
+	AuditPasswordReset,
+	AuditIdentity,
+	AuditSessionRevoke,
+	AuditRole,
+}
+
+// Valid reports whether k is one of AuditKinds.
+func (k AuditKind) Valid() bool {
+	for _, v := range AuditKinds {
+		if k == v {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditEvent is an entry of the security audit log. Entries are never changed
+// or deleted once added.
+type AuditEvent struct {
+	ID   int64
+	Kind AuditKind
+	// ActorID is the user who caused the event or 0 if they are not known,
+	// e.g. a failed login for an email address that has no account.
+	ActorID   int64
+	ActorName string
+	IP        string
+	UserAgent string
+	// Detail describes the event, e.g. why a session was rejected.
+	Detail  string
+	Created time.Time
+}
+
+// AuditFilter selects the events of the audit log. Zero fields match all
+// events.
+type AuditFilter struct {
+	Kind    AuditKind
+	ActorID int64
+	IP      string
+}
@@ -0,0 +1,141 @@
+package petfind
+
+import (
+	"strings"
+	"time"
+)
+
+// Contact holds the ways adopters can reach the owner of a pet. At least one
+// of Phone and Email is set.
+type Contact struct {
+	// Phone is in E.164 format, for example +302101234567.
+	Phone string
+	Email string
+	// Hours are the times the owner prefers to be contacted, in their own
+	// words.
+	Hours string
+}
+
+// IsZero reports whether c has no way to reach the owner.
+func (c Contact) IsZero() bool { return c.Phone == "" && c.Email == "" }
+
+// String returns the contact methods on a single line for compact listings.
+func (c Contact) String() string {
+	var parts []string
+	for _, part := range []string{c.Phone, c.Email, c.Hours} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+// DefaultCallingCode is the country calling code assumed for phone numbers
+// given without one.
+const DefaultCallingCode = "30"
+
+// NormalizePhone turns a phone number as people write it, for example
+// "(210) 123-4567", "00302101234567" or "+30 210 1234567", into E.164 format.
+// Numbers without an international prefix get DefaultCallingCode. It reports
+// false if the result is not a valid E.164 number.
+func NormalizePhone(phone string) (string, bool) {
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '/':
+			return -1
+		}
+		return r
+	}, phone)
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		digits = digits[1:]
+	case strings.HasPrefix(digits, "00"):
+		digits = digits[2:]
+	default:
+		digits = DefaultCallingCode + digits
+	}
+	// E.164 numbers have at most 15 digits including the country code,
+	// which never starts with 0.
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+	return "+" + digits, true
+}
+
+// ContactVisibility decides who can see the contact details of a pet so that
+// the owners' phone numbers are not trivially scraped.
+type ContactVisibility int64
+
+const (
+	// ContactPublic details are shown to every visitor.
+	ContactPublic ContactVisibility = iota
+	// ContactSignedIn details are shown to logged in users only.
+	ContactSignedIn
+	// ContactOnRequest details are shown to visitors that ask for them.
+	// Every request is logged and the requests of each visitor are rate
+	// limited.
+	ContactOnRequest
+	// ContactAfterReply details are shown to an adopter only after the
+	// owner has replied to their message.
+	ContactAfterReply
+)
+
+// ContactVisibilities are the options owners can choose from, in the order
+// they are shown.
+var ContactVisibilities = []ContactVisibility{ContactPublic, ContactSignedIn, ContactOnRequest, ContactAfterReply}
+
+var contactVisibilities = [...]string{
+	"Everyone",
+	"Signed in users",
+	"Visitors who ask to see them",
+	"People I reply to",
+}
+
+// String returns the English description of who can see the details.
+func (v ContactVisibility) String() string {
+	if !v.Valid() {
+		return "Unknown"
+	}
+	return contactVisibilities[v]
+}
+
+// Valid reports whether v is one of ContactVisibilities.
+func (v ContactVisibility) Valid() bool {
+	return v >= ContactPublic && v <= ContactAfterReply
+}
+
+// ContactShownTo reports whether the contact details of the pet are shown
+// along with the listing to u, who is nil for visitors that are not logged in.
+// Details that are not shown might still be revealed on request or in a
+// conversation.
+func (p Pet) ContactShownTo(u *User) bool {
+	switch {
+	case p.OwnedBy(u):
+		return true
+	case p.ContactVisibility == ContactPublic:
+		return true
+	case p.ContactVisibility == ContactSignedIn:
+		return u != nil
+	}
+	return false
+}
+
+// ContactReveal records that a visitor asked to see the contact details of a
+// pet.
+type ContactReveal struct {
+	ID    int64
+	PetID int64
+	// UserID is the user who asked or 0 for visitors that are not logged
+	// in.
+	UserID int64
+	// VisitorKey identifies the visitor, user or IP address, for rate
+	// limiting.
+	VisitorKey string
+	IP         string
+	Created    time.Time
+}
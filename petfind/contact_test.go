@@ -0,0 +1,92 @@
+package petfind
+
+import "testing"
+
+func TestPetContactShownTo(t *testing.T) {
+	owner := &User{ID: 1}
+	user := &User{ID: 2}
+
+	tests := []struct {
+		visibility ContactVisibility
+		visitor    bool
+		user       bool
+	}{
+		{ContactPublic, true, true},
+		{ContactSignedIn, false, true},
+		{ContactOnRequest, false, false},
+		{ContactAfterReply, false, false},
+	}
+	for _, tt := range tests {
+		p := Pet{OwnerID: owner.ID, ContactVisibility: tt.visibility}
+		if got := p.ContactShownTo(nil); got != tt.visitor {
+			t.Errorf("%v: ContactShownTo(visitor) = %v, want %v", tt.visibility, got, tt.visitor)
+		}
+		if got := p.ContactShownTo(user); got != tt.user {
+			t.Errorf("%v: ContactShownTo(user) = %v, want %v", tt.visibility, got, tt.user)
+		}
+		if !p.ContactShownTo(owner) {
+			t.Errorf("%v: ContactShownTo(owner) = false", tt.visibility)
+		}
+	}
+}
+
+func TestContactVisibilityValid(t *testing.T) {
+	for _, v := range ContactVisibilities {
+		if !v.Valid() {
+			t.Errorf("%v is not valid", v)
+		}
+	}
+	for _, v := range []ContactVisibility{-1, ContactAfterReply + 1} {
+		if v.Valid() {
+			t.Errorf("%d is valid", v)
+		}
+		if v.String() != "Unknown" {
+			t.Errorf("String of %d = %q, want Unknown", v, v.String())
+		}
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"2101234567", "+302101234567", true},
+		{"(210) 123-4567", "+302101234567", true},
+		{"+30 210 1234567", "+302101234567", true},
+		{"00302101234567", "+302101234567", true},
+		{"+1 (415) 555-0100", "+14155550100", true},
+		{"+44.20.7946.0958", "+442079460958", true},
+		{"", "", false},
+		{"+", "", false},
+		{"+0123456789", "", false},
+		{"+1234567", "", false},
+		{"+1234567890123456", "", false},
+		{"210 CALL ME", "", false},
+		{"+30 210 123 4567 ext 12", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := NormalizePhone(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("NormalizePhone(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestContactString(t *testing.T) {
+	tests := []struct {
+		c    Contact
+		want string
+	}{
+		{Contact{}, ""},
+		{Contact{Phone: "+302101234567"}, "+302101234567"},
+		{Contact{Email: "jane@example.com", Hours: "Evenings"}, "jane@example.com · Evenings"},
+		{Contact{Phone: "+302101234567", Email: "jane@example.com", Hours: "Evenings"}, "+302101234567 · jane@example.com · Evenings"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
@@ -59,3 +59,88 @@ func (p *PetGender) Scan(value interface{}) error {
 	}
 	return fmt.Errorf("cannot scan PetGender value")
 }
+
+func (p PetStatus) Value() (driver.Value, error) { return int64(p), nil }
+func (p *PetStatus) Scan(value interface{}) error {
+	if value == nil {
+		*p = PetListed
+		return nil
+	}
+	if v, ok := value.(int64); ok {
+		*p = PetStatus(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan PetStatus value")
+}
+
+func (c ContactVisibility) Value() (driver.Value, error) { return int64(c), nil }
+func (c *ContactVisibility) Scan(value interface{}) error {
+	if v, ok := value.(int64); ok {
+		*c = ContactVisibility(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan ContactVisibility value")
+}
+
+func (r ReportReason) Value() (driver.Value, error) { return int64(r), nil }
+func (r *ReportReason) Scan(value interface{}) error {
+	if v, ok := value.(int64); ok {
+		*r = ReportReason(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan ReportReason value")
+}
+
+func (s ApplicationStatus) Value() (driver.Value, error) { return int64(s), nil }
+func (s *ApplicationStatus) Scan(value interface{}) error {
+	if v, ok := value.(int64); ok {
+		*s = ApplicationStatus(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan ApplicationStatus value")
+}
+
+func (h Housing) Value() (driver.Value, error) { return int64(h), nil }
+func (h *Housing) Scan(value interface{}) error {
+	if v, ok := value.(int64); ok {
+		*h = Housing(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan Housing value")
+}
+
+func (e Experience) Value() (driver.Value, error) { return int64(e), nil }
+func (e *Experience) Scan(value interface{}) error {
+	if v, ok := value.(int64); ok {
+		*e = Experience(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan Experience value")
+}
+
+func (s NotificationStatus) Value() (driver.Value, error) { return int64(s), nil }
+func (s *NotificationStatus) Scan(value interface{}) error {
+	if v, ok := value.(int64); ok {
+		*s = NotificationStatus(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan NotificationStatus value")
+}
+
+func (s DeliveryStatus) Value() (driver.Value, error) { return int64(s), nil }
+func (s *DeliveryStatus) Scan(value interface{}) error {
+	if v, ok := value.(int64); ok {
+		*s = DeliveryStatus(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan DeliveryStatus value")
+}
+
+func (s APIScope) Value() (driver.Value, error) { return int64(s), nil }
+func (s *APIScope) Scan(value interface{}) error {
+	if v, ok := value.(int64); ok {
+		*s = APIScope(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan APIScope value")
+}
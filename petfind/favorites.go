@@ -0,0 +1,19 @@
+package petfind
+
+// PetEvent is something that happened to a pet which the users who saved it
+// as a favorite and the webhooks are told about.
+type PetEvent string
+
+const (
+	// PetCreated is when a new pet is listed, either right away or once a
+	// moderator approves it.
+	PetCreated PetEvent = "created"
+	// PetUpdated is when the listing of the pet changes, for example when a
+	// moderator hides or restores it.
+	PetUpdated PetEvent = "updated"
+	// PetAdopted is when the owner approves an application to adopt the
+	// pet.
+	PetAdopted PetEvent = "adopted"
+	// PetRemoved is when the listing of the pet is removed.
+	PetRemoved PetEvent = "removed"
+)
@@ -0,0 +1,47 @@
+package petfind
+
+import "time"
+
+// Conversation is the thread of messages between the owner of a pet and an
+// adopter interested in it. There is at most one conversation for each pet
+// and adopter.
+type Conversation struct {
+	ID          int64
+	PetID       int64
+	PetName     string
+	OwnerID     int64
+	OwnerName   string
+	AdopterID   int64
+	AdopterName string
+	// OwnerReplied is true once the owner has sent a message. The contact
+	// details of the pet are shown to the adopter after that.
+	OwnerReplied bool
+	Created      time.Time
+	// Updated is when the last message was sent.
+	Updated time.Time
+	// Unread is true if there are messages the user who fetched the
+	// conversation has not seen yet.
+	Unread bool
+}
+
+// HasParticipant reports whether the user with userID takes part in the
+// conversation.
+func (c *Conversation) HasParticipant(userID int64) bool {
+	return userID != 0 && (userID == c.OwnerID || userID == c.AdopterID)
+}
+
+// ContactVisibleTo reports whether the contact details of pet may be shown to
+// the user with userID in the conversation about it.
+func (c *Conversation) ContactVisibleTo(pet *Pet, userID int64) bool {
+	return pet.ContactVisibility <= ContactSignedIn || userID == c.OwnerID || c.OwnerReplied
+}
+
+// Message is a message of a conversation.
+type Message struct {
+	ID             int64
+	ConversationID int64
+	SenderID       int64
+	SenderName     string
+	Body           string
+	Created        time.Time
+}
@@ -0,0 +1,32 @@
+package petfind
+
+import "testing"
+
+func TestConversationContactVisibleTo(t *testing.T) {
+	c := &Conversation{OwnerID: 1, AdopterID: 2}
+	hidden := &Pet{OwnerID: 1, ContactVisibility: ContactAfterReply}
+	shown := &Pet{OwnerID: 1}
+
+	if !c.ContactVisibleTo(shown, 2) {
+		t.Error("adopter cannot see contact that is not hidden")
+	}
+	if c.ContactVisibleTo(hidden, 2) {
+		t.Error("adopter can see hidden contact before the owner replied")
+	}
+	if !c.ContactVisibleTo(hidden, 1) {
+		t.Error("owner cannot see their own hidden contact")
+	}
+	c.OwnerReplied = true
+	if !c.ContactVisibleTo(hidden, 2) {
+		t.Error("adopter cannot see hidden contact after the owner replied")
+	}
+}
+
+func TestConversationHasParticipant(t *testing.T) {
+	c := &Conversation{OwnerID: 1, AdopterID: 2}
+	for id, want := range map[int64]bool{0: false, 1: true, 2: true, 3: false} {
+		if got := c.HasParticipant(id); got != want {
+			t.Errorf("HasParticipant(%d) = %v, want %v", id, got, want)
+		}
+	}
+}
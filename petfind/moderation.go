@@ -0,0 +1,166 @@
+package petfind
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PetStatus decides whether a pet is shown to the public.
+type PetStatus int64
+
+const (
+	// PetListed pets show up on the home page and in search results.
+	PetListed PetStatus = iota
+	// PetHidden pets have been taken down by a moderator or by enough
+	// reports from visitors.
+	PetHidden
+	// PetPending pets wait for a moderator to approve them before they are
+	// listed.
+	PetPending
+	// PetRejected pets were not approved by a moderator.
+	PetRejected
+	// PetArchived pets were removed by their owner or an administrator.
+	// They are never shown again but are kept so that their reports,
+	// conversations, applications and favorites are not lost with them.
+	PetArchived
+	// PetHomed pets were adopted through an approved application and are
+	// no longer listed.
+	PetHomed
+)
+
+var statuses = [...]string{
+	"Listed",
+	"Hidden",
+	"Pending",
+	"Rejected",
+	"Removed",
+	"Adopted",
+}
+
+// String returns the English name of the pet's status ("Listed", ...).
+func (p PetStatus) String() string {
+	if p < 0 || int(p) >= len(statuses) {
+		return fmt.Sprintf("PetStatus(%d)", p)
+	}
+	return statuses[p]
+}
+
+// ReportReason is why a visitor reported a pet listing.
+type ReportReason int64
+
+const (
+	ReasonSpam ReportReason = iota + 1
+	ReasonScam
+	ReasonOffensive
+	ReasonNotAPet
+	ReasonOther
+)
+
+// ReportReasons are the reasons visitors can choose from, in the order they
+// are shown.
+var ReportReasons = []ReportReason{ReasonSpam, ReasonScam, ReasonOffensive, ReasonNotAPet, ReasonOther}
+
+var reasons = [...]string{
+	"Unknown",
+	"Spam or advertising",
+	"Scam or asking for money",
+	"Offensive content",
+	"Not a pet for adoption",
+	"Other",
+}
+
+// String returns the English description of the reason.
+func (r ReportReason) String() string {
+	if r < 0 || int(r) >= len(reasons) {
+		return reasons[0]
+	}
+	return reasons[r]
+}
+
+// Valid reports whether r is one of ReportReasons.
+func (r ReportReason) Valid() bool {
+	for _, v := range ReportReasons {
+		if r == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Report is a visitor's complaint about a pet listing. Reports stay open
+// until a moderator acts on the listing.
+type Report struct {
+	ID     int64
+	PetID  int64
+	Reason ReportReason
+	// Details is what the visitor wrote about the problem.
+	Details string
+	// ReporterID is the user who reported the listing or 0 for visitors
+	// that are not logged in.
+	ReporterID int64
+	// ReporterKey identifies the reporter, user or visitor, so that each
+	// one counts only once towards hiding the listing.
+	ReporterKey string
+	Created     time.Time
+	Resolved    bool
+}
+
+// ErrReported is returned when the same reporter reports a pet again.
+var ErrReported = errors.New("pet already reported")
+
+// ErrTransition is returned when a moderation action does not apply to the
+// pet's current status, e.g. approving a pet that is already listed.
+var ErrTransition = errors.New("action does not apply to the pet's status")
+
+// ModerationKind is what a moderator did to a listing.
+type ModerationKind string
+
+const (
+	// ModerationHide hides the listing and resolves its reports.
+	ModerationHide ModerationKind = "hide"
+	// ModerationRestore lists a hidden pet again and dismisses its reports.
+	ModerationRestore ModerationKind = "restore"
+	// ModerationDismiss dismisses the reports and leaves the listing as is.
+	ModerationDismiss ModerationKind = "dismiss"
+	// ModerationAutoHide is recorded when a listing is hidden because it
+	// was reported too many times.
+	ModerationAutoHide ModerationKind = "autohide"
+	// ModerationApprove lists a pending pet.
+	ModerationApprove ModerationKind = "approve"
+	// ModerationReject turns down a pending pet.
+	ModerationReject ModerationKind = "reject"
+)
+
+// Apply returns the status a pet with status from has after the action.
+// ErrTransition is returned if the action is not allowed on such a pet.
+func (k ModerationKind) Apply(from PetStatus) (PetStatus, error) {
+	switch {
+	case k == ModerationDismiss:
+		return from, nil
+	case (k == ModerationHide || k == ModerationAutoHide) && from == PetListed:
+		return PetHidden, nil
+	case k == ModerationRestore && from == PetHidden:
+		return PetListed, nil
+	case k == ModerationApprove && from == PetPending:
+		return PetListed, nil
+	case k == ModerationReject && from == PetPending:
+		return PetRejected, nil
+	}
+	return from, ErrTransition
+}
+
+// ModerationAction is an entry of the moderation audit trail.
+type ModerationAction struct {
+	ID    int64
+	PetID int64
+	// PetName is kept so that the trail stays readable after the pet is
+	// removed.
+	PetName string
+	Kind    ModerationKind
+	// ModeratorID is 0 for actions taken automatically.
+	ModeratorID   int64
+	ModeratorName string
+	Note          string
+	Created       time.Time
+}
@@ -0,0 +1,67 @@
+package petfind
+
+import "testing"
+
+func TestReportReasonValid(t *testing.T) {
+	for _, r := range ReportReasons {
+		if !r.Valid() {
+			t.Errorf("%v is not valid", r)
+		}
+	}
+	for _, r := range []ReportReason{0, -1, ReasonOther + 1} {
+		if r.Valid() {
+			t.Errorf("ReportReason(%d) is valid", int64(r))
+		}
+		if got, want := r.String(), "Unknown"; got != want {
+			t.Errorf("ReportReason(%d).String() = %q, want %q", int64(r), got, want)
+		}
+	}
+}
+
+func TestPetStatusString(t *testing.T) {
+	tests := []struct {
+		status PetStatus
+		want   string
+	}{
+		{PetListed, "Listed"},
+		{PetHomed, "Adopted"},
+		{-1, "PetStatus(-1)"},
+		{PetHomed + 1, "PetStatus(6)"},
+	}
+	for _, tt := range tests {
+		if got := tt.status.String(); got != tt.want {
+			t.Errorf("PetStatus(%d).String() = %q, want %q", int64(tt.status), got, tt.want)
+		}
+	}
+}
+
+func TestModerationKindApply(t *testing.T) {
+	tests := []struct {
+		kind    ModerationKind
+		from    PetStatus
+		want    PetStatus
+		wantErr error
+	}{
+		{ModerationHide, PetListed, PetHidden, nil},
+		{ModerationAutoHide, PetListed, PetHidden, nil},
+		{ModerationRestore, PetHidden, PetListed, nil},
+		{ModerationApprove, PetPending, PetListed, nil},
+		{ModerationReject, PetPending, PetRejected, nil},
+		{ModerationDismiss, PetPending, PetPending, nil},
+		{ModerationDismiss, PetHidden, PetHidden, nil},
+		// Restoring or hiding must not skip the approval.
+		{ModerationRestore, PetPending, PetPending, ErrTransition},
+		{ModerationRestore, PetRejected, PetRejected, ErrTransition},
+		{ModerationHide, PetPending, PetPending, ErrTransition},
+		{ModerationApprove, PetListed, PetListed, ErrTransition},
+		{ModerationApprove, PetRejected, PetRejected, ErrTransition},
+		{ModerationReject, PetHidden, PetHidden, ErrTransition},
+		{ModerationKind("bogus"), PetListed, PetListed, ErrTransition},
+	}
+	for _, tt := range tests {
+		got, err := tt.kind.Apply(tt.from)
+		if got != tt.want || err != tt.wantErr {
+			t.Errorf("%s.Apply(%v) = %v, %v; want %v, %v", tt.kind, tt.from, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
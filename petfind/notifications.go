@@ -0,0 +1,52 @@
+package petfind
+
+import "time"
+
+// NotificationStatus is where an email in the outbox stands.
+type NotificationStatus int64
+
+const (
+	// NotificationPending emails wait to be sent, either for the first time
+	// or again after a failed attempt.
+	NotificationPending NotificationStatus = iota
+	// NotificationSent emails were handed to the mail server. Like dead
+	// ones they are kept without their body.
+	NotificationSent
+	// NotificationDead emails failed too many times and are no longer
+	// retried.
+	NotificationDead
+)
+
+var notificationStatuses = [...]string{
+	"Pending",
+	"Sent",
+	"Dead",
+}
+
+// String returns the English name of the status ("Pending", ...).
+func (s NotificationStatus) String() string {
+	if s < 0 || int(s) >= len(notificationStatuses) {
+		return "Unknown"
+	}
+	return notificationStatuses[s]
+}
+
+// Notification is an email in the outbox. Emails are stored before they are
+// sent so that they survive restarts and outages of the mail server.
+type Notification struct {
+	ID      int64
+	To      string
+	Subject string
+	Body    string
+	// HTML is the optional HTML alternative of Body.
+	HTML   string
+	Status NotificationStatus
+	// Attempts is how many times sending the email was tried.
+	Attempts int64
+	// NextAttempt is when the email is due to be sent.
+	NextAttempt time.Time
+	// LastError is why the last attempt failed, if it did.
+	LastError string
+	Created   time.Time
+	Updated   time.Time
+}
@@ -90,18 +90,42 @@ type Pet struct {
 	Gender  PetGender
 	Created time.Time
 	Updated time.Time
-	Contact string
+	Contact Contact
 	Notes   string
 	PhotoID int64
 	OwnerID int64
 	PlaceID int64
-	Owner   *User
-	Place   *Place
+	Status  PetStatus
+	// ContactVisibility decides who can see Contact.
+	ContactVisibility ContactVisibility
+	Owner             *User
+	Place             *Place
 }
 
 // ErrNotFound is returned whenever an item does not exist in the Store.
 var ErrNotFound = errors.New("item not found")
 
+// ErrIdentityLinked is returned when linking an identity that already belongs
+// to a different user.
+var ErrIdentityLinked = errors.New("identity is linked to another user")
+
+// ErrLastIdentity is returned when unlinking the only identity a user can log
+// in with.
+var ErrLastIdentity = errors.New("cannot unlink the last identity")
+
+// ErrEmailTaken is returned when creating a local account with an email
+// address that already has one.
+var ErrEmailTaken = errors.New("email address already has an account")
+
+// ErrMergeCredentials is returned when merging two users who both have a
+// password or both have two-factor authentication turned on, as only one of
+// each can be kept.
+var ErrMergeCredentials = errors.New("both users have a password or two-factor authentication")
+
+// ErrInUse is returned when deleting an item that other items still refer
+// to, such as a place that pets are listed in.
+var ErrInUse = errors.New("item is in use")
+
 // Store describes the operations the application needs for persisting and
 // retrieving data.
 type Store interface {
@@ -111,21 +135,128 @@ type Store interface {
 	SearchPets(Search) ([]*Pet, error)
 	CountPets() (int64, error)
 	GetFeaturedPets() ([]*Pet, error)
+	ArchivePet(petID int64) error
+	ListPets(query string, limit, offset int) ([]*Pet, error)
+	SearchPetsPage(s Search, limit, offset int) ([]*Pet, error)
 
 	CreateUser(*User) error
 	GetUser(userID int64) (*User, error)
-	PutGithubUser(*GithubUser) (*User, error)
-	GetUserByGithubID(githubID int64) (*User, error)
-	PutLinkedinUser(*LinkedinUser) (*User, error)
-	GetUserByLinkedinID(linkedinID string) (*User, error)
+	PutIdentity(*Identity) (*User, error)
+	GetUserByIdentity(provider, subject string) (*User, error)
+	GetUserIdentities(userID int64) ([]*Identity, error)
+	LinkIdentity(userID int64, id *Identity) error
+	UnlinkIdentity(userID, identityID int64) error
+	MergeUsers(primaryID, duplicateID int64) error
+	SetUserRole(userID int64, role Role) error
+	SetUserDisabled(userID int64, disabled bool) error
+	SearchUsers(query string, limit, offset int) ([]*User, error)
+
+	CreateLocalUser(u *User, passwordHash []byte) error
+	GetPassword(userID int64) ([]byte, error)
+	PutPassword(userID int64, passwordHash []byte) error
+	VerifyEmail(userID int64, email string) error
+	ClaimEmail(userID int64, email string) error
+
+	AddToken(*Token) error
+	UseToken(kind, hash string) (*Token, error)
+	CountTokens(kind, email string, since time.Time) (int64, error)
+
+	GetTOTP(userID int64) (*TOTP, error)
+	PutTOTP(*TOTP) error
+	EnableTOTP(userID, step int64, recoveryHashes []string) error
+	UseTOTPStep(userID, step int64) error
+	FailSecondFactor(userID, freeAttempts int64, lockout, maxLockout time.Duration) (lockedUntil time.Time, err error)
+	ClearSecondFactorFailures(userID int64) error
+	DeleteTOTP(userID int64) error
+	UseRecoveryCode(userID int64, hash string) error
+	CountRecoveryCodes(userID int64) (int64, error)
+	PutRecoveryCodes(userID int64, hashes []string) error
+
+	AddWebAuthnCredential(*WebAuthnCredential) error
+	GetWebAuthnCredential(credentialID []byte) (*WebAuthnCredential, error)
+	GetUserWebAuthnCredentials(userID int64) ([]*WebAuthnCredential, error)
+	UseWebAuthnCredential(id, signCount int64) error
+	DeleteWebAuthnCredential(userID, id int64) error
+
+	AddAPIToken(*APIToken) error
+	GetAPIToken(hash string) (*APIToken, error)
+	GetUserAPITokens(userID int64) ([]*APIToken, error)
+	UseAPIToken(tokenID int64, ip string, window time.Duration) (requests int64, windowStart time.Time, err error)
+	DeleteAPIToken(userID, tokenID int64) error
+	DeleteUserAPITokens(userID int64) error
+
+	AddSession(*Session) error
+	GetSession(keyHash string) (*Session, error)
+	GetUserSessions(userID int64, since time.Time) ([]*Session, error)
+	TouchSession(sessionID int64) error
+	DeleteSession(sessionID int64) error
+	DeleteUserSessions(userID int64) error
+
+	AddReport(r *Report, hideAt int64) (hidden bool, err error)
+	GetOpenReports() ([]*Report, error)
+	ModeratePet(*ModerationAction) error
+	GetModerationActions(limit int) ([]*ModerationAction, error)
+	GetPendingPets() ([]*Pet, error)
+	HasApprovedPets(ownerID int64) (bool, error)
+
+	AddContactReveal(*ContactReveal) error
+	CountContactReveals(visitorKey string, since time.Time) (int64, error)
+
+	StartConversation(*Conversation, *Message) error
+	GetConversation(conversationID, userID int64) (*Conversation, error)
+	GetUserConversations(userID int64) ([]*Conversation, error)
+	AddMessage(*Message) error
+	GetMessages(conversationID int64) ([]*Message, error)
+	MarkConversationRead(conversationID, userID int64) error
+
+	AddFavorite(userID, petID int64) error
+	RemoveFavorite(userID, petID int64) error
+	GetFavoritePets(userID int64) ([]*Pet, error)
+	GetPetFavoriters(petID int64) ([]*User, error)
+
+	AddSavedSearch(*SavedSearch) error
+	GetSavedSearch(searchID int64) (*SavedSearch, error)
+	GetUserSavedSearches(userID int64) ([]*SavedSearch, error)
+	DeleteSavedSearch(searchID, userID int64) error
+	GetSavedSearches() ([]*SavedSearch, error)
+	MarkSavedSearchRun(searchID int64, at time.Time) error
+	SearchNewPets(s Search, since, until time.Time) ([]*Pet, error)
+
+	AddNotification(*Notification) error
+	ClaimNotifications(limit int, lease time.Duration) ([]*Notification, error)
+	UpdateNotification(*Notification) error
+
+	AddWebhook(*Webhook) error
+	GetWebhooks() ([]*Webhook, error)
+	SetWebhookActive(webhookID int64, active bool) error
+	DeleteWebhook(webhookID int64) error
+	AddWebhookDeliveries(event string, payload []byte) (int64, error)
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]*WebhookDelivery, error)
+	RecordWebhookAttempt(*WebhookDelivery, *WebhookAttempt) error
+	GetWebhookDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error)
+	RetryWebhookDelivery(deliveryID int64) error
+
+	AddApplication(*Application) error
+	GetApplication(applicationID int64) (*Application, error)
+	GetReceivedApplications(ownerID int64) ([]*Application, error)
+	GetSentApplications(applicantID int64) ([]*Application, error)
+	TransitionApplication(applicationID, userID int64, a ApplicationAction) (*Application, error)
+
+	AddAuditEvent(*AuditEvent) error
+	GetAuditEvents(f *AuditFilter, limit, offset int) ([]*AuditEvent, error)
 
 	AddPhoto(*Photo) error
 	GetPhoto(photoID int64) (*Photo, error)
+	ListPhotos(limit, offset int) ([]*Photo, error)
 
 	AddPlaceGroups([]PlaceGroup) error
 	GetPlaceGroups() ([]PlaceGroup, error)
 	AddPlaceGroup(*PlaceGroup) error
 	AddPlace(*Place) error
+	UpdatePlaceGroup(*PlaceGroup) error
+	DeletePlaceGroup(groupID int64) error
+	UpdatePlace(*Place) error
+	DeletePlace(placeID int64) error
 	GetPlace(int64) (*Place, error)
 	GetPlaceByKey(string) (*Place, error)
 	CountPlaces() (int64, error)
@@ -148,31 +279,38 @@ type Search struct {
 
 // User holds information about a user that is signed in the application.
 type User struct {
-	ID         int64
-	GithubID   int64
-	LinkedinID string
-	Login      string
-	Name       string
-	Email      string
-	Created    time.Time
-	Updated    time.Time
+	ID    int64
+	Login string
+	Name  string
+	Email string
+	// EmailVerified is true once the user has proven they own Email by
+	// following a verification link.
+	EmailVerified bool
+	// Role is what the user is allowed to do. New users get RoleUser.
+	Role Role
+	// Disabled users cannot log in and their sessions are rejected.
+	Disabled bool
+	Created  time.Time
+	Updated  time.Time
 }
 
-// GithubUser holds the data that we need to retrieve from a user's GitHub
-// account with their permission.
-type GithubUser struct {
-	ID    int64  `json:"id"`
-	Login string `json:"login"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
-}
+// EmailProvider is the Identity provider of local accounts that log in with
+// an email address and a password. The subject is the lowercased email.
+const EmailProvider = "email"
 
-// LinkedinUser holds the data that we need to retrieve from a user's LinkedIn
-// account with their permission.
-type LinkedinUser struct {
-	ID        string `json:"id"`
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
+// Identity links an account of an external login provider (GitHub, LinkedIn,
+// an OpenID Connect provider, ...) to a User. Provider and Subject together
+// uniquely identify the account.
+type Identity struct {
+	ID       int64
+	UserID   int64
+	Provider string
+	Subject  string
+	Login    string
+	Name     string
+	Email    string
+	Created  time.Time
+	Updated  time.Time
 }
 
 // TODO(psimika): Useful article in case a custom type needs to be stored in
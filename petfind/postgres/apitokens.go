@@ -0,0 +1,137 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func (db *store) AddAPIToken(t *petfind.APIToken) error {
+	const apiTokenInsertStmt = `
+	INSERT INTO api_tokens(user_id, name, prefix, hash, scope, created, expires)
+	VALUES ($1, $2, $3, $4, $5, now(), $6)
+	RETURNING id, created
+	`
+	return db.QueryRow(apiTokenInsertStmt, t.UserID, t.Name, t.Prefix, t.Hash, t.Scope, t.Expires).Scan(&t.ID, &t.Created)
+}
+
+const apiTokenSelect = `
+	SELECT id, user_id, name, prefix, hash, scope, created, expires, last_used, last_ip
+	FROM api_tokens`
+
+func scanAPIToken(row interface {
+	Scan(dest ...interface{}) error
+}) (*petfind.APIToken, error) {
+	t := new(petfind.APIToken)
+	var lastUsed pq.NullTime
+	if err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.Prefix,
+		&t.Hash,
+		&t.Scope,
+		&t.Created,
+		&t.Expires,
+		&lastUsed,
+		&t.LastIP,
+	); err != nil {
+		return nil, err
+	}
+	t.LastUsed = lastUsed.Time
+	return t, nil
+}
+
+// GetAPIToken returns the token with the given hash. petfind.ErrNotFound is
+// returned if there is no such token or it has expired.
+func (db *store) GetAPIToken(hash string) (*petfind.APIToken, error) {
+	const apiTokenGetQuery = apiTokenSelect + `
+	WHERE hash = $1 AND expires > now()
+	`
+	t, err := scanAPIToken(db.QueryRow(apiTokenGetQuery, hash))
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetUserAPITokens returns all the tokens of the user, including the expired
+// ones, the newest first.
+func (db *store) GetUserAPITokens(userID int64) ([]*petfind.APIToken, error) {
+	const apiTokensGetQuery = apiTokenSelect + `
+	WHERE user_id = $1
+	ORDER BY created DESC, id DESC
+	`
+	rows, err := db.Query(apiTokensGetQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	tokens := make([]*petfind.APIToken, 0)
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
+// UseAPIToken records a request made with the token and counts it against the
+// token's rate limiting window. A new window starts with the first request
+// made after the previous one is over. It returns how many requests have been
+// made in the current window, including this one, and when the window
+// started. Counting in the statement keeps the limit exact when the same token
+// is used on several servers at once. petfind.ErrNotFound is returned if the
+// token does not exist or has expired.
+func (db *store) UseAPIToken(tokenID int64, ip string, window time.Duration) (int64, time.Time, error) {
+	const apiTokenUseStmt = `
+	UPDATE api_tokens SET
+	  last_used = now(),
+	  last_ip = $2,
+	  window_start = CASE WHEN window_start IS NULL OR window_start <= now() - $3 * interval '1 second' THEN now() ELSE window_start END,
+	  window_count = CASE WHEN window_start IS NULL OR window_start <= now() - $3 * interval '1 second' THEN 1 ELSE window_count + 1 END
+	WHERE id = $1 AND expires > now()
+	RETURNING window_count, window_start
+	`
+	var (
+		requests    int64
+		windowStart time.Time
+	)
+	err := db.QueryRow(apiTokenUseStmt, tokenID, ip, window.Seconds()).Scan(&requests, &windowStart)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, petfind.ErrNotFound
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return requests, windowStart, nil
+}
+
+// DeleteAPIToken revokes a token of the user. petfind.ErrNotFound is returned
+// if the user has no such token.
+func (db *store) DeleteAPIToken(userID, tokenID int64) error {
+	res, err := db.Exec("DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", tokenID, userID)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
+
+// DeleteUserAPITokens revokes all the tokens of the user.
+func (db *store) DeleteUserAPITokens(userID int64) error {
+	_, err := db.Exec("DELETE FROM api_tokens WHERE user_id = $1", userID)
+	return err
+}
@@ -0,0 +1,119 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestAPITokens(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	other := &petfind.User{Name: "John Doe"}
+	if err := s.CreateUser(other); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	tok := &petfind.APIToken{
+		UserID:  u.ID,
+		Name:    "backup script",
+		Prefix:  "pfat_abcdef",
+		Hash:    "hash1",
+		Scope:   petfind.ScopeWrite,
+		Expires: time.Now().Add(time.Hour),
+	}
+	if err := s.AddAPIToken(tok); err != nil {
+		t.Fatalf("AddAPIToken failed: %v", err)
+	}
+	expired := &petfind.APIToken{UserID: u.ID, Name: "old", Prefix: "pfat_123456", Hash: "hash2", Expires: time.Now().Add(-time.Hour)}
+	if err := s.AddAPIToken(expired); err != nil {
+		t.Fatalf("AddAPIToken failed: %v", err)
+	}
+	// Hashes are unique.
+	dup := &petfind.APIToken{UserID: other.ID, Prefix: "pfat_abcdef", Hash: "hash1", Expires: time.Now().Add(time.Hour)}
+	if err := s.AddAPIToken(dup); err == nil {
+		t.Fatal("AddAPIToken with existing hash succeeded")
+	}
+
+	got, err := s.GetAPIToken("hash1")
+	if err != nil {
+		t.Fatalf("GetAPIToken failed: %v", err)
+	}
+	if got.ID != tok.ID || got.UserID != u.ID || got.Scope != petfind.ScopeWrite || got.Name != "backup script" || !got.LastUsed.IsZero() {
+		t.Errorf("GetAPIToken = %#v, expected %#v", got, tok)
+	}
+	if _, err := s.GetAPIToken("hash2"); err != petfind.ErrNotFound {
+		t.Errorf("GetAPIToken of expired token returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	// Every use is counted in the same window.
+	for want := int64(1); want <= 3; want++ {
+		n, start, err := s.UseAPIToken(tok.ID, "127.0.0.1", time.Hour)
+		if err != nil {
+			t.Fatalf("UseAPIToken failed: %v", err)
+		}
+		if n != want || start.IsZero() {
+			t.Errorf("UseAPIToken = %d requests since %v, expected %d", n, start, want)
+		}
+	}
+	// With an empty window each use starts a new one.
+	if n, _, err := s.UseAPIToken(tok.ID, "127.0.0.2", 0); err != nil || n != 1 {
+		t.Errorf("UseAPIToken after the window = %d, %v, expected 1", n, err)
+	}
+	if _, _, err := s.UseAPIToken(expired.ID, "127.0.0.1", time.Hour); err != petfind.ErrNotFound {
+		t.Errorf("UseAPIToken of expired token returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	tokens, err := s.GetUserAPITokens(u.ID)
+	if err != nil {
+		t.Fatalf("GetUserAPITokens failed: %v", err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("GetUserAPITokens returned %d tokens, expected 2", len(tokens))
+	}
+	for _, got := range tokens {
+		if got.ID == tok.ID && (got.LastUsed.IsZero() || got.LastIP != "127.0.0.2") {
+			t.Errorf("used token = %#v, expected last use from 127.0.0.2", got)
+		}
+		if got.ID == expired.ID && !got.LastUsed.IsZero() {
+			t.Errorf("unused token = %#v, expected no last use", got)
+		}
+	}
+
+	// Users can only revoke their own tokens.
+	if err := s.DeleteAPIToken(other.ID, tok.ID); err != petfind.ErrNotFound {
+		t.Errorf("DeleteAPIToken of another user's token returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if err := s.DeleteAPIToken(u.ID, tok.ID); err != nil {
+		t.Fatalf("DeleteAPIToken failed: %v", err)
+	}
+	if _, err := s.GetAPIToken("hash1"); err != petfind.ErrNotFound {
+		t.Errorf("GetAPIToken after DeleteAPIToken returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	// All of a user's tokens go at once, and only theirs.
+	mine := &petfind.APIToken{UserID: u.ID, Prefix: "pfat_aaaaaa", Hash: "hash3", Expires: time.Now().Add(time.Hour)}
+	theirs := &petfind.APIToken{UserID: other.ID, Prefix: "pfat_bbbbbb", Hash: "hash4", Expires: time.Now().Add(time.Hour)}
+	for _, tok := range []*petfind.APIToken{mine, theirs} {
+		if err := s.AddAPIToken(tok); err != nil {
+			t.Fatalf("AddAPIToken failed: %v", err)
+		}
+	}
+	if err := s.DeleteUserAPITokens(u.ID); err != nil {
+		t.Fatalf("DeleteUserAPITokens failed: %v", err)
+	}
+	if tokens, err := s.GetUserAPITokens(u.ID); err != nil || len(tokens) != 0 {
+		t.Errorf("GetUserAPITokens after DeleteUserAPITokens = %d tokens, %v, expected none", len(tokens), err)
+	}
+	if _, err := s.GetAPIToken("hash4"); err != nil {
+		t.Errorf("GetAPIToken of other user after DeleteUserAPITokens failed: %v", err)
+	}
+}
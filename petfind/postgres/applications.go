@@ -0,0 +1,250 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// AddApplication stores the application of a user to adopt a pet.
+// petfind.ErrNotFound is returned if the pet does not exist or is not listed
+// and petfind.ErrApplied if the user already has an open application for it.
+func (db *store) AddApplication(a *petfind.Application) (err error) {
+	const applicationInsertStmt = `
+	INSERT INTO applications(pet_id, applicant_id, status, housing, adults, children, experience, other_pets, message, created, updated)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, now(), now())
+	ON CONFLICT (pet_id, applicant_id) WHERE status IN (0, 1) DO NOTHING
+	RETURNING id, created, updated
+	`
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	var status petfind.PetStatus
+	if a.PetName, status, err = lockPet(tx, a.PetID); err != nil {
+		return err
+	}
+	if status != petfind.PetListed {
+		err = petfind.ErrNotFound
+		return err
+	}
+	a.Status = petfind.ApplicationSubmitted
+	err = tx.QueryRow(applicationInsertStmt,
+		a.PetID,
+		a.ApplicantID,
+		a.Status,
+		a.Housing,
+		a.Adults,
+		a.Children,
+		a.Experience,
+		a.OtherPets,
+		a.Message,
+	).Scan(&a.ID, &a.Created, &a.Updated)
+	if err == sql.ErrNoRows {
+		return petfind.ErrApplied
+	}
+	return err
+}
+
+// applicationSelect selects applications with the name and owner of their pet
+// and the name of the applicant.
+const applicationSelect = `
+	SELECT
+	  a.id,
+	  a.pet_id,
+	  p.name,
+	  p.owner_id,
+	  a.applicant_id,
+	  u.name,
+	  a.status,
+	  a.housing,
+	  a.adults,
+	  a.children,
+	  a.experience,
+	  a.other_pets,
+	  a.message,
+	  a.created,
+	  a.updated
+	FROM applications a
+	  JOIN pets p ON a.pet_id = p.id
+	  JOIN users u ON a.applicant_id = u.id`
+
+func scanApplication(s interface {
+	Scan(dest ...interface{}) error
+}) (*petfind.Application, error) {
+	a := new(petfind.Application)
+	err := s.Scan(
+		&a.ID,
+		&a.PetID,
+		&a.PetName,
+		&a.OwnerID,
+		&a.ApplicantID,
+		&a.ApplicantName,
+		&a.Status,
+		&a.Housing,
+		&a.Adults,
+		&a.Children,
+		&a.Experience,
+		&a.OtherPets,
+		&a.Message,
+		&a.Created,
+		&a.Updated,
+	)
+	return a, err
+}
+
+// GetApplication returns an application by its ID.
+func (db *store) GetApplication(applicationID int64) (*petfind.Application, error) {
+	const applicationGetQuery = applicationSelect + `
+	WHERE a.id = $1
+	`
+	a, err := scanApplication(db.QueryRow(applicationGetQuery, applicationID))
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetReceivedApplications returns the applications for the pets of an owner,
+// the open ones first and then the most recently updated.
+func (db *store) GetReceivedApplications(ownerID int64) ([]*petfind.Application, error) {
+	const receivedApplicationsQuery = applicationSelect + `
+	WHERE p.owner_id = $1
+	ORDER BY a.status IN (0, 1) DESC, a.updated DESC
+	`
+	return db.queryApplications(receivedApplicationsQuery, ownerID)
+}
+
+// GetSentApplications returns the applications of an applicant, the most
+// recently updated first.
+func (db *store) GetSentApplications(applicantID int64) ([]*petfind.Application, error) {
+	const sentApplicationsQuery = applicationSelect + `
+	WHERE a.applicant_id = $1
+	ORDER BY a.updated DESC
+	`
+	return db.queryApplications(sentApplicationsQuery, applicantID)
+}
+
+func (db *store) queryApplications(query string, args ...interface{}) (applications []*petfind.Application, err error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	applications = make([]*petfind.Application, 0)
+	for rows.Next() {
+		a, err := scanApplication(rows)
+		if err != nil {
+			return nil, err
+		}
+		applications = append(applications, a)
+	}
+	return applications, nil
+}
+
+// TransitionApplication takes the action of the user with userID on an
+// application and returns the updated application. The rules of which
+// actions apply are those of petfind.Application.Transition.
+//
+// Approving an application also marks its pet as adopted, which unlists it,
+// and declines the other open applications for the pet. Only listed pets can
+// be adopted so a pet is never adopted twice; petfind.ErrPetUnavailable is
+// returned otherwise. petfind.ErrNotFound is returned if the application does
+// not exist.
+func (db *store) TransitionApplication(applicationID, userID int64, action petfind.ApplicationAction) (a *petfind.Application, err error) {
+	const (
+		applicationLockQuery = applicationSelect + `
+	WHERE a.id = $1
+	FOR UPDATE OF a
+	`
+		applicationUpdateStmt = `
+	UPDATE applications SET status = $2, updated = now()
+	WHERE id = $1
+	RETURNING updated
+	`
+		petAdoptStmt = `
+	UPDATE pets SET status = $2, updated = now()
+	WHERE id = $1
+	`
+		applicationDeclineOthersStmt = `
+	UPDATE applications SET status = $3, updated = now()
+	WHERE pet_id = $1 AND id != $2 AND status IN (0, 1)
+	`
+	)
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// The pet is locked before the application, in the same order as
+	// AddApplication, so that approvals of two applications for the same
+	// pet happen one after the other without deadlocking.
+	var petID int64
+	err = tx.QueryRow("SELECT pet_id FROM applications WHERE id = $1", applicationID).Scan(&petID)
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, status, err := lockPet(tx, petID)
+	if err != nil {
+		return nil, err
+	}
+	a, err = scanApplication(tx.QueryRow(applicationLockQuery, applicationID))
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = a.Transition(action, userID); err != nil {
+		return nil, err
+	}
+	if a.Status == petfind.ApplicationApproved && status != petfind.PetListed {
+		err = petfind.ErrPetUnavailable
+		return nil, err
+	}
+	if err = tx.QueryRow(applicationUpdateStmt, a.ID, a.Status).Scan(&a.Updated); err != nil {
+		return nil, err
+	}
+	if a.Status != petfind.ApplicationApproved {
+		return a, nil
+	}
+	if _, err = tx.Exec(petAdoptStmt, a.PetID, petfind.PetHomed); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(applicationDeclineOthersStmt, a.PetID, a.ID, petfind.ApplicationDeclined); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
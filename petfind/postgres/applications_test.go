@@ -0,0 +1,162 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestApplications(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+	applicant := &petfind.User{Name: "John Doe", Login: "johndoe"}
+	if err := s.CreateUser(applicant); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	a := &petfind.Application{
+		PetID:       p.ID,
+		ApplicantID: applicant.ID,
+		Housing:     petfind.HousingHouseWithYard,
+		Adults:      2,
+		Children:    1,
+		Experience:  petfind.ExperienceSome,
+		OtherPets:   "A cat",
+		Message:     "We would love to meet her.",
+	}
+	if err := s.AddApplication(a); err != nil {
+		t.Fatalf("AddApplication failed: %v", err)
+	}
+	if a.ID == 0 || a.Status != petfind.ApplicationSubmitted || a.PetName != p.Name {
+		t.Fatalf("AddApplication returned %#v", a)
+	}
+	again := &petfind.Application{PetID: p.ID, ApplicantID: applicant.ID, Housing: petfind.HousingApartment, Adults: 1, Experience: petfind.ExperienceNone}
+	if err := s.AddApplication(again); err != petfind.ErrApplied {
+		t.Fatalf("AddApplication while open returned %v, expected %v", err, petfind.ErrApplied)
+	}
+	if err := s.AddApplication(&petfind.Application{PetID: p.ID + 1, ApplicantID: applicant.ID}); err != petfind.ErrNotFound {
+		t.Fatalf("AddApplication for unknown pet returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	received, err := s.GetReceivedApplications(p.OwnerID)
+	if err != nil {
+		t.Fatalf("GetReceivedApplications failed: %v", err)
+	}
+	if len(received) != 1 || received[0].ID != a.ID || received[0].ApplicantName != applicant.Name || received[0].OtherPets != "A cat" {
+		t.Fatalf("GetReceivedApplications = %#v", received)
+	}
+	sent, err := s.GetSentApplications(applicant.ID)
+	if err != nil {
+		t.Fatalf("GetSentApplications failed: %v", err)
+	}
+	if len(sent) != 1 || sent[0].ID != a.ID || sent[0].OwnerID != p.OwnerID {
+		t.Fatalf("GetSentApplications = %#v", sent)
+	}
+
+	// The applicant cannot approve their own application.
+	if _, err := s.TransitionApplication(a.ID, applicant.ID, petfind.ApplicationApprove); err != petfind.ErrNotAllowed {
+		t.Fatalf("TransitionApplication approve by applicant returned %v, expected %v", err, petfind.ErrNotAllowed)
+	}
+	got, err := s.TransitionApplication(a.ID, p.OwnerID, petfind.ApplicationReview)
+	if err != nil {
+		t.Fatalf("TransitionApplication review failed: %v", err)
+	}
+	if got.Status != petfind.ApplicationUnderReview {
+		t.Fatalf("TransitionApplication review status = %v, want %v", got.Status, petfind.ApplicationUnderReview)
+	}
+	if _, err := s.TransitionApplication(a.ID, applicant.ID, petfind.ApplicationWithdraw); err != nil {
+		t.Fatalf("TransitionApplication withdraw failed: %v", err)
+	}
+	if _, err := s.TransitionApplication(a.ID, p.OwnerID, petfind.ApplicationApprove); err != petfind.ErrApplicationTransition {
+		t.Fatalf("TransitionApplication approve after withdraw returned %v, expected %v", err, petfind.ErrApplicationTransition)
+	}
+	if got, err = s.GetApplication(a.ID); err != nil || got.Status != petfind.ApplicationWithdrawn {
+		t.Fatalf("GetApplication after withdraw = %#v, %v", got, err)
+	}
+	if _, err := s.TransitionApplication(a.ID+1, p.OwnerID, petfind.ApplicationApprove); err != petfind.ErrNotFound {
+		t.Fatalf("TransitionApplication for unknown application returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	// Once withdrawn the applicant can apply again.
+	if err := s.AddApplication(again); err != nil {
+		t.Fatalf("AddApplication after withdraw failed: %v", err)
+	}
+}
+
+func TestApproveApplication(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+
+	var apps []*petfind.Application
+	for _, name := range []string{"John Doe", "Mary Major", "Richard Roe"} {
+		u := &petfind.User{Name: name}
+		if err := s.CreateUser(u); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+		a := &petfind.Application{PetID: p.ID, ApplicantID: u.ID, Housing: petfind.HousingHouse, Adults: 1, Experience: petfind.ExperienceSome}
+		if err := s.AddApplication(a); err != nil {
+			t.Fatalf("AddApplication failed: %v", err)
+		}
+		apps = append(apps, a)
+	}
+	if _, err := s.TransitionApplication(apps[2].ID, apps[2].ApplicantID, petfind.ApplicationWithdraw); err != nil {
+		t.Fatalf("TransitionApplication withdraw failed: %v", err)
+	}
+
+	if _, err := s.TransitionApplication(apps[0].ID, p.OwnerID, petfind.ApplicationApprove); err != nil {
+		t.Fatalf("TransitionApplication approve failed: %v", err)
+	}
+	// A pet is adopted only once.
+	if _, err := s.TransitionApplication(apps[1].ID, p.OwnerID, petfind.ApplicationApprove); err != petfind.ErrApplicationTransition {
+		t.Fatalf("second TransitionApplication approve returned %v, expected %v", err, petfind.ErrApplicationTransition)
+	}
+	for i, want := range []petfind.ApplicationStatus{petfind.ApplicationApproved, petfind.ApplicationDeclined, petfind.ApplicationWithdrawn} {
+		got, err := s.GetApplication(apps[i].ID)
+		if err != nil {
+			t.Fatalf("GetApplication failed: %v", err)
+		}
+		if got.Status != want {
+			t.Errorf("application %d has status %v, expected %v", i, got.Status, want)
+		}
+	}
+	pet, err := s.GetPet(p.ID)
+	if err != nil {
+		t.Fatalf("GetPet failed: %v", err)
+	}
+	if pet.Status != petfind.PetHomed {
+		t.Errorf("adopted pet has status %v, expected %v", pet.Status, petfind.PetHomed)
+	}
+	// Nobody can apply for an adopted pet.
+	late := &petfind.Application{PetID: p.ID, ApplicantID: apps[2].ApplicantID, Housing: petfind.HousingHouse, Adults: 1, Experience: petfind.ExperienceSome}
+	if err := s.AddApplication(late); err != petfind.ErrNotFound {
+		t.Fatalf("AddApplication for adopted pet returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
+
+func TestApproveApplicationUnlistedPet(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+	u := &petfind.User{Name: "John Doe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	a := &petfind.Application{PetID: p.ID, ApplicantID: u.ID, Housing: petfind.HousingHouse, Adults: 1, Experience: petfind.ExperienceSome}
+	if err := s.AddApplication(a); err != nil {
+		t.Fatalf("AddApplication failed: %v", err)
+	}
+	if err := s.ArchivePet(p.ID); err != nil {
+		t.Fatalf("ArchivePet failed: %v", err)
+	}
+	if _, err := s.TransitionApplication(a.ID, p.OwnerID, petfind.ApplicationApprove); err != petfind.ErrPetUnavailable {
+		t.Fatalf("TransitionApplication approve for removed pet returned %v, expected %v", err, petfind.ErrPetUnavailable)
+	}
+	// Other decisions can still be made.
+	if _, err := s.TransitionApplication(a.ID, p.OwnerID, petfind.ApplicationDecline); err != nil {
+		t.Fatalf("TransitionApplication decline for removed pet failed: %v", err)
+	}
+}
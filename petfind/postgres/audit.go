@@ -0,0 +1,65 @@
+package postgres
+
+import "github.com/psimika/secure-web-app/petfind"
+
+// AddAuditEvent appends an event to the security audit log.
+func (db *store) AddAuditEvent(e *petfind.AuditEvent) error {
+	const auditEventInsertStmt = `
+	INSERT INTO audit_events(kind, actor_id, ip, user_agent, detail, created)
+	VALUES ($1, NULLIF($2, 0), $3, $4, $5, now())
+	RETURNING id, created
+	`
+	return db.QueryRow(auditEventInsertStmt, e.Kind, e.ActorID, e.IP, e.UserAgent, e.Detail).Scan(&e.ID, &e.Created)
+}
+
+// GetAuditEvents returns the events of the audit log that match the filter,
+// newest first.
+func (db *store) GetAuditEvents(f *petfind.AuditFilter, limit, offset int) ([]*petfind.AuditEvent, error) {
+	const auditEventsQuery = `
+	SELECT
+	  a.id,
+	  a.kind,
+	  COALESCE(a.actor_id, 0),
+	  COALESCE(u.name, ''),
+	  a.ip,
+	  a.user_agent,
+	  a.detail,
+	  a.created
+	FROM audit_events a
+	  LEFT JOIN users u ON a.actor_id = u.id
+	WHERE ($1 = '' OR a.kind = $1)
+	AND ($2::bigint = 0 OR a.actor_id = $2)
+	AND ($3 = '' OR a.ip = $3)
+	ORDER BY a.id DESC
+	LIMIT $4 OFFSET $5
+	`
+	rows, err := db.Query(auditEventsQuery, f.Kind, f.ActorID, f.IP, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	events := make([]*petfind.AuditEvent, 0)
+	for rows.Next() {
+		e := new(petfind.AuditEvent)
+		if err := rows.Scan(
+			&e.ID,
+			&e.Kind,
+			&e.ActorID,
+			&e.ActorName,
+			&e.IP,
+			&e.UserAgent,
+			&e.Detail,
+			&e.Created,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
@@ -0,0 +1,68 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestAuditEvents(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	u := &petfind.User{Name: "Alice", Login: "alice"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	events := []*petfind.AuditEvent{
+		{Kind: petfind.AuditLoginFailed, IP: "10.0.0.1", Detail: "password"},
+		{Kind: petfind.AuditLogin, ActorID: u.ID, IP: "10.0.0.1", UserAgent: "test", Detail: "password"},
+		{Kind: petfind.AuditLogout, ActorID: u.ID, IP: "10.0.0.2"},
+	}
+	for _, e := range events {
+		if err := s.AddAuditEvent(e); err != nil {
+			t.Fatalf("AddAuditEvent failed: %v", err)
+		}
+		if e.ID == 0 || e.Created.IsZero() {
+			t.Fatalf("AddAuditEvent did not set ID and Created: %#v", e)
+		}
+	}
+
+	tests := []struct {
+		filter petfind.AuditFilter
+		want   []int64
+	}{
+		{petfind.AuditFilter{}, []int64{events[2].ID, events[1].ID, events[0].ID}},
+		{petfind.AuditFilter{Kind: petfind.AuditLogin}, []int64{events[1].ID}},
+		{petfind.AuditFilter{ActorID: u.ID}, []int64{events[2].ID, events[1].ID}},
+		{petfind.AuditFilter{IP: "10.0.0.1"}, []int64{events[1].ID, events[0].ID}},
+		{petfind.AuditFilter{Kind: petfind.AuditLogout, IP: "10.0.0.1"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := s.GetAuditEvents(&tt.filter, 10, 0)
+		if err != nil {
+			t.Fatalf("GetAuditEvents(%#v) failed: %v", tt.filter, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("GetAuditEvents(%#v) returned %d events, want %d", tt.filter, len(got), len(tt.want))
+		}
+		for i := range got {
+			if got[i].ID != tt.want[i] {
+				t.Errorf("GetAuditEvents(%#v)[%d].ID = %d, want %d", tt.filter, i, got[i].ID, tt.want[i])
+			}
+		}
+	}
+
+	got, err := s.GetAuditEvents(&petfind.AuditFilter{Kind: petfind.AuditLogin}, 10, 0)
+	if err != nil {
+		t.Fatalf("GetAuditEvents failed: %v", err)
+	}
+	if got[0].ActorName != u.Name || got[0].UserAgent != "test" || got[0].Detail != "password" {
+		t.Fatalf("GetAuditEvents returned %#v", got[0])
+	}
+	if got, err := s.GetAuditEvents(&petfind.AuditFilter{}, 1, 1); err != nil || len(got) != 1 || got[0].ID != events[1].ID {
+		t.Fatalf("GetAuditEvents with offset returned %v, %v", got, err)
+	}
+}
@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// AddContactReveal logs that a visitor asked to see the contact details of a
+// pet.
+func (db *store) AddContactReveal(r *petfind.ContactReveal) error {
+	const contactRevealInsertStmt = `
+	INSERT INTO contact_reveals(pet_id, user_id, visitor_key, ip, created)
+	VALUES ($1, NULLIF($2, 0), $3, $4, now())
+	RETURNING id, created
+	`
+	return db.QueryRow(contactRevealInsertStmt, r.PetID, r.UserID, r.VisitorKey, r.IP).Scan(&r.ID, &r.Created)
+}
+
+// CountContactReveals returns how many contact details a visitor has asked
+// for since the given time. It is used to throttle scraping.
+func (db *store) CountContactReveals(visitorKey string, since time.Time) (int64, error) {
+	const contactRevealCountQuery = `
+	SELECT COUNT(*)
+	FROM contact_reveals
+	WHERE visitor_key = $1 AND created > $2
+	`
+	var count int64
+	if err := db.QueryRow(contactRevealCountQuery, visitorKey, since).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
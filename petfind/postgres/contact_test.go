@@ -0,0 +1,51 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestContactReveals(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+
+	before := time.Now().Add(-time.Minute)
+	for _, key := range []string{"a", "a", "b"} {
+		r := &petfind.ContactReveal{PetID: p.ID, VisitorKey: key, IP: "198.51.100.1"}
+		if err := s.AddContactReveal(r); err != nil {
+			t.Fatalf("AddContactReveal failed: %v", err)
+		}
+		if r.ID == 0 || r.Created.IsZero() {
+			t.Fatalf("AddContactReveal did not set ID and Created: %#v", r)
+		}
+	}
+	if n, err := s.CountContactReveals("a", before); err != nil || n != 2 {
+		t.Fatalf("CountContactReveals(a) = %d, %v, want 2", n, err)
+	}
+	if n, err := s.CountContactReveals("a", time.Now().Add(time.Minute)); err != nil || n != 0 {
+		t.Fatalf("CountContactReveals(a) in the future = %d, %v, want 0", n, err)
+	}
+}
+
+func TestPetContactVisibility(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+
+	q := &petfind.Pet{Name: "rex", OwnerID: p.OwnerID, PhotoID: p.PhotoID, PlaceID: p.PlaceID, ContactVisibility: petfind.ContactOnRequest}
+	if err := s.AddPet(q); err != nil {
+		t.Fatalf("AddPet failed: %v", err)
+	}
+	got, err := s.GetPet(q.ID)
+	if err != nil {
+		t.Fatalf("GetPet failed: %v", err)
+	}
+	if got.ContactVisibility != petfind.ContactOnRequest {
+		t.Fatalf("GetPet ContactVisibility = %v, want %v", got.ContactVisibility, petfind.ContactOnRequest)
+	}
+}
@@ -0,0 +1,151 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// AddFavorite saves a pet as a favorite of a user. Saving a favorite again
+// does nothing. petfind.ErrNotFound is returned if the pet does not exist.
+func (db *store) AddFavorite(userID, petID int64) error {
+	const favoriteInsertStmt = `
+	INSERT INTO favorites(user_id, pet_id, created)
+	SELECT $1, id, now()
+	FROM pets
+	WHERE id = $2
+	ON CONFLICT (user_id, pet_id) DO UPDATE SET user_id = EXCLUDED.user_id
+	RETURNING pet_id
+	`
+	var id int64
+	err := db.QueryRow(favoriteInsertStmt, userID, petID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return petfind.ErrNotFound
+	}
+	return err
+}
+
+// RemoveFavorite removes a pet from the favorites of a user.
+// petfind.ErrNotFound is returned if the pet was not a favorite.
+func (db *store) RemoveFavorite(userID, petID int64) error {
+	const favoriteDeleteStmt = `DELETE FROM favorites WHERE user_id = $1 AND pet_id = $2`
+	res, err := db.Exec(favoriteDeleteStmt, userID, petID)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
+
+// GetFavoritePets returns the listed pets a user has saved as favorites, the
+// most recently saved first. Pets that are no longer listed are left out.
+func (db *store) GetFavoritePets(userID int64) (pets []*petfind.Pet, err error) {
+	const favoritePetsQuery = petSelect + `
+	  JOIN favorites f ON f.pet_id = p.id
+	WHERE f.user_id = $1 AND p.status = $2
+	ORDER BY f.created DESC
+	`
+	rows, err := db.Query(favoritePetsQuery, userID, petfind.PetListed)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	pets = make([]*petfind.Pet, 0)
+	for rows.Next() {
+		p := new(petfind.Pet)
+		u := new(petfind.User)
+		pl := new(petfind.Place)
+		if err := rows.Scan(
+			&p.ID,
+			&p.Name,
+			&p.Age,
+			&p.Type,
+			&p.Size,
+			&p.Gender,
+			&p.Contact.Phone,
+			&p.Contact.Email,
+			&p.Contact.Hours,
+			&p.Notes,
+			&p.Created,
+			&p.Updated,
+			&p.OwnerID,
+			&p.PhotoID,
+			&p.PlaceID,
+			&p.Status,
+			&p.ContactVisibility,
+			&u.ID,
+			&u.Name,
+			&u.Login,
+			&u.Email,
+			&u.Created,
+			&u.Updated,
+			&pl.ID,
+			&pl.Key,
+			&pl.Name,
+			&pl.GroupID,
+		); err != nil {
+			return nil, err
+		}
+		p.Owner = u
+		p.Place = pl
+		pets = append(pets, p)
+	}
+	return pets, nil
+}
+
+// GetPetFavoriters returns the users who saved a pet as a favorite so that
+// they can be told when something happens to it. Disabled users are left
+// out.
+func (db *store) GetPetFavoriters(petID int64) (users []*petfind.User, err error) {
+	const petFavoritersQuery = `
+	SELECT
+	  u.id,
+	  u.login,
+	  u.name,
+	  u.email,
+	  u.email_verified,
+	  u.role,
+	  u.disabled,
+	  u.created,
+	  u.updated
+	FROM favorites f
+	  JOIN users u ON f.user_id = u.id
+	WHERE f.pet_id = $1 AND NOT u.disabled
+	ORDER BY u.id
+	`
+	rows, err := db.Query(petFavoritersQuery, petID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	users = make([]*petfind.User, 0)
+	for rows.Next() {
+		u := new(petfind.User)
+		if err := rows.Scan(
+			&u.ID,
+			&u.Login,
+			&u.Name,
+			&u.Email,
+			&u.EmailVerified,
+			&u.Role,
+			&u.Disabled,
+			&u.Created,
+			&u.Updated,
+		); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
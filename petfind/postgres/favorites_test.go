@@ -0,0 +1,63 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestFavorites(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+	fan := &petfind.User{Name: "John Doe", Login: "johndoe", Email: "john@example.com"}
+	if err := s.CreateUser(fan); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	if err := s.AddFavorite(fan.ID, p.ID); err != nil {
+		t.Fatalf("AddFavorite failed: %v", err)
+	}
+	// Saving it again does nothing.
+	if err := s.AddFavorite(fan.ID, p.ID); err != nil {
+		t.Fatalf("AddFavorite again failed: %v", err)
+	}
+	if err := s.AddFavorite(fan.ID, p.ID+1); err != petfind.ErrNotFound {
+		t.Fatalf("AddFavorite for unknown pet returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	pets, err := s.GetFavoritePets(fan.ID)
+	if err != nil {
+		t.Fatalf("GetFavoritePets failed: %v", err)
+	}
+	if len(pets) != 1 || pets[0].ID != p.ID || pets[0].Owner.Name != "Jane Doe" {
+		t.Fatalf("GetFavoritePets = %#v", pets)
+	}
+	fans, err := s.GetPetFavoriters(p.ID)
+	if err != nil {
+		t.Fatalf("GetPetFavoriters failed: %v", err)
+	}
+	if len(fans) != 1 || fans[0].ID != fan.ID || fans[0].Email != fan.Email {
+		t.Fatalf("GetPetFavoriters = %#v", fans)
+	}
+
+	// Pets that are not listed are left out.
+	if err := s.ModeratePet(&petfind.ModerationAction{PetID: p.ID, Kind: petfind.ModerationHide}); err != nil {
+		t.Fatalf("ModeratePet failed: %v", err)
+	}
+	if pets, err = s.GetFavoritePets(fan.ID); err != nil || len(pets) != 0 {
+		t.Fatalf("GetFavoritePets after hiding = %#v, %v", pets, err)
+	}
+
+	if err := s.RemoveFavorite(fan.ID, p.ID); err != nil {
+		t.Fatalf("RemoveFavorite failed: %v", err)
+	}
+	if err := s.RemoveFavorite(fan.ID, p.ID); err != petfind.ErrNotFound {
+		t.Fatalf("RemoveFavorite again returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if fans, err = s.GetPetFavoriters(p.ID); err != nil || len(fans) != 0 {
+		t.Fatalf("GetPetFavoriters after RemoveFavorite = %#v, %v", fans, err)
+	}
+}
@@ -0,0 +1,241 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// StartConversation adds the first message m of the adopter c.AdopterID to
+// the owner of pet c.PetID. If the adopter has already written about the pet,
+// m is added to the existing conversation instead. petfind.ErrNotFound is
+// returned if the pet does not exist.
+func (db *store) StartConversation(c *petfind.Conversation, m *petfind.Message) (err error) {
+	const conversationInsertStmt = `
+	INSERT INTO conversations(pet_id, owner_id, adopter_id, created, updated)
+	SELECT id, owner_id, $2, now(), now()
+	FROM pets
+	WHERE id = $1
+	ON CONFLICT (pet_id, adopter_id) DO UPDATE SET pet_id = EXCLUDED.pet_id
+	RETURNING id, owner_id, owner_replied, created
+	`
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	err = tx.QueryRow(conversationInsertStmt, c.PetID, c.AdopterID).Scan(&c.ID, &c.OwnerID, &c.OwnerReplied, &c.Created)
+	if err == sql.ErrNoRows {
+		return petfind.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	m.ConversationID = c.ID
+	m.SenderID = c.AdopterID
+	if err = addMessage(tx, m); err != nil {
+		return err
+	}
+	c.Updated = m.Created
+	return nil
+}
+
+// AddMessage adds a message to a conversation. The conversation counts as
+// read by the sender and, if they are the owner, as replied.
+// petfind.ErrNotFound is returned if the conversation does not exist.
+func (db *store) AddMessage(m *petfind.Message) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+	return addMessage(tx, m)
+}
+
+func addMessage(tx *sql.Tx, m *petfind.Message) error {
+	const (
+		conversationUpdateStmt = `
+	UPDATE conversations SET
+	  updated = now(),
+	  owner_replied = owner_replied OR owner_id = $2,
+	  owner_seen = CASE WHEN owner_id = $2 THEN now() ELSE owner_seen END,
+	  adopter_seen = CASE WHEN adopter_id = $2 THEN now() ELSE adopter_seen END
+	WHERE id = $1
+	`
+		messageInsertStmt = `
+	INSERT INTO messages(conversation_id, sender_id, body, created)
+	VALUES ($1, $2, $3, now())
+	RETURNING id, created
+	`
+	)
+	res, err := tx.Exec(conversationUpdateStmt, m.ConversationID, m.SenderID)
+	if err != nil {
+		return err
+	}
+	if err := notFoundIfNone(res); err != nil {
+		return err
+	}
+	return tx.QueryRow(messageInsertStmt, m.ConversationID, m.SenderID, m.Body).Scan(&m.ID, &m.Created)
+}
+
+// conversationSelect selects conversations with the names of their pet and
+// participants. $1 is the user for whom Unread is computed.
+const conversationSelect = `
+	SELECT
+	  c.id,
+	  c.pet_id,
+	  p.name,
+	  c.owner_id,
+	  o.name,
+	  c.adopter_id,
+	  a.name,
+	  c.owner_replied,
+	  c.created,
+	  c.updated,
+	  CASE WHEN c.owner_id = $1 THEN c.owner_seen < c.updated ELSE c.adopter_seen < c.updated END
+	FROM conversations c
+	  JOIN pets p ON c.pet_id = p.id
+	  JOIN users o ON c.owner_id = o.id
+	  JOIN users a ON c.adopter_id = a.id`
+
+func scanConversation(s interface {
+	Scan(dest ...interface{}) error
+}) (*petfind.Conversation, error) {
+	c := new(petfind.Conversation)
+	err := s.Scan(
+		&c.ID,
+		&c.PetID,
+		&c.PetName,
+		&c.OwnerID,
+		&c.OwnerName,
+		&c.AdopterID,
+		&c.AdopterName,
+		&c.OwnerReplied,
+		&c.Created,
+		&c.Updated,
+		&c.Unread,
+	)
+	return c, err
+}
+
+// GetConversation returns a conversation as seen by the user with userID.
+func (db *store) GetConversation(conversationID, userID int64) (*petfind.Conversation, error) {
+	const conversationGetQuery = conversationSelect + `
+	WHERE c.id = $2
+	`
+	c, err := scanConversation(db.QueryRow(conversationGetQuery, userID, conversationID))
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetUserConversations returns the conversations a user takes part in, as
+// owner or adopter, the most recently active first.
+func (db *store) GetUserConversations(userID int64) ([]*petfind.Conversation, error) {
+	const userConversationsQuery = conversationSelect + `
+	WHERE c.owner_id = $1 OR c.adopter_id = $1
+	ORDER BY c.updated DESC
+	`
+	rows, err := db.Query(userConversationsQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	conversations := make([]*petfind.Conversation, 0)
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, nil
+}
+
+// GetMessages returns the messages of a conversation, oldest first.
+func (db *store) GetMessages(conversationID int64) ([]*petfind.Message, error) {
+	const messagesQuery = `
+	SELECT
+	  m.id,
+	  m.conversation_id,
+	  m.sender_id,
+	  u.name,
+	  m.body,
+	  m.created
+	FROM messages m
+	  JOIN users u ON m.sender_id = u.id
+	WHERE m.conversation_id = $1
+	ORDER BY m.id
+	`
+	rows, err := db.Query(messagesQuery, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	messages := make([]*petfind.Message, 0)
+	for rows.Next() {
+		m := new(petfind.Message)
+		if err := rows.Scan(
+			&m.ID,
+			&m.ConversationID,
+			&m.SenderID,
+			&m.SenderName,
+			&m.Body,
+			&m.Created,
+		); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// MarkConversationRead records that the user with userID has seen all the
+// messages of a conversation.
+func (db *store) MarkConversationRead(conversationID, userID int64) error {
+	const conversationReadStmt = `
+	UPDATE conversations SET
+	  owner_seen = CASE WHEN owner_id = $2 THEN now() ELSE owner_seen END,
+	  adopter_seen = CASE WHEN adopter_id = $2 THEN now() ELSE adopter_seen END
+	WHERE id = $1
+	`
+	res, err := db.Exec(conversationReadStmt, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
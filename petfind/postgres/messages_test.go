@@ -0,0 +1,93 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestConversation(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+	adopter := &petfind.User{Name: "John Doe", Login: "johndoe"}
+	if err := s.CreateUser(adopter); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	c := &petfind.Conversation{PetID: p.ID, AdopterID: adopter.ID}
+	if err := s.StartConversation(c, &petfind.Message{Body: "Is she still available?"}); err != nil {
+		t.Fatalf("StartConversation failed: %v", err)
+	}
+	if c.OwnerID != p.OwnerID || c.OwnerReplied {
+		t.Fatalf("StartConversation returned %#v", c)
+	}
+	// Writing about the same pet again continues the conversation.
+	again := &petfind.Conversation{PetID: p.ID, AdopterID: adopter.ID}
+	if err := s.StartConversation(again, &petfind.Message{Body: "Hello?"}); err != nil {
+		t.Fatalf("StartConversation again failed: %v", err)
+	}
+	if again.ID != c.ID {
+		t.Fatalf("StartConversation again started conversation %d, want %d", again.ID, c.ID)
+	}
+	if err := s.StartConversation(&petfind.Conversation{PetID: p.ID + 1, AdopterID: adopter.ID}, &petfind.Message{Body: "Hi"}); err != petfind.ErrNotFound {
+		t.Fatalf("StartConversation for unknown pet returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	inbox, err := s.GetUserConversations(p.OwnerID)
+	if err != nil {
+		t.Fatalf("GetUserConversations failed: %v", err)
+	}
+	if len(inbox) != 1 || inbox[0].ID != c.ID || !inbox[0].Unread || inbox[0].PetName != p.Name || inbox[0].AdopterName != adopter.Name {
+		t.Fatalf("GetUserConversations for owner = %#v", inbox)
+	}
+	got, err := s.GetConversation(c.ID, adopter.ID)
+	if err != nil {
+		t.Fatalf("GetConversation failed: %v", err)
+	}
+	if got.Unread {
+		t.Fatalf("GetConversation for the sender is unread")
+	}
+
+	if err := s.MarkConversationRead(c.ID, p.OwnerID); err != nil {
+		t.Fatalf("MarkConversationRead failed: %v", err)
+	}
+	if got, err = s.GetConversation(c.ID, p.OwnerID); err != nil || got.Unread {
+		t.Fatalf("GetConversation after MarkConversationRead = %#v, %v", got, err)
+	}
+
+	reply := &petfind.Message{ConversationID: c.ID, SenderID: p.OwnerID, Body: "Yes!"}
+	if err := s.AddMessage(reply); err != nil {
+		t.Fatalf("AddMessage failed: %v", err)
+	}
+	if got, err = s.GetConversation(c.ID, adopter.ID); err != nil || !got.OwnerReplied || !got.Unread {
+		t.Fatalf("GetConversation after reply = %#v, %v", got, err)
+	}
+	if err := s.AddMessage(&petfind.Message{ConversationID: c.ID + 1, SenderID: p.OwnerID, Body: "Yes!"}); err != petfind.ErrNotFound {
+		t.Fatalf("AddMessage to unknown conversation returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	messages, err := s.GetMessages(c.ID)
+	if err != nil {
+		t.Fatalf("GetMessages failed: %v", err)
+	}
+	if got, want := len(messages), 3; got != want {
+		t.Fatalf("GetMessages returned %d messages, want %d", got, want)
+	}
+	if messages[2].ID != reply.ID || messages[2].SenderName != "Jane Doe" || messages[0].SenderID != adopter.ID {
+		t.Fatalf("GetMessages = %#v", messages)
+	}
+
+	// Conversations outlive the listing of their pet.
+	if err := s.ArchivePet(p.ID); err != nil {
+		t.Fatalf("ArchivePet failed: %v", err)
+	}
+	if _, err := s.GetConversation(c.ID, adopter.ID); err != nil {
+		t.Fatalf("GetConversation after ArchivePet failed: %v", err)
+	}
+	if messages, err := s.GetMessages(c.ID); err != nil || len(messages) != 3 {
+		t.Fatalf("GetMessages after ArchivePet returned %d messages, %v, expected 3", len(messages), err)
+	}
+}
@@ -0,0 +1,306 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// AddReport stores a report about a pet. If the pet is listed and it now has
+// open reports from at least hideAt different users, it is hidden and hidden
+// is true. Reports of visitors who are not logged in are only counted by
+// IP address, which is cheap to change, so they never hide a pet on their own.
+// petfind.ErrReported is returned if the reporter has reported the pet before
+// and petfind.ErrNotFound if the pet does not exist.
+func (db *store) AddReport(r *petfind.Report, hideAt int64) (hidden bool, err error) {
+	const (
+		reportInsertStmt = `
+	INSERT INTO reports(pet_id, reason, details, reporter_id, reporter_key, created)
+	VALUES ($1, $2, $3, NULLIF($4, 0), $5, now())
+	ON CONFLICT (pet_id, reporter_key) DO NOTHING
+	RETURNING id, created
+	`
+		autoHideNote = "Hidden after %d reports."
+	)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Locking the pet makes concurrent reports count one after the other.
+	name, status, err := lockPet(tx, r.PetID)
+	if err != nil {
+		return false, err
+	}
+	err = tx.QueryRow(reportInsertStmt, r.PetID, r.Reason, r.Details, r.ReporterID, r.ReporterKey).Scan(&r.ID, &r.Created)
+	if err == sql.ErrNoRows {
+		return false, petfind.ErrReported
+	}
+	if err != nil {
+		return false, err
+	}
+
+	const openUserReportsQuery = `
+	SELECT COUNT(DISTINCT reporter_id)
+	FROM reports
+	WHERE pet_id = $1 AND NOT resolved AND reporter_id IS NOT NULL
+	`
+	var open int64
+	if err = tx.QueryRow(openUserReportsQuery, r.PetID).Scan(&open); err != nil {
+		return false, err
+	}
+	if status != petfind.PetListed || open < hideAt {
+		return false, nil
+	}
+	if _, err = tx.Exec("UPDATE pets SET status = $2 WHERE id = $1", r.PetID, petfind.PetHidden); err != nil {
+		return false, err
+	}
+	a := &petfind.ModerationAction{PetID: r.PetID, PetName: name, Kind: petfind.ModerationAutoHide, Note: fmt.Sprintf(autoHideNote, open)}
+	if err = addModerationAction(tx, a); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lockPet returns the name and status of a pet, locking its row until the
+// end of the transaction.
+func lockPet(tx *sql.Tx, petID int64) (name string, status petfind.PetStatus, err error) {
+	err = tx.QueryRow("SELECT name, status FROM pets WHERE id = $1 FOR UPDATE", petID).Scan(&name, &status)
+	if err == sql.ErrNoRows {
+		return "", 0, petfind.ErrNotFound
+	}
+	return name, status, err
+}
+
+func addModerationAction(tx *sql.Tx, a *petfind.ModerationAction) error {
+	const moderationActionInsertStmt = `
+	INSERT INTO moderation_actions(pet_id, pet_name, kind, moderator_id, note, created)
+	VALUES ($1, $2, $3, NULLIF($4, 0), $5, now())
+	RETURNING id, created
+	`
+	return tx.QueryRow(moderationActionInsertStmt, a.PetID, a.PetName, a.Kind, a.ModeratorID, a.Note).Scan(&a.ID, &a.Created)
+}
+
+// GetOpenReports returns the reports no moderator has acted on yet, grouped
+// by pet.
+func (db *store) GetOpenReports() ([]*petfind.Report, error) {
+	const openReportsQuery = `
+	SELECT
+	  id,
+	  pet_id,
+	  reason,
+	  details,
+	  COALESCE(reporter_id, 0),
+	  reporter_key,
+	  created,
+	  resolved
+	FROM reports
+	WHERE NOT resolved
+	ORDER BY pet_id, id
+	`
+	rows, err := db.Query(openReportsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	reports := make([]*petfind.Report, 0)
+	for rows.Next() {
+		r := new(petfind.Report)
+		if err := rows.Scan(
+			&r.ID,
+			&r.PetID,
+			&r.Reason,
+			&r.Details,
+			&r.ReporterID,
+			&r.ReporterKey,
+			&r.Created,
+			&r.Resolved,
+		); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// ModeratePet applies the action of a moderator to a pet, resolves the pet's
+// open reports and records the action in the audit trail.
+// petfind.ErrNotFound is returned if the pet does not exist and
+// petfind.ErrTransition if the action does not apply to the pet's status.
+func (db *store) ModeratePet(a *petfind.ModerationAction) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	var from, to petfind.PetStatus
+	if a.PetName, from, err = lockPet(tx, a.PetID); err != nil {
+		return err
+	}
+	if to, err = a.Kind.Apply(from); err != nil {
+		return err
+	}
+	if to != from {
+		// An approved pet counts as new from now on for saved search
+		// alerts.
+		const petStatusStmt = `
+		UPDATE pets SET
+		  status = $2,
+		  listed = CASE WHEN $2 = 0 THEN COALESCE(listed, now()) ELSE listed END
+		WHERE id = $1
+		`
+		if _, err = tx.Exec(petStatusStmt, a.PetID, to); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec("UPDATE reports SET resolved = true WHERE pet_id = $1 AND NOT resolved", a.PetID); err != nil {
+		return err
+	}
+	return addModerationAction(tx, a)
+}
+
+// GetModerationActions returns the latest entries of the moderation audit
+// trail, newest first.
+func (db *store) GetModerationActions(limit int) ([]*petfind.ModerationAction, error) {
+	const moderationActionsQuery = `
+	SELECT
+	  a.id,
+	  a.pet_id,
+	  a.pet_name,
+	  a.kind,
+	  COALESCE(a.moderator_id, 0),
+	  COALESCE(u.name, ''),
+	  a.note,
+	  a.created
+	FROM moderation_actions a
+	  LEFT JOIN users u ON a.moderator_id = u.id
+	ORDER BY a.id DESC
+	LIMIT $1
+	`
+	rows, err := db.Query(moderationActionsQuery, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	actions := make([]*petfind.ModerationAction, 0)
+	for rows.Next() {
+		a := new(petfind.ModerationAction)
+		if err := rows.Scan(
+			&a.ID,
+			&a.PetID,
+			&a.PetName,
+			&a.Kind,
+			&a.ModeratorID,
+			&a.ModeratorName,
+			&a.Note,
+			&a.Created,
+		); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
+
+// GetPendingPets returns the pets waiting for approval, oldest first.
+func (db *store) GetPendingPets() ([]*petfind.Pet, error) {
+	const pendingPetsQuery = petSelect + `
+	WHERE p.status = $1
+	ORDER BY p.id
+	`
+	rows, err := db.Query(pendingPetsQuery, petfind.PetPending)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	pets := make([]*petfind.Pet, 0)
+	for rows.Next() {
+		p := new(petfind.Pet)
+		u := new(petfind.User)
+		pl := new(petfind.Place)
+		if err := rows.Scan(
+			&p.ID,
+			&p.Name,
+			&p.Age,
+			&p.Type,
+			&p.Size,
+			&p.Gender,
+			&p.Contact.Phone,
+			&p.Contact.Email,
+			&p.Contact.Hours,
+			&p.Notes,
+			&p.Created,
+			&p.Updated,
+			&p.OwnerID,
+			&p.PhotoID,
+			&p.PlaceID,
+			&p.Status,
+			&p.ContactVisibility,
+			&u.ID,
+			&u.Name,
+			&u.Login,
+			&u.Email,
+			&u.Created,
+			&u.Updated,
+			&pl.ID,
+			&pl.Key,
+			&pl.Name,
+			&pl.GroupID,
+		); err != nil {
+			return nil, err
+		}
+		p.Owner = u
+		p.Place = pl
+		pets = append(pets, p)
+	}
+	return pets, nil
+}
+
+// HasApprovedPets reports whether a moderator has ever let a pet of the user
+// be listed. Hidden pets count since they were listed before.
+func (db *store) HasApprovedPets(ownerID int64) (bool, error) {
+	const approvedPetsQuery = `
+	SELECT EXISTS (SELECT 1 FROM pets WHERE owner_id = $1 AND status IN ($2, $3))
+	`
+	var approved bool
+	err := db.QueryRow(approvedPetsQuery, ownerID, petfind.PetListed, petfind.PetHidden).Scan(&approved)
+	return approved, err
+}
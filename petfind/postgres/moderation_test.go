@@ -0,0 +1,186 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestAddReport_autoHide(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+	var reporters []int64
+	for _, name := range []string{"Jane Roe", "John Roe", "Mary Roe"} {
+		u := &petfind.User{Name: name}
+		if err := s.CreateUser(u); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+		reporters = append(reporters, u.ID)
+	}
+
+	// Visitors who are not logged in never hide a pet on their own.
+	for i, key := range []string{"ip1", "ip2", "ip3"} {
+		hidden, err := s.AddReport(&petfind.Report{PetID: p.ID, Reason: petfind.ReasonSpam, ReporterKey: key}, 3)
+		if err != nil {
+			t.Fatalf("AddReport of visitor #%d failed: %v", i, err)
+		}
+		if hidden {
+			t.Fatalf("AddReport of visitor #%d hid the pet", i)
+		}
+	}
+	for i, key := range []string{"a", "b"} {
+		hidden, err := s.AddReport(&petfind.Report{PetID: p.ID, Reason: petfind.ReasonSpam, ReporterID: reporters[i], ReporterKey: key}, 3)
+		if err != nil {
+			t.Fatalf("AddReport #%d failed: %v", i, err)
+		}
+		if hidden {
+			t.Fatalf("AddReport #%d hid the pet before the threshold", i)
+		}
+	}
+	// The same reporter only counts once.
+	if _, err := s.AddReport(&petfind.Report{PetID: p.ID, Reason: petfind.ReasonScam, ReporterKey: "a"}, 3); err != petfind.ErrReported {
+		t.Fatalf("AddReport again returned %v, expected %v", err, petfind.ErrReported)
+	}
+	if _, err := s.AddReport(&petfind.Report{PetID: p.ID + 1, Reason: petfind.ReasonScam, ReporterKey: "a"}, 3); err != petfind.ErrNotFound {
+		t.Fatalf("AddReport for unknown pet returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	hidden, err := s.AddReport(&petfind.Report{PetID: p.ID, Reason: petfind.ReasonOther, Details: "fake", ReporterID: reporters[2], ReporterKey: "c"}, 3)
+	if err != nil {
+		t.Fatalf("AddReport failed: %v", err)
+	}
+	if !hidden {
+		t.Fatalf("AddReport did not hide the pet at the threshold")
+	}
+	if pets, err := s.GetFeaturedPets(); err != nil || len(pets) != 0 {
+		t.Fatalf("GetFeaturedPets after hiding returned %d pets, %v", len(pets), err)
+	}
+	pet, err := s.GetPet(p.ID)
+	if err != nil {
+		t.Fatalf("GetPet failed: %v", err)
+	}
+	if pet.Status != petfind.PetHidden {
+		t.Fatalf("GetPet after hiding Status = %v, want %v", pet.Status, petfind.PetHidden)
+	}
+
+	reports, err := s.GetOpenReports()
+	if err != nil {
+		t.Fatalf("GetOpenReports failed: %v", err)
+	}
+	if got, want := len(reports), 6; got != want {
+		t.Fatalf("GetOpenReports returned %d reports, want %d", got, want)
+	}
+	actions, err := s.GetModerationActions(10)
+	if err != nil {
+		t.Fatalf("GetModerationActions failed: %v", err)
+	}
+	if len(actions) != 1 || actions[0].Kind != petfind.ModerationAutoHide || actions[0].ModeratorID != 0 || actions[0].PetName != p.Name {
+		t.Fatalf("GetModerationActions after auto-hide = %#v", actions)
+	}
+}
+
+func TestModeratePet(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+	mod := &petfind.User{Name: "Mod", Login: "mod", Role: petfind.RoleModerator}
+	if err := s.CreateUser(mod); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	if _, err := s.AddReport(&petfind.Report{PetID: p.ID, Reason: petfind.ReasonSpam, ReporterKey: "a"}, 3); err != nil {
+		t.Fatalf("AddReport failed: %v", err)
+	}
+	if err := s.ModeratePet(&petfind.ModerationAction{PetID: p.ID, Kind: petfind.ModerationHide, ModeratorID: mod.ID, Note: "spam"}); err != nil {
+		t.Fatalf("ModeratePet hide failed: %v", err)
+	}
+	if pet, _ := s.GetPet(p.ID); pet.Status != petfind.PetHidden {
+		t.Fatalf("Status after hide = %v, want %v", pet.Status, petfind.PetHidden)
+	}
+	if reports, _ := s.GetOpenReports(); len(reports) != 0 {
+		t.Fatalf("GetOpenReports after hide returned %d reports, want 0", len(reports))
+	}
+
+	if err := s.ModeratePet(&petfind.ModerationAction{PetID: p.ID, Kind: petfind.ModerationRestore, ModeratorID: mod.ID}); err != nil {
+		t.Fatalf("ModeratePet restore failed: %v", err)
+	}
+	if pet, _ := s.GetPet(p.ID); pet.Status != petfind.PetListed {
+		t.Fatalf("Status after restore = %v, want %v", pet.Status, petfind.PetListed)
+	}
+	if err := s.ModeratePet(&petfind.ModerationAction{PetID: p.ID + 1, Kind: petfind.ModerationDismiss}); err != petfind.ErrNotFound {
+		t.Fatalf("ModeratePet for unknown pet returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	actions, err := s.GetModerationActions(10)
+	if err != nil {
+		t.Fatalf("GetModerationActions failed: %v", err)
+	}
+	if len(actions) != 2 {
+		t.Fatalf("GetModerationActions returned %d actions, want 2", len(actions))
+	}
+	if a := actions[1]; a.Kind != petfind.ModerationHide || a.ModeratorName != "Mod" || a.Note != "spam" {
+		t.Errorf("GetModerationActions oldest action = %#v", a)
+	}
+	if a := actions[0]; a.Kind != petfind.ModerationRestore {
+		t.Errorf("GetModerationActions newest action = %#v", a)
+	}
+}
+
+func TestPendingPets(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+
+	approved, err := s.HasApprovedPets(p.OwnerID)
+	if err != nil {
+		t.Fatalf("HasApprovedPets failed: %v", err)
+	}
+	if !approved {
+		t.Fatalf("HasApprovedPets = false for owner of a listed pet")
+	}
+
+	pending := &petfind.Pet{Name: "rex", OwnerID: p.OwnerID, PhotoID: p.PhotoID, PlaceID: p.PlaceID, Status: petfind.PetPending}
+	if err := s.AddPet(pending); err != nil {
+		t.Fatalf("AddPet failed: %v", err)
+	}
+	featured, err := s.GetFeaturedPets()
+	if err != nil {
+		t.Fatalf("GetFeaturedPets failed: %v", err)
+	}
+	if len(featured) != 1 || featured[0].ID != p.ID {
+		t.Fatalf("GetFeaturedPets included a pending pet: %v", featured)
+	}
+	found, err := s.SearchPets(petfind.Search{PlaceKey: p.Place.Key})
+	if err != nil {
+		t.Fatalf("SearchPets failed: %v", err)
+	}
+	if len(found) != 1 || found[0].ID != p.ID {
+		t.Fatalf("SearchPets included a pending pet: %v", found)
+	}
+	list, err := s.GetPendingPets()
+	if err != nil {
+		t.Fatalf("GetPendingPets failed: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != pending.ID || list[0].Owner.ID != p.OwnerID {
+		t.Fatalf("GetPendingPets = %v, want the pending pet", list)
+	}
+
+	if err := s.ModeratePet(&petfind.ModerationAction{PetID: pending.ID, Kind: petfind.ModerationRestore}); err != petfind.ErrTransition {
+		t.Fatalf("ModeratePet restore of pending pet returned %v, expected %v", err, petfind.ErrTransition)
+	}
+	if err := s.ModeratePet(&petfind.ModerationAction{PetID: pending.ID, Kind: petfind.ModerationReject, Note: "blurry"}); err != nil {
+		t.Fatalf("ModeratePet reject failed: %v", err)
+	}
+	if pet, _ := s.GetPet(pending.ID); pet.Status != petfind.PetRejected {
+		t.Fatalf("Status after reject = %v, want %v", pet.Status, petfind.PetRejected)
+	}
+	if err := s.ModeratePet(&petfind.ModerationAction{PetID: pending.ID, Kind: petfind.ModerationApprove}); err != petfind.ErrTransition {
+		t.Fatalf("ModeratePet approve of rejected pet returned %v, expected %v", err, petfind.ErrTransition)
+	}
+	if list, _ := s.GetPendingPets(); len(list) != 0 {
+		t.Fatalf("GetPendingPets after reject returned %d pets", len(list))
+	}
+}
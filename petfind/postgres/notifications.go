@@ -0,0 +1,97 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// AddNotification puts an email in the outbox, due to be sent right away.
+func (db *store) AddNotification(n *petfind.Notification) error {
+	const notificationInsertStmt = `
+	INSERT INTO outbox(recipient, subject, body, html, status, attempts, next_attempt, last_error, created, updated)
+	VALUES ($1, $2, $3, $4, 0, 0, now(), '', now(), now())
+	RETURNING id, status, attempts, next_attempt, created, updated
+	`
+	return db.QueryRow(notificationInsertStmt, n.To, n.Subject, n.Body, n.HTML).Scan(
+		&n.ID,
+		&n.Status,
+		&n.Attempts,
+		&n.NextAttempt,
+		&n.Created,
+		&n.Updated,
+	)
+}
+
+// ClaimNotifications returns up to limit pending emails that are due, the
+// oldest first. Their next attempt is pushed back by lease so that other
+// workers skip them while they are being sent and so that they are tried
+// again if the worker dies before updating them.
+func (db *store) ClaimNotifications(limit int, lease time.Duration) (notifications []*petfind.Notification, err error) {
+	const notificationsClaimStmt = `
+	UPDATE outbox SET next_attempt = now() + $2 * interval '1 microsecond'
+	WHERE id IN (
+	  SELECT id FROM outbox
+	  WHERE status = 0 AND next_attempt <= now()
+	  ORDER BY next_attempt
+	  LIMIT $1
+	  FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, recipient, subject, body, html, status, attempts, next_attempt, last_error, created, updated
+	`
+	rows, err := db.Query(notificationsClaimStmt, limit, int64(lease/time.Microsecond))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	notifications = make([]*petfind.Notification, 0)
+	for rows.Next() {
+		n := new(petfind.Notification)
+		if err := rows.Scan(
+			&n.ID,
+			&n.To,
+			&n.Subject,
+			&n.Body,
+			&n.HTML,
+			&n.Status,
+			&n.Attempts,
+			&n.NextAttempt,
+			&n.LastError,
+			&n.Created,
+			&n.Updated,
+		); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+// UpdateNotification records the outcome of an attempt to send an email.
+// Once an email is sent or dead its body is dropped. Emails carry sign in and
+// password reset links among others, which must not outlive their delivery in
+// the database.
+func (db *store) UpdateNotification(n *petfind.Notification) error {
+	const notificationUpdateStmt = `
+	UPDATE outbox SET
+	  status = $2,
+	  attempts = $3,
+	  next_attempt = $4,
+	  last_error = $5,
+	  body = CASE WHEN $2 = 0 THEN body ELSE '' END,
+	  html = CASE WHEN $2 = 0 THEN html ELSE '' END,
+	  updated = now()
+	WHERE id = $1
+	`
+	res, err := db.Exec(notificationUpdateStmt, n.ID, n.Status, n.Attempts, n.NextAttempt, n.LastError)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
@@ -0,0 +1,74 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestNotifications(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	n := &petfind.Notification{To: "jane@doe.com", Subject: "hi", Body: "hello", HTML: "<p>hello</p>"}
+	if err := s.AddNotification(n); err != nil {
+		t.Fatalf("AddNotification failed: %v", err)
+	}
+	if n.ID == 0 || n.Status != petfind.NotificationPending || n.Attempts != 0 {
+		t.Fatalf("AddNotification = %#v", n)
+	}
+
+	claimed, err := s.ClaimNotifications(10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimNotifications failed: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != n.ID || claimed[0].HTML != n.HTML {
+		t.Fatalf("ClaimNotifications = %#v", claimed)
+	}
+	// Claimed emails are leased to the worker that claimed them.
+	if claimed, err = s.ClaimNotifications(10, time.Minute); err != nil || len(claimed) != 0 {
+		t.Fatalf("ClaimNotifications while leased = %#v, %v", claimed, err)
+	}
+
+	// A failed attempt that is due again is claimed again.
+	n.Attempts = 1
+	n.LastError = "connection refused"
+	n.NextAttempt = time.Now().Add(-time.Second)
+	if err := s.UpdateNotification(n); err != nil {
+		t.Fatalf("UpdateNotification failed: %v", err)
+	}
+	claimed, err = s.ClaimNotifications(10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].Attempts != 1 || claimed[0].LastError != "connection refused" {
+		t.Fatalf("ClaimNotifications after failed attempt = %#v, %v", claimed, err)
+	}
+
+	// Sent and dead emails are never claimed.
+	for _, status := range []petfind.NotificationStatus{petfind.NotificationSent, petfind.NotificationDead} {
+		n.Status = status
+		if err := s.UpdateNotification(n); err != nil {
+			t.Fatalf("UpdateNotification failed: %v", err)
+		}
+		if claimed, err = s.ClaimNotifications(10, 0); err != nil || len(claimed) != 0 {
+			t.Fatalf("ClaimNotifications for %s email = %#v, %v", status, claimed, err)
+		}
+	}
+
+	// The body of sent and dead emails is gone for good, as seen by
+	// claiming the email again.
+	n.Status = petfind.NotificationPending
+	n.NextAttempt = time.Now().Add(-time.Second)
+	if err := s.UpdateNotification(n); err != nil {
+		t.Fatalf("UpdateNotification failed: %v", err)
+	}
+	if claimed, err = s.ClaimNotifications(10, 0); err != nil || len(claimed) != 1 || claimed[0].Body != "" || claimed[0].HTML != "" {
+		t.Fatalf("ClaimNotifications after sending = %#v, %v, expected no body", claimed, err)
+	}
+
+	n.ID++
+	if err := s.UpdateNotification(n); err != petfind.ErrNotFound {
+		t.Fatalf("UpdateNotification for unknown email returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
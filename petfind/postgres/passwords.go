@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// CreateLocalUser creates a user who logs in with their email address and a
+// password. The account starts with an unverified email address.
+// petfind.ErrEmailTaken is returned if the address already has an account.
+func (db *store) CreateLocalUser(u *petfind.User, passwordHash []byte) (err error) {
+	u.Email = strings.ToLower(strings.TrimSpace(u.Email))
+	const (
+		userInsertStmt = `
+	INSERT INTO users(login, name, email, email_verified, created, updated)
+	VALUES ($1, $2, $3, false, now(), now())
+	RETURNING id, created, updated
+	`
+		identityInsertStmt = `
+	INSERT INTO user_identities(user_id, provider, subject, login, name, email, created, updated)
+	VALUES ($1, $2, $3, '', $4, $3, now(), now())
+	ON CONFLICT (provider, subject) DO NOTHING
+	`
+		passwordInsertStmt = `
+	INSERT INTO user_passwords(user_id, hash, updated)
+	VALUES ($1, $2, now())
+	`
+	)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	err = tx.QueryRow(userInsertStmt, u.Login, u.Name, u.Email).Scan(&u.ID, &u.Created, &u.Updated)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(identityInsertStmt, u.ID, petfind.EmailProvider, u.Email, u.Name)
+	if err != nil {
+		return err
+	}
+	if n, rerr := res.RowsAffected(); rerr != nil || n == 0 {
+		// Roll back the user we just created.
+		err = petfind.ErrEmailTaken
+		return err
+	}
+	_, err = tx.Exec(passwordInsertStmt, u.ID, string(passwordHash))
+	return err
+}
+
+// GetPassword returns the password hash of a user or petfind.ErrNotFound if
+// they do not have a password.
+func (db *store) GetPassword(userID int64) ([]byte, error) {
+	var hash string
+	err := db.QueryRow("SELECT hash FROM user_passwords WHERE user_id = $1", userID).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(hash), nil
+}
+
+func (db *store) PutPassword(userID int64, passwordHash []byte) error {
+	const passwordPutStmt = `
+	INSERT INTO user_passwords(user_id, hash, updated)
+	VALUES ($1, $2, now())
+	ON CONFLICT (user_id) DO UPDATE SET hash = $2, updated = now()
+	`
+	_, err := db.Exec(passwordPutStmt, userID, string(passwordHash))
+	return err
+}
+
+// VerifyEmail marks email as the verified address of the user. Nothing
+// changes if the user's address has changed since the verification link was
+// sent.
+func (db *store) VerifyEmail(userID int64, email string) error {
+	const verifyEmailStmt = `
+	UPDATE users SET email_verified = true, updated = now()
+	WHERE id = $1 AND lower(email) = lower($2)
+	`
+	res, err := db.Exec(verifyEmailStmt, userID, email)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return petfind.ErrNotFound
+	}
+	return nil
+}
+
+// ClaimEmail marks email as the verified address of the user like VerifyEmail
+// when its owner signs in with a link sent to it. If the address was not
+// verified yet, whoever signed up with it never proved they own it and could
+// be someone else waiting for the owner to verify the account for them. So
+// the password, two-factor settings, passkeys, API tokens and sessions the
+// account has are removed in the same transaction. petfind.ErrNotFound is
+// returned if the user's address has changed since the link was sent.
+func (db *store) ClaimEmail(userID int64, email string) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	const emailLockQuery = `
+	SELECT email_verified FROM users
+	WHERE id = $1 AND lower(email) = lower($2)
+	FOR UPDATE
+	`
+	var verified bool
+	err = tx.QueryRow(emailLockQuery, userID, email).Scan(&verified)
+	if err == sql.ErrNoRows {
+		return petfind.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if verified {
+		return nil
+	}
+	for _, table := range []string{
+		"user_passwords",
+		"user_recovery_codes",
+		"user_totp",
+		"webauthn_credentials",
+		"api_tokens",
+		"user_sessions",
+	} {
+		if _, err = tx.Exec("DELETE FROM "+table+" WHERE user_id = $1", userID); err != nil {
+			return fmt.Errorf("error clearing %s of unverified user: %v", table, err)
+		}
+	}
+	_, err = tx.Exec("UPDATE users SET email_verified = true, updated = now() WHERE id = $1", userID)
+	return err
+}
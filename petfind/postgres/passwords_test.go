@@ -0,0 +1,147 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/psimika/secure-web-app/password"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestCreateLocalUser(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe", Email: " Jane@Doe.com "}
+	if err := s.CreateLocalUser(u, []byte("hash")); err != nil {
+		t.Fatalf("CreateLocalUser failed: %v", err)
+	}
+	if got, want := u.Email, "jane@doe.com"; got != want {
+		t.Fatalf("CreateLocalUser stored email %q, expected %q", got, want)
+	}
+
+	got, err := s.GetUserByIdentity(petfind.EmailProvider, "jane@doe.com")
+	if err != nil {
+		t.Fatalf("GetUserByIdentity failed: %v", err)
+	}
+	if got.ID != u.ID || got.EmailVerified {
+		t.Fatalf("GetUserByIdentity returned %#v, expected unverified user %d", got, u.ID)
+	}
+	hash, err := s.GetPassword(u.ID)
+	if err != nil {
+		t.Fatalf("GetPassword failed: %v", err)
+	}
+	if string(hash) != "hash" {
+		t.Fatalf("GetPassword returned %q, expected %q", hash, "hash")
+	}
+
+	// The same address cannot get a second account.
+	err = s.CreateLocalUser(&petfind.User{Name: "Jane", Email: "jane@doe.com"}, []byte("hash"))
+	if err != petfind.ErrEmailTaken {
+		t.Fatalf("CreateLocalUser with taken email returned %v, expected %v", err, petfind.ErrEmailTaken)
+	}
+
+	if err := s.VerifyEmail(u.ID, "jane@doe.com"); err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	got, err = s.GetUser(u.ID)
+	if err != nil {
+		t.Fatalf("GetUser failed: %v", err)
+	}
+	if !got.EmailVerified {
+		t.Fatal("VerifyEmail did not mark the email as verified")
+	}
+	if err := s.VerifyEmail(u.ID, "other@doe.com"); err != petfind.ErrNotFound {
+		t.Fatalf("VerifyEmail with a different address returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	if err := s.PutPassword(u.ID, []byte("new hash")); err != nil {
+		t.Fatalf("PutPassword failed: %v", err)
+	}
+	hash, err = s.GetPassword(u.ID)
+	if err != nil {
+		t.Fatalf("GetPassword failed: %v", err)
+	}
+	if string(hash) != "new hash" {
+		t.Fatalf("GetPassword after PutPassword returned %q, expected %q", hash, "new hash")
+	}
+}
+
+func TestGetPassword_notFound(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := s.GetPassword(u.ID); err != petfind.ErrNotFound {
+		t.Fatalf("GetPassword for user without password returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
+
+func TestClaimEmail(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	// Someone signs up with the owner's address and a password of their
+	// choosing, and manages to enroll a second factor and a passkey.
+	hash, err := password.Hash("attacker's password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &petfind.User{Name: "Jane Doe", Email: "jane@doe.com"}
+	if err := s.CreateLocalUser(u, hash); err != nil {
+		t.Fatalf("CreateLocalUser failed: %v", err)
+	}
+	if err := s.PutTOTP(&petfind.TOTP{UserID: u.ID, Secret: "SECRET"}); err != nil {
+		t.Fatalf("PutTOTP failed: %v", err)
+	}
+	if err := s.EnableTOTP(u.ID, 1, []string{"code"}); err != nil {
+		t.Fatalf("EnableTOTP failed: %v", err)
+	}
+	if err := s.AddWebAuthnCredential(&petfind.WebAuthnCredential{UserID: u.ID, CredentialID: []byte("cred"), PublicKey: []byte("key"), Name: "key"}); err != nil {
+		t.Fatalf("AddWebAuthnCredential failed: %v", err)
+	}
+
+	// The owner signs in with a link sent to the address.
+	if err := s.ClaimEmail(u.ID, "Jane@Doe.com"); err != nil {
+		t.Fatalf("ClaimEmail failed: %v", err)
+	}
+	got, err := s.GetUser(u.ID)
+	if err != nil {
+		t.Fatalf("GetUser failed: %v", err)
+	}
+	if !got.EmailVerified {
+		t.Error("ClaimEmail did not mark the email as verified")
+	}
+	// The old password no longer logs in.
+	hash, err = s.GetPassword(u.ID)
+	if err != nil && err != petfind.ErrNotFound {
+		t.Fatalf("GetPassword failed: %v", err)
+	}
+	if err := password.Compare(hash, "attacker's password"); err == nil {
+		t.Error("password set before the email was claimed still logs in")
+	}
+	if _, err := s.GetTOTP(u.ID); err != petfind.ErrNotFound {
+		t.Errorf("GetTOTP after ClaimEmail returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if creds, err := s.GetUserWebAuthnCredentials(u.ID); err != nil || len(creds) != 0 {
+		t.Errorf("GetUserWebAuthnCredentials after ClaimEmail = %d credentials, %v, expected none", len(creds), err)
+	}
+
+	// Once verified, claiming the address again keeps what the owner set up.
+	if err := s.PutPassword(u.ID, []byte("owner's hash")); err != nil {
+		t.Fatalf("PutPassword failed: %v", err)
+	}
+	if err := s.ClaimEmail(u.ID, "jane@doe.com"); err != nil {
+		t.Fatalf("ClaimEmail of verified address failed: %v", err)
+	}
+	if hash, err := s.GetPassword(u.ID); err != nil || string(hash) != "owner's hash" {
+		t.Errorf("GetPassword after claiming verified address = %q, %v, expected the owner's hash", hash, err)
+	}
+	if err := s.ClaimEmail(u.ID, "other@doe.com"); err != petfind.ErrNotFound {
+		t.Errorf("ClaimEmail with a different address returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
@@ -22,8 +22,8 @@ func (db *store) CountPets() (int64, error) {
 
 func (db *store) AddPet(p *petfind.Pet) error {
 	const petInsertStmt = `
-	INSERT INTO pets(name, age, size, type, gender, contact, notes, owner_id, photo_id, place_id, created, updated)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, now(), now())
+	INSERT INTO pets(name, age, size, type, gender, contact_phone, contact_email, contact_hours, notes, owner_id, photo_id, place_id, status, contact_visibility, created, updated, listed)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, now(), now(), CASE WHEN $13 = 0 THEN now() END)
 	RETURNING id, created, updated
 	`
 	stmt, err := db.Prepare(petInsertStmt)
@@ -36,41 +36,94 @@ func (db *store) AddPet(p *petfind.Pet) error {
 			return
 		}
 	}()
-	err = stmt.QueryRow(p.Name, p.Age, p.Size, p.Type, p.Gender, p.Contact, p.Notes, p.OwnerID, p.PhotoID, p.PlaceID).Scan(&p.ID, &p.Created, &p.Updated)
+	err = stmt.QueryRow(p.Name, p.Age, p.Size, p.Type, p.Gender, p.Contact.Phone, p.Contact.Email, p.Contact.Hours, p.Notes, p.OwnerID, p.PhotoID, p.PlaceID, p.Status, p.ContactVisibility).Scan(&p.ID, &p.Created, &p.Updated)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (db *store) GetPet(petID int64) (*petfind.Pet, error) {
-	const petGetQuery = `
-	SELECT *
+// ArchivePet removes a pet listing by giving it status petfind.PetArchived.
+// The pet's row stays so that everything that refers to it is kept.
+// petfind.ErrNotFound is returned if the pet does not exist or was already
+// removed.
+func (db *store) ArchivePet(petID int64) error {
+	res, err := db.Exec("UPDATE pets SET status = $2, updated = now() WHERE id = $1 AND status != $2", petID, petfind.PetArchived)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
+
+// petSelect selects pets together with their owner and place. The columns
+// are listed explicitly so that adding a column to one of the tables does not
+// shift the ones the Scan calls expect.
+const petSelect = `
+	SELECT
+	  p.id,
+	  p.name,
+	  p.age,
+	  p.type,
+	  p.size,
+	  p.gender,
+	  p.contact_phone,
+	  p.contact_email,
+	  p.contact_hours,
+	  p.notes,
+	  p.created,
+	  p.updated,
+	  p.owner_id,
+	  p.photo_id,
+	  p.place_id,
+	  p.status,
+	  p.contact_visibility,
+	  u.id,
+	  u.name,
+	  u.login,
+	  u.email,
+	  u.created,
+	  u.updated,
+	  pl.id,
+	  pl.key,
+	  pl.name,
+	  pl.group_id
 	FROM pets p
 	  JOIN users u ON p.owner_id = u.id
-	  JOIN places pl ON p.place_id = pl.id
-	WHERE p.id = $1
+	  JOIN places pl ON p.place_id = pl.id`
+
+// listedPetSelect selects only the pets that are shown to the public, the ones
+// with status petfind.PetListed.
+const listedPetSelect = petSelect + `
+	WHERE p.status = 0`
+
+// GetPet returns a pet. Removed pets are treated as if they did not exist and
+// petfind.ErrNotFound is returned for them.
+func (db *store) GetPet(petID int64) (*petfind.Pet, error) {
+	const petGetQuery = petSelect + `
+	WHERE p.id = $1 AND p.status != $2
 	`
 	p := new(petfind.Pet)
 	u := new(petfind.User)
 	pl := new(petfind.Place)
-	err := db.QueryRow(petGetQuery, petID).Scan(
+	err := db.QueryRow(petGetQuery, petID, petfind.PetArchived).Scan(
 		&p.ID,
 		&p.Name,
 		&p.Age,
 		&p.Type,
 		&p.Size,
 		&p.Gender,
-		&p.Contact,
+		&p.Contact.Phone,
+		&p.Contact.Email,
+		&p.Contact.Hours,
 		&p.Notes,
 		&p.Created,
 		&p.Updated,
 		&p.OwnerID,
 		&p.PhotoID,
 		&p.PlaceID,
+		&p.Status,
+		&p.ContactVisibility,
 		&u.ID,
-		&u.GithubID,
-		&u.LinkedinID,
 		&u.Name,
 		&u.Login,
 		&u.Email,
@@ -93,11 +146,7 @@ func (db *store) GetPet(petID int64) (*petfind.Pet, error) {
 }
 
 func (db *store) GetFeaturedPets() ([]*petfind.Pet, error) {
-	const petGetFeaturedQuery = `
-	SELECT *
-	FROM pets p
-	  JOIN users u ON p.owner_id = u.id
-	  JOIN places pl ON p.place_id = pl.id
+	const petGetFeaturedQuery = listedPetSelect + `
 	  ORDER by p.Created desc LIMIT 3
 	`
 	rows, err := db.Query(petGetFeaturedQuery)
@@ -123,16 +172,18 @@ func (db *store) GetFeaturedPets() ([]*petfind.Pet, error) {
 			&p.Type,
 			&p.Size,
 			&p.Gender,
-			&p.Contact,
+			&p.Contact.Phone,
+			&p.Contact.Email,
+			&p.Contact.Hours,
 			&p.Notes,
 			&p.Created,
 			&p.Updated,
 			&p.OwnerID,
 			&p.PhotoID,
 			&p.PlaceID,
+			&p.Status,
+			&p.ContactVisibility,
 			&u.ID,
-			&u.GithubID,
-			&u.LinkedinID,
 			&u.Name,
 			&u.Login,
 			&u.Email,
@@ -153,37 +204,7 @@ func (db *store) GetFeaturedPets() ([]*petfind.Pet, error) {
 }
 
 func (db *store) GetAllPets() ([]petfind.Pet, error) {
-	const petGetAllQuery = `
-	SELECT
-	  p.id,
-	  p.name,
-	  p.age,
-	  p.type,
-	  p.size,
-	  p.gender,
-	  p.contact,
-	  p.notes,
-	  p.created,
-	  p.updated,
-	  p.owner_id,
-	  p.photo_id,
-	  p.place_id,
-	  u.id,
-	  u.github_id,
-	  u.linkedin_id,
-	  u.name,
-	  u.login,
-	  u.email,
-	  u.created,
-	  u.updated,
-	  pl.id,
-	  pl.key,
-	  pl.name,
-	  pl.group_id
-	FROM pets p
-	  JOIN users u ON p.owner_id = u.id
-	  JOIN places pl ON p.place_id = pl.id
-	`
+	const petGetAllQuery = listedPetSelect
 	rows, err := db.Query(petGetAllQuery)
 	if err != nil {
 		return nil, err
@@ -207,16 +228,18 @@ func (db *store) GetAllPets() ([]petfind.Pet, error) {
 			&p.Type,
 			&p.Size,
 			&p.Gender,
-			&p.Contact,
+			&p.Contact.Phone,
+			&p.Contact.Email,
+			&p.Contact.Hours,
 			&p.Notes,
 			&p.Created,
 			&p.Updated,
 			&p.OwnerID,
 			&p.PhotoID,
 			&p.PlaceID,
+			&p.Status,
+			&p.ContactVisibility,
 			&u.ID,
-			&u.GithubID,
-			&u.LinkedinID,
 			&u.Name,
 			&u.Login,
 			&u.Email,
@@ -243,159 +266,111 @@ func (db *store) SearchPets(s petfind.Search) ([]*petfind.Pet, error) {
 	switch {
 	// 0000
 	default:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1`
+		q = listedPetSelect + `
+	      AND pl.key = $1`
 		rows, err = db.Query(q, s.PlaceKey)
 	// 0001
 	case !s.UseAge && !s.UseGender && !s.UseSize && s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.type = $2`
 		rows, err = db.Query(q, s.PlaceKey, s.Type)
 	// 0010
 	case !s.UseAge && !s.UseGender && s.UseSize && !s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.size = $2`
 		rows, err = db.Query(q, s.PlaceKey, s.Size)
 	// 0011
 	case !s.UseAge && !s.UseGender && s.UseSize && s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.size = $2
 	      AND p.type = $3`
 		rows, err = db.Query(q, s.PlaceKey, s.Size, s.Type)
 	// 0100
 	case !s.UseAge && s.UseGender && !s.UseSize && !s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.gender = $2`
 		rows, err = db.Query(q, s.PlaceKey, s.Gender)
 	// 0101
 	case !s.UseAge && s.UseGender && !s.UseSize && s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.gender = $2
 	      AND p.type = $3`
 		rows, err = db.Query(q, s.PlaceKey, s.Gender, s.Type)
 	// 0110
 	case !s.UseAge && s.UseGender && s.UseSize && !s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.gender = $2
 	      AND p.size = $3`
 		rows, err = db.Query(q, s.PlaceKey, s.Gender, s.Size)
 	// 0111
 	case !s.UseAge && s.UseGender && s.UseSize && s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.gender = $2
 	      AND p.size = $3
 		  AND p.type = $4`
 		rows, err = db.Query(q, s.PlaceKey, s.Gender, s.Size, s.Type)
 	// 1000
 	case s.UseAge && !s.UseGender && !s.UseSize && !s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.age = $2`
 		rows, err = db.Query(q, s.PlaceKey, s.Age)
 	// 1001
 	case s.UseAge && !s.UseGender && !s.UseSize && s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.age = $2
 		  AND p.type = $3`
 		rows, err = db.Query(q, s.PlaceKey, s.Age, s.Type)
 	// 1010
 	case s.UseAge && !s.UseGender && s.UseSize && !s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.age = $2
 		  AND p.size = $3`
 		rows, err = db.Query(q, s.PlaceKey, s.Age, s.Size)
 	// 1011
 	case s.UseAge && !s.UseGender && s.UseSize && s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.age = $2
 		  AND p.size = $3
 		  AND p.type = $4`
 		rows, err = db.Query(q, s.PlaceKey, s.Age, s.Size, s.Type)
 	// 1100
 	case s.UseAge && s.UseGender && !s.UseSize && !s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.age = $2
 		  AND p.gender = $3`
 		rows, err = db.Query(q, s.PlaceKey, s.Age, s.Gender)
 	// 1101
 	case s.UseAge && s.UseGender && !s.UseSize && s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.age = $2
 		  AND p.gender = $3
 		  AND p.type = $4`
 		rows, err = db.Query(q, s.PlaceKey, s.Age, s.Gender, s.Type)
 	// 1110
 	case s.UseAge && s.UseGender && s.UseSize && !s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.age = $2
 		  AND p.gender = $3
 		  AND p.size = $4`
 		rows, err = db.Query(q, s.PlaceKey, s.Age, s.Gender, s.Size)
 	// 1111
 	case s.UseAge && s.UseGender && s.UseSize && s.UseType:
-		q = `SELECT *
-	    FROM pets p
-	      JOIN users u ON p.owner_id = u.id
-	      JOIN places pl ON p.place_id = pl.id
-	      WHERE pl.key = $1
+		q = listedPetSelect + `
+	      AND pl.key = $1
 	      AND p.age = $2
 		  AND p.gender = $3
 		  AND p.size = $4
@@ -424,16 +399,18 @@ func (db *store) SearchPets(s petfind.Search) ([]*petfind.Pet, error) {
 			&p.Type,
 			&p.Size,
 			&p.Gender,
-			&p.Contact,
+			&p.Contact.Phone,
+			&p.Contact.Email,
+			&p.Contact.Hours,
 			&p.Notes,
 			&p.Created,
 			&p.Updated,
 			&p.OwnerID,
 			&p.PhotoID,
 			&p.PlaceID,
+			&p.Status,
+			&p.ContactVisibility,
 			&u.ID,
-			&u.GithubID,
-			&u.LinkedinID,
 			&u.Name,
 			&u.Login,
 			&u.Email,
@@ -452,3 +429,138 @@ func (db *store) SearchPets(s petfind.Search) ([]*petfind.Pet, error) {
 	}
 	return pets, nil
 }
+
+// SearchPetsPage returns a page of the listed pets that match s, the newest
+// first. Unlike SearchPets, an empty place key matches the pets of every
+// place.
+func (db *store) SearchPetsPage(s petfind.Search, limit, offset int) (pets []*petfind.Pet, err error) {
+	const petSearchPageQuery = listedPetSelect + `
+	  AND ($1 = '' OR pl.key = $1)
+	  AND ($2::integer IS NULL OR p.age = $2)
+	  AND ($3::integer IS NULL OR p.gender = $3)
+	  AND ($4::integer IS NULL OR p.size = $4)
+	  AND ($5::integer IS NULL OR p.type = $5)
+	ORDER BY p.id DESC
+	LIMIT $6 OFFSET $7
+	`
+	age, gender, size, typ := searchArgs(s)
+	rows, err := db.Query(petSearchPageQuery, s.PlaceKey, age, gender, size, typ, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	pets = make([]*petfind.Pet, 0)
+	for rows.Next() {
+		p := new(petfind.Pet)
+		u := new(petfind.User)
+		pl := new(petfind.Place)
+		if err := rows.Scan(
+			&p.ID,
+			&p.Name,
+			&p.Age,
+			&p.Type,
+			&p.Size,
+			&p.Gender,
+			&p.Contact.Phone,
+			&p.Contact.Email,
+			&p.Contact.Hours,
+			&p.Notes,
+			&p.Created,
+			&p.Updated,
+			&p.OwnerID,
+			&p.PhotoID,
+			&p.PlaceID,
+			&p.Status,
+			&p.ContactVisibility,
+			&u.ID,
+			&u.Name,
+			&u.Login,
+			&u.Email,
+			&u.Created,
+			&u.Updated,
+			&pl.ID,
+			&pl.Key,
+			&pl.Name,
+			&pl.GroupID,
+		); err != nil {
+			return nil, err
+		}
+		p.Owner = u
+		p.Place = pl
+		pets = append(pets, p)
+	}
+	return pets, nil
+}
+
+// ListPets returns the newest pets first whose name or owner's name, login or
+// email address contains query. An empty query matches all pets that have not
+// been removed.
+func (db *store) ListPets(query string, limit, offset int) ([]*petfind.Pet, error) {
+	const petListQuery = petSelect + `
+	WHERE p.status != $4 AND ($1 = ''
+	  OR p.name ILIKE '%' || $1 || '%'
+	  OR u.name ILIKE '%' || $1 || '%'
+	  OR u.login ILIKE '%' || $1 || '%'
+	  OR u.email ILIKE '%' || $1 || '%')
+	ORDER BY p.id DESC
+	LIMIT $2 OFFSET $3
+	`
+	rows, err := db.Query(petListQuery, escapeLike(query), limit, offset, petfind.PetArchived)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	pets := make([]*petfind.Pet, 0)
+	for rows.Next() {
+		p := new(petfind.Pet)
+		u := new(petfind.User)
+		pl := new(petfind.Place)
+		if err := rows.Scan(
+			&p.ID,
+			&p.Name,
+			&p.Age,
+			&p.Type,
+			&p.Size,
+			&p.Gender,
+			&p.Contact.Phone,
+			&p.Contact.Email,
+			&p.Contact.Hours,
+			&p.Notes,
+			&p.Created,
+			&p.Updated,
+			&p.OwnerID,
+			&p.PhotoID,
+			&p.PlaceID,
+			&p.Status,
+			&p.ContactVisibility,
+			&u.ID,
+			&u.Name,
+			&u.Login,
+			&u.Email,
+			&u.Created,
+			&u.Updated,
+			&pl.ID,
+			&pl.Key,
+			&pl.Name,
+			&pl.GroupID,
+		); err != nil {
+			return nil, err
+		}
+		p.Owner = u
+		p.Place = pl
+		pets = append(pets, p)
+	}
+	return pets, nil
+}
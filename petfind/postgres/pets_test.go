@@ -16,8 +16,7 @@ func TestAddPet(t *testing.T) {
 	defer teardown(t, s)
 
 	// Create pet's owner.
-	githubID := int64(5)
-	owner := &petfind.User{Name: "Jane Doe", GithubID: githubID}
+	owner := &petfind.User{Name: "Jane Doe", Login: "janedoe"}
 	if err := s.CreateUser(owner); err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -45,7 +44,7 @@ func TestAddPet(t *testing.T) {
 		PhotoID: photo.ID,
 		PlaceID: place.ID,
 		Notes:   "notes",
-		Contact: "123",
+		Contact: petfind.Contact{Phone: "+302101234567", Email: "jane@example.com", Hours: "Evenings"},
 	}
 	if err := s.AddPet(p); err != nil {
 		t.Fatalf("AddPet failed: %v", err)
@@ -74,7 +73,7 @@ func TestAddPet(t *testing.T) {
 			Owner:   owner,
 			Place:   place,
 			Notes:   "notes",
-			Contact: "123",
+			Contact: petfind.Contact{Phone: "+302101234567", Email: "jane@example.com", Hours: "Evenings"},
 		},
 	}
 	if got := pets; !reflect.DeepEqual(got, want) {
@@ -298,6 +297,44 @@ func TestSearchPets(t *testing.T) {
 	}
 }
 
+func TestSearchPetsPage(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+
+	tests := []struct {
+		s    petfind.Search
+		want int
+	}{
+		{petfind.Search{}, 1},
+		{petfind.Search{PlaceKey: "key"}, 1},
+		{petfind.Search{Type: petfind.Cat, UseType: true, Gender: petfind.Female, UseGender: true}, 1},
+		{petfind.Search{PlaceKey: "other"}, 0},
+		{petfind.Search{Type: petfind.Dog, UseType: true}, 0},
+		// A zero value is only matched if it is used.
+		{petfind.Search{Size: petfind.Large, UseSize: true}, 0},
+	}
+	for i, tt := range tests {
+		pets, err := s.SearchPetsPage(tt.s, 10, 0)
+		if err != nil {
+			t.Fatalf("SearchPetsPage #%d failed: %v", i, err)
+		}
+		if len(pets) != tt.want {
+			t.Fatalf("SearchPetsPage #%d returned %d pets, want %d", i, len(pets), tt.want)
+		}
+		if tt.want != 0 && (pets[0].ID != p.ID || pets[0].Place.Key != "key") {
+			t.Errorf("SearchPetsPage #%d returned %#v, want %#v", i, pets[0], p)
+		}
+	}
+	pets, err := s.SearchPetsPage(petfind.Search{}, 10, 1)
+	if err != nil {
+		t.Fatalf("SearchPetsPage with offset failed: %v", err)
+	}
+	if len(pets) != 0 {
+		t.Errorf("SearchPetsPage with offset past the end returned %d pets", len(pets))
+	}
+}
+
 func TestCountPets(t *testing.T) {
 	s := setup(t)
 	defer teardown(t, s)
@@ -348,10 +385,91 @@ func TestGetFeaturedPets(t *testing.T) {
 	}
 }
 
+func TestArchivePet(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+	report := &petfind.Report{PetID: p.ID, Reason: petfind.ReasonSpam, ReporterKey: "key"}
+	if _, err := s.AddReport(report, 10); err != nil {
+		t.Fatalf("AddReport failed: %v", err)
+	}
+
+	if err := s.ArchivePet(p.ID); err != nil {
+		t.Fatalf("ArchivePet failed: %v", err)
+	}
+	if _, err := s.GetPet(p.ID); err != petfind.ErrNotFound {
+		t.Fatalf("GetPet after ArchivePet returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if err := s.ArchivePet(p.ID); err != petfind.ErrNotFound {
+		t.Fatalf("ArchivePet again returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	pets, err := s.SearchPetsPage(petfind.Search{}, 10, 0)
+	if err != nil {
+		t.Fatalf("SearchPetsPage failed: %v", err)
+	}
+	listed, err := s.ListPets("", 10, 0)
+	if err != nil {
+		t.Fatalf("ListPets failed: %v", err)
+	}
+	if len(pets) != 0 || len(listed) != 0 {
+		t.Fatalf("removed pet is still listed: %d search results, %d admin results", len(pets), len(listed))
+	}
+	// The evidence moderators rely on is kept.
+	reports, err := s.GetOpenReports()
+	if err != nil {
+		t.Fatalf("GetOpenReports failed: %v", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("GetOpenReports after ArchivePet returned %d reports, expected 1", len(reports))
+	}
+}
+
+func TestListPets(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 1},
+		{"ZAZ", 1},
+		{"janedoe", 1},
+		{"rex", 0},
+	}
+	for _, tt := range tests {
+		pets, err := s.ListPets(tt.query, 10, 0)
+		if err != nil {
+			t.Fatalf("ListPets(%q) failed: %v", tt.query, err)
+		}
+		if got := len(pets); got != tt.want {
+			t.Fatalf("ListPets(%q) returned %d pets, want %d", tt.query, got, tt.want)
+		}
+		if tt.want != 0 && (pets[0].ID != p.ID || pets[0].Owner.Login != "janedoe" || pets[0].Place.Key != "key") {
+			t.Errorf("ListPets(%q) returned %#v, want %#v", tt.query, pets[0], p)
+		}
+	}
+	pets, err := s.ListPets("", 10, 1)
+	if err != nil {
+		t.Fatalf("ListPets with offset failed: %v", err)
+	}
+	if len(pets) != 0 {
+		t.Errorf("ListPets with offset past the end returned %d pets", len(pets))
+	}
+
+	photos, err := s.ListPhotos(10, 0)
+	if err != nil {
+		t.Fatalf("ListPhotos failed: %v", err)
+	}
+	if len(photos) != 1 || photos[0].ID != p.PhotoID {
+		t.Errorf("ListPhotos returned %v, want the photo with ID %d", photos, p.PhotoID)
+	}
+}
+
 func addTestPet(t *testing.T, s petfind.Store) *petfind.Pet {
 	// Create pet's owner.
-	githubID := int64(5)
-	owner := &petfind.User{Name: "Jane Doe", GithubID: githubID}
+	owner := &petfind.User{Name: "Jane Doe", Login: "janedoe"}
 	if err := s.CreateUser(owner); err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
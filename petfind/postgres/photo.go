@@ -58,3 +58,46 @@ func (db *store) GetPhoto(photoID int64) (*petfind.Photo, error) {
 	}
 	return p, nil
 }
+
+// ListPhotos returns the most recently uploaded photos first.
+func (db *store) ListPhotos(limit, offset int) ([]*petfind.Photo, error) {
+	const photoListQuery = `
+	SELECT
+	  id,
+	  key,
+	  url,
+	  original_filename,
+	  content_type,
+	  created
+	FROM photos
+	ORDER BY id DESC
+	LIMIT $1 OFFSET $2
+	`
+	rows, err := db.Query(photoListQuery, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	photos := make([]*petfind.Photo, 0)
+	for rows.Next() {
+		p := new(petfind.Photo)
+		if err := rows.Scan(
+			&p.ID,
+			&p.Key,
+			&p.URL,
+			&p.OriginalFilename,
+			&p.ContentType,
+			&p.Created,
+		); err != nil {
+			return nil, err
+		}
+		photos = append(photos, p)
+	}
+	return photos, nil
+}
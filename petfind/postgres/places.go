@@ -222,3 +222,86 @@ func (db *store) GetPlaceByKey(placeKey string) (*petfind.Place, error) {
 	return p, nil
 
 }
+
+// UpdatePlaceGroup renames a place group. petfind.ErrNotFound is returned if
+// the group does not exist.
+func (db *store) UpdatePlaceGroup(g *petfind.PlaceGroup) error {
+	res, err := db.Exec("UPDATE place_groups SET name = $2 WHERE id = $1", g.ID, g.Name)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
+
+// DeletePlaceGroup removes a place group. petfind.ErrInUse is returned if it
+// still has places and petfind.ErrNotFound if it does not exist.
+func (db *store) DeletePlaceGroup(groupID int64) error {
+	const placeGroupDeleteStmt = `
+	DELETE FROM place_groups
+	WHERE id = $1
+	  AND NOT EXISTS (SELECT 1 FROM places WHERE group_id = $1)
+	`
+	res, err := db.Exec(placeGroupDeleteStmt, groupID)
+	if err != nil {
+		return err
+	}
+	if err = notFoundIfNone(res); err != petfind.ErrNotFound {
+		return err
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM place_groups WHERE id = $1)", groupID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return petfind.ErrInUse
+	}
+	return petfind.ErrNotFound
+}
+
+// UpdatePlace changes the key, name and group of a place. petfind.ErrNotFound
+// is returned if the place does not exist.
+func (db *store) UpdatePlace(p *petfind.Place) error {
+	res, err := db.Exec("UPDATE places SET key = $2, name = $3, group_id = $4 WHERE id = $1", p.ID, p.Key, p.Name, p.GroupID)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
+
+// DeletePlace removes a place. petfind.ErrInUse is returned if pets are still
+// listed in it and petfind.ErrNotFound if it does not exist.
+func (db *store) DeletePlace(placeID int64) error {
+	const placeDeleteStmt = `
+	DELETE FROM places
+	WHERE id = $1
+	  AND NOT EXISTS (SELECT 1 FROM pets WHERE place_id = $1)
+	`
+	res, err := db.Exec(placeDeleteStmt, placeID)
+	if err != nil {
+		return err
+	}
+	if err = notFoundIfNone(res); err != petfind.ErrNotFound {
+		return err
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM places WHERE id = $1)", placeID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return petfind.ErrInUse
+	}
+	return petfind.ErrNotFound
+}
+
+// notFoundIfNone returns petfind.ErrNotFound if a statement did not affect
+// any rows.
+func notFoundIfNone(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return petfind.ErrNotFound
+	}
+	return nil
+}
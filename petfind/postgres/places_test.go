@@ -45,3 +45,70 @@ func TestGetPlaceGroups(t *testing.T) {
 		}
 	}
 }
+
+func TestManagePlaces(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	g := &petfind.PlaceGroup{Name: "group"}
+	if err := s.AddPlaceGroup(g); err != nil {
+		t.Fatalf("AddPlaceGroup failed: %v", err)
+	}
+	p := &petfind.Place{Name: "place", Key: "key", GroupID: g.ID}
+	if err := s.AddPlace(p); err != nil {
+		t.Fatalf("AddPlace failed: %v", err)
+	}
+
+	g.Name = "renamed group"
+	if err := s.UpdatePlaceGroup(g); err != nil {
+		t.Fatalf("UpdatePlaceGroup failed: %v", err)
+	}
+	p.Name, p.Key = "renamed place", "key2"
+	if err := s.UpdatePlace(p); err != nil {
+		t.Fatalf("UpdatePlace failed: %v", err)
+	}
+	got, err := s.GetPlace(p.ID)
+	if err != nil {
+		t.Fatalf("GetPlace failed: %v", err)
+	}
+	if *got != *p {
+		t.Fatalf("GetPlace after UpdatePlace = %#v, want %#v", got, p)
+	}
+	groups, err := s.GetPlaceGroups()
+	if err != nil {
+		t.Fatalf("GetPlaceGroups failed: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Name != "renamed group" {
+		t.Fatalf("GetPlaceGroups after UpdatePlaceGroup = %#v", groups)
+	}
+
+	// A group cannot be deleted while it has places.
+	if err := s.DeletePlaceGroup(g.ID); err != petfind.ErrInUse {
+		t.Fatalf("DeletePlaceGroup with places returned %v, expected %v", err, petfind.ErrInUse)
+	}
+	if err := s.DeletePlace(p.ID); err != nil {
+		t.Fatalf("DeletePlace failed: %v", err)
+	}
+	if err := s.DeletePlace(p.ID); err != petfind.ErrNotFound {
+		t.Fatalf("DeletePlace again returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if err := s.DeletePlaceGroup(g.ID); err != nil {
+		t.Fatalf("DeletePlaceGroup failed: %v", err)
+	}
+	if err := s.DeletePlaceGroup(g.ID); err != petfind.ErrNotFound {
+		t.Fatalf("DeletePlaceGroup again returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if err := s.UpdatePlace(p); err != petfind.ErrNotFound {
+		t.Fatalf("UpdatePlace of deleted place returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
+
+func TestDeletePlace_inUse(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	pet := addTestPet(t, s)
+
+	if err := s.DeletePlace(pet.PlaceID); err != petfind.ErrInUse {
+		t.Fatalf("DeletePlace with pets returned %v, expected %v", err, petfind.ErrInUse)
+	}
+}
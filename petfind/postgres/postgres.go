@@ -30,17 +30,165 @@ func (db *store) MakeSchema() error {
 	// users
 	const users = `CREATE TABLE IF NOT EXISTS users (
 		id bigserial PRIMARY KEY,
-		github_id bigint DEFAULT 0,
-		linkedin_id varchar(100) NOT NULL DEFAULT '',
 		name varchar(70),
 		login varchar(70) NOT NULL DEFAULT '',
 		email varchar(70) NOT NULL DEFAULT '',
+		email_verified boolean NOT NULL DEFAULT false,
+		role integer NOT NULL DEFAULT 0,
+		disabled boolean NOT NULL DEFAULT false,
 		created timestamptz,
 		updated timestamptz
 	)`
 	if _, err := db.Exec(users); err != nil {
 		return fmt.Errorf("error creating table users: %v", err)
 	}
+	// Databases created before local accounts lack the column.
+	const usersEmailVerified = `ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified boolean NOT NULL DEFAULT false`
+	if _, err := db.Exec(usersEmailVerified); err != nil {
+		return fmt.Errorf("error adding users.email_verified: %v", err)
+	}
+	const usersRole = `ALTER TABLE users ADD COLUMN IF NOT EXISTS role integer NOT NULL DEFAULT 0`
+	if _, err := db.Exec(usersRole); err != nil {
+		return fmt.Errorf("error adding users.role: %v", err)
+	}
+	const usersDisabled = `ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled boolean NOT NULL DEFAULT false`
+	if _, err := db.Exec(usersDisabled); err != nil {
+		return fmt.Errorf("error adding users.disabled: %v", err)
+	}
+
+	// user_identities
+	const userIdentities = `CREATE TABLE IF NOT EXISTS user_identities (
+		id bigserial PRIMARY KEY,
+		user_id bigint NOT NULL references users ON DELETE CASCADE,
+		provider varchar(30) NOT NULL,
+		subject varchar(255) NOT NULL,
+		login varchar(70) NOT NULL DEFAULT '',
+		name varchar(70) NOT NULL DEFAULT '',
+		email varchar(255) NOT NULL DEFAULT '',
+		created timestamptz,
+		updated timestamptz,
+		UNIQUE (provider, subject)
+	)`
+	if _, err := db.Exec(userIdentities); err != nil {
+		return fmt.Errorf("error creating table user_identities: %v", err)
+	}
+	if err := db.migrateUserIdentities(); err != nil {
+		return fmt.Errorf("error migrating users to user_identities: %v", err)
+	}
+
+	// user_passwords
+	const userPasswords = `CREATE TABLE IF NOT EXISTS user_passwords (
+		user_id bigint PRIMARY KEY references users ON DELETE CASCADE,
+		hash varchar(255) NOT NULL,
+		updated timestamptz
+	)`
+	if _, err := db.Exec(userPasswords); err != nil {
+		return fmt.Errorf("error creating table user_passwords: %v", err)
+	}
+
+	// user_tokens
+	const userTokens = `CREATE TABLE IF NOT EXISTS user_tokens (
+		id bigserial PRIMARY KEY,
+		user_id bigint references users ON DELETE CASCADE,
+		kind varchar(30) NOT NULL,
+		hash varchar(64) UNIQUE NOT NULL,
+		email varchar(255) NOT NULL DEFAULT '',
+		created timestamptz,
+		expires timestamptz NOT NULL,
+		used timestamptz
+	)`
+	if _, err := db.Exec(userTokens); err != nil {
+		return fmt.Errorf("error creating table user_tokens: %v", err)
+	}
+	// Sign in links can be sent to addresses without an account yet.
+	const userTokensNullUser = `ALTER TABLE user_tokens ALTER COLUMN user_id DROP NOT NULL`
+	if _, err := db.Exec(userTokensNullUser); err != nil {
+		return fmt.Errorf("error altering user_tokens.user_id: %v", err)
+	}
+	const userTokensEmailIndex = `CREATE INDEX IF NOT EXISTS user_tokens_kind_email_idx ON user_tokens (kind, email, created)`
+	if _, err := db.Exec(userTokensEmailIndex); err != nil {
+		return fmt.Errorf("error creating index on user_tokens: %v", err)
+	}
+
+	// user_totp
+	const userTOTP = `CREATE TABLE IF NOT EXISTS user_totp (
+		user_id bigint PRIMARY KEY references users ON DELETE CASCADE,
+		secret varchar(64) NOT NULL,
+		enabled boolean NOT NULL DEFAULT false,
+		last_step bigint NOT NULL DEFAULT 0,
+		created timestamptz
+	)`
+	if _, err := db.Exec(userTOTP); err != nil {
+		return fmt.Errorf("error creating table user_totp: %v", err)
+	}
+	const userTOTPFailures = `ALTER TABLE user_totp
+		ADD COLUMN IF NOT EXISTS failed_attempts bigint NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS locked_until timestamptz`
+	if _, err := db.Exec(userTOTPFailures); err != nil {
+		return fmt.Errorf("error adding user_totp failed attempts: %v", err)
+	}
+
+	// user_recovery_codes
+	const userRecoveryCodes = `CREATE TABLE IF NOT EXISTS user_recovery_codes (
+		id bigserial PRIMARY KEY,
+		user_id bigint NOT NULL references users ON DELETE CASCADE,
+		hash varchar(64) NOT NULL,
+		created timestamptz,
+		used timestamptz,
+		UNIQUE (user_id, hash)
+	)`
+	if _, err := db.Exec(userRecoveryCodes); err != nil {
+		return fmt.Errorf("error creating table user_recovery_codes: %v", err)
+	}
+
+	// webauthn_credentials
+	const webauthnCredentials = `CREATE TABLE IF NOT EXISTS webauthn_credentials (
+		id bigserial PRIMARY KEY,
+		user_id bigint NOT NULL references users ON DELETE CASCADE,
+		credential_id bytea UNIQUE NOT NULL,
+		public_key bytea NOT NULL,
+		sign_count bigint NOT NULL DEFAULT 0,
+		name varchar(70) NOT NULL DEFAULT '',
+		created timestamptz,
+		last_used timestamptz
+	)`
+	if _, err := db.Exec(webauthnCredentials); err != nil {
+		return fmt.Errorf("error creating table webauthn_credentials: %v", err)
+	}
+
+	// api_tokens. window_start and window_count count the requests made with
+	// a token in the current rate limiting window.
+	const apiTokens = `CREATE TABLE IF NOT EXISTS api_tokens (
+		id bigserial PRIMARY KEY,
+		user_id bigint NOT NULL references users ON DELETE CASCADE,
+		name varchar(70) NOT NULL DEFAULT '',
+		prefix varchar(20) NOT NULL,
+		hash varchar(64) UNIQUE NOT NULL,
+		scope integer NOT NULL DEFAULT 0,
+		created timestamptz,
+		expires timestamptz NOT NULL,
+		last_used timestamptz,
+		last_ip varchar(45) NOT NULL DEFAULT '',
+		window_start timestamptz,
+		window_count bigint NOT NULL DEFAULT 0
+	)`
+	if _, err := db.Exec(apiTokens); err != nil {
+		return fmt.Errorf("error creating table api_tokens: %v", err)
+	}
+
+	// user_sessions
+	const userSessions = `CREATE TABLE IF NOT EXISTS user_sessions (
+		id bigserial PRIMARY KEY,
+		key_hash varchar(64) UNIQUE NOT NULL,
+		user_id bigint references users ON DELETE CASCADE,
+		ip varchar(45) NOT NULL DEFAULT '',
+		user_agent text NOT NULL DEFAULT '',
+		created timestamptz,
+		last_seen timestamptz
+	)`
+	if _, err := db.Exec(userSessions); err != nil {
+		return fmt.Errorf("error creating table user_sessions: %v", err)
+	}
 
 	// photos
 	const photos = `CREATE TABLE IF NOT EXISTS photos (
@@ -83,21 +231,332 @@ func (db *store) MakeSchema() error {
 		type integer,
 		size integer,
 		gender integer,
-		contact varchar(50),
+		contact_phone varchar(50) NOT NULL DEFAULT '',
+		contact_email varchar(255) NOT NULL DEFAULT '',
+		contact_hours varchar(100) NOT NULL DEFAULT '',
 		notes text,
 		created timestamptz,
 		updated timestamptz,
 		owner_id bigint references users,
 		photo_id bigint references photos,
-		place_id bigint references places
+		place_id bigint references places,
+		status integer NOT NULL DEFAULT 0,
+		contact_visibility integer NOT NULL DEFAULT 0
 	)`
 	if _, err := db.Exec(pets); err != nil {
 		return fmt.Errorf("error creating table pets: %v", err)
 	}
+	const petsStatus = `ALTER TABLE pets ADD COLUMN IF NOT EXISTS status integer NOT NULL DEFAULT 0`
+	if _, err := db.Exec(petsStatus); err != nil {
+		return fmt.Errorf("error adding pets.status: %v", err)
+	}
+	const petsContactVisibility = `ALTER TABLE pets ADD COLUMN IF NOT EXISTS contact_visibility integer NOT NULL DEFAULT 0`
+	if _, err := db.Exec(petsContactVisibility); err != nil {
+		return fmt.Errorf("error adding pets.contact_visibility: %v", err)
+	}
+	// listed is when the pet was first shown to the public which, with
+	// pre-moderation, can be long after it was created. Pets listed before
+	// the column existed were listed when they were created.
+	const petsListed = `ALTER TABLE pets ADD COLUMN IF NOT EXISTS listed timestamptz`
+	if _, err := db.Exec(petsListed); err != nil {
+		return fmt.Errorf("error adding pets.listed: %v", err)
+	}
+	const petsListedBackfill = `UPDATE pets SET listed = created WHERE listed IS NULL AND status IN (0, 1)`
+	if _, err := db.Exec(petsListedBackfill); err != nil {
+		return fmt.Errorf("error filling in pets.listed: %v", err)
+	}
+	if err := db.migrateHideContact(); err != nil {
+		return fmt.Errorf("error migrating pets.hide_contact: %v", err)
+	}
+	const petsContactMethods = `ALTER TABLE pets
+		ADD COLUMN IF NOT EXISTS contact_phone varchar(50) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS contact_email varchar(255) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS contact_hours varchar(100) NOT NULL DEFAULT ''`
+	if _, err := db.Exec(petsContactMethods); err != nil {
+		return fmt.Errorf("error adding pets contact methods: %v", err)
+	}
+	if err := db.migrateContact(); err != nil {
+		return fmt.Errorf("error migrating pets.contact: %v", err)
+	}
+
+	// reports
+	const reports = `CREATE TABLE IF NOT EXISTS reports (
+		id bigserial PRIMARY KEY,
+		pet_id bigint NOT NULL references pets ON DELETE CASCADE,
+		reason integer NOT NULL,
+		details text NOT NULL DEFAULT '',
+		reporter_id bigint references users ON DELETE SET NULL,
+		reporter_key varchar(64) NOT NULL,
+		created timestamptz,
+		resolved boolean NOT NULL DEFAULT false,
+		UNIQUE (pet_id, reporter_key)
+	)`
+	if _, err := db.Exec(reports); err != nil {
+		return fmt.Errorf("error creating table reports: %v", err)
+	}
+
+	// moderation_actions
+	const moderationActions = `CREATE TABLE IF NOT EXISTS moderation_actions (
+		id bigserial PRIMARY KEY,
+		pet_id bigint NOT NULL,
+		pet_name varchar(70) NOT NULL DEFAULT '',
+		kind varchar(30) NOT NULL,
+		moderator_id bigint references users ON DELETE SET NULL,
+		note text NOT NULL DEFAULT '',
+		created timestamptz
+	)`
+	if _, err := db.Exec(moderationActions); err != nil {
+		return fmt.Errorf("error creating table moderation_actions: %v", err)
+	}
+
+	// contact_reveals
+	const contactReveals = `CREATE TABLE IF NOT EXISTS contact_reveals (
+		id bigserial PRIMARY KEY,
+		pet_id bigint NOT NULL references pets ON DELETE CASCADE,
+		user_id bigint references users ON DELETE SET NULL,
+		visitor_key varchar(64) NOT NULL,
+		ip varchar(45) NOT NULL DEFAULT '',
+		created timestamptz
+	)`
+	if _, err := db.Exec(contactReveals); err != nil {
+		return fmt.Errorf("error creating table contact_reveals: %v", err)
+	}
+	const contactRevealsIndex = `CREATE INDEX IF NOT EXISTS contact_reveals_visitor_idx ON contact_reveals (visitor_key, created)`
+	if _, err := db.Exec(contactRevealsIndex); err != nil {
+		return fmt.Errorf("error creating index contact_reveals_visitor_idx: %v", err)
+	}
+
+	// conversations
+	const conversations = `CREATE TABLE IF NOT EXISTS conversations (
+		id bigserial PRIMARY KEY,
+		pet_id bigint NOT NULL references pets ON DELETE CASCADE,
+		owner_id bigint NOT NULL references users ON DELETE CASCADE,
+		adopter_id bigint NOT NULL references users ON DELETE CASCADE,
+		owner_replied boolean NOT NULL DEFAULT false,
+		owner_seen timestamptz NOT NULL DEFAULT '-infinity',
+		adopter_seen timestamptz NOT NULL DEFAULT '-infinity',
+		created timestamptz,
+		updated timestamptz,
+		UNIQUE (pet_id, adopter_id)
+	)`
+	if _, err := db.Exec(conversations); err != nil {
+		return fmt.Errorf("error creating table conversations: %v", err)
+	}
+
+	// messages
+	const messages = `CREATE TABLE IF NOT EXISTS messages (
+		id bigserial PRIMARY KEY,
+		conversation_id bigint NOT NULL references conversations ON DELETE CASCADE,
+		sender_id bigint NOT NULL references users ON DELETE CASCADE,
+		body text NOT NULL,
+		created timestamptz
+	)`
+	if _, err := db.Exec(messages); err != nil {
+		return fmt.Errorf("error creating table messages: %v", err)
+	}
+
+	// favorites
+	const favorites = `CREATE TABLE IF NOT EXISTS favorites (
+		user_id bigint NOT NULL references users ON DELETE CASCADE,
+		pet_id bigint NOT NULL references pets ON DELETE CASCADE,
+		created timestamptz,
+		PRIMARY KEY (user_id, pet_id)
+	)`
+	if _, err := db.Exec(favorites); err != nil {
+		return fmt.Errorf("error creating table favorites: %v", err)
+	}
+	const favoritesPetIndex = `CREATE INDEX IF NOT EXISTS favorites_pet_idx ON favorites (pet_id)`
+	if _, err := db.Exec(favoritesPetIndex); err != nil {
+		return fmt.Errorf("error creating index favorites_pet_idx: %v", err)
+	}
+
+	// saved_searches
+	const savedSearches = `CREATE TABLE IF NOT EXISTS saved_searches (
+		id bigserial PRIMARY KEY,
+		user_id bigint NOT NULL references users ON DELETE CASCADE,
+		name varchar(200) NOT NULL,
+		place_key varchar(70) NOT NULL,
+		age integer,
+		gender integer,
+		size integer,
+		type integer,
+		last_run timestamptz NOT NULL,
+		created timestamptz
+	)`
+	if _, err := db.Exec(savedSearches); err != nil {
+		return fmt.Errorf("error creating table saved_searches: %v", err)
+	}
+
+	// outbox
+	const outbox = `CREATE TABLE IF NOT EXISTS outbox (
+		id bigserial PRIMARY KEY,
+		recipient varchar(255) NOT NULL,
+		subject text NOT NULL,
+		body text NOT NULL,
+		html text NOT NULL DEFAULT '',
+		status integer NOT NULL DEFAULT 0,
+		attempts integer NOT NULL DEFAULT 0,
+		next_attempt timestamptz NOT NULL,
+		last_error text NOT NULL DEFAULT '',
+		created timestamptz,
+		updated timestamptz
+	)`
+	if _, err := db.Exec(outbox); err != nil {
+		return fmt.Errorf("error creating table outbox: %v", err)
+	}
+	const outboxPendingIndex = `CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (next_attempt) WHERE status = 0`
+	if _, err := db.Exec(outboxPendingIndex); err != nil {
+		return fmt.Errorf("error creating index outbox_pending_idx: %v", err)
+	}
+
+	// webhooks
+	const webhooks = `CREATE TABLE IF NOT EXISTS webhooks (
+		id bigserial PRIMARY KEY,
+		name varchar(100) NOT NULL,
+		url varchar(2000) NOT NULL,
+		secret varchar(100) NOT NULL,
+		events text[] NOT NULL,
+		active boolean NOT NULL DEFAULT true,
+		created timestamptz,
+		updated timestamptz
+	)`
+	if _, err := db.Exec(webhooks); err != nil {
+		return fmt.Errorf("error creating table webhooks: %v", err)
+	}
+
+	// webhook_deliveries
+	const webhookDeliveries = `CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id bigserial PRIMARY KEY,
+		webhook_id bigint NOT NULL references webhooks ON DELETE CASCADE,
+		event varchar(50) NOT NULL,
+		payload bytea NOT NULL,
+		status integer NOT NULL DEFAULT 0,
+		attempts integer NOT NULL DEFAULT 0,
+		next_attempt timestamptz NOT NULL,
+		last_status_code integer NOT NULL DEFAULT 0,
+		last_error text NOT NULL DEFAULT '',
+		created timestamptz,
+		updated timestamptz
+	)`
+	if _, err := db.Exec(webhookDeliveries); err != nil {
+		return fmt.Errorf("error creating table webhook_deliveries: %v", err)
+	}
+	const webhookDeliveriesPendingIndex = `CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt) WHERE status = 0`
+	if _, err := db.Exec(webhookDeliveriesPendingIndex); err != nil {
+		return fmt.Errorf("error creating index webhook_deliveries_pending_idx: %v", err)
+	}
+	const webhookDeliveriesWebhookIndex = `CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id)`
+	if _, err := db.Exec(webhookDeliveriesWebhookIndex); err != nil {
+		return fmt.Errorf("error creating index webhook_deliveries_webhook_idx: %v", err)
+	}
+
+	// webhook_attempts
+	const webhookAttempts = `CREATE TABLE IF NOT EXISTS webhook_attempts (
+		id bigserial PRIMARY KEY,
+		delivery_id bigint NOT NULL references webhook_deliveries ON DELETE CASCADE,
+		status_code integer NOT NULL,
+		error text NOT NULL DEFAULT '',
+		duration_ms integer NOT NULL,
+		created timestamptz
+	)`
+	if _, err := db.Exec(webhookAttempts); err != nil {
+		return fmt.Errorf("error creating table webhook_attempts: %v", err)
+	}
+
+	// applications
+	const applications = `CREATE TABLE IF NOT EXISTS applications (
+		id bigserial PRIMARY KEY,
+		pet_id bigint NOT NULL references pets ON DELETE CASCADE,
+		applicant_id bigint NOT NULL references users ON DELETE CASCADE,
+		status integer NOT NULL DEFAULT 0,
+		housing integer NOT NULL,
+		adults integer NOT NULL,
+		children integer NOT NULL,
+		experience integer NOT NULL,
+		other_pets varchar(200) NOT NULL DEFAULT '',
+		message text NOT NULL DEFAULT '',
+		created timestamptz,
+		updated timestamptz
+	)`
+	if _, err := db.Exec(applications); err != nil {
+		return fmt.Errorf("error creating table applications: %v", err)
+	}
+	// Each applicant can have only one open application for a pet.
+	const applicationsOpenIndex = `CREATE UNIQUE INDEX IF NOT EXISTS applications_open_idx ON applications (pet_id, applicant_id) WHERE status IN (0, 1)`
+	if _, err := db.Exec(applicationsOpenIndex); err != nil {
+		return fmt.Errorf("error creating index applications_open_idx: %v", err)
+	}
+
+	// audit_events is append-only. The rules turn any attempt to change or
+	// delete its rows into a no-op. actor_id has no foreign key so that the
+	// events outlive the users that caused them.
+	const auditEvents = `CREATE TABLE IF NOT EXISTS audit_events (
+		id bigserial PRIMARY KEY,
+		kind varchar(30) NOT NULL,
+		actor_id bigint,
+		ip varchar(45) NOT NULL DEFAULT '',
+		user_agent text NOT NULL DEFAULT '',
+		detail text NOT NULL DEFAULT '',
+		created timestamptz NOT NULL
+	)`
+	if _, err := db.Exec(auditEvents); err != nil {
+		return fmt.Errorf("error creating table audit_events: %v", err)
+	}
+	const auditEventsIndex = `CREATE INDEX IF NOT EXISTS audit_events_kind_idx ON audit_events (kind, id)`
+	if _, err := db.Exec(auditEventsIndex); err != nil {
+		return fmt.Errorf("error creating index audit_events_kind_idx: %v", err)
+	}
+	const auditEventsNoUpdate = `CREATE OR REPLACE RULE audit_events_no_update AS ON UPDATE TO audit_events DO INSTEAD NOTHING`
+	if _, err := db.Exec(auditEventsNoUpdate); err != nil {
+		return fmt.Errorf("error creating rule audit_events_no_update: %v", err)
+	}
+	const auditEventsNoDelete = `CREATE OR REPLACE RULE audit_events_no_delete AS ON DELETE TO audit_events DO INSTEAD NOTHING`
+	if _, err := db.Exec(auditEventsNoDelete); err != nil {
+		return fmt.Errorf("error creating rule audit_events_no_delete: %v", err)
+	}
 	return nil
 }
 
 func (db *store) DropSchema() error {
+	if _, err := db.Exec("DROP TABLE audit_events"); err != nil {
+		return fmt.Errorf("error dropping table audit_events: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE outbox"); err != nil {
+		return fmt.Errorf("error dropping table outbox: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE webhook_attempts"); err != nil {
+		return fmt.Errorf("error dropping table webhook_attempts: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE webhook_deliveries"); err != nil {
+		return fmt.Errorf("error dropping table webhook_deliveries: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE webhooks"); err != nil {
+		return fmt.Errorf("error dropping table webhooks: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE saved_searches"); err != nil {
+		return fmt.Errorf("error dropping table saved_searches: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE favorites"); err != nil {
+		return fmt.Errorf("error dropping table favorites: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE applications"); err != nil {
+		return fmt.Errorf("error dropping table applications: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE contact_reveals"); err != nil {
+		return fmt.Errorf("error dropping table contact_reveals: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE messages"); err != nil {
+		return fmt.Errorf("error dropping table messages: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE conversations"); err != nil {
+		return fmt.Errorf("error dropping table conversations: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE moderation_actions"); err != nil {
+		return fmt.Errorf("error dropping table moderation_actions: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE reports"); err != nil {
+		return fmt.Errorf("error dropping table reports: %v", err)
+	}
 	if _, err := db.Exec("DROP TABLE pets"); err != nil {
 		return fmt.Errorf("error dropping table pets: %v", err)
 	}
@@ -107,6 +566,30 @@ func (db *store) DropSchema() error {
 	if _, err := db.Exec("DROP TABLE place_groups"); err != nil {
 		return fmt.Errorf("error dropping table place_groups: %v", err)
 	}
+	if _, err := db.Exec("DROP TABLE user_identities"); err != nil {
+		return fmt.Errorf("error dropping table user_identities: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE user_passwords"); err != nil {
+		return fmt.Errorf("error dropping table user_passwords: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE user_tokens"); err != nil {
+		return fmt.Errorf("error dropping table user_tokens: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE user_totp"); err != nil {
+		return fmt.Errorf("error dropping table user_totp: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE user_recovery_codes"); err != nil {
+		return fmt.Errorf("error dropping table user_recovery_codes: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE webauthn_credentials"); err != nil {
+		return fmt.Errorf("error dropping table webauthn_credentials: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE api_tokens"); err != nil {
+		return fmt.Errorf("error dropping table api_tokens: %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE user_sessions"); err != nil {
+		return fmt.Errorf("error dropping table user_sessions: %v", err)
+	}
 	if _, err := db.Exec("DROP TABLE users"); err != nil {
 		return fmt.Errorf("error dropping table users: %v", err)
 	}
@@ -115,3 +598,182 @@ func (db *store) DropSchema() error {
 	}
 	return nil
 }
+
+// migrateHideContact turns the hide_contact flag pets used to have into the
+// equivalent contact_visibility. It does nothing on databases that have
+// already been migrated or never had the flag.
+func (db *store) migrateHideContact() (err error) {
+	const legacyColumnQuery = `
+	SELECT COUNT(*)
+	FROM information_schema.columns
+	WHERE table_schema = current_schema()
+	AND table_name = 'pets'
+	AND column_name = 'hide_contact'
+	`
+	var count int64
+	if err = db.QueryRow(legacyColumnQuery).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	const (
+		updateStmt = `UPDATE pets SET contact_visibility = $1 WHERE hide_contact`
+		dropStmt   = `ALTER TABLE pets DROP COLUMN hide_contact`
+	)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(updateStmt, petfind.ContactAfterReply); err != nil {
+		return err
+	}
+	_, err = tx.Exec(dropStmt)
+	return err
+}
+
+// migrateContact moves the phone numbers that used to be stored in the contact
+// column of pets into contact_phone, normalized to E.164. Numbers that cannot
+// be normalized are kept as they were so that no contact details are lost. It
+// does nothing on databases that have already been migrated or never had the
+// column.
+func (db *store) migrateContact() (err error) {
+	const legacyColumnQuery = `
+	SELECT COUNT(*)
+	FROM information_schema.columns
+	WHERE table_schema = current_schema()
+	AND table_name = 'pets'
+	AND column_name = 'contact'
+	`
+	var count int64
+	if err = db.QueryRow(legacyColumnQuery).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	const (
+		selectQuery = `SELECT id, contact FROM pets WHERE contact IS NOT NULL AND contact != ''`
+		updateStmt  = `UPDATE pets SET contact_phone = $2 WHERE id = $1`
+		dropStmt    = `ALTER TABLE pets DROP COLUMN contact`
+	)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	type legacyContact struct {
+		petID   int64
+		contact string
+	}
+	var contacts []legacyContact
+	rows, err := tx.Query(selectQuery)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var c legacyContact
+		if err = rows.Scan(&c.petID, &c.contact); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		contacts = append(contacts, c)
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+
+	for _, c := range contacts {
+		phone, ok := petfind.NormalizePhone(c.contact)
+		if !ok {
+			phone = c.contact
+		}
+		if _, err = tx.Exec(updateStmt, c.petID, phone); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(dropStmt)
+	return err
+}
+
+// migrateUserIdentities moves the GitHub and LinkedIn IDs that used to be
+// stored as columns of users into user_identities. It does nothing on
+// databases that have already been migrated or were created after the move.
+func (db *store) migrateUserIdentities() (err error) {
+	const legacyColumnQuery = `
+	SELECT COUNT(*)
+	FROM information_schema.columns
+	WHERE table_schema = current_schema()
+	AND table_name = 'users'
+	AND column_name = 'github_id'
+	`
+	var count int64
+	if err = db.QueryRow(legacyColumnQuery).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	const (
+		githubStmt = `
+	INSERT INTO user_identities(user_id, provider, subject, login, name, email, created, updated)
+	SELECT id, 'github', github_id::text, login, COALESCE(name, ''), email, created, updated
+	FROM users
+	WHERE github_id <> 0
+	ON CONFLICT (provider, subject) DO NOTHING
+	`
+		linkedinStmt = `
+	INSERT INTO user_identities(user_id, provider, subject, login, name, email, created, updated)
+	SELECT id, 'linkedin', linkedin_id, login, COALESCE(name, ''), email, created, updated
+	FROM users
+	WHERE linkedin_id <> ''
+	ON CONFLICT (provider, subject) DO NOTHING
+	`
+		dropStmt = `ALTER TABLE users DROP COLUMN github_id, DROP COLUMN linkedin_id`
+	)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	for _, stmt := range []string{githubStmt, linkedinStmt, dropStmt} {
+		if _, err = tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// searchArgs returns the optional criteria of a search as query arguments
+// that are NULL when the criterion is not used.
+func searchArgs(s petfind.Search) (age, gender, size, typ sql.NullInt64) {
+	age = sql.NullInt64{Int64: int64(s.Age), Valid: s.UseAge}
+	gender = sql.NullInt64{Int64: int64(s.Gender), Valid: s.UseGender}
+	size = sql.NullInt64{Int64: int64(s.Size), Valid: s.UseSize}
+	typ = sql.NullInt64{Int64: int64(s.Type), Valid: s.UseType}
+	return age, gender, size, typ
+}
+
+// AddSavedSearch saves a search of a user. The search is matched against pets
+// added from now on.
+func (db *store) AddSavedSearch(ss *petfind.SavedSearch) error {
+	const savedSearchInsertStmt = `
+	INSERT INTO saved_searches(user_id, name, place_key, age, gender, size, type, last_run, created)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, now(), now())
+	RETURNING id, last_run, created
+	`
+	age, gender, size, typ := searchArgs(ss.Search)
+	return db.QueryRow(savedSearchInsertStmt, ss.UserID, ss.Name, ss.Search.PlaceKey, age, gender, size, typ).Scan(&ss.ID, &ss.LastRun, &ss.Created)
+}
+
+// savedSearchSelect selects saved searches together with their users.
+const savedSearchSelect = `
+	SELECT
+	  s.id,
+	  s.user_id,
+	  s.name,
+	  s.place_key,
+	  s.age,
+	  s.gender,
+	  s.size,
+	  s.type,
+	  s.last_run,
+	  s.created,
+	  u.id,
+	  u.login,
+	  u.name,
+	  u.email,
+	  u.email_verified,
+	  u.role,
+	  u.disabled,
+	  u.created,
+	  u.updated
+	FROM saved_searches s
+	  JOIN users u ON s.user_id = u.id`
+
+func scanSavedSearch(s interface {
+	Scan(dest ...interface{}) error
+}) (*petfind.SavedSearch, error) {
+	ss := &petfind.SavedSearch{User: new(petfind.User)}
+	u := ss.User
+	err := s.Scan(
+		&ss.ID,
+		&ss.UserID,
+		&ss.Name,
+		&ss.Search.PlaceKey,
+		&ss.Search.Age,
+		&ss.Search.Gender,
+		&ss.Search.Size,
+		&ss.Search.Type,
+		&ss.LastRun,
+		&ss.Created,
+		&u.ID,
+		&u.Login,
+		&u.Name,
+		&u.Email,
+		&u.EmailVerified,
+		&u.Role,
+		&u.Disabled,
+		&u.Created,
+		&u.Updated,
+	)
+	// Criteria that are not used are stored as NULL which scans as the
+	// unknown value.
+	ss.Search.UseAge = ss.Search.Age != petfind.UnknownAge
+	ss.Search.UseGender = ss.Search.Gender != petfind.UnknownGender
+	ss.Search.UseSize = ss.Search.Size != petfind.UnknownSize
+	ss.Search.UseType = ss.Search.Type != petfind.UnknownType
+	return ss, err
+}
+
+func (db *store) querySavedSearches(query string, args ...interface{}) (searches []*petfind.SavedSearch, err error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	searches = make([]*petfind.SavedSearch, 0)
+	for rows.Next() {
+		ss, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, ss)
+	}
+	return searches, nil
+}
+
+// GetSavedSearch returns a saved search by its ID.
+func (db *store) GetSavedSearch(searchID int64) (*petfind.SavedSearch, error) {
+	const savedSearchGetQuery = savedSearchSelect + `
+	WHERE s.id = $1
+	`
+	ss, err := scanSavedSearch(db.QueryRow(savedSearchGetQuery, searchID))
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// GetUserSavedSearches returns the saved searches of a user, oldest first.
+func (db *store) GetUserSavedSearches(userID int64) ([]*petfind.SavedSearch, error) {
+	const userSavedSearchesQuery = savedSearchSelect + `
+	WHERE s.user_id = $1
+	ORDER BY s.id
+	`
+	return db.querySavedSearches(userSavedSearchesQuery, userID)
+}
+
+// GetSavedSearches returns the saved searches of all the users that are not
+// disabled so that they can be matched against new pets.
+func (db *store) GetSavedSearches() ([]*petfind.SavedSearch, error) {
+	const savedSearchesQuery = savedSearchSelect + `
+	WHERE NOT u.disabled
+	ORDER BY s.id
+	`
+	return db.querySavedSearches(savedSearchesQuery)
+}
+
+// DeleteSavedSearch deletes a saved search of a user. petfind.ErrNotFound is
+// returned if the user has no such search.
+func (db *store) DeleteSavedSearch(searchID, userID int64) error {
+	const savedSearchDeleteStmt = `DELETE FROM saved_searches WHERE id = $1 AND user_id = $2`
+	res, err := db.Exec(savedSearchDeleteStmt, searchID, userID)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
+
+// MarkSavedSearchRun records that a saved search was matched against the
+// pets added until at.
+func (db *store) MarkSavedSearchRun(searchID int64, at time.Time) error {
+	const savedSearchRunStmt = `UPDATE saved_searches SET last_run = $2 WHERE id = $1`
+	res, err := db.Exec(savedSearchRunStmt, searchID, at)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
+
+// SearchNewPets returns the listed pets that match a search and were first
+// listed after since and up to until, oldest first. Pets that waited for a
+// moderator count from when they were approved, not when they were added.
+func (db *store) SearchNewPets(s petfind.Search, since, until time.Time) (pets []*petfind.Pet, err error) {
+	const newPetsQuery = listedPetSelect + `
+	  AND pl.key = $1
+	  AND ($2::integer IS NULL OR p.age = $2)
+	  AND ($3::integer IS NULL OR p.gender = $3)
+	  AND ($4::integer IS NULL OR p.size = $4)
+	  AND ($5::integer IS NULL OR p.type = $5)
+	  AND p.listed > $6 AND p.listed <= $7
+	ORDER BY p.listed
+	`
+	age, gender, size, typ := searchArgs(s)
+	rows, err := db.Query(newPetsQuery, s.PlaceKey, age, gender, size, typ, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	pets = make([]*petfind.Pet, 0)
+	for rows.Next() {
+		p := new(petfind.Pet)
+		u := new(petfind.User)
+		pl := new(petfind.Place)
+		if err := rows.Scan(
+			&p.ID,
+			&p.Name,
+			&p.Age,
+			&p.Type,
+			&p.Size,
+			&p.Gender,
+			&p.Contact.Phone,
+			&p.Contact.Email,
+			&p.Contact.Hours,
+			&p.Notes,
+			&p.Created,
+			&p.Updated,
+			&p.OwnerID,
+			&p.PhotoID,
+			&p.PlaceID,
+			&p.Status,
+			&p.ContactVisibility,
+			&u.ID,
+			&u.Name,
+			&u.Login,
+			&u.Email,
+			&u.Created,
+			&u.Updated,
+			&pl.ID,
+			&pl.Key,
+			&pl.Name,
+			&pl.GroupID,
+		); err != nil {
+			return nil, err
+		}
+		p.Owner = u
+		p.Place = pl
+		pets = append(pets, p)
+	}
+	return pets, nil
+}
@@ -0,0 +1,134 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestSavedSearches(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+	u := &petfind.User{Name: "John Doe", Login: "johndoe", Email: "john@example.com"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	cats := &petfind.SavedSearch{
+		UserID: u.ID,
+		Name:   "Cat in place",
+		Search: petfind.Search{PlaceKey: "key", Type: petfind.Cat, UseType: true},
+	}
+	if err := s.AddSavedSearch(cats); err != nil {
+		t.Fatalf("AddSavedSearch failed: %v", err)
+	}
+	dogs := &petfind.SavedSearch{
+		UserID: u.ID,
+		Name:   "Dog in place",
+		Search: petfind.Search{PlaceKey: "key", Type: petfind.Dog, UseType: true},
+	}
+	if err := s.AddSavedSearch(dogs); err != nil {
+		t.Fatalf("AddSavedSearch failed: %v", err)
+	}
+
+	got, err := s.GetSavedSearch(cats.ID)
+	if err != nil {
+		t.Fatalf("GetSavedSearch failed: %v", err)
+	}
+	if got.Name != cats.Name || got.Search != cats.Search || got.User.Email != u.Email {
+		t.Fatalf("GetSavedSearch = %#v, expected %#v", got, cats)
+	}
+	if _, err := s.GetSavedSearch(dogs.ID + 1); err != petfind.ErrNotFound {
+		t.Fatalf("GetSavedSearch for unknown search returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	searches, err := s.GetUserSavedSearches(u.ID)
+	if err != nil {
+		t.Fatalf("GetUserSavedSearches failed: %v", err)
+	}
+	if len(searches) != 2 || searches[0].ID != cats.ID || searches[1].ID != dogs.ID {
+		t.Fatalf("GetUserSavedSearches = %#v", searches)
+	}
+
+	// The pet was added before the searches were saved so it is only found
+	// when looking further back.
+	now := time.Now()
+	pets, err := s.SearchNewPets(cats.Search, cats.LastRun, now)
+	if err != nil {
+		t.Fatalf("SearchNewPets failed: %v", err)
+	}
+	if len(pets) != 0 {
+		t.Fatalf("SearchNewPets since the search was saved = %#v, expected none", pets)
+	}
+	since := p.Created.Add(-time.Second)
+	if pets, err = s.SearchNewPets(cats.Search, since, now); err != nil || len(pets) != 1 || pets[0].ID != p.ID {
+		t.Fatalf("SearchNewPets for cats = %#v, %v", pets, err)
+	}
+	if pets, err = s.SearchNewPets(dogs.Search, since, now); err != nil || len(pets) != 0 {
+		t.Fatalf("SearchNewPets for dogs = %#v, %v", pets, err)
+	}
+	all := petfind.Search{PlaceKey: "key"}
+	if pets, err = s.SearchNewPets(all, since, now); err != nil || len(pets) != 1 {
+		t.Fatalf("SearchNewPets for all pets = %#v, %v", pets, err)
+	}
+
+	if err := s.MarkSavedSearchRun(cats.ID, now); err != nil {
+		t.Fatalf("MarkSavedSearchRun failed: %v", err)
+	}
+	if got, err = s.GetSavedSearch(cats.ID); err != nil || !got.LastRun.Equal(now.Truncate(time.Microsecond)) {
+		t.Fatalf("LastRun after MarkSavedSearchRun = %v, %v, expected %v", got.LastRun, err, now)
+	}
+
+	// Searches of disabled users are not matched.
+	if searches, err = s.GetSavedSearches(); err != nil || len(searches) != 2 {
+		t.Fatalf("GetSavedSearches = %#v, %v", searches, err)
+	}
+	if err := s.SetUserDisabled(u.ID, true); err != nil {
+		t.Fatalf("SetUserDisabled failed: %v", err)
+	}
+	if searches, err = s.GetSavedSearches(); err != nil || len(searches) != 0 {
+		t.Fatalf("GetSavedSearches after disabling user = %#v, %v", searches, err)
+	}
+
+	if err := s.DeleteSavedSearch(cats.ID, p.OwnerID); err != petfind.ErrNotFound {
+		t.Fatalf("DeleteSavedSearch by another user returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if err := s.DeleteSavedSearch(cats.ID, u.ID); err != nil {
+		t.Fatalf("DeleteSavedSearch failed: %v", err)
+	}
+	if _, err := s.GetSavedSearch(cats.ID); err != petfind.ErrNotFound {
+		t.Fatalf("GetSavedSearch after delete returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
+
+func TestSearchNewPetsApproved(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+	p := addTestPet(t, s)
+
+	pending := &petfind.Pet{Name: "rex", Type: petfind.Dog, OwnerID: p.OwnerID, PhotoID: p.PhotoID, PlaceID: p.PlaceID, Status: petfind.PetPending}
+	if err := s.AddPet(pending); err != nil {
+		t.Fatalf("AddPet failed: %v", err)
+	}
+	dogs := petfind.Search{PlaceKey: "key", Type: petfind.Dog, UseType: true}
+	since := time.Now()
+	if pets, err := s.SearchNewPets(dogs, pending.Created.Add(-time.Second), time.Now()); err != nil || len(pets) != 0 {
+		t.Fatalf("SearchNewPets before approval = %#v, %v, expected none", pets, err)
+	}
+
+	// A pet approved after the last run is new even though it was added
+	// before it.
+	if err := s.ModeratePet(&petfind.ModerationAction{PetID: pending.ID, Kind: petfind.ModerationApprove}); err != nil {
+		t.Fatalf("ModeratePet approve failed: %v", err)
+	}
+	pets, err := s.SearchNewPets(dogs, since, time.Now())
+	if err != nil {
+		t.Fatalf("SearchNewPets failed: %v", err)
+	}
+	if len(pets) != 1 || pets[0].ID != pending.ID {
+		t.Fatalf("SearchNewPets after approval = %#v, expected the approved pet", pets)
+	}
+}
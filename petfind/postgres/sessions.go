@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func (db *store) AddSession(s *petfind.Session) error {
+	const sessionInsertStmt = `
+	INSERT INTO user_sessions(key_hash, user_id, ip, user_agent, created, last_seen)
+	VALUES ($1, $2, $3, $4, now(), now())
+	RETURNING id, created, last_seen
+	`
+	stmt, err := db.Prepare(sessionInsertStmt)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := stmt.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+	err = stmt.QueryRow(s.KeyHash, s.UserID, s.IP, s.UserAgent).Scan(&s.ID, &s.Created, &s.LastSeen)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *store) GetSession(keyHash string) (*petfind.Session, error) {
+	const sessionGetQuery = `
+	SELECT
+	  id,
+	  key_hash,
+	  user_id,
+	  ip,
+	  user_agent,
+	  created,
+	  last_seen
+	FROM user_sessions
+	WHERE key_hash = $1
+	`
+	s := new(petfind.Session)
+	err := db.QueryRow(sessionGetQuery, keyHash).Scan(
+		&s.ID,
+		&s.KeyHash,
+		&s.UserID,
+		&s.IP,
+		&s.UserAgent,
+		&s.Created,
+		&s.LastSeen,
+	)
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (db *store) GetUserSessions(userID int64, since time.Time) ([]*petfind.Session, error) {
+	const sessionsGetByUserQuery = `
+	SELECT
+	  id,
+	  key_hash,
+	  user_id,
+	  ip,
+	  user_agent,
+	  created,
+	  last_seen
+	FROM user_sessions
+	WHERE user_id = $1
+	AND created > $2
+	ORDER BY last_seen DESC
+	`
+	rows, err := db.Query(sessionsGetByUserQuery, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	sessions := make([]*petfind.Session, 0)
+	for rows.Next() {
+		s := new(petfind.Session)
+		if err := rows.Scan(
+			&s.ID,
+			&s.KeyHash,
+			&s.UserID,
+			&s.IP,
+			&s.UserAgent,
+			&s.Created,
+			&s.LastSeen,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+func (db *store) TouchSession(sessionID int64) error {
+	const sessionTouchStmt = `UPDATE user_sessions SET last_seen = now() WHERE id = $1`
+	_, err := db.Exec(sessionTouchStmt, sessionID)
+	return err
+}
+
+func (db *store) DeleteSession(sessionID int64) error {
+	const sessionDeleteStmt = `DELETE FROM user_sessions WHERE id = $1`
+	_, err := db.Exec(sessionDeleteStmt, sessionID)
+	return err
+}
+
+func (db *store) DeleteUserSessions(userID int64) error {
+	const sessionsDeleteByUserStmt = `DELETE FROM user_sessions WHERE user_id = $1`
+	_, err := db.Exec(sessionsDeleteByUserStmt, userID)
+	return err
+}
@@ -0,0 +1,73 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestSessions(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe", Login: "janedoe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	sessions := []*petfind.Session{
+		{KeyHash: "hash1", UserID: u.ID, IP: "198.51.100.1", UserAgent: "Firefox"},
+		{KeyHash: "hash2", UserID: u.ID, IP: "198.51.100.2", UserAgent: "Chrome"},
+	}
+	for _, rs := range sessions {
+		if err := s.AddSession(rs); err != nil {
+			t.Fatalf("AddSession failed: %v", err)
+		}
+	}
+
+	got, err := s.GetSession("hash2")
+	if err != nil {
+		t.Fatalf("GetSession failed: %v", err)
+	}
+	if got.ID != sessions[1].ID || got.UserID != u.ID || got.IP != "198.51.100.2" || got.UserAgent != "Chrome" {
+		t.Fatalf("GetSession returned %#v, want %#v", got, sessions[1])
+	}
+
+	list, err := s.GetUserSessions(u.ID, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetUserSessions failed: %v", err)
+	}
+	if got, want := len(list), 2; got != want {
+		t.Fatalf("GetUserSessions returned %d sessions, want %d", got, want)
+	}
+
+	// Sessions created before since are excluded.
+	list, err = s.GetUserSessions(u.ID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetUserSessions failed: %v", err)
+	}
+	if got, want := len(list), 0; got != want {
+		t.Fatalf("GetUserSessions in the future returned %d sessions, want %d", got, want)
+	}
+
+	if err := s.TouchSession(sessions[0].ID); err != nil {
+		t.Fatalf("TouchSession failed: %v", err)
+	}
+
+	if err := s.DeleteSession(sessions[0].ID); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if _, err := s.GetSession("hash1"); err != petfind.ErrNotFound {
+		t.Fatalf("GetSession for deleted session returned %v, expected: %q", err, petfind.ErrNotFound)
+	}
+
+	if err := s.DeleteUserSessions(u.ID); err != nil {
+		t.Fatalf("DeleteUserSessions failed: %v", err)
+	}
+	if _, err := s.GetSession("hash2"); err != petfind.ErrNotFound {
+		t.Fatalf("GetSession after DeleteUserSessions returned %v, expected: %q", err, petfind.ErrNotFound)
+	}
+}
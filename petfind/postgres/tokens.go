@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func (db *store) AddToken(t *petfind.Token) error {
+	const tokenInsertStmt = `
+	INSERT INTO user_tokens(user_id, kind, hash, email, created, expires)
+	VALUES (NULLIF($1, 0), $2, $3, $4, now(), $5)
+	RETURNING id, created
+	`
+	return db.QueryRow(tokenInsertStmt, t.UserID, t.Kind, t.Hash, t.Email, t.Expires).Scan(&t.ID, &t.Created)
+}
+
+// UseToken marks the token with the given kind and hash as used and returns
+// it. Marking happens in a single statement so that a token can only ever be
+// used once even when two requests race. petfind.ErrNotFound is returned if
+// the token does not exist, has expired or has already been used.
+func (db *store) UseToken(kind, hash string) (*petfind.Token, error) {
+	const tokenUseStmt = `
+	UPDATE user_tokens SET used = now()
+	WHERE kind = $1 AND hash = $2 AND used IS NULL AND expires > now()
+	RETURNING id, COALESCE(user_id, 0), kind, hash, email, created, expires
+	`
+	t := new(petfind.Token)
+	err := db.QueryRow(tokenUseStmt, kind, hash).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Kind,
+		&t.Hash,
+		&t.Email,
+		&t.Created,
+		&t.Expires,
+	)
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// CountTokens returns how many tokens of a kind have been sent to email since
+// the given time. It is used to throttle how often emails can be requested
+// for the same address.
+func (db *store) CountTokens(kind, email string, since time.Time) (int64, error) {
+	const tokenCountQuery = `
+	SELECT COUNT(*)
+	FROM user_tokens
+	WHERE kind = $1 AND email = $2 AND created > $3
+	`
+	var count int64
+	if err := db.QueryRow(tokenCountQuery, kind, email, since).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,103 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestUseToken(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	tok := &petfind.Token{
+		UserID:  u.ID,
+		Kind:    petfind.TokenVerifyEmail,
+		Hash:    "hash1",
+		Email:   "jane@doe.com",
+		Expires: time.Now().Add(time.Hour),
+	}
+	if err := s.AddToken(tok); err != nil {
+		t.Fatalf("AddToken failed: %v", err)
+	}
+	expired := &petfind.Token{
+		UserID:  u.ID,
+		Kind:    petfind.TokenVerifyEmail,
+		Hash:    "hash2",
+		Expires: time.Now().Add(-time.Hour),
+	}
+	if err := s.AddToken(expired); err != nil {
+		t.Fatalf("AddToken failed: %v", err)
+	}
+
+	// A token cannot be used as a different kind.
+	if _, err := s.UseToken(petfind.TokenResetPassword, "hash1"); err != petfind.ErrNotFound {
+		t.Fatalf("UseToken with wrong kind returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	got, err := s.UseToken(petfind.TokenVerifyEmail, "hash1")
+	if err != nil {
+		t.Fatalf("UseToken failed: %v", err)
+	}
+	if got.UserID != u.ID || got.Email != "jane@doe.com" {
+		t.Fatalf("UseToken returned %#v", got)
+	}
+
+	// Tokens are single use.
+	if _, err := s.UseToken(petfind.TokenVerifyEmail, "hash1"); err != petfind.ErrNotFound {
+		t.Fatalf("UseToken for used token returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if _, err := s.UseToken(petfind.TokenVerifyEmail, "hash2"); err != petfind.ErrNotFound {
+		t.Fatalf("UseToken for expired token returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	start := time.Now().Add(-time.Minute)
+	for _, hash := range []string{"hash1", "hash2"} {
+		// Sign in links can be sent to addresses without an account.
+		tok := &petfind.Token{
+			Kind:    petfind.TokenMagicLink,
+			Hash:    hash,
+			Email:   "jane@doe.com",
+			Expires: time.Now().Add(time.Hour),
+		}
+		if err := s.AddToken(tok); err != nil {
+			t.Fatalf("AddToken failed: %v", err)
+		}
+	}
+
+	count, err := s.CountTokens(petfind.TokenMagicLink, "jane@doe.com", start)
+	if err != nil {
+		t.Fatalf("CountTokens failed: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("CountTokens = %d, expected 2", count)
+	}
+	count, err = s.CountTokens(petfind.TokenMagicLink, "john@doe.com", start)
+	if err != nil {
+		t.Fatalf("CountTokens failed: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("CountTokens for other address = %d, expected 0", count)
+	}
+
+	got, err := s.UseToken(petfind.TokenMagicLink, "hash1")
+	if err != nil {
+		t.Fatalf("UseToken failed: %v", err)
+	}
+	if got.UserID != 0 {
+		t.Fatalf("UseToken returned user %d for token without user", got.UserID)
+	}
+}
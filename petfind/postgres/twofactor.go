@@ -0,0 +1,238 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func (db *store) GetTOTP(userID int64) (*petfind.TOTP, error) {
+	const totpGetQuery = `
+	SELECT user_id, secret, enabled, last_step, created, failed_attempts, locked_until
+	FROM user_totp
+	WHERE user_id = $1
+	`
+	t := new(petfind.TOTP)
+	var lockedUntil pq.NullTime
+	err := db.QueryRow(totpGetQuery, userID).Scan(&t.UserID, &t.Secret, &t.Enabled, &t.LastStep, &t.Created, &t.Failures, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.LockedUntil = lockedUntil.Time
+	return t, nil
+}
+
+// PutTOTP starts a new enrolment for t.UserID, replacing any unfinished one.
+// petfind.ErrTOTPEnabled is returned if the user has already finished
+// enrolling so that an enabled secret is never silently replaced.
+func (db *store) PutTOTP(t *petfind.TOTP) error {
+	const totpPutStmt = `
+	INSERT INTO user_totp(user_id, secret, enabled, last_step, created)
+	VALUES ($1, $2, false, 0, now())
+	ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_step = 0, created = now()
+	WHERE user_totp.enabled = false
+	RETURNING created
+	`
+	err := db.QueryRow(totpPutStmt, t.UserID, t.Secret).Scan(&t.Created)
+	if err == sql.ErrNoRows {
+		return petfind.ErrTOTPEnabled
+	}
+	if err != nil {
+		return err
+	}
+	t.Enabled = false
+	t.LastStep = 0
+	return nil
+}
+
+// EnableTOTP finishes the enrolment of a user, recording step as the step of
+// the code they confirmed with, and replaces their recovery codes.
+// petfind.ErrNotFound is returned if there is no unfinished enrolment.
+func (db *store) EnableTOTP(userID, step int64, recoveryHashes []string) (err error) {
+	const totpEnableStmt = `
+	UPDATE user_totp SET enabled = true, last_step = $2
+	WHERE user_id = $1 AND enabled = false
+	`
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	res, err := tx.Exec(totpEnableStmt, userID, step)
+	if err != nil {
+		return err
+	}
+	if n, rerr := res.RowsAffected(); rerr != nil || n == 0 {
+		err = petfind.ErrNotFound
+		return err
+	}
+	return putRecoveryCodes(tx, userID, recoveryHashes)
+}
+
+// UseTOTPStep records step as the step of the last code accepted for the user.
+// It happens in a single statement so that the same code cannot be used twice
+// even when two requests race. petfind.ErrNotFound is returned if two-factor
+// authentication is not enabled or a code of the same or a later step has
+// already been accepted.
+func (db *store) UseTOTPStep(userID, step int64) error {
+	const totpUseStmt = `
+	UPDATE user_totp SET last_step = $2
+	WHERE user_id = $1 AND enabled = true AND last_step < $2
+	`
+	res, err := db.Exec(totpUseStmt, userID, step)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return petfind.ErrNotFound
+	}
+	return nil
+}
+
+// FailSecondFactor records a wrong code entered by the user. After
+// freeAttempts wrong codes in a row every further one locks the second factor
+// for lockout, doubled for each failure past the first lockout and capped at
+// maxLockout. Counting happens in the statement so that parallel guesses are
+// all counted. It returns until when the second factor is locked, which is
+// the zero time if it is not. petfind.ErrNotFound is returned if the user has
+// not enrolled.
+func (db *store) FailSecondFactor(userID, freeAttempts int64, lockout, maxLockout time.Duration) (time.Time, error) {
+	const secondFactorFailStmt = `
+	UPDATE user_totp SET
+	  failed_attempts = failed_attempts + 1,
+	  locked_until = CASE WHEN failed_attempts + 1 >= $2
+	    THEN now() + LEAST($3 * power(2, failed_attempts + 1 - $2), $4) * interval '1 second'
+	    ELSE locked_until END
+	WHERE user_id = $1
+	RETURNING locked_until
+	`
+	var lockedUntil pq.NullTime
+	err := db.QueryRow(secondFactorFailStmt, userID, freeAttempts, lockout.Seconds(), maxLockout.Seconds()).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, petfind.ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return lockedUntil.Time, nil
+}
+
+// ClearSecondFactorFailures forgets the wrong codes of the user after they
+// entered a correct one.
+func (db *store) ClearSecondFactorFailures(userID int64) error {
+	_, err := db.Exec("UPDATE user_totp SET failed_attempts = 0, locked_until = NULL WHERE user_id = $1", userID)
+	return err
+}
+
+// DeleteTOTP turns off two-factor authentication for a user, removing their
+// secret and recovery codes.
+func (db *store) DeleteTOTP(userID int64) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM user_totp WHERE user_id = $1", userID)
+	return err
+}
+
+// UseRecoveryCode marks the unused recovery code of the user with the given
+// hash as used. petfind.ErrNotFound is returned if there is no such code.
+func (db *store) UseRecoveryCode(userID int64, hash string) error {
+	const recoveryCodeUseStmt = `
+	UPDATE user_recovery_codes SET used = now()
+	WHERE user_id = $1 AND hash = $2 AND used IS NULL
+	`
+	res, err := db.Exec(recoveryCodeUseStmt, userID, hash)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return petfind.ErrNotFound
+	}
+	return nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left.
+func (db *store) CountRecoveryCodes(userID int64) (int64, error) {
+	const recoveryCodeCountQuery = `
+	SELECT COUNT(*)
+	FROM user_recovery_codes
+	WHERE user_id = $1 AND used IS NULL
+	`
+	var count int64
+	if err := db.QueryRow(recoveryCodeCountQuery, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// PutRecoveryCodes replaces all the recovery codes of a user.
+func (db *store) PutRecoveryCodes(userID int64, hashes []string) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	return putRecoveryCodes(tx, userID, hashes)
+}
+
+func putRecoveryCodes(tx *sql.Tx, userID int64, hashes []string) error {
+	const recoveryCodeInsertStmt = `
+	INSERT INTO user_recovery_codes(user_id, hash, created)
+	VALUES ($1, $2, now())
+	`
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.Exec(recoveryCodeInsertStmt, userID, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
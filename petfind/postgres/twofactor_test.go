@@ -0,0 +1,146 @@
+// +build db
+
+package postgres_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestTOTPEnrolment(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	if _, err := s.GetTOTP(u.ID); err != petfind.ErrNotFound {
+		t.Fatalf("GetTOTP before enrolment returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if err := s.PutTOTP(&petfind.TOTP{UserID: u.ID, Secret: "SECRET1"}); err != nil {
+		t.Fatalf("PutTOTP failed: %v", err)
+	}
+	// An unfinished enrolment can be restarted.
+	if err := s.PutTOTP(&petfind.TOTP{UserID: u.ID, Secret: "SECRET2"}); err != nil {
+		t.Fatalf("PutTOTP again failed: %v", err)
+	}
+	// Codes are not accepted before enrolment is finished.
+	if err := s.UseTOTPStep(u.ID, 100); err != petfind.ErrNotFound {
+		t.Fatalf("UseTOTPStep before enabling returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	if err := s.EnableTOTP(u.ID, 100, []string{"hash1", "hash2"}); err != nil {
+		t.Fatalf("EnableTOTP failed: %v", err)
+	}
+	got, err := s.GetTOTP(u.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP failed: %v", err)
+	}
+	if !got.Enabled || got.Secret != "SECRET2" || got.LastStep != 100 {
+		t.Fatalf("GetTOTP returned %#v", got)
+	}
+	if err := s.PutTOTP(&petfind.TOTP{UserID: u.ID, Secret: "SECRET3"}); err != petfind.ErrTOTPEnabled {
+		t.Fatalf("PutTOTP after enabling returned %v, expected %v", err, petfind.ErrTOTPEnabled)
+	}
+
+	// A step is only accepted once and never an earlier one.
+	if err := s.UseTOTPStep(u.ID, 100); err != petfind.ErrNotFound {
+		t.Fatalf("UseTOTPStep replay returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if err := s.UseTOTPStep(u.ID, 101); err != nil {
+		t.Fatalf("UseTOTPStep failed: %v", err)
+	}
+	if err := s.UseTOTPStep(u.ID, 99); err != petfind.ErrNotFound {
+		t.Fatalf("UseTOTPStep for earlier step returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	if err := s.DeleteTOTP(u.ID); err != nil {
+		t.Fatalf("DeleteTOTP failed: %v", err)
+	}
+	if _, err := s.GetTOTP(u.ID); err != petfind.ErrNotFound {
+		t.Fatalf("GetTOTP after delete returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if n, err := s.CountRecoveryCodes(u.ID); err != nil || n != 0 {
+		t.Fatalf("CountRecoveryCodes after delete = %d, %v, expected 0", n, err)
+	}
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := s.PutRecoveryCodes(u.ID, []string{"hash1", "hash2"}); err != nil {
+		t.Fatalf("PutRecoveryCodes failed: %v", err)
+	}
+
+	if err := s.UseRecoveryCode(u.ID, "hash1"); err != nil {
+		t.Fatalf("UseRecoveryCode failed: %v", err)
+	}
+	if err := s.UseRecoveryCode(u.ID, "hash1"); err != petfind.ErrNotFound {
+		t.Fatalf("UseRecoveryCode for used code returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if n, err := s.CountRecoveryCodes(u.ID); err != nil || n != 1 {
+		t.Fatalf("CountRecoveryCodes = %d, %v, expected 1", n, err)
+	}
+
+	// New codes replace the old ones.
+	if err := s.PutRecoveryCodes(u.ID, []string{"hash3"}); err != nil {
+		t.Fatalf("PutRecoveryCodes failed: %v", err)
+	}
+	if err := s.UseRecoveryCode(u.ID, "hash2"); err != petfind.ErrNotFound {
+		t.Fatalf("UseRecoveryCode for replaced code returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
+
+func TestFailSecondFactor(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := s.FailSecondFactor(u.ID, 2, time.Minute, time.Hour); err != petfind.ErrNotFound {
+		t.Fatalf("FailSecondFactor before enrolment returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if err := s.PutTOTP(&petfind.TOTP{UserID: u.ID, Secret: "SECRET"}); err != nil {
+		t.Fatalf("PutTOTP failed: %v", err)
+	}
+
+	// The first wrong code is free, then the lockout doubles up to the cap.
+	now := time.Now()
+	for i, want := range []time.Duration{0, time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		until, err := s.FailSecondFactor(u.ID, 2, time.Minute, 3*time.Minute)
+		if err != nil {
+			t.Fatalf("FailSecondFactor failed: %v", err)
+		}
+		if want > 3*time.Minute {
+			want = 3 * time.Minute
+		}
+		if want == 0 && !until.IsZero() || want != 0 && (until.Before(now.Add(want-time.Second)) || until.After(now.Add(want+time.Minute))) {
+			t.Errorf("failure %d locked until %v, expected about %v from now", i+1, until, want)
+		}
+	}
+	got, err := s.GetTOTP(u.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP failed: %v", err)
+	}
+	if got.Failures != 4 || !got.LockedUntil.After(now) {
+		t.Errorf("GetTOTP = %#v, expected 4 failures and a lockout", got)
+	}
+
+	if err := s.ClearSecondFactorFailures(u.ID); err != nil {
+		t.Fatalf("ClearSecondFactorFailures failed: %v", err)
+	}
+	if got, err = s.GetTOTP(u.ID); err != nil || got.Failures != 0 || !got.LockedUntil.IsZero() {
+		t.Errorf("GetTOTP after ClearSecondFactorFailures = %#v, %v, expected no failures", got, err)
+	}
+}
@@ -3,13 +3,14 @@ package postgres
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/psimika/secure-web-app/petfind"
 )
 
 func (db *store) CreateUser(u *petfind.User) error {
 	const userInsertStmt = `
-	INSERT INTO users(github_id, linkedin_id, login, name, email, created, updated)
+	INSERT INTO users(login, name, email, email_verified, role, created, updated)
 	VALUES ($1, $2, $3, $4, $5, now(), now())
 	RETURNING id, created, updated
 	`
@@ -23,7 +24,7 @@ func (db *store) CreateUser(u *petfind.User) error {
 			return
 		}
 	}()
-	err = stmt.QueryRow(u.GithubID, u.LinkedinID, u.Login, u.Name, u.Email).Scan(&u.ID, &u.Created, &u.Updated)
+	err = stmt.QueryRow(u.Login, u.Name, u.Email, u.EmailVerified, u.Role).Scan(&u.ID, &u.Created, &u.Updated)
 	if err != nil {
 		return err
 	}
@@ -34,11 +35,12 @@ func (db *store) GetUser(userID int64) (*petfind.User, error) {
 	const userGetQuery = `
 	SELECT
 	  id,
-	  github_id,
-	  linkedin_id,
 	  login,
 	  name,
 	  email,
+	  email_verified,
+	  role,
+	  disabled,
 	  created,
 	  updated
 	FROM users
@@ -47,11 +49,12 @@ func (db *store) GetUser(userID int64) (*petfind.User, error) {
 	u := new(petfind.User)
 	err := db.QueryRow(userGetQuery, userID).Scan(
 		&u.ID,
-		&u.GithubID,
-		&u.LinkedinID,
 		&u.Login,
 		&u.Name,
 		&u.Email,
+		&u.EmailVerified,
+		&u.Role,
+		&u.Disabled,
 		&u.Created,
 		&u.Updated,
 	)
@@ -64,27 +67,48 @@ func (db *store) GetUser(userID int64) (*petfind.User, error) {
 	return u, nil
 }
 
-func (db *store) PutGithubUser(ghu *petfind.GithubUser) (u *petfind.User, err error) {
-	// A GitHub user might not have provided their name in their profile but
-	// every GitHub user has a login. So in the case they haven't provided a
-	// name we will use their login as a name instead.
-	if ghu.Name == "" {
-		ghu.Name = ghu.Login
+// PutIdentity stores the identity of a user who just logged in with an
+// external provider. If the identity is seen for the first time, a new user is
+// created for it. Otherwise the identity and its user are updated with the
+// latest details from the provider.
+func (db *store) PutIdentity(id *petfind.Identity) (u *petfind.User, err error) {
+	// A user might not have provided their name in their profile (e.g. on
+	// GitHub) but they usually have a login. So in the case they haven't
+	// provided a name we will use their login as a name instead.
+	if id.Name == "" {
+		id.Name = id.Login
 	}
 	const (
+		identityUpdateStmt = `
+	UPDATE user_identities SET
+	  login = $3,
+	  name = $4,
+	  email = $5,
+	  updated = now()
+	WHERE provider = $1 AND subject = $2
+	RETURNING id, user_id, created, updated
+	`
+		identityInsertStmt = `
+	INSERT INTO user_identities(user_id, provider, subject, login, name, email, created, updated)
+	VALUES ($1, $2, $3, $4, $5, $6, now(), now())
+	RETURNING id, created, updated
+	`
+		// Empty details from the provider do not overwrite the ones we
+		// already have and neither does anything overwrite an email
+		// address the user has verified with us.
 		userUpdateStmt = `
 	UPDATE users SET
-	  login = $2,
-	  name = $3,
-	  email = $4,
+	  login = COALESCE(NULLIF($2, ''), login),
+	  name = COALESCE(NULLIF($3, ''), name),
+	  email = CASE WHEN email_verified THEN email ELSE COALESCE(NULLIF($4, ''), email) END,
 	  updated = now()
-	WHERE github_id = $1
-	RETURNING id, github_id, login, name, email, created, updated
+	WHERE id = $1
+	RETURNING id, login, name, email, email_verified, role, disabled, created, updated
 	`
 		userInsertStmt = `
-	INSERT INTO users(github_id, login, name, email, created, updated)
-	VALUES ($1, $2, $3, $4, now(), now())
-	RETURNING id, github_id, login, name, email, created, updated
+	INSERT INTO users(login, name, email, created, updated)
+	VALUES ($1, $2, $3, now(), now())
+	RETURNING id, login, name, email, email_verified, role, disabled, created, updated
 	`
 	)
 
@@ -103,11 +127,17 @@ func (db *store) PutGithubUser(ghu *petfind.GithubUser) (u *petfind.User, err er
 	}()
 
 	u = new(petfind.User)
-	err = tx.QueryRow(userUpdateStmt, ghu.ID, ghu.Login, ghu.Name, ghu.Email).
-		Scan(&u.ID, &u.GithubID, &u.Login, &u.Name, &u.Email, &u.Created, &u.Updated)
+	err = tx.QueryRow(identityUpdateStmt, id.Provider, id.Subject, id.Login, id.Name, id.Email).
+		Scan(&id.ID, &id.UserID, &id.Created, &id.Updated)
 	if err == sql.ErrNoRows {
-		err = tx.QueryRow(userInsertStmt, ghu.ID, ghu.Login, ghu.Name, ghu.Email).
-			Scan(&u.ID, &u.GithubID, &u.Login, &u.Name, &u.Email, &u.Created, &u.Updated)
+		err = tx.QueryRow(userInsertStmt, id.Login, id.Name, id.Email).
+			Scan(&u.ID, &u.Login, &u.Name, &u.Email, &u.EmailVerified, &u.Role, &u.Disabled, &u.Created, &u.Updated)
+		if err != nil {
+			return nil, err
+		}
+		id.UserID = u.ID
+		err = tx.QueryRow(identityInsertStmt, id.UserID, id.Provider, id.Subject, id.Login, id.Name, id.Email).
+			Scan(&id.ID, &id.Created, &id.Updated)
 		if err != nil {
 			return nil, err
 		}
@@ -116,31 +146,42 @@ func (db *store) PutGithubUser(ghu *petfind.GithubUser) (u *petfind.User, err er
 	if err != nil {
 		return nil, err
 	}
+
+	err = tx.QueryRow(userUpdateStmt, id.UserID, id.Login, id.Name, id.Email).
+		Scan(&u.ID, &u.Login, &u.Name, &u.Email, &u.EmailVerified, &u.Role, &u.Disabled, &u.Created, &u.Updated)
+	if err != nil {
+		return nil, err
+	}
 	return u, nil
 }
 
-func (db *store) GetUserByGithubID(userID int64) (*petfind.User, error) {
-	const userGetByGithubIDQuery = `
+func (db *store) GetUserByIdentity(provider, subject string) (*petfind.User, error) {
+	const userGetByIdentityQuery = `
 	SELECT
-	  id,
-	  github_id,
-	  linkedin_id,
-	  login,
-	  name,
-	  email,
-	  created
-	FROM users
-	WHERE github_id = $1
+	  u.id,
+	  u.login,
+	  u.name,
+	  u.email,
+	  u.email_verified,
+	  u.role,
+	  u.disabled,
+	  u.created,
+	  u.updated
+	FROM users u
+	  JOIN user_identities i ON i.user_id = u.id
+	WHERE i.provider = $1 AND i.subject = $2
 	`
 	u := new(petfind.User)
-	err := db.QueryRow(userGetByGithubIDQuery, userID).Scan(
+	err := db.QueryRow(userGetByIdentityQuery, provider, subject).Scan(
 		&u.ID,
-		&u.GithubID,
-		&u.LinkedinID,
 		&u.Login,
 		&u.Name,
 		&u.Email,
+		&u.EmailVerified,
+		&u.Role,
+		&u.Disabled,
 		&u.Created,
+		&u.Updated,
 	)
 	if err == sql.ErrNoRows {
 		return nil, petfind.ErrNotFound
@@ -151,27 +192,84 @@ func (db *store) GetUserByGithubID(userID int64) (*petfind.User, error) {
 	return u, nil
 }
 
-func (db *store) PutLinkedinUser(llu *petfind.LinkedinUser) (u *petfind.User, err error) {
-	fmt.Printf("llu: %#v\n", llu)
-	lluName := fmt.Sprintf("%s %s", llu.FirstName, llu.LastName)
+func (db *store) GetUserIdentities(userID int64) ([]*petfind.Identity, error) {
+	const userIdentitiesQuery = `
+	SELECT
+	  id,
+	  user_id,
+	  provider,
+	  subject,
+	  login,
+	  name,
+	  email,
+	  created,
+	  updated
+	FROM user_identities
+	WHERE user_id = $1
+	ORDER BY created
+	`
+	rows, err := db.Query(userIdentitiesQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*petfind.Identity
+	for rows.Next() {
+		id := new(petfind.Identity)
+		err := rows.Scan(
+			&id.ID,
+			&id.UserID,
+			&id.Provider,
+			&id.Subject,
+			&id.Login,
+			&id.Name,
+			&id.Email,
+			&id.Created,
+			&id.Updated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// LinkIdentity connects an identity to an existing user so that they can log
+// in with one more provider. Linking an identity the user already has only
+// refreshes its details. If the identity belongs to someone else
+// petfind.ErrIdentityLinked is returned; those accounts have to be merged
+// instead.
+func (db *store) LinkIdentity(userID int64, id *petfind.Identity) (err error) {
 	const (
-		userUpdateStmt = `
-	UPDATE users SET
-	  name = $2,
+		identityOwnerQuery = `
+	SELECT user_id FROM user_identities
+	WHERE provider = $1 AND subject = $2
+	FOR UPDATE
+	`
+		identityUpdateStmt = `
+	UPDATE user_identities SET
+	  login = $3,
+	  name = $4,
+	  email = $5,
 	  updated = now()
-	WHERE linkedin_id = $1
-	RETURNING id, github_id, linkedin_id, login, name, email, created, updated
+	WHERE provider = $1 AND subject = $2
+	RETURNING id, created, updated
 	`
-		userInsertStmt = `
-	INSERT INTO users(linkedin_id, name, created, updated)
-	VALUES ($1, $2, now(), now())
-	RETURNING id, github_id, linkedin_id, login, name, email, created, updated
+		identityInsertStmt = `
+	INSERT INTO user_identities(user_id, provider, subject, login, name, email, created, updated)
+	VALUES ($1, $2, $3, $4, $5, $6, now(), now())
+	RETURNING id, created, updated
 	`
 	)
 
 	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
 		if err != nil {
@@ -183,52 +281,366 @@ func (db *store) PutLinkedinUser(llu *petfind.LinkedinUser) (u *petfind.User, er
 		err = tx.Commit()
 	}()
 
-	u = new(petfind.User)
-	err = tx.QueryRow(userUpdateStmt, llu.ID, lluName).
-		Scan(&u.ID, &u.GithubID, &u.LinkedinID, &u.Login, &u.Name, &u.Email, &u.Created, &u.Updated)
-	if err == sql.ErrNoRows {
-		err = tx.QueryRow(userInsertStmt, llu.ID, lluName).
-			Scan(&u.ID, &u.GithubID, &u.LinkedinID, &u.Login, &u.Name, &u.Email, &u.Created, &u.Updated)
+	var owner int64
+	err = tx.QueryRow(identityOwnerQuery, id.Provider, id.Subject).Scan(&owner)
+	switch {
+	case err == sql.ErrNoRows:
+		id.UserID = userID
+		return tx.QueryRow(identityInsertStmt, userID, id.Provider, id.Subject, id.Login, id.Name, id.Email).
+			Scan(&id.ID, &id.Created, &id.Updated)
+	case err != nil:
+		return err
+	case owner != userID:
+		return petfind.ErrIdentityLinked
+	}
+	id.UserID = userID
+	return tx.QueryRow(identityUpdateStmt, id.Provider, id.Subject, id.Login, id.Name, id.Email).
+		Scan(&id.ID, &id.Created, &id.Updated)
+}
+
+// UnlinkIdentity removes one of the user's identities. It returns
+// petfind.ErrLastIdentity instead of leaving the user without a way to log
+// in.
+func (db *store) UnlinkIdentity(userID, identityID int64) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
 		if err != nil {
-			return nil, err
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
 		}
-		return u, nil
+		err = tx.Commit()
+	}()
+
+	// Lock all of the user's identities so that two concurrent unlinks
+	// cannot both see one remaining identity.
+	rows, err := tx.Query("SELECT id FROM user_identities WHERE user_id = $1 FOR UPDATE", userID)
+	if err != nil {
+		return err
+	}
+	var count int
+	var found bool
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		count++
+		if id == identityID {
+			found = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return petfind.ErrNotFound
+	}
+	if count <= 1 {
+		return petfind.ErrLastIdentity
+	}
+	_, err = tx.Exec("DELETE FROM user_identities WHERE id = $1 AND user_id = $2", identityID, userID)
+	return err
+}
+
+// MergeUsers moves the pets, identities and everything else a duplicate
+// account owns into the primary one and then deletes the duplicate along with
+// its sessions. Where both accounts have the same thing, such as a
+// conversation about the same pet or a favorite, the two are combined.
+//
+// The password and two-factor settings of the duplicate are moved too.
+// petfind.ErrMergeCredentials is returned if both users have a password or
+// both have two-factor authentication turned on, as the one to keep cannot be
+// chosen for them. The primary keeps its email address unless it has none or
+// only the duplicate's address is verified, in which case it takes the
+// duplicate's.
+func (db *store) MergeUsers(primaryID, duplicateID int64) (err error) {
+	if primaryID == duplicateID {
+		return fmt.Errorf("cannot merge user %d into itself", primaryID)
 	}
+
+	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
 
-	return u, nil
+	for _, id := range []int64{primaryID, duplicateID} {
+		var locked int64
+		err = tx.QueryRow("SELECT id FROM users WHERE id = $1 FOR UPDATE", id).Scan(&locked)
+		if err == sql.ErrNoRows {
+			return petfind.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	const credentialsQuery = `
+	SELECT
+	  (SELECT count(*) FROM user_passwords WHERE user_id IN ($1, $2)),
+	  (SELECT count(*) FROM user_totp WHERE user_id IN ($1, $2) AND enabled),
+	  EXISTS (SELECT 1 FROM user_totp WHERE user_id = $2 AND enabled)
+	`
+	var (
+		passwords, secondFactors int64
+		moveSecondFactor         bool
+	)
+	err = tx.QueryRow(credentialsQuery, primaryID, duplicateID).Scan(&passwords, &secondFactors, &moveSecondFactor)
+	if err != nil {
+		return err
+	}
+	if passwords > 1 || secondFactors > 1 {
+		return petfind.ErrMergeCredentials
+	}
+	if _, err = tx.Exec("UPDATE user_passwords SET user_id = $1 WHERE user_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving password: %v", err)
+	}
+	if moveSecondFactor {
+		// Whatever the primary has is an unfinished enrolment which the
+		// duplicate's working second factor replaces.
+		if _, err = tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", primaryID); err != nil {
+			return fmt.Errorf("error moving recovery codes: %v", err)
+		}
+		if _, err = tx.Exec("DELETE FROM user_totp WHERE user_id = $1", primaryID); err != nil {
+			return fmt.Errorf("error moving two-factor settings: %v", err)
+		}
+		if _, err = tx.Exec("UPDATE user_totp SET user_id = $1 WHERE user_id = $2", primaryID, duplicateID); err != nil {
+			return fmt.Errorf("error moving two-factor settings: %v", err)
+		}
+		if _, err = tx.Exec("UPDATE user_recovery_codes SET user_id = $1 WHERE user_id = $2", primaryID, duplicateID); err != nil {
+			return fmt.Errorf("error moving recovery codes: %v", err)
+		}
+	}
+	// The duplicate's email identity is moved below so the primary needs a
+	// verified address for password logins to keep working.
+	const emailMoveStmt = `
+	UPDATE users p SET email = d.email, email_verified = d.email_verified, updated = now()
+	FROM users d
+	WHERE p.id = $1 AND d.id = $2 AND d.email != ''
+	  AND (p.email = '' OR (d.email_verified AND NOT p.email_verified))
+	`
+	if _, err = tx.Exec(emailMoveStmt, primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving email address: %v", err)
+	}
+
+	if _, err = tx.Exec("UPDATE pets SET owner_id = $1 WHERE owner_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving pets: %v", err)
+	}
+	if _, err = tx.Exec("UPDATE user_identities SET user_id = $1 WHERE user_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving identities: %v", err)
+	}
+	if _, err = tx.Exec("UPDATE webauthn_credentials SET user_id = $1 WHERE user_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving passkeys: %v", err)
+	}
+	if _, err = tx.Exec("UPDATE api_tokens SET user_id = $1 WHERE user_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving API tokens: %v", err)
+	}
+
+	// Both accounts may have asked about the same pet. The messages of the
+	// duplicate's conversation are moved into the primary's one before the
+	// conversation itself is dropped.
+	const conversationMergeStmt = `
+	UPDATE messages m SET conversation_id = p.id
+	FROM conversations d, conversations p
+	WHERE m.conversation_id = d.id AND d.adopter_id = $2 AND p.adopter_id = $1 AND p.pet_id = d.pet_id
+	`
+	if _, err = tx.Exec(conversationMergeStmt, primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error merging conversations: %v", err)
+	}
+	const conversationDropStmt = `
+	DELETE FROM conversations d
+	USING conversations p
+	WHERE d.adopter_id = $2 AND p.adopter_id = $1 AND p.pet_id = d.pet_id
+	`
+	if _, err = tx.Exec(conversationDropStmt, primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error merging conversations: %v", err)
+	}
+	if _, err = tx.Exec("UPDATE conversations SET adopter_id = $1 WHERE adopter_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving conversations: %v", err)
+	}
+	if _, err = tx.Exec("UPDATE conversations SET owner_id = $1 WHERE owner_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving conversations: %v", err)
+	}
+	if _, err = tx.Exec("UPDATE messages SET sender_id = $1 WHERE sender_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving messages: %v", err)
+	}
+
+	// Only one open application per pet is allowed so the duplicate's open
+	// applications for pets the primary has also applied for are withdrawn.
+	const applicationWithdrawStmt = `
+	UPDATE applications d SET status = $3, updated = now()
+	FROM applications p
+	WHERE d.applicant_id = $2 AND p.applicant_id = $1 AND p.pet_id = d.pet_id
+	  AND d.status IN (0, 1) AND p.status IN (0, 1)
+	`
+	if _, err = tx.Exec(applicationWithdrawStmt, primaryID, duplicateID, petfind.ApplicationWithdrawn); err != nil {
+		return fmt.Errorf("error merging applications: %v", err)
+	}
+	if _, err = tx.Exec("UPDATE applications SET applicant_id = $1 WHERE applicant_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving applications: %v", err)
+	}
+
+	// Favorites are copied over; those the primary already has are left
+	// behind for the cascade.
+	const favoriteMoveStmt = `
+	INSERT INTO favorites(user_id, pet_id, created)
+	SELECT $1, pet_id, created FROM favorites WHERE user_id = $2
+	ON CONFLICT (user_id, pet_id) DO NOTHING
+	`
+	if _, err = tx.Exec(favoriteMoveStmt, primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving favorites: %v", err)
+	}
+	if _, err = tx.Exec("UPDATE saved_searches SET user_id = $1 WHERE user_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving saved searches: %v", err)
+	}
+
+	// Records that would otherwise lose their user.
+	if _, err = tx.Exec("UPDATE reports SET reporter_id = $1 WHERE reporter_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving reports: %v", err)
+	}
+	if _, err = tx.Exec("UPDATE moderation_actions SET moderator_id = $1 WHERE moderator_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving moderation actions: %v", err)
+	}
+	if _, err = tx.Exec("UPDATE contact_reveals SET user_id = $1 WHERE user_id = $2", primaryID, duplicateID); err != nil {
+		return fmt.Errorf("error moving contact reveals: %v", err)
+	}
+	// Sessions and the favorites the primary already had are removed by
+	// the cascade.
+	if _, err = tx.Exec("DELETE FROM users WHERE id = $1", duplicateID); err != nil {
+		return fmt.Errorf("error deleting duplicate user: %v", err)
+	}
+	return nil
+}
+
+// SetUserRole changes the role of a user. petfind.ErrNotFound is returned if
+// the user does not exist.
+func (db *store) SetUserRole(userID int64, role petfind.Role) error {
+	res, err := db.Exec("UPDATE users SET role = $2, updated = now() WHERE id = $1", userID, role)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return petfind.ErrNotFound
+	}
+	return nil
+}
+
+// SetUserDisabled disables or enables a user. Disabling a user also removes
+// all of their sessions and API tokens. petfind.ErrNotFound is returned if the
+// user does not exist.
+func (db *store) SetUserDisabled(userID int64, disabled bool) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	res, err := tx.Exec("UPDATE users SET disabled = $2, updated = now() WHERE id = $1", userID, disabled)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return petfind.ErrNotFound
+	}
+	if disabled {
+		if _, err = tx.Exec("DELETE FROM user_sessions WHERE user_id = $1", userID); err != nil {
+			return fmt.Errorf("error deleting sessions: %v", err)
+		}
+		if _, err = tx.Exec("DELETE FROM api_tokens WHERE user_id = $1", userID); err != nil {
+			return fmt.Errorf("error deleting API tokens: %v", err)
+		}
+	}
+	return nil
 }
 
-func (db *store) GetUserByLinkedinID(userID string) (*petfind.User, error) {
-	const userGetByLinkedinIDQuery = `
+// SearchUsers returns the users whose name, login or email address contains
+// query, ordered by ID. An empty query matches all users.
+func (db *store) SearchUsers(query string, limit, offset int) ([]*petfind.User, error) {
+	const usersSearchQuery = `
 	SELECT
 	  id,
-	  github_id,
-	  linkedin_id,
 	  login,
 	  name,
 	  email,
-	  created
+	  email_verified,
+	  role,
+	  disabled,
+	  created,
+	  updated
 	FROM users
-	WHERE linkedin_id = $1
+	WHERE $1 = ''
+	  OR name ILIKE '%' || $1 || '%'
+	  OR login ILIKE '%' || $1 || '%'
+	  OR email ILIKE '%' || $1 || '%'
+	ORDER BY id
+	LIMIT $2 OFFSET $3
 	`
-	u := new(petfind.User)
-	err := db.QueryRow(userGetByLinkedinIDQuery, userID).Scan(
-		&u.ID,
-		&u.GithubID,
-		&u.LinkedinID,
-		&u.Login,
-		&u.Name,
-		&u.Email,
-		&u.Created,
-	)
-	if err == sql.ErrNoRows {
-		return nil, petfind.ErrNotFound
-	}
+	rows, err := db.Query(usersSearchQuery, escapeLike(query), limit, offset)
 	if err != nil {
 		return nil, err
 	}
-	return u, nil
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	users := make([]*petfind.User, 0)
+	for rows.Next() {
+		u := new(petfind.User)
+		if err := rows.Scan(
+			&u.ID,
+			&u.Login,
+			&u.Name,
+			&u.Email,
+			&u.EmailVerified,
+			&u.Role,
+			&u.Disabled,
+			&u.Created,
+			&u.Updated,
+		); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
 }
+
+// escapeLike escapes the characters that have a special meaning in LIKE
+// patterns so that a search query only ever matches literally.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
@@ -14,75 +14,64 @@ func TestCreateUser(t *testing.T) {
 	s := setup(t)
 	defer teardown(t, s)
 
-	githubID := int64(5)
-	u := &petfind.User{Name: "Jane Doe", GithubID: githubID}
+	u := &petfind.User{Name: "Jane Doe", Login: "janedoe"}
 	if err := s.CreateUser(u); err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
 
-	// Test get user by their GitHub ID.
-	user, err := s.GetUserByGithubID(githubID)
-	if err != nil {
-		t.Fatalf("GetUserByGithubID failed: %v", err)
-	}
-
-	// Ignore time field.
-	user.Created = time.Time{}
-	want := &petfind.User{ID: 1, Name: "Jane Doe", GithubID: githubID}
-	if got := user; !reflect.DeepEqual(got, want) {
-		t.Fatalf("GetUserByGithubID \nhave: %#v\nwant: %#v", got, want)
-	}
-
-	// Test get user by their ID.
-	user, err = s.GetUser(1)
+	user, err := s.GetUser(u.ID)
 	if err != nil {
 		t.Fatalf("GetUser failed: %v", err)
 	}
 
-	// Ignore time field.
+	// Ignore time fields.
 	user.Created = time.Time{}
 	user.Updated = time.Time{}
-	want = &petfind.User{ID: 1, Name: "Jane Doe", GithubID: githubID}
+	want := &petfind.User{ID: 1, Name: "Jane Doe", Login: "janedoe"}
 	if got := user; !reflect.DeepEqual(got, want) {
 		t.Fatalf("GetUser \nhave: %#v\nwant: %#v", got, want)
 	}
 }
 
-func TestGetUserByGithubID_notFound(t *testing.T) {
+func TestGetUser_notFound(t *testing.T) {
 	s := setup(t)
 	defer teardown(t, s)
 
-	_, err := s.GetUserByGithubID(0)
+	_, err := s.GetUser(0)
 	if err != petfind.ErrNotFound {
-		t.Fatalf("GetUserByGithubID for unknown githubID returned %v, expected: %q", err, petfind.ErrNotFound)
+		t.Fatalf("GetUser for unknown ID returned %v, expected: %q", err, petfind.ErrNotFound)
 	}
 }
 
-func TestGetUser_notFound(t *testing.T) {
+func TestGetUserByIdentity_notFound(t *testing.T) {
 	s := setup(t)
 	defer teardown(t, s)
 
-	_, err := s.GetUser(0)
+	_, err := s.GetUserByIdentity("github", "5")
 	if err != petfind.ErrNotFound {
-		t.Fatalf("GetUser for unknown ID returned %v, expected: %q", err, petfind.ErrNotFound)
+		t.Fatalf("GetUserByIdentity for unknown identity returned %v, expected: %q", err, petfind.ErrNotFound)
 	}
 }
 
-func TestPutGithubUser(t *testing.T) {
+func TestPutIdentity(t *testing.T) {
 	s := setup(t)
 	defer teardown(t, s)
 
-	// We Put the GitHub user for the first time. The user does not exist so we
+	// We Put the identity for the first time. The user does not exist so we
 	// expect Put to create the user.
-	ghu := &petfind.GithubUser{
-		ID:    5,
-		Login: "janedoe",
-		Name:  "Jane Doe",
-		Email: "jane@doe.com",
+	id := &petfind.Identity{
+		Provider: "github",
+		Subject:  "5",
+		Login:    "janedoe",
+		Name:     "Jane Doe",
+		Email:    "jane@doe.com",
 	}
-	got, err := s.PutGithubUser(ghu)
+	got, err := s.PutIdentity(id)
 	if err != nil {
-		t.Fatal("PutGithubUser for non existent user returned err:", err)
+		t.Fatal("PutIdentity for non existent user returned err:", err)
+	}
+	if id.UserID != got.ID {
+		t.Fatalf("PutIdentity linked identity to user %d, expected %d", id.UserID, got.ID)
 	}
 
 	// Save created time to check it was the same when we Put for a second time
@@ -93,127 +82,454 @@ func TestPutGithubUser(t *testing.T) {
 	got.Updated = time.Time{}
 
 	want := &petfind.User{
-		ID:       1, // A newly created user should get ID 1 from Postgres.
-		GithubID: 5,
-		Login:    "janedoe",
-		Name:     "Jane Doe",
-		Email:    "jane@doe.com",
+		ID:    1, // A newly created user should get ID 1 from Postgres.
+		Login: "janedoe",
+		Name:  "Jane Doe",
+		Email: "jane@doe.com",
 	}
 	if !reflect.DeepEqual(got, want) {
-		t.Fatalf("PutGithubUser first run \nhave: %#v\nwant: %#v", got, want)
+		t.Fatalf("PutIdentity first run \nhave: %#v\nwant: %#v", got, want)
 	}
 
-	// Attempt to Put again the github User. The GitHub user should have been
-	// already been created from the previous run and we now expect the values
-	// to be updated.
-	ghu = &petfind.GithubUser{
-		ID:    5,
-		Login: "jane", // changed
-		Name:  "Jane", // changed
-		Email: "jane@doe.com",
+	// Attempt to Put the identity again. It should have already been created
+	// from the previous run and we now expect the values to be updated.
+	id = &petfind.Identity{
+		Provider: "github",
+		Subject:  "5",
+		Login:    "jane", // changed
+		Name:     "Jane", // changed
+		Email:    "jane@doe.com",
 	}
-	got, err = s.PutGithubUser(ghu)
+	got, err = s.PutIdentity(id)
 	if err != nil {
-		t.Fatal("PutGithubUser for existing user returned err:", err)
+		t.Fatal("PutIdentity for existing user returned err:", err)
 	}
 
 	// Ignore updated.
 	got.Updated = time.Time{}
 
 	want = &petfind.User{
-		ID:       1, // ID stays the same as we are doing an update.
-		GithubID: 5,
-		Login:    "jane",
-		Name:     "Jane",
-		Email:    "jane@doe.com",
-		Created:  created,
+		ID:      1, // ID stays the same as we are doing an update.
+		Login:   "jane",
+		Name:    "Jane",
+		Email:   "jane@doe.com",
+		Created: created,
 	}
 	// This time we expect the values to be updated but the created time should
 	// be the same as the first run.
 	if !reflect.DeepEqual(got, want) {
-		t.Fatalf("PutGithubUser second run \nhave: %#v\nwant: %#v", got, want)
+		t.Fatalf("PutIdentity second run \nhave: %#v\nwant: %#v", got, want)
+	}
+
+	user, err := s.GetUserByIdentity("github", "5")
+	if err != nil {
+		t.Fatalf("GetUserByIdentity failed: %v", err)
+	}
+	if user.ID != 1 {
+		t.Fatalf("GetUserByIdentity returned user %d, expected 1", user.ID)
+	}
+
+	// The same subject with a different provider is a different user.
+	other, err := s.PutIdentity(&petfind.Identity{Provider: "linkedin", Subject: "5", Name: "John Doe"})
+	if err != nil {
+		t.Fatal("PutIdentity for different provider returned err:", err)
+	}
+	if other.ID == user.ID {
+		t.Fatal("PutIdentity with same subject for different provider reused the same user")
 	}
 }
 
-// When a github user doesn't have their name filled in their profile, we
-// use login instead.
-func TestPutGithubUser_emptyName(t *testing.T) {
+// When a user doesn't have their name filled in their profile, we use login
+// instead.
+func TestPutIdentity_emptyName(t *testing.T) {
 	s := setup(t)
 	defer teardown(t, s)
 
-	githubUser := &petfind.GithubUser{
-		ID:    5,
-		Login: "janedoe",
-		Name:  "",
-		Email: "",
+	id := &petfind.Identity{
+		Provider: "github",
+		Subject:  "5",
+		Login:    "janedoe",
 	}
-	u, err := s.PutGithubUser(githubUser)
+	u, err := s.PutIdentity(id)
 	if err != nil {
-		t.Fatal("PutGithubUser with empty name returned err:", err)
+		t.Fatal("PutIdentity with empty name returned err:", err)
 	}
 
 	// Check that login was used as a Name.
 	if got, want := u.Name, "janedoe"; got != want {
-		t.Errorf("PutGithubUser with empty name -> user.Name=%q, expected %q", got, want)
+		t.Errorf("PutIdentity with empty name -> user.Name=%q, expected %q", got, want)
 	}
 }
-func TestPutLinkedinbUser(t *testing.T) {
+
+func TestLinkIdentity(t *testing.T) {
 	s := setup(t)
 	defer teardown(t, s)
 
-	// We Put the LinkedIn user for the first time. The user does not exist so we
-	// expect Put to create the user.
-	llu := &petfind.LinkedinUser{
-		ID:        "JANEDOE",
-		FirstName: "Jane",
-		LastName:  "Doe",
+	jane, err := s.PutIdentity(&petfind.Identity{Provider: "github", Subject: "5", Login: "janedoe"})
+	if err != nil {
+		t.Fatal("PutIdentity failed:", err)
 	}
-	got, err := s.PutLinkedinUser(llu)
+	john, err := s.PutIdentity(&petfind.Identity{Provider: "github", Subject: "6", Login: "johndoe"})
 	if err != nil {
-		t.Fatal("PutLinkedinUser for non existent user returned err:", err)
+		t.Fatal("PutIdentity failed:", err)
 	}
 
-	// Save created time to check it was the same when we Put for a second time
-	// below.
-	created := got.Created
-	// Ignore time values.
-	got.Created = time.Time{}
-	got.Updated = time.Time{}
+	if err := s.LinkIdentity(jane.ID, &petfind.Identity{Provider: "linkedin", Subject: "JANEDOE", Name: "Jane Doe"}); err != nil {
+		t.Fatal("LinkIdentity failed:", err)
+	}
+	u, err := s.GetUserByIdentity("linkedin", "JANEDOE")
+	if err != nil {
+		t.Fatal("GetUserByIdentity failed:", err)
+	}
+	if u.ID != jane.ID {
+		t.Fatalf("linked identity belongs to user %d, expected %d", u.ID, jane.ID)
+	}
 
-	want := &petfind.User{
-		ID:         1, // A newly created user should get ID 1 from Postgres.
-		LinkedinID: "JANEDOE",
-		Name:       "Jane Doe",
+	// Linking an identity of another user must fail.
+	err = s.LinkIdentity(jane.ID, &petfind.Identity{Provider: "github", Subject: "6"})
+	if err != petfind.ErrIdentityLinked {
+		t.Fatalf("LinkIdentity of another user's identity returned %v, expected %v", err, petfind.ErrIdentityLinked)
 	}
-	if !reflect.DeepEqual(got, want) {
-		t.Fatalf("PutLinkedinUser first run \nhave: %#v\nwant: %#v", got, want)
+
+	ids, err := s.GetUserIdentities(jane.ID)
+	if err != nil {
+		t.Fatal("GetUserIdentities failed:", err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("GetUserIdentities returned %d identities, expected 2", len(ids))
 	}
 
-	// Attempt to Put again the github User. The LinkedIn user should have been
-	// already been created from the previous run and we now expect the values
-	// to be updated.
-	llu = &petfind.LinkedinUser{
-		ID:        "JANEDOE",
-		FirstName: "Michaella", // changed
-		LastName:  "Neirou",    // changed
+	// Unlink one of Jane's two identities.
+	if err := s.UnlinkIdentity(jane.ID, ids[0].ID); err != nil {
+		t.Fatal("UnlinkIdentity failed:", err)
 	}
-	got, err = s.PutLinkedinUser(llu)
+	// The last one must stay.
+	if err := s.UnlinkIdentity(jane.ID, ids[1].ID); err != petfind.ErrLastIdentity {
+		t.Fatalf("UnlinkIdentity of last identity returned %v, expected %v", err, petfind.ErrLastIdentity)
+	}
+	// Users cannot unlink identities of others.
+	johnIDs, err := s.GetUserIdentities(john.ID)
 	if err != nil {
-		t.Fatal("PutLinkedinUser for existing user returned err:", err)
+		t.Fatal("GetUserIdentities failed:", err)
+	}
+	if err := s.UnlinkIdentity(jane.ID, johnIDs[0].ID); err != petfind.ErrNotFound {
+		t.Fatalf("UnlinkIdentity of another user's identity returned %v, expected %v", err, petfind.ErrNotFound)
 	}
+}
 
-	// Ignore updated.
-	got.Updated = time.Time{}
+func TestMergeUsers(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
 
-	want = &petfind.User{
-		ID:         1, // ID stays the same as we are doing an update.
-		LinkedinID: "JANEDOE",
-		Name:       "Michaella Neirou",
-		Created:    created,
+	pet := addTestPet(t, s)
+	duplicateID := pet.OwnerID
+	if err := s.LinkIdentity(duplicateID, &petfind.Identity{Provider: "linkedin", Subject: "JANEDOE"}); err != nil {
+		t.Fatal("LinkIdentity failed:", err)
 	}
-	// This time we expect the values to be updated but the created time should
-	// be the same as the first run.
-	if !reflect.DeepEqual(got, want) {
-		t.Fatalf("PutLinkedinUser second run \nhave: %#v\nwant: %#v", got, want)
+	primary, err := s.PutIdentity(&petfind.Identity{Provider: "github", Subject: "5", Login: "janedoe"})
+	if err != nil {
+		t.Fatal("PutIdentity failed:", err)
+	}
+
+	if err := s.MergeUsers(primary.ID, duplicateID); err != nil {
+		t.Fatal("MergeUsers failed:", err)
+	}
+
+	got, err := s.GetPet(pet.ID)
+	if err != nil {
+		t.Fatal("GetPet failed:", err)
+	}
+	if got.OwnerID != primary.ID {
+		t.Fatalf("merged pet has owner %d, expected %d", got.OwnerID, primary.ID)
+	}
+	u, err := s.GetUserByIdentity("linkedin", "JANEDOE")
+	if err != nil {
+		t.Fatal("GetUserByIdentity failed:", err)
+	}
+	if u.ID != primary.ID {
+		t.Fatalf("merged identity belongs to user %d, expected %d", u.ID, primary.ID)
+	}
+	if _, err := s.GetUser(duplicateID); err != petfind.ErrNotFound {
+		t.Fatalf("GetUser for merged duplicate returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
+
+func TestMergeUsersCredentials(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	// The duplicate signed up with a password and turned on two-factor
+	// authentication; the primary logs in with GitHub and started but did
+	// not finish enrolling.
+	primary, err := s.PutIdentity(&petfind.Identity{Provider: "github", Subject: "5", Login: "janedoe"})
+	if err != nil {
+		t.Fatal("PutIdentity failed:", err)
+	}
+	if err := s.PutTOTP(&petfind.TOTP{UserID: primary.ID, Secret: "PRIMARY"}); err != nil {
+		t.Fatal("PutTOTP failed:", err)
+	}
+	duplicate := &petfind.User{Name: "Jane Doe", Email: "jane@doe.com"}
+	if err := s.CreateLocalUser(duplicate, []byte("hash")); err != nil {
+		t.Fatal("CreateLocalUser failed:", err)
+	}
+	if err := s.VerifyEmail(duplicate.ID, duplicate.Email); err != nil {
+		t.Fatal("VerifyEmail failed:", err)
+	}
+	if err := s.PutTOTP(&petfind.TOTP{UserID: duplicate.ID, Secret: "DUPLICATE"}); err != nil {
+		t.Fatal("PutTOTP failed:", err)
+	}
+	if err := s.EnableTOTP(duplicate.ID, 1, []string{"code1", "code2"}); err != nil {
+		t.Fatal("EnableTOTP failed:", err)
+	}
+
+	if err := s.MergeUsers(primary.ID, duplicate.ID); err != nil {
+		t.Fatal("MergeUsers failed:", err)
+	}
+
+	// The password still logs in to the merged account.
+	u, err := s.GetUserByIdentity(petfind.EmailProvider, "jane@doe.com")
+	if err != nil {
+		t.Fatal("GetUserByIdentity failed:", err)
+	}
+	if u.ID != primary.ID || u.Email != "jane@doe.com" || !u.EmailVerified {
+		t.Errorf("merged user = %#v, expected user %d with the verified address of the duplicate", u, primary.ID)
+	}
+	if hash, err := s.GetPassword(primary.ID); err != nil || string(hash) != "hash" {
+		t.Errorf("GetPassword of merged user = %q, %v, expected the duplicate's hash", hash, err)
+	}
+	tp, err := s.GetTOTP(primary.ID)
+	if err != nil {
+		t.Fatal("GetTOTP failed:", err)
+	}
+	if !tp.Enabled || tp.Secret != "DUPLICATE" {
+		t.Errorf("two-factor settings of merged user = %#v, expected the duplicate's", tp)
+	}
+	if err := s.UseRecoveryCode(primary.ID, "code1"); err != nil {
+		t.Errorf("UseRecoveryCode of moved code failed: %v", err)
+	}
+}
+
+func TestMergeUsersCredentialsConflict(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	primary := &petfind.User{Name: "Jane Doe", Email: "jane@doe.com"}
+	duplicate := &petfind.User{Name: "Jane Doe", Email: "jane@example.com"}
+	for _, u := range []*petfind.User{primary, duplicate} {
+		if err := s.CreateLocalUser(u, []byte("hash of "+u.Email)); err != nil {
+			t.Fatal("CreateLocalUser failed:", err)
+		}
+	}
+	if err := s.MergeUsers(primary.ID, duplicate.ID); err != petfind.ErrMergeCredentials {
+		t.Fatalf("MergeUsers of users with passwords returned %v, expected %v", err, petfind.ErrMergeCredentials)
+	}
+	// Nothing was changed.
+	for _, u := range []*petfind.User{primary, duplicate} {
+		if hash, err := s.GetPassword(u.ID); err != nil || string(hash) != "hash of "+u.Email {
+			t.Errorf("GetPassword of user %d after refused merge = %q, %v", u.ID, hash, err)
+		}
+	}
+}
+
+func TestMergeUsersData(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	pet := addTestPet(t, s)
+	primary := &petfind.User{Name: "Jane Doe", Login: "janedoe"}
+	duplicate := &petfind.User{Name: "Jane Doe", Login: "JaneDoe"}
+	for _, u := range []*petfind.User{primary, duplicate} {
+		if err := s.CreateUser(u); err != nil {
+			t.Fatal("CreateUser failed:", err)
+		}
+		// Both accounts have asked about, applied for and saved the same
+		// pet.
+		c := &petfind.Conversation{PetID: pet.ID, AdopterID: u.ID}
+		if err := s.StartConversation(c, &petfind.Message{Body: "Hello from " + u.Login}); err != nil {
+			t.Fatal("StartConversation failed:", err)
+		}
+		a := &petfind.Application{PetID: pet.ID, ApplicantID: u.ID, Housing: petfind.HousingApartment, Adults: 1, Experience: petfind.ExperienceNone}
+		if err := s.AddApplication(a); err != nil {
+			t.Fatal("AddApplication failed:", err)
+		}
+		if err := s.AddFavorite(u.ID, pet.ID); err != nil {
+			t.Fatal("AddFavorite failed:", err)
+		}
+		if err := s.AddSavedSearch(&petfind.SavedSearch{UserID: u.ID, Name: "Cats", Search: petfind.Search{PlaceKey: "key"}}); err != nil {
+			t.Fatal("AddSavedSearch failed:", err)
+		}
+	}
+	tok := &petfind.APIToken{UserID: duplicate.ID, Name: "script", Prefix: "pfat_abcdef", Hash: "hash", Expires: time.Now().Add(time.Hour)}
+	if err := s.AddAPIToken(tok); err != nil {
+		t.Fatal("AddAPIToken failed:", err)
+	}
+
+	if err := s.MergeUsers(primary.ID, duplicate.ID); err != nil {
+		t.Fatal("MergeUsers failed:", err)
+	}
+
+	conversations, err := s.GetUserConversations(primary.ID)
+	if err != nil {
+		t.Fatal("GetUserConversations failed:", err)
+	}
+	if len(conversations) != 1 {
+		t.Fatalf("merged user has %d conversations, expected 1", len(conversations))
+	}
+	messages, err := s.GetMessages(conversations[0].ID)
+	if err != nil {
+		t.Fatal("GetMessages failed:", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("merged conversation has %d messages, expected 2", len(messages))
+	}
+	for _, m := range messages {
+		if m.SenderID != primary.ID {
+			t.Errorf("merged message %q was sent by %d, expected %d", m.Body, m.SenderID, primary.ID)
+		}
+	}
+
+	applications, err := s.GetSentApplications(primary.ID)
+	if err != nil {
+		t.Fatal("GetSentApplications failed:", err)
+	}
+	var open int
+	for _, a := range applications {
+		if a.Status == petfind.ApplicationSubmitted {
+			open++
+		}
+	}
+	if len(applications) != 2 || open != 1 {
+		t.Fatalf("merged user has %d applications with %d open, expected 2 with 1 open", len(applications), open)
+	}
+
+	favorites, err := s.GetFavoritePets(primary.ID)
+	if err != nil {
+		t.Fatal("GetFavoritePets failed:", err)
+	}
+	if len(favorites) != 1 {
+		t.Fatalf("merged user has %d favorites, expected 1", len(favorites))
+	}
+	searches, err := s.GetUserSavedSearches(primary.ID)
+	if err != nil {
+		t.Fatal("GetUserSavedSearches failed:", err)
+	}
+	if len(searches) != 2 {
+		t.Fatalf("merged user has %d saved searches, expected 2", len(searches))
+	}
+	tokens, err := s.GetUserAPITokens(primary.ID)
+	if err != nil {
+		t.Fatal("GetUserAPITokens failed:", err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != tok.ID {
+		t.Fatalf("merged user has tokens %#v, expected token %d", tokens, tok.ID)
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe", Login: "janedoe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := s.SetUserRole(u.ID, petfind.RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole failed: %v", err)
+	}
+	user, err := s.GetUser(u.ID)
+	if err != nil {
+		t.Fatalf("GetUser failed: %v", err)
+	}
+	if got, want := user.Role, petfind.RoleAdmin; got != want {
+		t.Fatalf("GetUser after SetUserRole Role = %v, want %v", got, want)
+	}
+	if err := s.SetUserRole(u.ID+1, petfind.RoleAdmin); err != petfind.ErrNotFound {
+		t.Fatalf("SetUserRole for unknown user returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
+
+func TestSetUserDisabled(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe", Login: "janedoe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := s.AddSession(&petfind.Session{KeyHash: "hash1", UserID: u.ID}); err != nil {
+		t.Fatalf("AddSession failed: %v", err)
+	}
+
+	if err := s.SetUserDisabled(u.ID, true); err != nil {
+		t.Fatalf("SetUserDisabled failed: %v", err)
+	}
+	user, err := s.GetUser(u.ID)
+	if err != nil {
+		t.Fatalf("GetUser failed: %v", err)
+	}
+	if !user.Disabled {
+		t.Fatalf("GetUser after SetUserDisabled returned an enabled user")
+	}
+	if _, err := s.GetSession("hash1"); err != petfind.ErrNotFound {
+		t.Fatalf("GetSession of disabled user returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	if err := s.SetUserDisabled(u.ID, false); err != nil {
+		t.Fatalf("SetUserDisabled false failed: %v", err)
+	}
+	if user, err = s.GetUser(u.ID); err != nil || user.Disabled {
+		t.Fatalf("GetUser after enabling returned %#v, %v", user, err)
+	}
+	if err := s.SetUserDisabled(u.ID+1, true); err != petfind.ErrNotFound {
+		t.Fatalf("SetUserDisabled for unknown user returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	for _, u := range []*petfind.User{
+		{Name: "Jane Doe", Login: "janedoe", Email: "jane@example.com"},
+		{Name: "John Doe", Login: "johndoe", Email: "john@example.com"},
+		{Name: "Mary 100%", Login: "mary", Email: "mary@example.org"},
+	} {
+		if err := s.CreateUser(u); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{"", 10, []string{"janedoe", "johndoe", "mary"}},
+		{"", 2, []string{"janedoe", "johndoe"}},
+		{"DOE", 10, []string{"janedoe", "johndoe"}},
+		{"example.org", 10, []string{"mary"}},
+		{"%", 10, []string{"mary"}},
+		{"_", 10, []string{}},
+	}
+	for _, tt := range tests {
+		users, err := s.SearchUsers(tt.query, tt.limit, 0)
+		if err != nil {
+			t.Fatalf("SearchUsers(%q) failed: %v", tt.query, err)
+		}
+		got := make([]string, 0, len(users))
+		for _, u := range users {
+			got = append(got, u.Login)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchUsers(%q, %d) = %q, want %q", tt.query, tt.limit, got, tt.want)
+		}
+	}
+
+	users, err := s.SearchUsers("doe", 10, 1)
+	if err != nil {
+		t.Fatalf("SearchUsers with offset failed: %v", err)
+	}
+	if len(users) != 1 || users[0].Login != "johndoe" {
+		t.Errorf("SearchUsers with offset returned %v, want johndoe", users)
 	}
 }
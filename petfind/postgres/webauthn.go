@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func (db *store) AddWebAuthnCredential(c *petfind.WebAuthnCredential) error {
+	const credentialInsertStmt = `
+	INSERT INTO webauthn_credentials(user_id, credential_id, public_key, sign_count, name, created, last_used)
+	VALUES ($1, $2, $3, $4, $5, now(), now())
+	RETURNING id, created, last_used
+	`
+	return db.QueryRow(credentialInsertStmt, c.UserID, c.CredentialID, c.PublicKey, c.SignCount, c.Name).Scan(&c.ID, &c.Created, &c.LastUsed)
+}
+
+const credentialSelect = `
+	SELECT id, user_id, credential_id, public_key, sign_count, name, created, last_used
+	FROM webauthn_credentials`
+
+func (db *store) GetWebAuthnCredential(credentialID []byte) (*petfind.WebAuthnCredential, error) {
+	const credentialGetQuery = credentialSelect + `
+	WHERE credential_id = $1
+	`
+	c := new(petfind.WebAuthnCredential)
+	err := db.QueryRow(credentialGetQuery, credentialID).Scan(
+		&c.ID,
+		&c.UserID,
+		&c.CredentialID,
+		&c.PublicKey,
+		&c.SignCount,
+		&c.Name,
+		&c.Created,
+		&c.LastUsed,
+	)
+	if err == sql.ErrNoRows {
+		return nil, petfind.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (db *store) GetUserWebAuthnCredentials(userID int64) ([]*petfind.WebAuthnCredential, error) {
+	const credentialsGetQuery = credentialSelect + `
+	WHERE user_id = $1
+	ORDER BY created
+	`
+	rows, err := db.Query(credentialsGetQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	credentials := make([]*petfind.WebAuthnCredential, 0)
+	for rows.Next() {
+		c := new(petfind.WebAuthnCredential)
+		if err := rows.Scan(
+			&c.ID,
+			&c.UserID,
+			&c.CredentialID,
+			&c.PublicKey,
+			&c.SignCount,
+			&c.Name,
+			&c.Created,
+			&c.LastUsed,
+		); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, c)
+	}
+	return credentials, nil
+}
+
+// UseWebAuthnCredential records a login with the credential and its new
+// signature counter. The counter is checked again in the statement so that
+// two logins racing with the same assertion cannot both succeed.
+// petfind.ErrNotFound is returned if the credential does not exist or its
+// counter has moved past signCount.
+func (db *store) UseWebAuthnCredential(id, signCount int64) error {
+	const credentialUseStmt = `
+	UPDATE webauthn_credentials SET sign_count = $2, last_used = now()
+	WHERE id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))
+	`
+	res, err := db.Exec(credentialUseStmt, id, signCount)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return petfind.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteWebAuthnCredential removes a credential of the user.
+// petfind.ErrNotFound is returned if the user has no such credential.
+func (db *store) DeleteWebAuthnCredential(userID, id int64) error {
+	res, err := db.Exec("DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return petfind.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// +build db
+
+package postgres_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestWebAuthnCredentials(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	u := &petfind.User{Name: "Jane Doe"}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	other := &petfind.User{Name: "John Doe"}
+	if err := s.CreateUser(other); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	c := &petfind.WebAuthnCredential{
+		UserID:       u.ID,
+		CredentialID: []byte{1, 2, 3},
+		PublicKey:    []byte{4, 5, 6},
+		SignCount:    1,
+		Name:         "Laptop",
+	}
+	if err := s.AddWebAuthnCredential(c); err != nil {
+		t.Fatalf("AddWebAuthnCredential failed: %v", err)
+	}
+	// Credential IDs are unique.
+	dup := &petfind.WebAuthnCredential{UserID: other.ID, CredentialID: []byte{1, 2, 3}, PublicKey: []byte{7}}
+	if err := s.AddWebAuthnCredential(dup); err == nil {
+		t.Fatal("AddWebAuthnCredential with existing credential ID succeeded")
+	}
+
+	got, err := s.GetWebAuthnCredential([]byte{1, 2, 3})
+	if err != nil {
+		t.Fatalf("GetWebAuthnCredential failed: %v", err)
+	}
+	if got.UserID != u.ID || !bytes.Equal(got.PublicKey, c.PublicKey) || got.SignCount != 1 {
+		t.Fatalf("GetWebAuthnCredential returned %#v", got)
+	}
+	if _, err := s.GetWebAuthnCredential([]byte{9}); err != petfind.ErrNotFound {
+		t.Fatalf("GetWebAuthnCredential for unknown ID returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	if err := s.UseWebAuthnCredential(c.ID, 2); err != nil {
+		t.Fatalf("UseWebAuthnCredential failed: %v", err)
+	}
+	if err := s.UseWebAuthnCredential(c.ID, 2); err != petfind.ErrNotFound {
+		t.Fatalf("UseWebAuthnCredential with same counter returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+
+	list, err := s.GetUserWebAuthnCredentials(u.ID)
+	if err != nil {
+		t.Fatalf("GetUserWebAuthnCredentials failed: %v", err)
+	}
+	if len(list) != 1 || list[0].SignCount != 2 {
+		t.Fatalf("GetUserWebAuthnCredentials returned %#v", list)
+	}
+
+	if err := s.DeleteWebAuthnCredential(other.ID, c.ID); err != petfind.ErrNotFound {
+		t.Fatalf("DeleteWebAuthnCredential by another user returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+	if err := s.DeleteWebAuthnCredential(u.ID, c.ID); err != nil {
+		t.Fatalf("DeleteWebAuthnCredential failed: %v", err)
+	}
+}
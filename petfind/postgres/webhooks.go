@@ -0,0 +1,248 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// AddWebhook stores a new webhook subscription.
+func (db *store) AddWebhook(w *petfind.Webhook) error {
+	const webhookInsertStmt = `
+	INSERT INTO webhooks(name, url, secret, events, active, created, updated)
+	VALUES ($1, $2, $3, $4, $5, now(), now())
+	RETURNING id, created, updated
+	`
+	return db.QueryRow(webhookInsertStmt, w.Name, w.URL, w.Secret, pq.Array(w.Events), w.Active).Scan(&w.ID, &w.Created, &w.Updated)
+}
+
+// GetWebhooks returns all the webhooks, oldest first.
+func (db *store) GetWebhooks() (webhooks []*petfind.Webhook, err error) {
+	const webhooksQuery = `
+	SELECT id, name, url, secret, events, active, created, updated
+	FROM webhooks
+	ORDER BY id
+	`
+	rows, err := db.Query(webhooksQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	webhooks = make([]*petfind.Webhook, 0)
+	for rows.Next() {
+		w := new(petfind.Webhook)
+		if err := rows.Scan(
+			&w.ID,
+			&w.Name,
+			&w.URL,
+			&w.Secret,
+			pq.Array(&w.Events),
+			&w.Active,
+			&w.Created,
+			&w.Updated,
+		); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+// SetWebhookActive turns the delivery of events to a webhook on or off.
+func (db *store) SetWebhookActive(webhookID int64, active bool) error {
+	const webhookActiveStmt = `UPDATE webhooks SET active = $2, updated = now() WHERE id = $1`
+	res, err := db.Exec(webhookActiveStmt, webhookID, active)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
+
+// DeleteWebhook deletes a webhook together with its deliveries.
+func (db *store) DeleteWebhook(webhookID int64) error {
+	const webhookDeleteStmt = `DELETE FROM webhooks WHERE id = $1`
+	res, err := db.Exec(webhookDeleteStmt, webhookID)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
+
+// AddWebhookDeliveries queues the payload of an event for every active
+// webhook that subscribed to it and returns how many were queued.
+func (db *store) AddWebhookDeliveries(event string, payload []byte) (int64, error) {
+	const webhookDeliveriesInsertStmt = `
+	INSERT INTO webhook_deliveries(webhook_id, event, payload, status, attempts, next_attempt, created, updated)
+	SELECT id, $1::text, $2, 0, 0, now(), now(), now()
+	FROM webhooks
+	WHERE active AND $1::text = ANY(events)
+	`
+	res, err := db.Exec(webhookDeliveriesInsertStmt, event, payload)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// webhookDeliverySelect selects deliveries together with the URL and secret
+// of their webhook.
+const webhookDeliverySelect = `
+	SELECT
+	  d.id,
+	  d.webhook_id,
+	  w.url,
+	  w.secret,
+	  d.event,
+	  d.payload,
+	  d.status,
+	  d.attempts,
+	  d.next_attempt,
+	  d.last_status_code,
+	  d.last_error,
+	  d.created,
+	  d.updated
+	FROM webhook_deliveries d
+	  JOIN webhooks w ON d.webhook_id = w.id`
+
+func (db *store) queryWebhookDeliveries(query string, args ...interface{}) (deliveries []*petfind.WebhookDelivery, err error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+			return
+		}
+	}()
+
+	deliveries = make([]*petfind.WebhookDelivery, 0)
+	for rows.Next() {
+		d := new(petfind.WebhookDelivery)
+		if err := rows.Scan(
+			&d.ID,
+			&d.WebhookID,
+			&d.URL,
+			&d.Secret,
+			&d.Event,
+			&d.Payload,
+			&d.Status,
+			&d.Attempts,
+			&d.NextAttempt,
+			&d.LastStatusCode,
+			&d.LastError,
+			&d.Created,
+			&d.Updated,
+		); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries of active
+// webhooks that are due, the oldest first. Their next attempt is pushed back
+// by lease so that other workers skip them while they are being attempted and
+// so that they are attempted again if the worker dies before recording the
+// attempt.
+func (db *store) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]*petfind.WebhookDelivery, error) {
+	const webhookDeliveriesClaimQuery = `
+	WITH claimed AS (
+	  UPDATE webhook_deliveries SET next_attempt = now() + $2 * interval '1 microsecond'
+	  WHERE id IN (
+	    SELECT d.id FROM webhook_deliveries d
+	      JOIN webhooks w ON d.webhook_id = w.id
+	    WHERE d.status = 0 AND d.next_attempt <= now() AND w.active
+	    ORDER BY d.next_attempt
+	    LIMIT $1
+	    FOR UPDATE OF d SKIP LOCKED
+	  )
+	  RETURNING id
+	)` + webhookDeliverySelect + `
+	WHERE d.id IN (SELECT id FROM claimed)
+	ORDER BY d.id
+	`
+	return db.queryWebhookDeliveries(webhookDeliveriesClaimQuery, limit, int64(lease/time.Microsecond))
+}
+
+// RecordWebhookAttempt stores an attempt to deliver d and updates d with its
+// outcome. The status, attempts and next attempt of d are saved as they are.
+func (db *store) RecordWebhookAttempt(d *petfind.WebhookDelivery, a *petfind.WebhookAttempt) (err error) {
+	const (
+		webhookAttemptInsertStmt = `
+	INSERT INTO webhook_attempts(delivery_id, status_code, error, duration_ms, created)
+	VALUES ($1, $2, $3, $4, now())
+	RETURNING id, created
+	`
+		webhookDeliveryUpdateStmt = `
+	UPDATE webhook_deliveries
+	SET status = $2, attempts = $3, next_attempt = $4, last_status_code = $5, last_error = $6, updated = now()
+	WHERE id = $1
+	RETURNING updated
+	`
+	)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				err = fmt.Errorf("rollback failed: %v: %v", rerr, err)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	a.DeliveryID = d.ID
+	durationMS := int64(a.Duration / time.Millisecond)
+	if err = tx.QueryRow(webhookAttemptInsertStmt, a.DeliveryID, a.StatusCode, a.Error, durationMS).Scan(&a.ID, &a.Created); err != nil {
+		return err
+	}
+	d.LastStatusCode = a.StatusCode
+	d.LastError = a.Error
+	err = tx.QueryRow(webhookDeliveryUpdateStmt,
+		d.ID,
+		d.Status,
+		d.Attempts,
+		d.NextAttempt,
+		d.LastStatusCode,
+		d.LastError,
+	).Scan(&d.Updated)
+	return err
+}
+
+// GetWebhookDeliveries returns the latest deliveries of a webhook, the newest
+// first.
+func (db *store) GetWebhookDeliveries(webhookID int64, limit int) ([]*petfind.WebhookDelivery, error) {
+	const webhookDeliveriesQuery = webhookDeliverySelect + `
+	WHERE d.webhook_id = $1
+	ORDER BY d.id DESC
+	LIMIT $2
+	`
+	return db.queryWebhookDeliveries(webhookDeliveriesQuery, webhookID, limit)
+}
+
+// RetryWebhookDelivery makes a delivery due again, resetting its attempts,
+// e.g. to redeliver a dead one once the webhook is fixed.
+func (db *store) RetryWebhookDelivery(deliveryID int64) error {
+	const webhookDeliveryRetryStmt = `
+	UPDATE webhook_deliveries SET status = 0, attempts = 0, next_attempt = now(), updated = now()
+	WHERE id = $1
+	`
+	res, err := db.Exec(webhookDeliveryRetryStmt, deliveryID)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(res)
+}
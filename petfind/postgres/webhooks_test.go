@@ -0,0 +1,108 @@
+// +build db
+
+package postgres_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestWebhooks(t *testing.T) {
+	s := setup(t)
+	defer teardown(t, s)
+
+	shelter := &petfind.Webhook{Name: "shelter", URL: "https://shelter.example.com/hook", Secret: "secret", Events: []string{"pet.created", "pet.adopted"}, Active: true}
+	paused := &petfind.Webhook{Name: "paused", URL: "https://paused.example.com/hook", Secret: "secret", Events: []string{"pet.created"}}
+	for _, w := range []*petfind.Webhook{shelter, paused} {
+		if err := s.AddWebhook(w); err != nil {
+			t.Fatalf("AddWebhook failed: %v", err)
+		}
+	}
+	webhooks, err := s.GetWebhooks()
+	if err != nil {
+		t.Fatalf("GetWebhooks failed: %v", err)
+	}
+	if len(webhooks) != 2 || !reflect.DeepEqual(webhooks[0].Events, shelter.Events) || webhooks[1].Active {
+		t.Fatalf("GetWebhooks = %#v", webhooks)
+	}
+
+	// Only active webhooks that subscribed to the event get a delivery.
+	n, err := s.AddWebhookDeliveries("pet.created", []byte(`{"event":"pet.created"}`))
+	if err != nil || n != 1 {
+		t.Fatalf("AddWebhookDeliveries = %d, %v, expected 1 delivery", n, err)
+	}
+	if n, err = s.AddWebhookDeliveries("pet.removed", []byte(`{}`)); err != nil || n != 0 {
+		t.Fatalf("AddWebhookDeliveries for unsubscribed event = %d, %v", n, err)
+	}
+
+	claimed, err := s.ClaimWebhookDeliveries(10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimWebhookDeliveries failed: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].URL != shelter.URL || claimed[0].Secret != "secret" || string(claimed[0].Payload) != `{"event":"pet.created"}` {
+		t.Fatalf("ClaimWebhookDeliveries = %#v", claimed)
+	}
+	// Claimed deliveries are leased to the worker that claimed them.
+	if again, err := s.ClaimWebhookDeliveries(10, time.Minute); err != nil || len(again) != 0 {
+		t.Fatalf("ClaimWebhookDeliveries while leased = %#v, %v", again, err)
+	}
+
+	d := claimed[0]
+	d.Attempts = 1
+	d.NextAttempt = time.Now().Add(-time.Second)
+	a := &petfind.WebhookAttempt{StatusCode: 503, Error: "503 Service Unavailable", Duration: 120 * time.Millisecond}
+	if err := s.RecordWebhookAttempt(d, a); err != nil {
+		t.Fatalf("RecordWebhookAttempt failed: %v", err)
+	}
+	if a.ID == 0 || a.DeliveryID != d.ID || d.LastStatusCode != 503 {
+		t.Fatalf("RecordWebhookAttempt = %#v, %#v", a, d)
+	}
+
+	// Deliveries of inactive webhooks wait until the webhook is enabled.
+	if err := s.SetWebhookActive(shelter.ID, false); err != nil {
+		t.Fatalf("SetWebhookActive failed: %v", err)
+	}
+	if claimed, err = s.ClaimWebhookDeliveries(10, time.Minute); err != nil || len(claimed) != 0 {
+		t.Fatalf("ClaimWebhookDeliveries of inactive webhook = %#v, %v", claimed, err)
+	}
+	if err := s.SetWebhookActive(shelter.ID, true); err != nil {
+		t.Fatalf("SetWebhookActive failed: %v", err)
+	}
+	if claimed, err = s.ClaimWebhookDeliveries(10, time.Minute); err != nil || len(claimed) != 1 || claimed[0].LastError != a.Error {
+		t.Fatalf("ClaimWebhookDeliveries after failed attempt = %#v, %v", claimed, err)
+	}
+
+	// Dead deliveries are not claimed until they are retried.
+	d.Status = petfind.DeliveryDead
+	d.Attempts = 10
+	d.NextAttempt = time.Now().Add(-time.Second)
+	if err := s.RecordWebhookAttempt(d, &petfind.WebhookAttempt{Error: "connection refused"}); err != nil {
+		t.Fatalf("RecordWebhookAttempt failed: %v", err)
+	}
+	if claimed, err = s.ClaimWebhookDeliveries(10, 0); err != nil || len(claimed) != 0 {
+		t.Fatalf("ClaimWebhookDeliveries of dead delivery = %#v, %v", claimed, err)
+	}
+	if err := s.RetryWebhookDelivery(d.ID); err != nil {
+		t.Fatalf("RetryWebhookDelivery failed: %v", err)
+	}
+	deliveries, err := s.GetWebhookDeliveries(shelter.ID, 20)
+	if err != nil {
+		t.Fatalf("GetWebhookDeliveries failed: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != petfind.DeliveryPending || deliveries[0].Attempts != 0 || deliveries[0].LastError != "connection refused" {
+		t.Fatalf("GetWebhookDeliveries after retry = %#v", deliveries)
+	}
+
+	if err := s.DeleteWebhook(shelter.ID); err != nil {
+		t.Fatalf("DeleteWebhook failed: %v", err)
+	}
+	if deliveries, err = s.GetWebhookDeliveries(shelter.ID, 20); err != nil || len(deliveries) != 0 {
+		t.Fatalf("GetWebhookDeliveries of deleted webhook = %#v, %v", deliveries, err)
+	}
+	if err := s.DeleteWebhook(shelter.ID); err != petfind.ErrNotFound {
+		t.Fatalf("DeleteWebhook for deleted webhook returned %v, expected %v", err, petfind.ErrNotFound)
+	}
+}
@@ -0,0 +1,56 @@
+package petfind
+
+import "fmt"
+
+// Role decides what a user is allowed to do besides managing their own pets.
+// Roles are ordered and each one can do everything the ones before it can.
+type Role int64
+
+const (
+	RoleUser Role = iota
+	RoleModerator
+	RoleAdmin
+)
+
+var roles = [...]string{
+	"user",
+	"moderator",
+	"admin",
+}
+
+// String returns the name of the role ("user", "moderator", "admin").
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(roles) {
+		return fmt.Sprintf("Role(%d)", int64(r))
+	}
+	return roles[r]
+}
+
+// ParseRole returns the role with the given name.
+func ParseRole(name string) (Role, error) {
+	for i, n := range roles {
+		if n == name {
+			return Role(i), nil
+		}
+	}
+	return RoleUser, fmt.Errorf("unknown role %q", name)
+}
+
+// HasRole reports whether the user has role r or a more powerful one.
+func (u *User) HasRole(r Role) bool {
+	return u != nil && u.Role >= r
+}
+
+// ManageableBy is the ownership policy of pets. It reports whether u may
+// change or remove the pet: its owner can and so can moderators.
+func (p Pet) ManageableBy(u *User) bool {
+	if u == nil {
+		return false
+	}
+	return p.OwnerID == u.ID || u.HasRole(RoleModerator)
+}
+
+// OwnedBy reports whether u is the owner of the pet.
+func (p Pet) OwnedBy(u *User) bool {
+	return u != nil && p.OwnerID == u.ID
+}
@@ -0,0 +1,39 @@
+package petfind
+
+import "testing"
+
+func TestParseRole(t *testing.T) {
+	for _, r := range []Role{RoleUser, RoleModerator, RoleAdmin} {
+		got, err := ParseRole(r.String())
+		if err != nil || got != r {
+			t.Errorf("ParseRole(%q) = %v, %v, expected %v", r.String(), got, err, r)
+		}
+	}
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("ParseRole accepted an unknown role")
+	}
+}
+
+func TestPetManageableBy(t *testing.T) {
+	owner := &User{ID: 1}
+	stranger := &User{ID: 2}
+	moderator := &User{ID: 3, Role: RoleModerator}
+	admin := &User{ID: 4, Role: RoleAdmin}
+	p := Pet{OwnerID: owner.ID}
+
+	tests := []struct {
+		user *User
+		want bool
+	}{
+		{owner, true},
+		{stranger, false},
+		{moderator, true},
+		{admin, true},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := p.ManageableBy(tt.user); got != tt.want {
+			t.Errorf("ManageableBy(%+v) = %v, expected %v", tt.user, got, tt.want)
+		}
+	}
+}
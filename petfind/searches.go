@@ -0,0 +1,46 @@
+package petfind
+
+import (
+	"strings"
+	"time"
+)
+
+// SavedSearch is a search a user saved to be told about new pets that match
+// it.
+type SavedSearch struct {
+	ID     int64
+	UserID int64
+	// User is the owner of the search, set when fetching the searches to
+	// match.
+	User *User
+	// Name describes the search to the user, e.g. "Young cats in Χαλκίδα".
+	Name   string
+	Search Search
+	// LastRun is when the search was last matched. Only pets listed after
+	// it are reported.
+	LastRun time.Time
+	Created time.Time
+}
+
+// Describe returns a short English description of the search for pets in the
+// place with placeName, e.g. "Young, Cat in Χαλκίδα".
+func (s Search) Describe(placeName string) string {
+	var parts []string
+	if s.UseAge {
+		parts = append(parts, s.Age.String())
+	}
+	if s.UseGender {
+		parts = append(parts, s.Gender.String())
+	}
+	if s.UseSize {
+		parts = append(parts, s.Size.String())
+	}
+	if s.UseType {
+		parts = append(parts, s.Type.String())
+	}
+	what := "All pets"
+	if len(parts) != 0 {
+		what = strings.Join(parts, ", ")
+	}
+	return what + " in " + placeName
+}
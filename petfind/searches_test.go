@@ -0,0 +1,22 @@
+package petfind
+
+import "testing"
+
+func TestSearchDescribe(t *testing.T) {
+	tests := []struct {
+		s    Search
+		want string
+	}{
+		{Search{PlaceKey: "1"}, "All pets in Χαλκίδα"},
+		{Search{PlaceKey: "1", Type: Cat, UseType: true}, "Cat in Χαλκίδα"},
+		{Search{PlaceKey: "1", Age: Young, UseAge: true, Type: Cat, UseType: true}, "Young, Cat in Χαλκίδα"},
+		{Search{PlaceKey: "1", Age: Senior, UseAge: true, Gender: Female, UseGender: true, Size: Small, UseSize: true, Type: Dog, UseType: true}, "Senior, Female, Small, Dog in Χαλκίδα"},
+		// Values that are not used are ignored.
+		{Search{PlaceKey: "1", Type: Cat}, "All pets in Χαλκίδα"},
+	}
+	for _, tt := range tests {
+		if got := tt.s.Describe("Χαλκίδα"); got != tt.want {
+			t.Errorf("%#v.Describe() = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
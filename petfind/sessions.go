@@ -0,0 +1,16 @@
+package petfind
+
+import "time"
+
+// Session holds information about a logged in session of a user. The session
+// data itself lives in the session store; this is the application's record of
+// which sessions belong to which user so that they can be listed and revoked.
+type Session struct {
+	ID        int64
+	KeyHash   string
+	UserID    int64
+	IP        string
+	UserAgent string
+	Created   time.Time
+	LastSeen  time.Time
+}
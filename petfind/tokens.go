@@ -0,0 +1,30 @@
+package petfind
+
+import "time"
+
+// Kinds of tokens.
+const (
+	// TokenVerifyEmail proves ownership of the email address of a local
+	// account.
+	TokenVerifyEmail = "verify"
+	// TokenResetPassword allows setting a new password for a local account.
+	TokenResetPassword = "reset"
+	// TokenMagicLink signs in the owner of an email address without a
+	// password.
+	TokenMagicLink = "magic"
+)
+
+// Token is a single-use secret sent to a user, for example inside an email
+// verification link. Only the SHA-256 hash of the secret is stored so that a
+// leaked database cannot be used to take over accounts.
+type Token struct {
+	ID int64
+	// UserID is 0 for tokens sent to addresses that have no account yet.
+	UserID int64
+	Kind   string
+	Hash   string
+	// Email is the address the token was sent to.
+	Email   string
+	Created time.Time
+	Expires time.Time
+}
@@ -0,0 +1,29 @@
+package petfind
+
+import (
+	"errors"
+	"time"
+)
+
+// TOTP is a user's authenticator app enrolment for two-factor authentication.
+// A secret is stored as soon as enrolment starts but it is only enforced at
+// login once the user has proven their app works by entering a code.
+type TOTP struct {
+	UserID int64
+	// Secret is the base32 encoded shared secret.
+	Secret  string
+	Enabled bool
+	// LastStep is the time step of the last accepted code. Codes from this
+	// step or earlier are rejected so that a code cannot be replayed.
+	LastStep int64
+	Created  time.Time
+	// Failures is the number of wrong codes entered in a row, across
+	// logins and changes to the settings. Once there are too many, codes
+	// are not checked at all until LockedUntil.
+	Failures    int64
+	LockedUntil time.Time
+}
+
+// ErrTOTPEnabled is returned when starting a new enrolment for a user who
+// already has two-factor authentication enabled.
+var ErrTOTPEnabled = errors.New("two-factor authentication is already enabled")
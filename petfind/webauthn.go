@@ -0,0 +1,20 @@
+package petfind
+
+import "time"
+
+// WebAuthnCredential is a passkey or security key a user has registered to
+// log in with.
+type WebAuthnCredential struct {
+	ID     int64
+	UserID int64
+	// CredentialID is the ID the authenticator assigned to the credential.
+	CredentialID []byte
+	// PublicKey is the credential's public key in COSE_Key format.
+	PublicKey []byte
+	// SignCount is the authenticator's signature counter at the last login.
+	SignCount int64
+	// Name is chosen by the user to tell their credentials apart.
+	Name     string
+	Created  time.Time
+	LastUsed time.Time
+}
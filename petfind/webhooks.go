@@ -0,0 +1,114 @@
+package petfind
+
+import "time"
+
+// WebhookEvent returns the name of the event as sent to webhooks, e.g.
+// "pet.adopted".
+func (e PetEvent) WebhookEvent() string { return "pet." + string(e) }
+
+// WebhookEvents are the events webhooks can subscribe to.
+var WebhookEvents = []string{
+	PetCreated.WebhookEvent(),
+	PetUpdated.WebhookEvent(),
+	PetAdopted.WebhookEvent(),
+	PetRemoved.WebhookEvent(),
+}
+
+// ValidWebhookEvent reports whether event is one of WebhookEvents.
+func ValidWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook is a subscription of a partner organisation to the events of the
+// pets, which are posted to URL signed with Secret.
+type Webhook struct {
+	ID int64
+	// Name tells the admins whose webhook it is.
+	Name   string
+	URL    string
+	Secret string
+	// Events are the WebhookEvents the webhook subscribed to.
+	Events []string
+	// Active webhooks receive events. Inactive ones are kept with their
+	// deliveries but receive nothing.
+	Active  bool
+	Created time.Time
+	Updated time.Time
+}
+
+// Subscribed reports whether the webhook receives event.
+func (w *Webhook) Subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryStatus is where the delivery of an event to a webhook stands.
+type DeliveryStatus int64
+
+const (
+	// DeliveryPending deliveries wait to be attempted, either for the first
+	// time or again after a failed attempt.
+	DeliveryPending DeliveryStatus = iota
+	// DeliveryDelivered deliveries were accepted by the webhook with a 2xx
+	// response.
+	DeliveryDelivered
+	// DeliveryDead deliveries failed too many times and are no longer
+	// retried.
+	DeliveryDead
+)
+
+var deliveryStatuses = [...]string{
+	"Pending",
+	"Delivered",
+	"Dead",
+}
+
+// String returns the English name of the status ("Pending", ...).
+func (s DeliveryStatus) String() string {
+	if s < 0 || int(s) >= len(deliveryStatuses) {
+		return "Unknown"
+	}
+	return deliveryStatuses[s]
+}
+
+// WebhookDelivery is an event queued for a webhook. The payload is fixed when
+// the event happens so that retries send exactly the same body.
+type WebhookDelivery struct {
+	ID        int64
+	WebhookID int64
+	// URL and Secret are those of the webhook, set when claiming deliveries.
+	URL         string
+	Secret      string
+	Event       string
+	Payload     []byte
+	Status      DeliveryStatus
+	Attempts    int64
+	NextAttempt time.Time
+	// LastStatusCode and LastError are those of the last attempt.
+	LastStatusCode int
+	LastError      string
+	Created        time.Time
+	Updated        time.Time
+}
+
+// WebhookAttempt records one attempt to deliver an event to a webhook.
+type WebhookAttempt struct {
+	ID         int64
+	DeliveryID int64
+	// StatusCode is the HTTP status the webhook responded with or 0 if it
+	// could not be reached.
+	StatusCode int
+	// Error is why the attempt failed, if it did.
+	Error    string
+	Duration time.Duration
+	Created  time.Time
+}
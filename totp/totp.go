@@ -0,0 +1,133 @@
+// Package totp implements the time-based one-time passwords of RFC 6238 used
+// as a second factor by authenticator apps:
+//
+// https://tools.ietf.org/html/rfc6238
+//
+// Codes are 6 digits long, change every 30 seconds and are computed with
+// HMAC-SHA1 which is what every authenticator app supports.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is how long each code is valid for, in seconds.
+	Period = 30
+	// Digits is the length of the codes.
+	Digits = 6
+	// Skew is the number of periods before and after the current one whose
+	// codes are also accepted, to allow for clock drift and slow typing.
+	Skew = 1
+	// SecretSize is the length in bytes of the generated secrets. RFC 4226
+	// recommends 160 bits which matches the output of SHA-1.
+	SecretSize = 20
+)
+
+// NewSecret returns a new random secret encoded in base32, the format
+// authenticator apps expect.
+func NewSecret() (string, error) {
+	b := make([]byte, SecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating secret: %v", err)
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "="), nil
+}
+
+// decodeSecret decodes a base32 secret with or without padding as
+// authenticator apps usually leave it out.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimRight(secret, "="))
+	if n := len(secret) % 8; n != 0 {
+		secret += strings.Repeat("=", 8-n)
+	}
+	key, err := base32.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %v", err)
+	}
+	return key, nil
+}
+
+// Step returns the time step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code for secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, Step(t), Digits), nil
+}
+
+// code implements the HOTP algorithm of RFC 4226 section 5.3 for a counter
+// which in TOTP is the time step.
+func code(key []byte, step int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, bin%mod)
+}
+
+// Validate checks passcode against secret at time t, accepting the codes of
+// Skew steps around t. It returns the step the passcode belongs to so that the
+// caller can refuse to accept the same code, or an earlier one, twice as RFC
+// 6238 section 5.2 requires.
+func Validate(secret, passcode string, t time.Time) (step int64, ok bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	passcode = strings.Replace(passcode, " ", "", -1)
+	if len(passcode) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for s := now - Skew; s <= now+Skew; s++ {
+		if subtle.ConstantTimeCompare([]byte(passcode), []byte(code(key, s, Digits))) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth:// URI which authenticator apps scan from a QR code
+// to add an account, as described by the Key Uri Format of Google
+// Authenticator:
+//
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,92 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238 appendix B which use 8 digit codes.
+var rfcTests = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, tt := range rfcTests {
+		got := code(key, Step(time.Unix(tt.unix, 0)), 8)
+		if got != tt.code {
+			t.Errorf("code at %d = %q, expected %q", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1500000000, 0)
+
+	c, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, ok := Validate(secret, c, now)
+	if !ok || step != Step(now) {
+		t.Errorf("Validate current code = %d, %v, expected %d, true", step, ok, Step(now))
+	}
+
+	prev, _ := Code(secret, now.Add(-Period*time.Second))
+	if step, ok := Validate(secret, prev, now); !ok || step != Step(now)-1 {
+		t.Errorf("Validate previous code = %d, %v, expected %d, true", step, ok, Step(now)-1)
+	}
+
+	old, _ := Code(secret, now.Add(-2*Period*time.Second))
+	if old != c && old != prev {
+		if _, ok := Validate(secret, old, now); ok {
+			t.Error("Validate accepted a code from two periods ago")
+		}
+	}
+
+	for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(secret, bad, now); ok {
+			t.Errorf("Validate accepted %q", bad)
+		}
+	}
+	if _, ok := Validate("not base32!", c, now); ok {
+		t.Error("Validate accepted an invalid secret")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("petfind", "jane@example.com", "JBSWY3DPEHPK3PXP")
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/petfind:jane@example.com" {
+		t.Errorf("URI = %q has wrong scheme, type or label", uri)
+	}
+	q := u.Query()
+	if q.Get("secret") != "JBSWY3DPEHPK3PXP" || q.Get("issuer") != "petfind" {
+		t.Errorf("URI = %q has wrong secret or issuer", uri)
+	}
+}
+
+func TestDecodeSecret(t *testing.T) {
+	for _, secret := range []string{"MZXW6", "mzxw6", "MZXW6==="} {
+		key, err := decodeSecret(secret)
+		if err != nil || string(key) != "foo" {
+			t.Errorf("decodeSecret(%q) = %q, %v, expected %q", secret, key, err, "foo")
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/sessions"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// accountsPage is shown on /me/accounts.
+type accountsPage struct {
+	Identities []*identityItem
+	// Available are the providers the user has not connected yet.
+	Available []loginButton
+	Message   string
+}
+
+type identityItem struct {
+	*petfind.Identity
+	Title string
+}
+
+var accountMessages = map[string]string{
+	"linked":   "The account was connected.",
+	"taken":    "That account is already connected to a different user. Contact us if you'd like the two users merged.",
+	"unlinked": "The account was disconnected.",
+	"last":     "You cannot disconnect the only account you log in with.",
+}
+
+func (s *server) serveAccounts(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	identities, err := s.store.GetUserIdentities(user.ID)
+	if err != nil {
+		return E(err, "error getting user identities", http.StatusInternalServerError)
+	}
+
+	page := &accountsPage{Message: accountMessages[r.FormValue("m")]}
+	connected := make(map[string]bool)
+	for _, id := range identities {
+		title := id.Provider
+		if p := s.provider(id.Provider); p != nil {
+			title = p.Title()
+		}
+		if id.Provider == petfind.EmailProvider {
+			title = "Email and password"
+		}
+		page.Identities = append(page.Identities, &identityItem{Identity: id, Title: title})
+		connected[id.Provider] = true
+	}
+	for _, b := range s.loginButtons() {
+		if !connected[b.Name] {
+			page.Available = append(page.Available, b)
+		}
+	}
+	return s.render(w, r, s.templates.accounts, page, nil)
+}
+
+// handleLinkIdentity starts a login attempt with another provider which, once
+// completed, connects the provider's account to the logged in user instead of
+// logging in.
+func (s *server) handleLinkIdentity(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	p := s.provider(r.PostFormValue("provider"))
+	if p == nil {
+		return E(nil, "Unknown login provider", http.StatusBadRequest)
+	}
+
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+	session.Values["linkUserID"] = user.ID
+	return s.startLogin(w, r, session, p)
+}
+
+// linkIdentity completes a linking attempt. The session must still be a valid
+// login of the user who started it, otherwise a stale or stolen attempt
+// could attach an account to someone else.
+func (s *server) linkIdentity(w http.ResponseWriter, r *http.Request, session *sessions.Session, linkUserID int64, identity *petfind.Identity) *Error {
+	userID, err := fromSessionGetUserID(session)
+	if err != nil || userID != linkUserID {
+		return E(err, "session does not belong to the linking user", http.StatusForbidden)
+	}
+	if _, err := s.validateSession(r, session, userID); err != nil {
+		return E(err, "invalid session for linking", http.StatusForbidden)
+	}
+	if err := session.Save(r, w); err != nil {
+		return E(err, "error saving session", http.StatusInternalServerError)
+	}
+
+	err = s.store.LinkIdentity(userID, identity)
+	if err == petfind.ErrIdentityLinked {
+		log.Printf("user %d tried to link %s identity %s of another user", userID, identity.Provider, identity.Subject)
+		s.audit(r, petfind.AuditIdentity, userID, fmt.Sprintf("link rejected: %s account %s belongs to another user", identity.Provider, identity.Subject))
+		http.Redirect(w, r, "/me/accounts?m=taken", http.StatusFound)
+		return nil
+	}
+	if err != nil {
+		return E(err, "error linking identity", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditIdentity, userID, fmt.Sprintf("linked %s account %s", identity.Provider, identity.Subject))
+	http.Redirect(w, r, "/me/accounts?m=linked", http.StatusFound)
+	return nil
+}
+
+func (s *server) handleUnlinkIdentity(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		return E(err, "invalid identity id", http.StatusBadRequest)
+	}
+
+	err = s.store.UnlinkIdentity(user.ID, id)
+	switch err {
+	case nil:
+		s.audit(r, petfind.AuditIdentity, user.ID, fmt.Sprintf("unlinked identity %d", id))
+		http.Redirect(w, r, "/me/accounts?m=unlinked", http.StatusFound)
+		return nil
+	case petfind.ErrLastIdentity:
+		http.Redirect(w, r, "/me/accounts?m=last", http.StatusFound)
+		return nil
+	case petfind.ErrNotFound:
+		return E(nil, "Account does not exist", http.StatusNotFound)
+	}
+	return E(err, "error unlinking identity", http.StatusInternalServerError)
+}
@@ -0,0 +1,422 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// adminPageSize is how many rows the admin lists show per page.
+const adminPageSize = 50
+
+// pager holds the search query and page number of an admin list.
+type pager struct {
+	Query string
+	Page  int
+	// More is true if there is a page after this one.
+	More bool
+}
+
+// maxAdminPage keeps the offsets the database has to skip reasonable.
+const maxAdminPage = 1000
+
+// newPager reads the search query and the page number of a list from the
+// request. Anything invalid falls back to the first page.
+func newPager(r *http.Request) pager {
+	p := pager{Query: strings.TrimSpace(r.FormValue("q")), Page: 1}
+	if utf8.RuneCountInString(p.Query) > 100 {
+		p.Query = string([]rune(p.Query)[:100])
+	}
+	if n, err := strconv.Atoi(r.FormValue("p")); err == nil && n > 1 && n <= maxAdminPage {
+		p.Page = n
+	}
+	return p
+}
+
+// limit is how many rows to fetch. One more than a page is fetched to find
+// out whether there is a next page.
+func (p pager) limit() int  { return adminPageSize + 1 }
+func (p pager) offset() int { return (p.Page - 1) * adminPageSize }
+
+// Prev and Next are the numbers of the pages around this one.
+func (p pager) Prev() int { return p.Page - 1 }
+func (p pager) Next() int { return p.Page + 1 }
+
+// trim cuts a list fetched with limit down to a page and records whether
+// there are more rows.
+func (p *pager) trim(n int) int {
+	if n > adminPageSize {
+		p.More = true
+		return adminPageSize
+	}
+	return n
+}
+
+func (s *server) serveAdmin(w http.ResponseWriter, r *http.Request) *Error {
+	http.Redirect(w, r, "/admin/users", http.StatusFound)
+	return nil
+}
+
+// adminUsersPage is shown on /admin/users.
+type adminUsersPage struct {
+	pager
+	Users   []*petfind.User
+	Message string
+}
+
+var adminUserMessages = map[string]string{
+	"disabled": "The user was disabled and logged out everywhere.",
+	"enabled":  "The user was enabled.",
+}
+
+func (s *server) serveAdminUsers(w http.ResponseWriter, r *http.Request) *Error {
+	p := newPager(r)
+	users, err := s.store.SearchUsers(p.Query, p.limit(), p.offset())
+	if err != nil {
+		return E(err, "error searching users", http.StatusInternalServerError)
+	}
+	page := &adminUsersPage{Message: adminUserMessages[r.FormValue("m")]}
+	page.Users = users[:p.trim(len(users))]
+	page.pager = p
+	return s.render(w, r, s.templates.adminUsers, page, nil)
+}
+
+func (s *server) handleAdminDisableUser(w http.ResponseWriter, r *http.Request) *Error {
+	return s.setUserDisabled(w, r, true)
+}
+
+func (s *server) handleAdminEnableUser(w http.ResponseWriter, r *http.Request) *Error {
+	return s.setUserDisabled(w, r, false)
+}
+
+func (s *server) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	admin, ok := fromContextGetUser(r.Context())
+	if !ok || admin == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		return E(err, "invalid user id", http.StatusBadRequest)
+	}
+	// Administrators could lock everyone out by disabling themselves.
+	if id == admin.ID {
+		return E(nil, "You cannot disable your own account", http.StatusBadRequest)
+	}
+	err = s.store.SetUserDisabled(id, disabled)
+	if err == petfind.ErrNotFound {
+		return E(nil, "User does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error disabling user", http.StatusInternalServerError)
+	}
+	log.Printf("admin %d set disabled=%v on user %d", admin.ID, disabled, id)
+
+	m := "enabled"
+	if disabled {
+		m = "disabled"
+	}
+	s.audit(r, petfind.AuditAdmin, admin.ID, fmt.Sprintf("%s user %d", m, id))
+	http.Redirect(w, r, "/admin/users?m="+m, http.StatusFound)
+	return nil
+}
+
+// adminPetsPage is shown on /admin/pets.
+type adminPetsPage struct {
+	pager
+	Pets    []*petfind.Pet
+	Message string
+}
+
+var adminPetMessages = map[string]string{
+	"removed": "The pet was removed.",
+}
+
+func (s *server) serveAdminPets(w http.ResponseWriter, r *http.Request) *Error {
+	p := newPager(r)
+	pets, err := s.store.ListPets(p.Query, p.limit(), p.offset())
+	if err != nil {
+		return E(err, "error listing pets", http.StatusInternalServerError)
+	}
+	page := &adminPetsPage{Message: adminPetMessages[r.FormValue("m")]}
+	page.Pets = pets[:p.trim(len(pets))]
+	page.pager = p
+	return s.render(w, r, s.templates.adminPets, page, nil)
+}
+
+func (s *server) handleAdminRemovePet(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	admin, ok := fromContextGetUser(r.Context())
+	if !ok || admin == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		return E(err, "invalid pet id", http.StatusBadRequest)
+	}
+	pet, err := s.store.GetPet(id)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error getting pet", http.StatusInternalServerError)
+	}
+	s.petChanged(r, pet, petfind.PetRemoved)
+	err = s.store.ArchivePet(pet.ID)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error removing pet", http.StatusInternalServerError)
+	}
+	log.Printf("admin %d removed pet %d", admin.ID, id)
+	s.audit(r, petfind.AuditPetDelete, admin.ID, fmt.Sprintf("pet %d removed by admin", id))
+
+	http.Redirect(w, r, "/admin/pets?m=removed", http.StatusFound)
+	return nil
+}
+
+// adminPhotosPage is shown on /admin/photos.
+type adminPhotosPage struct {
+	pager
+	Photos []*petfind.Photo
+}
+
+func (s *server) serveAdminPhotos(w http.ResponseWriter, r *http.Request) *Error {
+	p := newPager(r)
+	photos, err := s.store.ListPhotos(p.limit(), p.offset())
+	if err != nil {
+		return E(err, "error listing photos", http.StatusInternalServerError)
+	}
+	page := &adminPhotosPage{}
+	page.Photos = photos[:p.trim(len(photos))]
+	page.pager = p
+	return s.render(w, r, s.templates.adminPhotos, page, nil)
+}
+
+// adminPlacesPage is shown on /admin/places.
+type adminPlacesPage struct {
+	Groups  []petfind.PlaceGroup
+	Message string
+	Err     string
+}
+
+var adminPlaceMessages = map[string]string{
+	"saved": "The places were saved. Pets can be listed in them right away.",
+}
+
+func (s *server) serveAdminPlaces(w http.ResponseWriter, r *http.Request) *Error {
+	return s.renderAdminPlaces(w, r, adminPlaceMessages[r.FormValue("m")], "")
+}
+
+func (s *server) renderAdminPlaces(w http.ResponseWriter, r *http.Request, message, errMsg string) *Error {
+	page := &adminPlacesPage{Groups: s.getPlaceGroups(), Message: message, Err: errMsg}
+	return s.render(w, r, s.templates.adminPlaces, page, nil)
+}
+
+// placesChanged records what changed in the audit log, reloads the places
+// everyone else sees and returns to the places page.
+func (s *server) placesChanged(w http.ResponseWriter, r *http.Request, change string) *Error {
+	if admin, ok := fromContextGetUser(r.Context()); ok && admin != nil {
+		s.audit(r, petfind.AuditAdmin, admin.ID, change)
+	}
+	if err := s.reloadPlaceGroups(); err != nil {
+		return E(err, "error reloading places", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/admin/places?m=saved", http.StatusFound)
+	return nil
+}
+
+func validPlaceName(name string) (bool, invalidReason) {
+	if name == "" {
+		return false, "Name cannot be empty."
+	}
+	if utf8.RuneCountInString(name) > 70 {
+		return false, "Name cannot be longer than 70 characters."
+	}
+	return true, ""
+}
+
+var placeKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+
+func validPlaceKey(key string) (bool, invalidReason) {
+	if key == "" {
+		return false, "Key cannot be empty."
+	}
+	if len(key) > 70 {
+		return false, "Key cannot be longer than 70 characters."
+	}
+	if !placeKeyRegex.MatchString(key) {
+		return false, "Key can only contain letters, digits and dashes."
+	}
+	return true, ""
+}
+
+func (s *server) placeGroupExists(groupID int64) bool {
+	for _, g := range s.getPlaceGroups() {
+		if g.ID == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+// postFormID returns the ID posted in the form field with name.
+func postFormID(r *http.Request, name string) (int64, *Error) {
+	id, err := strconv.ParseInt(r.PostFormValue(name), 10, 64)
+	if err != nil {
+		return 0, E(err, "invalid "+name, http.StatusBadRequest)
+	}
+	return id, nil
+}
+
+func (s *server) handleAdminAddPlaceGroup(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if valid, reason := validPlaceName(name); !valid {
+		return s.renderAdminPlaces(w, r, "", reason.String())
+	}
+	g := &petfind.PlaceGroup{Name: name}
+	if err := s.store.AddPlaceGroup(g); err != nil {
+		return E(err, "error adding place group", http.StatusInternalServerError)
+	}
+	return s.placesChanged(w, r, fmt.Sprintf("added place group %d %q", g.ID, name))
+}
+
+func (s *server) handleAdminRenamePlaceGroup(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if valid, reason := validPlaceName(name); !valid {
+		return s.renderAdminPlaces(w, r, "", reason.String())
+	}
+	err := s.store.UpdatePlaceGroup(&petfind.PlaceGroup{ID: id, Name: name})
+	if err == petfind.ErrNotFound {
+		return E(nil, "Place group does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error renaming place group", http.StatusInternalServerError)
+	}
+	return s.placesChanged(w, r, fmt.Sprintf("renamed place group %d to %q", id, name))
+}
+
+func (s *server) handleAdminRemovePlaceGroup(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	err := s.store.DeletePlaceGroup(id)
+	if err == petfind.ErrInUse {
+		return s.renderAdminPlaces(w, r, "", "Remove the places of the group first.")
+	}
+	if err == petfind.ErrNotFound {
+		return E(nil, "Place group does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error removing place group", http.StatusInternalServerError)
+	}
+	return s.placesChanged(w, r, fmt.Sprintf("removed place group %d", id))
+}
+
+func (s *server) handleAdminAddPlace(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	groupID, e := postFormID(r, "group")
+	if e != nil {
+		return e
+	}
+	if !s.placeGroupExists(groupID) {
+		return E(nil, "Place group does not exist", http.StatusNotFound)
+	}
+	key := strings.TrimSpace(r.PostFormValue("key"))
+	if valid, reason := validPlaceKey(key); !valid {
+		return s.renderAdminPlaces(w, r, "", reason.String())
+	}
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if valid, reason := validPlaceName(name); !valid {
+		return s.renderAdminPlaces(w, r, "", reason.String())
+	}
+	// The key identifies the place in search links so it must be unique.
+	_, err := s.store.GetPlaceByKey(key)
+	if err == nil {
+		return s.renderAdminPlaces(w, r, "", "Another place already has that key.")
+	}
+	if err != petfind.ErrNotFound {
+		return E(err, "error getting place", http.StatusInternalServerError)
+	}
+	place := &petfind.Place{GroupID: groupID, Key: key, Name: name}
+	if err := s.store.AddPlace(place); err != nil {
+		return E(err, "error adding place", http.StatusInternalServerError)
+	}
+	return s.placesChanged(w, r, fmt.Sprintf("added place %d %q (%s) to group %d", place.ID, name, key, groupID))
+}
+
+func (s *server) handleAdminRenamePlace(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if valid, reason := validPlaceName(name); !valid {
+		return s.renderAdminPlaces(w, r, "", reason.String())
+	}
+	place, err := s.store.GetPlace(id)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Place does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error getting place", http.StatusInternalServerError)
+	}
+	place.Name = name
+	if err := s.store.UpdatePlace(place); err != nil {
+		return E(err, "error renaming place", http.StatusInternalServerError)
+	}
+	return s.placesChanged(w, r, fmt.Sprintf("renamed place %d to %q", id, name))
+}
+
+func (s *server) handleAdminRemovePlace(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	err := s.store.DeletePlace(id)
+	if err == petfind.ErrInUse {
+		return s.renderAdminPlaces(w, r, "", "Pets are still listed in that place.")
+	}
+	if err == petfind.ErrNotFound {
+		return E(nil, "Place does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error removing place", http.StatusInternalServerError)
+	}
+	return s.placesChanged(w, r, fmt.Sprintf("removed place %d", id))
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewPager(t *testing.T) {
+	tests := []struct {
+		url        string
+		wantQuery  string
+		wantPage   int
+		wantOffset int
+	}{
+		{"/admin/users", "", 1, 0},
+		{"/admin/users?p=3&q=+jane+", "jane", 3, 2 * adminPageSize},
+		{"/admin/users?p=0", "", 1, 0},
+		{"/admin/users?p=-2", "", 1, 0},
+		{"/admin/users?p=abc", "", 1, 0},
+		{"/admin/users?p=1000000", "", 1, 0},
+		{"/admin/users?q=" + strings.Repeat("ά", 150), strings.Repeat("ά", 100), 1, 0},
+	}
+	for _, tt := range tests {
+		p := newPager(httptest.NewRequest("GET", tt.url, nil))
+		if p.Query != tt.wantQuery || p.Page != tt.wantPage || p.offset() != tt.wantOffset {
+			t.Errorf("newPager(%q) = %q page %d offset %d, want %q page %d offset %d",
+				tt.url, p.Query, p.Page, p.offset(), tt.wantQuery, tt.wantPage, tt.wantOffset)
+		}
+	}
+}
+
+func TestPagerTrim(t *testing.T) {
+	var p pager
+	if n := p.trim(adminPageSize); n != adminPageSize || p.More {
+		t.Errorf("trim of a full page = %d, More %v; want %d, false", n, p.More, adminPageSize)
+	}
+	if n := p.trim(p.limit()); n != adminPageSize || !p.More {
+		t.Errorf("trim of more than a page = %d, More %v; want %d, true", n, p.More, adminPageSize)
+	}
+}
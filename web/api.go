@@ -0,0 +1,580 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// apiPrefix is where version 1 of the JSON API is served. Changes that break
+// clients, such as the mobile app, need a new version.
+const apiPrefix = "/api/v1"
+
+// apiRoute is an endpoint of the JSON API. The routes are both served and
+// described in the OpenAPI document from the same table so that the two
+// cannot drift apart.
+type apiRoute struct {
+	// Path is relative to apiPrefix and may end in a {parameter}.
+	Path        string
+	Method      string
+	OperationID string
+	Summary     string
+	// Auth routes need a logged in user.
+	Auth bool
+	// Scope is the scope an API token needs to use the route. Routes that
+	// change anything need ScopeWrite.
+	Scope  petfind.APIScope
+	Params []apiParam
+	// Response is a value of the type the route responds with.
+	Response interface{}
+	Handle   handler
+}
+
+// pattern is the ServeMux pattern of the route. Paths that end in a parameter
+// are served as subtrees.
+func (rt apiRoute) pattern() string {
+	if i := strings.Index(rt.Path, "{"); i >= 0 {
+		return apiPrefix + rt.Path[:i]
+	}
+	return apiPrefix + rt.Path
+}
+
+// apiParam is a parameter of an API route.
+type apiParam struct {
+	Name        string
+	In          string // "query" or "path"
+	Type        string // "integer" or "string"
+	Description string
+	Required    bool
+	Enum        []int64
+}
+
+var pageParams = []apiParam{
+	{Name: "page", In: "query", Type: "integer", Description: fmt.Sprintf("Page of results, from 1 to %d.", maxAPIPage)},
+	{Name: "per_page", In: "query", Type: "integer", Description: fmt.Sprintf("Results per page, from 1 to %d. Defaults to %d.", maxAPIPerPage, defaultAPIPerPage)},
+}
+
+// searchParams are the same filters as those of /search/submit.
+var searchParams = []apiParam{
+	{Name: "place", In: "query", Type: "string", Required: true, Description: "Key of the place the pets are in, as listed by /places."},
+	{Name: "type", In: "query", Type: "integer", Description: enumDescription(int64(petfind.Cat), int64(petfind.Dog), func(i int64) string { return petfind.PetType(i).String() }), Enum: enumValues(int64(petfind.Cat), int64(petfind.Dog))},
+	{Name: "age", In: "query", Type: "integer", Description: enumDescription(int64(petfind.Baby), int64(petfind.Senior), func(i int64) string { return petfind.PetAge(i).String() }), Enum: enumValues(int64(petfind.Baby), int64(petfind.Senior))},
+	{Name: "size", In: "query", Type: "integer", Description: enumDescription(int64(petfind.Small), int64(petfind.Huge), func(i int64) string { return petfind.PetSize(i).String() }), Enum: enumValues(int64(petfind.Small), int64(petfind.Huge))},
+	{Name: "gender", In: "query", Type: "integer", Description: enumDescription(int64(petfind.Male), int64(petfind.Female), func(i int64) string { return petfind.PetGender(i).String() }), Enum: enumValues(int64(petfind.Male), int64(petfind.Female))},
+}
+
+func enumValues(from, to int64) []int64 {
+	var values []int64
+	for i := from; i <= to; i++ {
+		values = append(values, i)
+	}
+	return values
+}
+
+func enumDescription(from, to int64, name func(int64) string) string {
+	var names []string
+	for i := from; i <= to; i++ {
+		names = append(names, fmt.Sprintf("%d %s", i, name(i)))
+	}
+	return strings.Join(names, ", ") + "."
+}
+
+// apiRoutes are the routes of the JSON API.
+func (s *server) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{
+			Path:        "/pets",
+			Method:      "GET",
+			OperationID: "listPets",
+			Summary:     "Lists the pets that are up for adoption, the newest first.",
+			Params:      pageParams,
+			Response:    apiPetsResponse{},
+			Handle:      s.apiListPets,
+		},
+		{
+			Path:        "/pets/search",
+			Method:      "GET",
+			OperationID: "searchPets",
+			Summary:     "Searches the pets of a place, the newest first.",
+			Params:      append(append([]apiParam{}, searchParams...), pageParams...),
+			Response:    apiPetsResponse{},
+			Handle:      s.apiSearchPets,
+		},
+		{
+			Path:        "/pets/{id}",
+			Method:      "GET",
+			OperationID: "getPet",
+			Summary:     "Gets a pet that is up for adoption.",
+			Params:      []apiParam{{Name: "id", In: "path", Type: "integer", Required: true, Description: "ID of the pet."}},
+			Response:    apiPetResponse{},
+			Handle:      s.apiGetPet,
+		},
+		{
+			Path:        "/places",
+			Method:      "GET",
+			OperationID: "listPlaces",
+			Summary:     "Lists the places pets can be listed in by group.",
+			Response:    apiPlaceGroupsResponse{},
+			Handle:      s.apiListPlaces,
+		},
+		{
+			Path:        "/me",
+			Method:      "GET",
+			OperationID: "getCurrentUser",
+			Summary:     "Gets the logged in user.",
+			Auth:        true,
+			Response:    apiUserResponse{},
+			Handle:      s.apiGetCurrentUser,
+		},
+		{
+			Path:        "/me/favorites",
+			Method:      "GET",
+			OperationID: "listFavorites",
+			Summary:     "Lists the pets the logged in user saved as favorites.",
+			Auth:        true,
+			Response:    apiFavoritesResponse{},
+			Handle:      s.apiListFavorites,
+		},
+		{
+			Path:        "/me/favorites/{id}",
+			Method:      "PUT",
+			OperationID: "addFavorite",
+			Summary:     "Saves a pet to the favorites of the logged in user and lists them.",
+			Auth:        true,
+			Scope:       petfind.ScopeWrite,
+			Params:      []apiParam{{Name: "id", In: "path", Type: "integer", Required: true, Description: "ID of the pet."}},
+			Response:    apiFavoritesResponse{},
+			Handle:      s.apiAddFavorite,
+		},
+		{
+			Path:        "/me/favorites/{id}",
+			Method:      "DELETE",
+			OperationID: "removeFavorite",
+			Summary:     "Removes a pet from the favorites of the logged in user and lists the rest.",
+			Auth:        true,
+			Scope:       petfind.ScopeWrite,
+			Params:      []apiParam{{Name: "id", In: "path", Type: "integer", Required: true, Description: "ID of the pet."}},
+			Response:    apiFavoritesResponse{},
+			Handle:      s.apiRemoveFavorite,
+		},
+	}
+}
+
+// handleAPI registers the routes of the JSON API and its OpenAPI document.
+func (s *server) handleAPI() {
+	routes := s.apiRoutes()
+	// Routes that only differ in their method share a pattern.
+	var patterns []string
+	byPattern := make(map[string][]apiRoute)
+	for _, rt := range routes {
+		p := rt.pattern()
+		if _, ok := byPattern[p]; !ok {
+			patterns = append(patterns, p)
+		}
+		byPattern[p] = append(byPattern[p], rt)
+	}
+	for _, p := range patterns {
+		s.mux.Handle(p, s.api(byPattern[p]...))
+	}
+	doc, err := json.MarshalIndent(openAPIDocument(routes), "", "  ")
+	if err != nil {
+		// The document only depends on the route table so this is a bug.
+		panic(fmt.Sprintf("error encoding OpenAPI document: %v", err))
+	}
+	s.mux.Handle(apiPrefix+"/openapi.json", apiHandler(func(w http.ResponseWriter, r *http.Request) *Error {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+		return nil
+	}))
+	// Unknown API paths get an error envelope instead of the home page.
+	s.mux.Handle(apiPrefix+"/", apiHandler(func(w http.ResponseWriter, r *http.Request) *Error {
+		return E(nil, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	}))
+}
+
+// api serves the routes of the JSON API that share a pattern to guests and
+// logged in users alike. Users are logged in either by their session or by a
+// personal API token in the Authorization header. Requests with a token never
+// fall back to the session as they are not checked for CSRF.
+func (s *server) api(routes ...apiRoute) apiHandler {
+	return apiHandler(func(w http.ResponseWriter, r *http.Request) *Error {
+		for _, rt := range routes {
+			if r.Method != rt.Method {
+				continue
+			}
+			if _, ok := bearerToken(r); ok {
+				return s.tokenAuth(rt.Scope, rt.Handle)(w, r)
+			}
+			return s.guest(rt.Handle)(w, r)
+		}
+		methods := make([]string, 0, len(routes))
+		for _, rt := range routes {
+			methods = append(methods, rt.Method)
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	})
+}
+
+// apiHandler is a handler of the JSON API. Unlike handler, it responds with
+// errors in a JSON envelope:
+//
+//	{"error": {"code": 404, "status": "Not Found", "message": "Pet does not exist"}}
+type apiHandler handler
+
+func (fn apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e := fn(w, r); e != nil {
+		log.Println(e)
+		writeAPIError(w, e)
+	}
+}
+
+// apiError is the body of the error responses of the JSON API, derived from
+// an *Error. Fields is only set for invalid parameters.
+type apiError struct {
+	Code    int               `json:"code"`
+	Status  string            `json:"status"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// fieldErrors can be the Err of an *Error returned by API handlers to tell
+// the client which parameters are invalid and why.
+type fieldErrors map[string]string
+
+func (fe fieldErrors) Error() string {
+	var fields []string
+	for name, reason := range fe {
+		fields = append(fields, name+": "+reason)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, "; ")
+}
+
+func writeAPIError(w http.ResponseWriter, e *Error) {
+	body := apiErrorResponse{apiError{Code: e.Code, Status: http.StatusText(e.Code), Message: e.Message}}
+	if fe, ok := e.Err.(fieldErrors); ok {
+		body.Error.Fields = fe
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(e.Code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("error encoding API error:", err)
+	}
+}
+
+const (
+	defaultAPIPerPage = 20
+	maxAPIPerPage     = 100
+	// maxAPIPage keeps the offsets the database has to skip reasonable.
+	maxAPIPage = 1000
+)
+
+// apiPagination tells API clients where they are in a list. Next is the URL
+// of the next page, if any.
+type apiPagination struct {
+	Page    int    `json:"page"`
+	PerPage int    `json:"per_page"`
+	HasMore bool   `json:"has_more"`
+	Next    string `json:"next,omitempty"`
+}
+
+// parseAPIPage reads the page parameters of a request. Unlike newPager,
+// invalid values are reported to the client.
+func parseAPIPage(r *http.Request) (*apiPagination, *Error) {
+	p := &apiPagination{Page: 1, PerPage: defaultAPIPerPage}
+	fe := make(fieldErrors)
+	if v := r.FormValue("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAPIPage {
+			fe["page"] = fmt.Sprintf("Page must be a number from 1 to %d.", maxAPIPage)
+		}
+		p.Page = n
+	}
+	if v := r.FormValue("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAPIPerPage {
+			fe["per_page"] = fmt.Sprintf("Results per page must be a number from 1 to %d.", maxAPIPerPage)
+		}
+		p.PerPage = n
+	}
+	if len(fe) != 0 {
+		return nil, E(fe, "Invalid page", http.StatusBadRequest)
+	}
+	return p, nil
+}
+
+// limit is how many rows to fetch. One more than a page is fetched to find
+// out whether there is a next page.
+func (p *apiPagination) limit() int  { return p.PerPage + 1 }
+func (p *apiPagination) offset() int { return (p.Page - 1) * p.PerPage }
+
+// trim cuts a list fetched with limit down to a page and links to the next
+// page if there are more rows.
+func (p *apiPagination) trim(r *http.Request, n int) int {
+	if n <= p.PerPage {
+		return n
+	}
+	p.HasMore = true
+	q := r.URL.Query()
+	q.Set("page", strconv.Itoa(p.Page+1))
+	q.Set("per_page", strconv.Itoa(p.PerPage))
+	p.Next = r.URL.Path + "?" + q.Encode()
+	return p.PerPage
+}
+
+// apiPet is a pet as shown by the API. The contact details of the owner are
+// left out as they are only revealed on the site.
+type apiPet struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	Age      string    `json:"age"`
+	Type     string    `json:"type"`
+	Size     string    `json:"size"`
+	Gender   string    `json:"gender"`
+	Notes    string    `json:"notes"`
+	Place    apiPlace  `json:"place"`
+	PhotoURL string    `json:"photo_url"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+type apiPlace struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+type apiPlaceGroup struct {
+	ID     int64      `json:"id"`
+	Name   string     `json:"name"`
+	Places []apiPlace `json:"places"`
+}
+
+type apiUser struct {
+	ID            int64     `json:"id"`
+	Login         string    `json:"login"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`
+	Created       time.Time `json:"created"`
+}
+
+type apiPetsResponse struct {
+	Data       []apiPet      `json:"data"`
+	Pagination apiPagination `json:"pagination"`
+}
+
+type apiPetResponse struct {
+	Data apiPet `json:"data"`
+}
+
+type apiPlaceGroupsResponse struct {
+	Data []apiPlaceGroup `json:"data"`
+}
+
+type apiUserResponse struct {
+	Data apiUser `json:"data"`
+}
+
+type apiFavoritesResponse struct {
+	Data []apiPet `json:"data"`
+}
+
+func (s *server) newAPIPet(r *http.Request, p *petfind.Pet) apiPet {
+	pet := apiPet{
+		ID:       p.ID,
+		Name:     p.Name,
+		Age:      p.Age.String(),
+		Type:     p.Type.String(),
+		Size:     p.Size.String(),
+		Gender:   p.Gender.String(),
+		Notes:    p.Notes,
+		PhotoURL: s.baseURL(r) + "/photos/" + strconv.FormatInt(p.PhotoID, 10),
+		Created:  p.Created,
+		Updated:  p.Updated,
+	}
+	if p.Place != nil {
+		pet.Place = apiPlace{Key: p.Place.Key, Name: p.Place.Name}
+	}
+	return pet
+}
+
+// servePetsPage responds with a page of the pets that match search.
+func (s *server) servePetsPage(w http.ResponseWriter, r *http.Request, search petfind.Search) *Error {
+	p, e := parseAPIPage(r)
+	if e != nil {
+		return e
+	}
+	pets, err := s.store.SearchPetsPage(search, p.limit(), p.offset())
+	if err != nil {
+		return E(err, "error getting pets", http.StatusInternalServerError)
+	}
+	pets = pets[:p.trim(r, len(pets))]
+	resp := apiPetsResponse{Data: make([]apiPet, 0, len(pets)), Pagination: *p}
+	for _, pet := range pets {
+		resp.Data = append(resp.Data, s.newAPIPet(r, pet))
+	}
+	return writeJSON(w, resp)
+}
+
+func (s *server) apiListPets(w http.ResponseWriter, r *http.Request) *Error {
+	return s.servePetsPage(w, r, petfind.Search{})
+}
+
+func (s *server) apiSearchPets(w http.ResponseWriter, r *http.Request) *Error {
+	search, form := s.parseSearch(r)
+	if form.Invalid {
+		fe := make(fieldErrors)
+		for name, reason := range map[string]string{
+			"place":  form.PlaceErr,
+			"type":   form.TypeErr,
+			"age":    form.AgeErr,
+			"size":   form.SizeErr,
+			"gender": form.GenderErr,
+		} {
+			if reason != "" {
+				fe[name] = reason
+			}
+		}
+		return E(fe, "Invalid search", http.StatusBadRequest)
+	}
+	return s.servePetsPage(w, r, search)
+}
+
+func apiPetID(s string) (int64, *Error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, E(fieldErrors{"id": "Pet ID must be a number."}, "Invalid pet ID", http.StatusBadRequest)
+	}
+	return id, nil
+}
+
+func (s *server) apiGetPet(w http.ResponseWriter, r *http.Request) *Error {
+	idStr := strings.TrimPrefix(r.URL.Path, apiPrefix+"/pets/")
+	id, e := apiPetID(idStr)
+	if e != nil {
+		return e
+	}
+	// Only listed pets are shown so that the IDs of hidden ones cannot be
+	// probed.
+	pet, err := s.store.GetPet(id)
+	if err == petfind.ErrNotFound || (err == nil && pet.Status != petfind.PetListed) {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error getting pet", http.StatusInternalServerError)
+	}
+	return writeJSON(w, apiPetResponse{s.newAPIPet(r, pet)})
+}
+
+func (s *server) apiListPlaces(w http.ResponseWriter, r *http.Request) *Error {
+	groups := s.getPlaceGroups()
+	resp := apiPlaceGroupsResponse{Data: make([]apiPlaceGroup, 0, len(groups))}
+	for _, g := range groups {
+		group := apiPlaceGroup{ID: g.ID, Name: g.Name, Places: make([]apiPlace, 0, len(g.Places))}
+		for _, p := range g.Places {
+			group.Places = append(group.Places, apiPlace{Key: p.Key, Name: p.Name})
+		}
+		resp.Data = append(resp.Data, group)
+	}
+	return writeJSON(w, resp)
+}
+
+func (s *server) apiGetCurrentUser(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	return writeJSON(w, apiUserResponse{apiUser{
+		ID:            user.ID,
+		Login:         user.Login,
+		Name:          user.Name,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role.String(),
+		Created:       user.Created,
+	}})
+}
+
+// serveAPIFavorites responds with the favorite pets of the user that are still
+// listed.
+func (s *server) serveAPIFavorites(w http.ResponseWriter, r *http.Request, user *petfind.User) *Error {
+	pets, err := s.store.GetFavoritePets(user.ID)
+	if err != nil {
+		return E(err, "error getting favorite pets", http.StatusInternalServerError)
+	}
+	resp := apiFavoritesResponse{Data: make([]apiPet, 0, len(pets))}
+	for _, pet := range pets {
+		if pet.Status == petfind.PetListed {
+			resp.Data = append(resp.Data, s.newAPIPet(r, pet))
+		}
+	}
+	return writeJSON(w, resp)
+}
+
+func (s *server) apiListFavorites(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	return s.serveAPIFavorites(w, r, user)
+}
+
+func (s *server) apiAddFavorite(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, e := apiPetID(strings.TrimPrefix(r.URL.Path, apiPrefix+"/me/favorites/"))
+	if e != nil {
+		return e
+	}
+	// As on the site, only listed pets can be saved.
+	pet, err := s.store.GetPet(id)
+	if err == petfind.ErrNotFound || (err == nil && pet.Status != petfind.PetListed) {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error getting pet", http.StatusInternalServerError)
+	}
+	err = s.store.AddFavorite(user.ID, pet.ID)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error adding favorite", http.StatusInternalServerError)
+	}
+	return s.serveAPIFavorites(w, r, user)
+}
+
+func (s *server) apiRemoveFavorite(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, e := apiPetID(strings.TrimPrefix(r.URL.Path, apiPrefix+"/me/favorites/"))
+	if e != nil {
+		return e
+	}
+	err := s.store.RemoveFavorite(user.ID, id)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Pet is not one of your favorites", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error removing favorite", http.StatusInternalServerError)
+	}
+	return s.serveAPIFavorites(w, r, user)
+}
@@ -0,0 +1,221 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+type apiStore struct {
+	petfind.Store
+	pets   []*petfind.Pet
+	search petfind.Search
+}
+
+func (s *apiStore) SearchPetsPage(search petfind.Search, limit, offset int) ([]*petfind.Pet, error) {
+	s.search = search
+	if offset > len(s.pets) {
+		return nil, nil
+	}
+	pets := s.pets[offset:]
+	if len(pets) > limit {
+		pets = pets[:limit]
+	}
+	return pets, nil
+}
+
+func (s *apiStore) GetPet(petID int64) (*petfind.Pet, error) {
+	for _, p := range s.pets {
+		if p.ID == petID {
+			return p, nil
+		}
+	}
+	return nil, petfind.ErrNotFound
+}
+
+func newAPITestServer() *server {
+	place := petfind.Place{ID: 1, GroupID: 1, Key: "chalkida", Name: "Χαλκίδα"}
+	return &server{
+		siteURL:     "https://petfind.example.com",
+		placeGroups: []petfind.PlaceGroup{{ID: 1, Name: "Εύβοια", Places: []petfind.Place{place}}},
+		store: &apiStore{pets: []*petfind.Pet{
+			{ID: 3, Name: "zazzles", Type: petfind.Cat, PhotoID: 5, Place: &place},
+			{ID: 2, Name: "rex", Type: petfind.Dog, Place: &place},
+			{ID: 1, Name: "blinky", Type: petfind.Cat, Place: &place, Status: petfind.PetHidden},
+		}},
+	}
+}
+
+// serveAPI serves a request with an API handler and decodes the response into
+// v.
+func serveAPI(t *testing.T, h http.Handler, r *http.Request, v interface{}) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s Content-Type = %q, expected application/json", r.Method, r.URL, ct)
+	}
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: error decoding response: %v", r.Method, r.URL, err)
+	}
+	return w.Code
+}
+
+func TestAPIListPets(t *testing.T) {
+	s := newAPITestServer()
+	var resp apiPetsResponse
+	code := serveAPI(t, apiHandler(s.apiListPets), httptest.NewRequest("GET", "/api/v1/pets?per_page=2", nil), &resp)
+	if code != http.StatusOK || len(resp.Data) != 2 {
+		t.Fatalf("list pets = %d with %d pets, expected 200 with 2", code, len(resp.Data))
+	}
+	if p := resp.Data[0]; p.Name != "zazzles" || p.Type != "Cat" || p.Place.Key != "chalkida" || p.PhotoURL != "https://petfind.example.com/photos/5" {
+		t.Errorf("first pet = %#v", p)
+	}
+	want := apiPagination{Page: 1, PerPage: 2, HasMore: true, Next: "/api/v1/pets?page=2&per_page=2"}
+	if resp.Pagination != want {
+		t.Errorf("pagination = %#v, expected %#v", resp.Pagination, want)
+	}
+
+	resp = apiPetsResponse{}
+	serveAPI(t, apiHandler(s.apiListPets), httptest.NewRequest("GET", "/api/v1/pets?page=2&per_page=2", nil), &resp)
+	if len(resp.Data) != 1 || resp.Pagination.HasMore || resp.Pagination.Next != "" {
+		t.Errorf("last page = %#v", resp)
+	}
+
+	for _, query := range []string{"page=0", "page=1001", "per_page=101", "per_page=x"} {
+		var e apiErrorResponse
+		code := serveAPI(t, apiHandler(s.apiListPets), httptest.NewRequest("GET", "/api/v1/pets?"+query, nil), &e)
+		if code != http.StatusBadRequest || e.Error.Code != code || len(e.Error.Fields) != 1 {
+			t.Errorf("list pets with %s = %d %#v, expected 400 with a field error", query, code, e)
+		}
+	}
+}
+
+func TestAPISearchPets(t *testing.T) {
+	s := newAPITestServer()
+	var resp apiPetsResponse
+	code := serveAPI(t, apiHandler(s.apiSearchPets), httptest.NewRequest("GET", "/api/v1/pets/search?place=chalkida&type=1&gender=2", nil), &resp)
+	if code != http.StatusOK {
+		t.Fatalf("search = %d, expected 200", code)
+	}
+	want := petfind.Search{PlaceKey: "chalkida", Type: petfind.Cat, UseType: true, Gender: petfind.Female, UseGender: true}
+	if got := s.store.(*apiStore).search; got != want {
+		t.Errorf("searched for %#v, expected %#v", got, want)
+	}
+
+	var e apiErrorResponse
+	code = serveAPI(t, apiHandler(s.apiSearchPets), httptest.NewRequest("GET", "/api/v1/pets/search?place=nowhere&type=7", nil), &e)
+	if code != http.StatusBadRequest || e.Error.Message != "Invalid search" {
+		t.Fatalf("invalid search = %d %#v, expected 400", code, e)
+	}
+	wantFields := map[string]string{"place": "Unrecognized location.", "type": "Invalid value for pet's type."}
+	if !reflect.DeepEqual(e.Error.Fields, wantFields) {
+		t.Errorf("invalid search fields = %v, expected %v", e.Error.Fields, wantFields)
+	}
+}
+
+func TestAPIGetPet(t *testing.T) {
+	s := newAPITestServer()
+	var resp apiPetResponse
+	code := serveAPI(t, apiHandler(s.apiGetPet), httptest.NewRequest("GET", "/api/v1/pets/3", nil), &resp)
+	if code != http.StatusOK || resp.Data.ID != 3 || resp.Data.Name != "zazzles" {
+		t.Fatalf("get pet = %d %#v", code, resp)
+	}
+	// Hidden pets do not exist as far as the API is concerned.
+	for path, want := range map[string]int{
+		"/api/v1/pets/1":   http.StatusNotFound,
+		"/api/v1/pets/404": http.StatusNotFound,
+		"/api/v1/pets/x":   http.StatusBadRequest,
+	} {
+		var e apiErrorResponse
+		code := serveAPI(t, apiHandler(s.apiGetPet), httptest.NewRequest("GET", path, nil), &e)
+		if code != want || e.Error.Code != want || e.Error.Status != http.StatusText(want) {
+			t.Errorf("GET %s = %d %#v, expected %d", path, code, e, want)
+		}
+	}
+}
+
+func TestAPIListPlaces(t *testing.T) {
+	s := newAPITestServer()
+	var resp apiPlaceGroupsResponse
+	serveAPI(t, apiHandler(s.apiListPlaces), httptest.NewRequest("GET", "/api/v1/places", nil), &resp)
+	want := []apiPlaceGroup{{ID: 1, Name: "Εύβοια", Places: []apiPlace{{Key: "chalkida", Name: "Χαλκίδα"}}}}
+	if !reflect.DeepEqual(resp.Data, want) {
+		t.Errorf("places = %#v, expected %#v", resp.Data, want)
+	}
+}
+
+func TestAPIGetCurrentUser(t *testing.T) {
+	s := newAPITestServer()
+	r := httptest.NewRequest("GET", "/api/v1/me", nil)
+	var e apiErrorResponse
+	if code := serveAPI(t, apiHandler(s.apiGetCurrentUser), r, &e); code != http.StatusUnauthorized || e.Error.Code != code {
+		t.Errorf("me as guest = %d %#v, expected 401", code, e)
+	}
+
+	user := &petfind.User{ID: 7, Login: "janedoe", Name: "Jane Doe", Role: petfind.RoleModerator}
+	r = r.WithContext(newContextWithUser(r.Context(), user))
+	var resp apiUserResponse
+	if code := serveAPI(t, apiHandler(s.apiGetCurrentUser), r, &resp); code != http.StatusOK || resp.Data.ID != 7 || resp.Data.Role != "moderator" {
+		t.Errorf("me = %d %#v", code, resp)
+	}
+}
+
+func TestAPIMethodNotAllowed(t *testing.T) {
+	s := newAPITestServer()
+	for _, rt := range s.apiRoutes() {
+		var e apiErrorResponse
+		code := serveAPI(t, s.api(rt), httptest.NewRequest("PATCH", rt.pattern(), nil), &e)
+		if code != http.StatusMethodNotAllowed || e.Error.Code != code {
+			t.Errorf("PATCH %s = %d %#v, expected 405", rt.Path, code, e)
+		}
+	}
+}
+
+var regexpRefs = regexp.MustCompile(`"#/components/schemas/([A-Za-z]+)"`)
+
+func TestOpenAPIDocument(t *testing.T) {
+	s := newAPITestServer()
+	routes := s.apiRoutes()
+	b, err := json.Marshal(openAPIDocument(routes))
+	if err != nil {
+		t.Fatalf("error encoding OpenAPI document: %v", err)
+	}
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage
+				Required   []string
+			}
+		}
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("error decoding OpenAPI document: %v", err)
+	}
+	for _, rt := range routes {
+		if _, ok := doc.Paths[rt.Path][strings.ToLower(rt.Method)]; !ok {
+			t.Errorf("OpenAPI document does not describe %s %s", rt.Method, rt.Path)
+		}
+	}
+	for _, ref := range regexpRefs.FindAllStringSubmatch(string(b), -1) {
+		if _, ok := doc.Components.Schemas[ref[1]]; !ok {
+			t.Errorf("OpenAPI document refers to missing schema %q", ref[1])
+		}
+	}
+	pet := doc.Components.Schemas["Pet"]
+	if _, ok := pet.Properties["photo_url"]; !ok || len(pet.Required) != len(pet.Properties) {
+		t.Errorf("Pet schema = %#v", pet)
+	}
+	// Omitted fields are optional.
+	for _, name := range doc.Components.Schemas["Pagination"].Required {
+		if name == "next" {
+			t.Errorf("Pagination schema requires next")
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package web
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/securecookie"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+const (
+	// apiTokenPrefix starts every personal API token so that leaked tokens
+	// are easy to recognize, e.g. by secret scanners.
+	apiTokenPrefix = "pfat_"
+	// apiTokenShownPrefix is how much of a token is stored in the clear and
+	// shown in the settings to tell tokens apart.
+	apiTokenShownPrefix = len(apiTokenPrefix) + 6
+	// maxAPITokens is how many tokens, expired or not, a user can have.
+	maxAPITokens = 20
+	// apiTokenRateLimit is how many requests can be made with a token in
+	// apiTokenRateWindow.
+	apiTokenRateLimit  = 1000
+	apiTokenRateWindow = time.Hour
+)
+
+// apiTokenLifetimes are the days a new token can be valid for. Tokens always
+// expire so that forgotten ones do not stay around forever.
+var apiTokenLifetimes = []int{7, 30, 90, 365}
+
+// defaultAPITokenLifetime is preselected when creating a token.
+const defaultAPITokenLifetime = 30
+
+// bearerToken returns the token of an "Authorization: Bearer" header. ok is
+// false if the request has no such header.
+func bearerToken(r *http.Request) (token string, ok bool) {
+	auth := r.Header.Get("Authorization")
+	const scheme = "bearer "
+	if len(auth) < len(scheme) || !strings.EqualFold(auth[:len(scheme)], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(scheme):]), true
+}
+
+// tokenAuth logs in the user of the personal API token in the Authorization
+// header before calling fn. Requests are rejected if the token does not allow
+// scope or its rate limit has been reached.
+func (s *server) tokenAuth(scope petfind.APIScope, fn handler) handler {
+	return func(w http.ResponseWriter, r *http.Request) *Error {
+		raw, _ := bearerToken(r)
+		unauthorized := func(err error, message string) *Error {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="petfind", error="invalid_token"`)
+			return E(err, message, http.StatusUnauthorized)
+		}
+		// Anything that does not look like one of our tokens is rejected
+		// without a database lookup.
+		if !strings.HasPrefix(raw, apiTokenPrefix) || len(raw) <= apiTokenShownPrefix {
+			return unauthorized(nil, "Invalid API token")
+		}
+		t, err := s.store.GetAPIToken(hashToken(raw))
+		if err == petfind.ErrNotFound {
+			return unauthorized(nil, "Invalid or expired API token")
+		}
+		if err != nil {
+			return E(err, "error getting API token", http.StatusInternalServerError)
+		}
+		user, err := s.store.GetUser(t.UserID)
+		if err != nil {
+			return E(err, "error getting user of API token", http.StatusInternalServerError)
+		}
+		if user.Disabled {
+			log.Printf("rejecting API token %d of disabled user %d", t.ID, user.ID)
+			return unauthorized(nil, "Invalid or expired API token")
+		}
+
+		requests, windowStart, err := s.store.UseAPIToken(t.ID, fromContextGetClient(r).IP, apiTokenRateWindow)
+		if err == petfind.ErrNotFound {
+			// The token was revoked or expired in the meantime.
+			return unauthorized(nil, "Invalid or expired API token")
+		}
+		if err != nil {
+			return E(err, "error recording use of API token", http.StatusInternalServerError)
+		}
+		reset := windowStart.Add(apiTokenRateWindow)
+		remaining := apiTokenRateLimit - requests
+		if remaining < 0 {
+			remaining = 0
+		}
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(apiTokenRateLimit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		if requests > apiTokenRateLimit {
+			retry := int64(math.Ceil(time.Until(reset).Seconds()))
+			if retry < 1 {
+				retry = 1
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+			return E(nil, "Too many requests with this API token. Please try again later.", http.StatusTooManyRequests)
+		}
+
+		if !t.Scope.Allows(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="petfind", error="insufficient_scope", scope="%s"`, scope))
+			return E(nil, fmt.Sprintf("API token needs the %s scope", scope), http.StatusForbidden)
+		}
+
+		// Put user in the context so that the next handler can access it.
+		ctx := newContextWithUser(r.Context(), user)
+		return fn(w, r.WithContext(ctx))
+	}
+}
+
+// newAPIToken returns a new personal API token and its stored form.
+func newAPIToken(userID int64, name string, scope petfind.APIScope, expires time.Time) (string, *petfind.APIToken, error) {
+	secret := securecookie.GenerateRandomKey(32)
+	if secret == nil {
+		return "", nil, fmt.Errorf("error generating random API token")
+	}
+	raw := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	t := &petfind.APIToken{
+		UserID:  userID,
+		Name:    name,
+		Prefix:  raw[:apiTokenShownPrefix],
+		Hash:    hashToken(raw),
+		Scope:   scope,
+		Expires: expires,
+	}
+	return raw, t, nil
+}
+
+// apiTokensPage is shown on /me/tokens.
+type apiTokensPage struct {
+	Tokens    []*petfind.APIToken
+	Scopes    []petfind.APIScope
+	Lifetimes []int
+	Default   int
+	Now       time.Time
+	Message   string
+	Err       string
+	// Added is the token that was just created and Secret is the token
+	// itself. It is shown once as only its hash is stored.
+	Added  *petfind.APIToken
+	Secret string
+	// AskCode is set when creating a token needs a two-factor code and
+	// LoginAgain when it needs a fresh login as the user did not log in
+	// recently.
+	AskCode    bool
+	LoginAgain bool
+}
+
+var apiTokenMessages = map[string]string{
+	"revoked": "The token was revoked. Requests made with it will be rejected.",
+}
+
+func (s *server) serveAPITokens(w http.ResponseWriter, r *http.Request) *Error {
+	page := &apiTokensPage{Message: apiTokenMessages[r.FormValue("m")]}
+	return s.renderAPITokens(w, r, page)
+}
+
+func (s *server) renderAPITokens(w http.ResponseWriter, r *http.Request, page *apiTokensPage) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	tokens, err := s.store.GetUserAPITokens(user.ID)
+	if err != nil {
+		return E(err, "error getting API tokens", http.StatusInternalServerError)
+	}
+	page.Tokens = tokens
+	page.Scopes = petfind.APIScopes
+	page.Lifetimes = apiTokenLifetimes
+	page.Default = defaultAPITokenLifetime
+	page.Now = time.Now()
+	if !s.recentLogin(r) {
+		tf, err := s.twoFactorPage(user)
+		if err != nil {
+			return E(err, "error getting two-factor settings", http.StatusInternalServerError)
+		}
+		page.AskCode = tf.Enabled
+		page.LoginAgain = !tf.Enabled
+	}
+	return s.render(w, r, s.templates.apiTokens, page, nil)
+}
+
+// handleAddAPIToken creates a token. A token is a lasting way into the account
+// so, like adding a passkey, it needs a second factor or a recent login so
+// that a hijacked session cannot mint one.
+func (s *server) handleAddAPIToken(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	if err := r.ParseForm(); err != nil {
+		return E(err, "error parsing form", http.StatusBadRequest)
+	}
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" || utf8.RuneCountInString(name) > 70 {
+		return s.renderAPITokens(w, r, &apiTokensPage{Err: "Name must have between 1 and 70 characters."})
+	}
+	scope, ok := parseAPIScope(r.PostFormValue("scope"))
+	if !ok {
+		return E(nil, "invalid token scope", http.StatusBadRequest)
+	}
+	days, err := strconv.Atoi(r.PostFormValue("days"))
+	if err != nil || !validAPITokenLifetime(days) {
+		return E(err, "invalid token lifetime", http.StatusBadRequest)
+	}
+	tokens, err := s.store.GetUserAPITokens(user.ID)
+	if err != nil {
+		return E(err, "error getting API tokens", http.StatusInternalServerError)
+	}
+	if len(tokens) >= maxAPITokens {
+		return s.renderAPITokens(w, r, &apiTokensPage{Err: fmt.Sprintf("You can have up to %d tokens. Please revoke one you no longer use first.", maxAPITokens)})
+	}
+	if !s.recentLogin(r) {
+		t, tf, e := s.confirmSecondFactor(r, user)
+		if e != nil {
+			return e
+		}
+		if t == nil {
+			msg := "Please log out and log in again to create a token."
+			if tf.Enabled {
+				msg = "Please enter a valid code from your authenticator app or a recovery code to create a token."
+				if tf.Err == secondFactorLockedErr {
+					msg = tf.Err
+				}
+			}
+			s.audit(r, petfind.AuditAPIToken, user.ID, "create rejected: no recent login or second factor")
+			return s.renderAPITokens(w, r, &apiTokensPage{Err: msg})
+		}
+	}
+
+	raw, t, err := newAPIToken(user.ID, name, scope, time.Now().AddDate(0, 0, days))
+	if err != nil {
+		return E(err, "error creating API token", http.StatusInternalServerError)
+	}
+	if err := s.store.AddAPIToken(t); err != nil {
+		return E(err, "error storing API token", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditAPIToken, user.ID, fmt.Sprintf("created token %d %q (%s) with %s scope for %d days", t.ID, name, t.Prefix, scope, days))
+	// The page is rendered instead of redirecting so that the token is not
+	// put in a URL.
+	return s.renderAPITokens(w, r, &apiTokensPage{Added: t, Secret: raw})
+}
+
+func parseAPIScope(name string) (petfind.APIScope, bool) {
+	for _, scope := range petfind.APIScopes {
+		if name == scope.String() {
+			return scope, true
+		}
+	}
+	return 0, false
+}
+
+func validAPITokenLifetime(days int) bool {
+	for _, d := range apiTokenLifetimes {
+		if days == d {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) handleRevokeAPIToken(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	err := s.store.DeleteAPIToken(user.ID, id)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Token does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error revoking API token", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditAPIToken, user.ID, fmt.Sprintf("revoked token %d", id))
+	http.Redirect(w, r, "/me/tokens?m=revoked", http.StatusFound)
+	return nil
+}
@@ -0,0 +1,236 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+type apiTokenStore struct {
+	apiStore
+	tokens    []*petfind.APIToken
+	users     map[int64]*petfind.User
+	requests  int64
+	favorites []int64
+}
+
+func (s *apiTokenStore) GetAPIToken(hash string) (*petfind.APIToken, error) {
+	for _, t := range s.tokens {
+		if t.Hash == hash && !t.Expired(time.Now()) {
+			return t, nil
+		}
+	}
+	return nil, petfind.ErrNotFound
+}
+
+func (s *apiTokenStore) GetUser(userID int64) (*petfind.User, error) {
+	if u, ok := s.users[userID]; ok {
+		return u, nil
+	}
+	return nil, petfind.ErrNotFound
+}
+
+func (s *apiTokenStore) UseAPIToken(tokenID int64, ip string, window time.Duration) (int64, time.Time, error) {
+	for _, t := range s.tokens {
+		if t.ID == tokenID {
+			s.requests++
+			t.LastUsed = time.Now()
+			t.LastIP = ip
+			return s.requests, time.Now().Add(-window / 2), nil
+		}
+	}
+	return 0, time.Time{}, petfind.ErrNotFound
+}
+
+func (s *apiTokenStore) AddFavorite(userID, petID int64) error {
+	s.favorites = append(s.favorites, petID)
+	return nil
+}
+
+func (s *apiTokenStore) GetFavoritePets(userID int64) ([]*petfind.Pet, error) {
+	var pets []*petfind.Pet
+	for _, id := range s.favorites {
+		p, _ := s.GetPet(id)
+		pets = append(pets, p)
+	}
+	return pets, nil
+}
+
+// newAPITokenTestServer returns a server with the JSON API behind the CSRF
+// protection and the raw read, write and expired tokens of its store.
+func newAPITokenTestServer(t *testing.T) (s *server, read, write, expired string) {
+	s = newAPITestServer()
+	store := &apiTokenStore{
+		apiStore: *s.store.(*apiStore),
+		users: map[int64]*petfind.User{
+			1: {ID: 1, Login: "janedoe", Name: "Jane Doe"},
+			2: {ID: 2, Login: "johndoe", Name: "John Doe", Disabled: true},
+		},
+	}
+	s.store = store
+	for i, tok := range []struct {
+		userID  int64
+		scope   petfind.APIScope
+		expires time.Time
+		raw     *string
+	}{
+		{1, petfind.ScopeRead, time.Now().Add(time.Hour), &read},
+		{1, petfind.ScopeWrite, time.Now().Add(time.Hour), &write},
+		{1, petfind.ScopeWrite, time.Now().Add(-time.Hour), &expired},
+	} {
+		raw, at, err := newAPIToken(tok.userID, "test", tok.scope, tok.expires)
+		if err != nil {
+			t.Fatalf("newAPIToken failed: %v", err)
+		}
+		at.ID = int64(i + 1)
+		store.tokens = append(store.tokens, at)
+		*tok.raw = raw
+	}
+	s.mux = http.NewServeMux()
+	s.handleAPI()
+	s.mux.Handle("/me/favorites/add", handler(func(w http.ResponseWriter, r *http.Request) *Error { return nil }))
+	s.handlers = csrf.Protect([]byte("32-byte-long-auth-key-for-tests!"), csrf.Secure(false))(s.mux)
+	return s, read, write, expired
+}
+
+func newBearerRequest(method, target, token string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestBearerToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"Bearer pfat_abc":    "pfat_abc",
+		"bearer pfat_abc ":   "pfat_abc",
+		"Basic dXNlcjpwdw==": "",
+		"":                   "",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", header)
+		if got, ok := bearerToken(r); got != want || ok != (want != "") {
+			t.Errorf("bearerToken(%q) = %q, %v, expected %q", header, got, ok, want)
+		}
+	}
+}
+
+func TestAPITokenAuth(t *testing.T) {
+	s, read, write, expired := newAPITokenTestServer(t)
+
+	var resp apiUserResponse
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, newBearerRequest("GET", "/api/v1/me", read))
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK || resp.Data.Login != "janedoe" {
+		t.Fatalf("me with read token = %d %#v, %v", w.Code, resp, err)
+	}
+	if w.Header().Get("X-RateLimit-Remaining") != "999" {
+		t.Errorf("X-RateLimit-Remaining = %q, expected 999", w.Header().Get("X-RateLimit-Remaining"))
+	}
+	if tok := s.store.(*apiTokenStore).tokens[0]; tok.LastUsed.IsZero() {
+		t.Errorf("read token was not marked as used")
+	}
+
+	for _, tt := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{"PUT", "/api/v1/me/favorites/3", read, http.StatusForbidden},
+		{"PUT", "/api/v1/me/favorites/3", write, http.StatusOK},
+		{"GET", "/api/v1/me", expired, http.StatusUnauthorized},
+		{"GET", "/api/v1/me", "pfat_" + strings.Repeat("x", 43), http.StatusUnauthorized},
+		{"GET", "/api/v1/me", "not-a-token", http.StatusUnauthorized},
+		// Guest routes reject invalid tokens as well instead of ignoring
+		// them.
+		{"GET", "/api/v1/pets", "not-a-token", http.StatusUnauthorized},
+		{"PATCH", "/api/v1/me/favorites/3", write, http.StatusMethodNotAllowed},
+	} {
+		var e apiErrorResponse
+		code := serveAPI(t, s, newBearerRequest(tt.method, tt.path, tt.token), &e)
+		if code != tt.want {
+			t.Errorf("%s %s = %d %#v, expected %d", tt.method, tt.path, code, e, tt.want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, newBearerRequest("GET", "/api/v1/me", expired))
+	if got := w.Header().Get("WWW-Authenticate"); !strings.Contains(got, "invalid_token") {
+		t.Errorf("WWW-Authenticate = %q, expected invalid_token", got)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, newBearerRequest("DELETE", "/api/v1/me/favorites/3", read))
+	if got := w.Header().Get("WWW-Authenticate"); !strings.Contains(got, `scope="write"`) {
+		t.Errorf("WWW-Authenticate = %q, expected the write scope", got)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, newBearerRequest("PATCH", "/api/v1/me/favorites/3", write))
+	if got := w.Header().Get("Allow"); got != "PUT, DELETE" {
+		t.Errorf("Allow = %q, expected PUT, DELETE", got)
+	}
+}
+
+func TestAPITokenDisabledUser(t *testing.T) {
+	s, read, _, _ := newAPITokenTestServer(t)
+	s.store.(*apiTokenStore).tokens[0].UserID = 2
+	var e apiErrorResponse
+	if code := serveAPI(t, s, newBearerRequest("GET", "/api/v1/me", read), &e); code != http.StatusUnauthorized {
+		t.Errorf("me with token of disabled user = %d %#v, expected 401", code, e)
+	}
+}
+
+func TestAPITokenRateLimit(t *testing.T) {
+	s, read, _, _ := newAPITokenTestServer(t)
+	s.store.(*apiTokenStore).requests = apiTokenRateLimit
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, newBearerRequest("GET", "/api/v1/me", read))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the rate limit = %d, expected 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1800" {
+		t.Errorf("Retry-After = %q, expected 1800", got)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, expected 0", got)
+	}
+}
+
+func TestAPITokenSkipsCSRF(t *testing.T) {
+	s, _, write, _ := newAPITokenTestServer(t)
+
+	// Without a token the API is protected by the CSRF check like the rest
+	// of the site.
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("PUT", "/api/v1/me/favorites/3", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("PUT without token = %d, expected 403", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, newBearerRequest("PUT", "/api/v1/me/favorites/3", write))
+	if w.Code != http.StatusOK {
+		t.Errorf("PUT with token = %d %s, expected 200", w.Code, w.Body)
+	}
+	// Tokens do not skip the check outside the API.
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, newBearerRequest("POST", "/me/favorites/add", write))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("POST /me/favorites/add with token = %d, expected 403", w.Code)
+	}
+}
+
+func TestNewAPIToken(t *testing.T) {
+	raw, tok, err := newAPIToken(1, "test", petfind.ScopeRead, time.Now())
+	if err != nil {
+		t.Fatalf("newAPIToken failed: %v", err)
+	}
+	if !strings.HasPrefix(raw, apiTokenPrefix) || !strings.HasPrefix(raw, tok.Prefix) || len(tok.Prefix) != apiTokenShownPrefix {
+		t.Errorf("token %q has prefix %q", raw, tok.Prefix)
+	}
+	if tok.Hash != hashToken(raw) || strings.Contains(tok.Hash, raw[apiTokenShownPrefix:]) {
+		t.Errorf("token %q has hash %q", raw, tok.Hash)
+	}
+}
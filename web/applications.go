@@ -0,0 +1,260 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// applyPage is shown on /pets/apply where a user applies to adopt a pet.
+type applyPage struct {
+	Pet         *petfind.Pet
+	Housings    []petfind.Housing
+	Experiences []petfind.Experience
+	Form        applyForm
+}
+
+type applyForm struct {
+	Housing       string
+	HousingErr    string
+	Adults        string
+	AdultsErr     string
+	Children      string
+	ChildrenErr   string
+	Experience    string
+	ExperienceErr string
+	OtherPets     string
+	OtherPetsErr  string
+	Message       string
+	MessageErr    string
+}
+
+// maxHousehold is how many adults or children an application can list.
+const maxHousehold = 20
+
+// postFormApplication validates the application form and returns the
+// application it describes. ok is false if any field is invalid.
+func postFormApplication(r *http.Request) (a *petfind.Application, form applyForm, ok bool) {
+	ok = true
+	a = new(petfind.Application)
+
+	form.Housing = r.PostFormValue("housing")
+	housing, err := strconv.ParseInt(form.Housing, 10, 64)
+	a.Housing = petfind.Housing(housing)
+	if err != nil || !a.Housing.Valid() {
+		ok = false
+		form.HousingErr = "Please choose where you live."
+	}
+
+	form.Adults = r.PostFormValue("adults")
+	if a.Adults, err = strconv.ParseInt(form.Adults, 10, 64); err != nil || a.Adults < 1 || a.Adults > maxHousehold {
+		ok = false
+		form.AdultsErr = "Please enter how many adults live with you, from 1 to 20."
+	}
+
+	form.Children = r.PostFormValue("children")
+	if form.Children == "" {
+		form.Children = "0"
+	}
+	if a.Children, err = strconv.ParseInt(form.Children, 10, 64); err != nil || a.Children < 0 || a.Children > maxHousehold {
+		ok = false
+		form.ChildrenErr = "Please enter how many children live with you, from 0 to 20."
+	}
+
+	form.Experience = r.PostFormValue("experience")
+	experience, err := strconv.ParseInt(form.Experience, 10, 64)
+	a.Experience = petfind.Experience(experience)
+	if err != nil || !a.Experience.Valid() {
+		ok = false
+		form.ExperienceErr = "Please choose your experience with pets."
+	}
+
+	form.OtherPets = strings.TrimSpace(r.PostFormValue("otherpets"))
+	a.OtherPets = form.OtherPets
+	if utf8.RuneCountInString(a.OtherPets) > 200 {
+		ok = false
+		form.OtherPetsErr = "Other pets cannot be longer than 200 characters."
+	}
+
+	form.Message = strings.TrimSpace(r.PostFormValue("message"))
+	a.Message = form.Message
+	if utf8.RuneCountInString(a.Message) > maxMessageLength {
+		ok = false
+		form.MessageErr = "Message cannot be longer than 2000 characters."
+	}
+	return a, form, ok
+}
+
+// getAppliedPet returns the pet the user wants to adopt. Users can only apply
+// for listed pets and not for their own.
+func (s *server) getAppliedPet(r *http.Request, user *petfind.User) (*petfind.Pet, *Error) {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return nil, E(err, "invalid pet id", http.StatusBadRequest)
+	}
+	pet, err := s.store.GetPet(id)
+	if err == petfind.ErrNotFound || (err == nil && pet.Status != petfind.PetListed) {
+		return nil, E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, E(err, "error getting pet", http.StatusInternalServerError)
+	}
+	if pet.OwnedBy(user) {
+		return nil, E(nil, "You cannot apply to adopt your own pet", http.StatusBadRequest)
+	}
+	return pet, nil
+}
+
+func (s *server) serveApplyPet(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	pet, e := s.getAppliedPet(r, user)
+	if e != nil {
+		return e
+	}
+	page := &applyPage{Pet: pet, Housings: petfind.Housings, Experiences: petfind.Experiences}
+	return s.render(w, r, s.templates.apply, page, nil)
+}
+
+func (s *server) handleApplyPet(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	pet, e := s.getAppliedPet(r, user)
+	if e != nil {
+		return e
+	}
+	a, form, valid := postFormApplication(r)
+	if !valid {
+		page := &applyPage{Pet: pet, Housings: petfind.Housings, Experiences: petfind.Experiences, Form: form}
+		return s.render(w, r, s.templates.apply, page, nil)
+	}
+	a.PetID = pet.ID
+	a.ApplicantID = user.ID
+	err := s.store.AddApplication(a)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err == petfind.ErrApplied {
+		return E(nil, "You have already applied to adopt this pet", http.StatusConflict)
+	}
+	if err != nil {
+		return E(err, "error adding application", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/applications/view?m=submitted&id="+strconv.FormatInt(a.ID, 10), http.StatusFound)
+	return nil
+}
+
+// applicationsPage is shown on /applications.
+type applicationsPage struct {
+	// Received are the applications for the user's pets.
+	Received []*petfind.Application
+	// Sent are the user's own applications.
+	Sent []*petfind.Application
+}
+
+func (s *server) serveApplications(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	received, err := s.store.GetReceivedApplications(user.ID)
+	if err != nil {
+		return E(err, "error getting received applications", http.StatusInternalServerError)
+	}
+	sent, err := s.store.GetSentApplications(user.ID)
+	if err != nil {
+		return E(err, "error getting sent applications", http.StatusInternalServerError)
+	}
+	return s.render(w, r, s.templates.applications, &applicationsPage{Received: received, Sent: sent}, nil)
+}
+
+var applicationMessages = map[string]string{
+	"submitted": "Your application was sent to the owner.",
+	"review":    "The application is now under review.",
+	"approve":   "The application was approved.",
+	"decline":   "The application was declined.",
+	"withdraw":  "Your application was withdrawn.",
+}
+
+// applicationPage is shown on /applications/view.
+type applicationPage struct {
+	Application *petfind.Application
+	// Actions are what the viewer can do to the application.
+	Actions []petfind.ApplicationAction
+	Message string
+}
+
+func (s *server) serveApplication(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return E(err, "invalid application id", http.StatusBadRequest)
+	}
+	// Applications of others are reported as missing so that their IDs
+	// cannot be probed.
+	a, err := s.store.GetApplication(id)
+	if err == petfind.ErrNotFound || (err == nil && a.OwnerID != user.ID && a.ApplicantID != user.ID) {
+		return E(nil, "Application does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error getting application", http.StatusInternalServerError)
+	}
+	page := &applicationPage{Application: a, Actions: a.Actions(user.ID), Message: applicationMessages[r.FormValue("m")]}
+	return s.render(w, r, s.templates.application, page, nil)
+}
+
+func (s *server) handleApplicationAction(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	action := petfind.ApplicationAction(r.PostFormValue("action"))
+	a, err := s.store.TransitionApplication(id, user.ID, action)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Application does not exist", http.StatusNotFound)
+	}
+	if err == petfind.ErrNotAllowed {
+		return E(nil, "You cannot do that to this application", http.StatusForbidden)
+	}
+	if err == petfind.ErrApplicationTransition {
+		return E(nil, "That action does not apply to the application anymore", http.StatusConflict)
+	}
+	if err == petfind.ErrPetUnavailable {
+		return E(nil, "The pet is not available for adoption anymore", http.StatusConflict)
+	}
+	if err != nil {
+		return E(err, "error updating application", http.StatusInternalServerError)
+	}
+	if a.Status == petfind.ApplicationApproved {
+		s.audit(r, petfind.AuditApplicationApprove, user.ID, fmt.Sprintf("adopted pet %d through application %d", a.PetID, a.ID))
+		if pet, err := s.store.GetPet(a.PetID); err == nil {
+			s.petChanged(r, pet, petfind.PetAdopted)
+		} else {
+			log.Printf("error getting adopted pet %d: %v", a.PetID, err)
+		}
+	}
+	http.Redirect(w, r, "/applications/view?m="+string(action)+"&id="+strconv.FormatInt(a.ID, 10), http.StatusFound)
+	return nil
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestPostFormApplication(t *testing.T) {
+	tests := []struct {
+		form  url.Values
+		valid bool
+	}{
+		{url.Values{"housing": {"3"}, "adults": {"2"}, "experience": {"2"}}, true},
+		{url.Values{"housing": {"1"}, "adults": {"1"}, "children": {"3"}, "experience": {"1"}, "otherpets": {"A cat"}, "message": {"Hello"}}, true},
+		{url.Values{"adults": {"2"}, "experience": {"2"}}, false},
+		{url.Values{"housing": {"9"}, "adults": {"2"}, "experience": {"2"}}, false},
+		{url.Values{"housing": {"3"}, "adults": {"0"}, "experience": {"2"}}, false},
+		{url.Values{"housing": {"3"}, "adults": {"2"}, "children": {"-1"}, "experience": {"2"}}, false},
+		{url.Values{"housing": {"3"}, "adults": {"2"}, "experience": {"0"}}, false},
+		{url.Values{"housing": {"3"}, "adults": {"2"}, "experience": {"2"}, "otherpets": {strings.Repeat("a", 201)}}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/pets/apply/submit", strings.NewReader(tt.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		a, _, valid := postFormApplication(r)
+		if valid != tt.valid {
+			t.Errorf("postFormApplication(%v) valid = %v, want %v", tt.form, valid, tt.valid)
+		}
+		if valid && (!a.Housing.Valid() || !a.Experience.Valid() || a.Status != petfind.ApplicationSubmitted) {
+			t.Errorf("postFormApplication(%v) = %#v", tt.form, a)
+		}
+	}
+}
+
+type approvalStore struct {
+	petfind.Store
+	events []*petfind.AuditEvent
+}
+
+func (s *approvalStore) TransitionApplication(applicationID, userID int64, a petfind.ApplicationAction) (*petfind.Application, error) {
+	return &petfind.Application{ID: applicationID, PetID: 3, OwnerID: userID, Status: petfind.ApplicationApproved}, nil
+}
+
+func (s *approvalStore) GetPet(petID int64) (*petfind.Pet, error) {
+	return &petfind.Pet{ID: petID, OwnerID: 1, Status: petfind.PetHomed}, nil
+}
+
+func (s *approvalStore) AddAuditEvent(e *petfind.AuditEvent) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestApplicationApproveAudited(t *testing.T) {
+	store := &approvalStore{}
+	s := &server{store: store}
+	form := url.Values{"id": {"7"}, "action": {string(petfind.ApplicationApprove)}}
+	r := httptest.NewRequest("POST", "/applications/update", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r = r.WithContext(newContextWithUser(r.Context(), &petfind.User{ID: 1}))
+	w := httptest.NewRecorder()
+
+	if e := s.handleApplicationAction(w, r); e != nil {
+		t.Fatalf("handleApplicationAction returned %v", e)
+	}
+	if w.Code != http.StatusFound {
+		t.Errorf("handleApplicationAction responded with %d, expected %d", w.Code, http.StatusFound)
+	}
+	if len(store.events) != 1 || store.events[0].Kind != petfind.AuditApplicationApprove || store.events[0].ActorID != 1 {
+		t.Fatalf("recorded events %#v, expected a %s event of user 1", store.events, petfind.AuditApplicationApprove)
+	}
+}
@@ -0,0 +1,90 @@
+// Passkey login and registration. The forms are hidden unless the browser
+// supports WebAuthn. Submitting one fetches the options from the server, asks
+// the browser for a credential and posts it back with the form.
+(function () {
+  'use strict';
+
+  if (!window.PublicKeyCredential) {
+    return;
+  }
+
+  function decode(s) {
+    s = s.replace(/-/g, '+').replace(/_/g, '/');
+    var bin = atob(s);
+    var buf = new Uint8Array(bin.length);
+    for (var i = 0; i < bin.length; i++) {
+      buf[i] = bin.charCodeAt(i);
+    }
+    return buf.buffer;
+  }
+
+  function encode(buf) {
+    if (!buf) {
+      return '';
+    }
+    var bytes = new Uint8Array(buf);
+    var bin = '';
+    for (var i = 0; i < bytes.length; i++) {
+      bin += String.fromCharCode(bytes[i]);
+    }
+    return btoa(bin).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+  }
+
+  function options(form, url) {
+    var token = form.querySelector('input[name="gorilla.csrf.Token"]').value;
+    return fetch(url, {
+      method: 'POST',
+      credentials: 'same-origin',
+      headers: {'X-CSRF-Token': token}
+    }).then(function (resp) {
+      if (!resp.ok) {
+        throw new Error('could not get options: ' + resp.status);
+      }
+      return resp.json();
+    });
+  }
+
+  function descriptors(list) {
+    return (list || []).map(function (c) {
+      return {type: c.type, id: decode(c.id)};
+    });
+  }
+
+  function setup(id, url, ceremony) {
+    var form = document.getElementById(id);
+    if (!form) {
+      return;
+    }
+    form.hidden = false;
+    form.addEventListener('submit', function (e) {
+      e.preventDefault();
+      options(form, url).then(function (opts) {
+        opts.challenge = decode(opts.challenge);
+        if (ceremony === 'create') {
+          opts.user.id = decode(opts.user.id);
+          opts.excludeCredentials = descriptors(opts.excludeCredentials);
+          return navigator.credentials.create({publicKey: opts});
+        }
+        opts.allowCredentials = descriptors(opts.allowCredentials);
+        return navigator.credentials.get({publicKey: opts});
+      }).then(function (cred) {
+        var r = cred.response;
+        var json = {id: encode(cred.rawId), type: cred.type, response: {clientDataJSON: encode(r.clientDataJSON)}};
+        if (ceremony === 'create') {
+          json.response.attestationObject = encode(r.attestationObject);
+        } else {
+          json.response.authenticatorData = encode(r.authenticatorData);
+          json.response.signature = encode(r.signature);
+          json.response.userHandle = encode(r.userHandle);
+        }
+        form.querySelector('input[name="credential"]').value = JSON.stringify(json);
+        form.submit();
+      }).catch(function (err) {
+        console.log('passkey ' + ceremony + ' failed:', err);
+      });
+    });
+  }
+
+  setup('passkey-login', '/login/passkey/options', 'get');
+  setup('passkey-register', '/me/passkeys/options', 'create');
+})();
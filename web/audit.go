@@ -0,0 +1,106 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/csrf"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// maxAuditText caps the user agent and detail of audit events as both can
+// be chosen by the client.
+const maxAuditText = 1000
+
+// audit records a security relevant event in the audit log. actorID is the
+// user who caused the event or 0 if they are not known. An event that cannot
+// be recorded is logged instead so that it is not lost and the request is not
+// failed because of it.
+func (s *server) audit(r *http.Request, kind petfind.AuditKind, actorID int64, detail string) {
+	e := &petfind.AuditEvent{
+		Kind:      kind,
+		ActorID:   actorID,
+		IP:        fromContextGetClient(r).IP,
+		UserAgent: truncate(r.UserAgent(), maxAuditText),
+		Detail:    truncate(detail, maxAuditText),
+	}
+	if err := s.store.AddAuditEvent(e); err != nil {
+		log.Printf("error recording audit event %s of user %d from %s (%s): %v", kind, actorID, e.IP, detail, err)
+	}
+}
+
+// truncate cuts s down to n runes.
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// handleCSRFFailure is called by the CSRF protection for requests that fail
+// its checks. The request is denied like gorilla/csrf would but it is also
+// recorded in the audit log together with whoever the session belongs to.
+func (s *server) handleCSRFFailure(w http.ResponseWriter, r *http.Request) *Error {
+	var actorID int64
+	if session, err := s.sessions.Get(r, sessionName); err == nil {
+		actorID, _ = fromSessionGetUserID(session)
+	}
+	reason := csrf.FailureReason(r)
+	s.audit(r, petfind.AuditCSRFFailure, actorID, fmt.Sprintf("%s %s: %v", r.Method, r.URL.Path, reason))
+	return E(reason, http.StatusText(http.StatusForbidden)+" - CSRF token invalid", http.StatusForbidden)
+}
+
+// adminAuditPage is shown on /admin/audit.
+type adminAuditPage struct {
+	pager
+	Events []*petfind.AuditEvent
+	Kinds  []petfind.AuditKind
+	Filter petfind.AuditFilter
+}
+
+// newAuditFilter reads the filters of the audit log from the request.
+// Invalid filters are ignored.
+func newAuditFilter(r *http.Request) petfind.AuditFilter {
+	var f petfind.AuditFilter
+	if k := petfind.AuditKind(r.FormValue("kind")); k.Valid() {
+		f.Kind = k
+	}
+	if id, err := strconv.ParseInt(r.FormValue("actor"), 10, 64); err == nil && id > 0 {
+		f.ActorID = id
+	}
+	if ip := net.ParseIP(r.FormValue("ip")); ip != nil {
+		f.IP = ip.String()
+	}
+	return f
+}
+
+// PageURL returns the link to page n of the audit log with the same filters.
+func (p *adminAuditPage) PageURL(n int) string {
+	v := url.Values{"p": {strconv.Itoa(n)}}
+	if p.Filter.Kind != "" {
+		v.Set("kind", string(p.Filter.Kind))
+	}
+	if p.Filter.ActorID != 0 {
+		v.Set("actor", strconv.FormatInt(p.Filter.ActorID, 10))
+	}
+	if p.Filter.IP != "" {
+		v.Set("ip", p.Filter.IP)
+	}
+	return "/admin/audit?" + v.Encode()
+}
+
+func (s *server) serveAdminAudit(w http.ResponseWriter, r *http.Request) *Error {
+	p := newPager(r)
+	page := &adminAuditPage{Kinds: petfind.AuditKinds, Filter: newAuditFilter(r)}
+	events, err := s.store.GetAuditEvents(&page.Filter, p.limit(), p.offset())
+	if err != nil {
+		return E(err, "error getting audit events", http.StatusInternalServerError)
+	}
+	page.Events = events[:p.trim(len(events))]
+	page.pager = p
+	return s.render(w, r, s.templates.adminAudit, page, nil)
+}
@@ -0,0 +1,47 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestNewAuditFilter(t *testing.T) {
+	tests := []struct {
+		query string
+		want  petfind.AuditFilter
+	}{
+		{"", petfind.AuditFilter{}},
+		{"?kind=login&actor=7&ip=198.51.100.1", petfind.AuditFilter{Kind: petfind.AuditLogin, ActorID: 7, IP: "198.51.100.1"}},
+		{"?ip=2001:DB8::1", petfind.AuditFilter{IP: "2001:db8::1"}},
+		{"?kind=unknown&actor=-1&ip=localhost", petfind.AuditFilter{}},
+		{"?actor=x&ip=198.51.100.1%27", petfind.AuditFilter{}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/admin/audit"+tt.query, nil)
+		if got := newAuditFilter(r); got != tt.want {
+			t.Errorf("newAuditFilter(%q) = %#v, want %#v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestAuditPageURL(t *testing.T) {
+	p := &adminAuditPage{Filter: petfind.AuditFilter{Kind: petfind.AuditLogout, ActorID: 3, IP: "198.51.100.1"}}
+	if got, want := p.PageURL(2), "/admin/audit?actor=3&ip=198.51.100.1&kind=logout&p=2"; got != want {
+		t.Errorf("PageURL(2) = %q, want %q", got, want)
+	}
+	p = &adminAuditPage{}
+	if got, want := p.PageURL(1), "/admin/audit?p=1"; got != want {
+		t.Errorf("PageURL(1) without filters = %q, want %q", got, want)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("αβγδ", 2); got != "αβ" {
+		t.Errorf("truncate = %q, want %q", got, "αβ")
+	}
+	if got := truncate("ab", 2); got != "ab" {
+		t.Errorf("truncate = %q, want %q", got, "ab")
+	}
+}
@@ -3,17 +3,13 @@ package web
 import (
 	"context"
 	"crypto/subtle"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
-	"golang.org/x/oauth2"
-
-	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"github.com/psimika/secure-web-app/login"
 	"github.com/psimika/secure-web-app/petfind"
 )
 
@@ -22,18 +18,15 @@ const (
 	// session ID name to avoid exposing implementation details:
 	//
 	// https://www.owasp.org/index.php/Session_Management_Cheat_Sheet#Session_ID_Name_Fingerprinting
-	sessionName         = "id"
-	oauthStateTokenSize = 32
+	sessionName = "id"
 )
 
 // auth protects other handlers letting only logged in users access them. If
 // the session ID is not found in the context, the handler redirects to /login.
 func (s *server) auth(fn handler) handler {
 	return func(w http.ResponseWriter, r *http.Request) *Error {
-		log.Println("r.URL.Path:", r.URL.Path)
-		log.Println("r.Referer():", r.Referer())
-		log.Println("r.RemoteAddr:", r.RemoteAddr)
-		log.Println("r.Header.Get(\"X-Forwarded-For\"):", r.Header.Get("X-Forwarded-For"))
+		c := fromContextGetClient(r)
+		log.Printf("auth: %s %s%s from %s (referer %q)", r.Method, c.Host, r.URL.Path, c.IP, r.Referer())
 
 		session, err := s.sessions.Get(r, sessionName)
 		if err != nil {
@@ -42,43 +35,57 @@ func (s *server) auth(fn handler) handler {
 			return nil
 		}
 
-		// Store the original URL path the user was trying to access so we can
-		// redirect to that later after a successful login.
-		session.Values["redirectPath"] = r.URL.Path
-		if err = session.Save(r, w); err != nil {
-			return E(err, "error storing redirectPath in session", http.StatusInternalServerError)
-		}
-
 		// If the session is brand new, it means that the user has not logged
 		// in before.
 		if session.IsNew {
-			http.Redirect(w, r, "/login", http.StatusFound)
-			return nil
+			return s.redirectToLogin(w, r, session)
 		}
 
 		// Get the user's ID stored in the session.
 		userID, err := fromSessionGetUserID(session)
 		if err != nil {
 			log.Println(err)
-			http.Redirect(w, r, "/login", http.StatusFound)
-			return nil
+			return s.redirectToLogin(w, r, session)
 		}
 
 		// Get the user from the database based on the session's user ID.
 		user, err := s.store.GetUser(userID)
+		if err == petfind.ErrNotFound {
+			// The user was deleted, e.g. merged into another one, while
+			// the session was still around.
+			log.Printf("rejecting session of deleted user %d", userID)
+			s.audit(r, petfind.AuditSessionInvalid, userID, "user does not exist")
+			if err = s.rotateSession(w, r, session); err != nil {
+				return E(err, "error replacing session of deleted user", http.StatusInternalServerError)
+			}
+			return s.redirectToLogin(w, r, session)
+		}
 		if err != nil {
 			return E(err, "error getting user", http.StatusInternalServerError)
 		}
 
+		// Disabled users are logged out even if a session of theirs
+		// survived.
+		if user.Disabled {
+			log.Printf("rejecting session of disabled user %d", user.ID)
+			s.audit(r, petfind.AuditSessionInvalid, user.ID, "user is disabled")
+			if err = s.rotateSession(w, r, session); err != nil {
+				return E(err, "error replacing session of disabled user", http.StatusInternalServerError)
+			}
+			http.Redirect(w, r, "/login?m=disabled", http.StatusFound)
+			return nil
+		}
+
 		// If the session is not valid then we delete it.
-		if err = s.validateSession(r, session); err != nil {
+		rs, err := s.validateSession(r, session, userID)
+		if err != nil {
 			log.Println(err)
-			session.Options.MaxAge = -1
-			if err = sessions.Save(r, w); err != nil {
-				return E(err, "error deleting invalid session", http.StatusInternalServerError)
+			s.audit(r, petfind.AuditSessionInvalid, userID, err.Error())
+			// Replace the invalid session with a fresh anonymous one.
+			if err = s.rotateSession(w, r, session); err != nil {
+				return E(err, "error replacing invalid session", http.StatusInternalServerError)
 			}
-			http.Redirect(w, r, "/login", http.StatusFound)
-			return nil
+			return s.redirectToLogin(w, r, session)
 		}
 
 		// Extend session's idle timeout.
@@ -86,6 +93,9 @@ func (s *server) auth(fn handler) handler {
 		if err = sessions.Save(r, w); err != nil {
 			return E(err, "error saving session", http.StatusInternalServerError)
 		}
+		if err = s.store.TouchSession(rs.ID); err != nil {
+			return E(err, "error updating session registry", http.StatusInternalServerError)
+		}
 
 		// Put user in the context so that the next handler can access it.
 		ctx := newContextWithUser(r.Context(), user)
@@ -93,30 +103,77 @@ func (s *server) auth(fn handler) handler {
 	}
 }
 
-func (s *server) validateSession(r *http.Request, session *sessions.Session) error {
+// require protects handlers that only logged in users with at least the
+// given role may access. Users with an elevated role must also have
+// two-factor authentication turned on, as a stolen password of theirs does
+// much more damage.
+func (s *server) require(role petfind.Role, fn handler) handler {
+	return s.auth(func(w http.ResponseWriter, r *http.Request) *Error {
+		user, ok := fromContextGetUser(r.Context())
+		if !ok || user == nil {
+			return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+		if !user.HasRole(role) {
+			log.Printf("user %d with role %v denied access to %s which requires %v", user.ID, user.Role, r.URL.Path, role)
+			return E(nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		}
+		if role > petfind.RoleUser {
+			t, err := s.store.GetTOTP(user.ID)
+			if err != nil && err != petfind.ErrNotFound {
+				return E(err, "error getting two-factor settings", http.StatusInternalServerError)
+			}
+			if err == petfind.ErrNotFound || !t.Enabled {
+				http.Redirect(w, r, "/me/2fa?m=required", http.StatusFound)
+				return nil
+			}
+		}
+		return fn(w, r)
+	})
+}
+
+// redirectIfDisabled stops the login of a user who has been disabled by an
+// administrator and sends them back to the login page. It reports whether it
+// did so.
+func (s *server) redirectIfDisabled(w http.ResponseWriter, r *http.Request, userID int64) (bool, *Error) {
+	user, err := s.store.GetUser(userID)
+	if err != nil {
+		return false, E(err, "error getting user", http.StatusInternalServerError)
+	}
+	if !user.Disabled {
+		return false, nil
+	}
+	log.Printf("login of disabled user %d refused", userID)
+	s.audit(r, petfind.AuditLoginFailed, userID, "user is disabled")
+	http.Redirect(w, r, "/login?m=disabled", http.StatusSeeOther)
+	return true, nil
+}
+
+func (s *server) validateSession(r *http.Request, session *sessions.Session, userID int64) (*petfind.Session, error) {
 	// Get the session's userAgent value and check with the current HTTP
 	// request's user agent. If it's not the same we consider the session
 	// as invalid for extra safety.
 	userAgent, err := fromSessionGetUserAgent(session)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if subtle.ConstantTimeCompare([]byte(userAgent), []byte(r.UserAgent())) != 1 {
-		return fmt.Errorf("User-Agent doesn't match")
+		return nil, fmt.Errorf("User-Agent doesn't match")
 	}
 
 	// Get the session's created value to find when it was created.
 	created, err := fromSessionGetCreated(session)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Check if the session has expired.
 	expirationTime := time.Unix(created, 0).Add(time.Duration(s.sessionMaxTTL) * time.Second)
 	if expirationTime.Before(time.Now()) {
-		return fmt.Errorf("session expired")
+		return nil, fmt.Errorf("session expired")
 	}
-	return nil
+
+	// Check that the session has not been revoked by the user.
+	return s.registeredSession(session, userID)
 }
 
 // ---
@@ -129,6 +186,7 @@ type contextKey int
 
 const (
 	userContextKey contextKey = iota
+	clientContextKey
 )
 
 // fromContextGetUser retrieves User from the context.
@@ -178,11 +236,45 @@ func fromSessionGetUserAgent(session *sessions.Session) (string, error) {
 	return fromSessionGetString(session, "userAgent")
 }
 
-// fromSessionGetRedirectPath returns the path the user was trying to access as
-// string. It will return an error if the value does not exist or if it is the
-// wrong type.
-func fromSessionGetRedirectPath(session *sessions.Session) (string, error) {
-	return fromSessionGetString(session, "redirectPath")
+// newSessionWithAttempt stores the secrets of a login attempt with provider p
+// in the session.
+func newSessionWithAttempt(session *sessions.Session, p login.Provider, a *login.Attempt) {
+	session.Values["provider"] = p.Name()
+	session.Values["state"] = a.State
+	session.Values["nonce"] = a.Nonce
+	session.Values["verifier"] = a.Verifier
+	session.Values["redirectURL"] = a.RedirectURL
+}
+
+// fromSessionGetAttempt returns the login attempt stored in the session. It
+// will return an error if there is no attempt or it was started with a
+// different provider than p.
+func fromSessionGetAttempt(session *sessions.Session, p login.Provider) (*login.Attempt, error) {
+	provider, err := fromSessionGetString(session, "provider")
+	if err != nil {
+		return nil, err
+	}
+	if provider != p.Name() {
+		return nil, fmt.Errorf("login attempt started with %q, not %q", provider, p.Name())
+	}
+	a := new(login.Attempt)
+	for key, v := range map[string]*string{
+		"state":       &a.State,
+		"nonce":       &a.Nonce,
+		"verifier":    &a.Verifier,
+		"redirectURL": &a.RedirectURL,
+	} {
+		if *v, err = fromSessionGetString(session, key); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func clearSessionAttempt(session *sessions.Session) {
+	for _, key := range []string{"provider", "state", "nonce", "verifier", "redirectURL"} {
+		delete(session.Values, key)
+	}
 }
 
 func fromSessionGetString(session *sessions.Session, key string) (string, error) {
@@ -199,8 +291,44 @@ func fromSessionGetString(session *sessions.Session, key string) (string, error)
 
 // ---
 
+// loginButton is how a login provider is shown on the login page.
+type loginButton struct {
+	Name  string
+	Title string
+	Icon  string
+	Class string
+}
+
+// provider returns the configured provider with the given name or nil.
+func (s *server) provider(name string) login.Provider {
+	for _, p := range s.providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// loginButtons returns the login page buttons of the configured providers.
+// Providers without a dedicated icon get a generic one.
+func (s *server) loginButtons() []loginButton {
+	var buttons []loginButton
+	for _, p := range s.providers {
+		b := loginButton{Name: p.Name(), Title: p.Title(), Icon: "fa-sign-in", Class: "btn-outline-secondary"}
+		switch p.Name() {
+		case "github":
+			b.Icon, b.Class = "fa-github", "btn-outline-dark"
+		case "linkedin":
+			b.Icon, b.Class = "fa-linkedin-square", "btn-outline-primary"
+		}
+		buttons = append(buttons, b)
+	}
+	return buttons
+}
+
 func (s *server) serveLogin(w http.ResponseWriter, r *http.Request) *Error {
-	return s.render(w, r, s.templates.login, nil, nil)
+	page := &loginPage{Buttons: s.loginButtons(), Message: loginMessages[r.FormValue("m")]}
+	return s.render(w, r, s.templates.login, page, nil)
 }
 
 func (s *server) handleLogout(w http.ResponseWriter, r *http.Request) *Error {
@@ -212,119 +340,109 @@ func (s *server) handleLogout(w http.ResponseWriter, r *http.Request) *Error {
 	if err != nil {
 		return E(err, "error getting session for logout", http.StatusInternalServerError)
 	}
+	if user, ok := fromContextGetUser(r.Context()); ok && user != nil {
+		s.audit(r, petfind.AuditLogout, user.ID, "")
+	}
 
-	session.Options.MaxAge = -1
-	if err = session.Save(r, w); err != nil {
-		return E(err, "error deleting session for logout", http.StatusInternalServerError)
+	// Rotating drops the user's ID and leaves the browser with a fresh
+	// anonymous session.
+	if err = s.rotateSession(w, r, session); err != nil {
+		return E(err, "error rotating session for logout", http.StatusInternalServerError)
 	}
 
 	http.Redirect(w, r, "/", http.StatusFound)
 	return nil
 }
 
-// handleLoginGitHub sends an oauth request to login with GitHub (Caserta
-// 2015).
+// handleLogin starts a login attempt with provider p by redirecting the user
+// to the provider's consent page (Caserta 2015).
 //
 // http://pierrecaserta.com/go-oauth-facebook-github-twitter-google-plus/
-func (s *server) handleLoginGitHub(w http.ResponseWriter, r *http.Request) *Error {
-	// Create oauth state token.
-	key := securecookie.GenerateRandomKey(oauthStateTokenSize)
-	if key == nil {
-		return E(nil, "error generating random oauth state token", http.StatusInternalServerError)
+func (s *server) handleLogin(p login.Provider) handler {
+	return func(w http.ResponseWriter, r *http.Request) *Error {
+		session, err := s.sessions.Get(r, sessionName)
+		if err != nil {
+			return E(err, "error getting session", http.StatusInternalServerError)
+		}
+		// A plain login attempt must not link to whoever used the session
+		// before.
+		delete(session.Values, "linkUserID")
+		session.Values["created"] = time.Now().UTC().Unix()
+		session.Values["userAgent"] = r.UserAgent()
+		return s.startLogin(w, r, session, p)
 	}
-	state := base64.URLEncoding.EncodeToString(key)
+}
 
-	session, err := s.sessions.Get(r, sessionName)
+// startLogin stores a new login attempt with provider p in the session and
+// redirects the user to the provider's consent page.
+func (s *server) startLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session, p login.Provider) *Error {
+	c := fromContextGetClient(r)
+	a, err := login.NewAttempt(c.Scheme + "://" + c.Host + "/login/" + p.Name() + "/cb")
 	if err != nil {
-		return E(err, "error getting session", http.StatusInternalServerError)
+		return E(err, "error creating login attempt", http.StatusInternalServerError)
 	}
-	session.Values["state"] = state
-	session.Values["created"] = time.Now().UTC().Unix()
-	session.Values["userAgent"] = r.UserAgent()
+	newSessionWithAttempt(session, p, a)
 	if err := session.Save(r, w); err != nil {
 		return E(err, "error saving session", http.StatusInternalServerError)
 	}
-
-	url := s.github.AuthCodeURL(state, oauth2.AccessTypeOnline)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	// 303 makes the browser follow with GET when starting from a POST.
+	http.Redirect(w, r, p.AuthCodeURL(a), http.StatusSeeOther)
 	return nil
 }
 
-// handleLoginGitHubCallback receives the callback request returned by GitHub
-// after the user has given consent to access their information (Caserta 2015):
+// handleLoginCallback receives the callback request returned by provider p
+// after the user has given consent to access their information (Caserta
+// 2015):
 //
 // http://pierrecaserta.com/go-oauth-facebook-github-twitter-google-plus/
-func (s *server) handleLoginGitHubCallback(w http.ResponseWriter, r *http.Request) *Error {
-	session, err := s.sessions.Get(r, sessionName)
-	if err != nil {
-		return E(err, "error getting session", http.StatusInternalServerError)
-	}
-	val, ok := session.Values["state"]
-	if !ok {
-		return E(nil, "no state in session", http.StatusForbidden)
-	}
-	oauthState, ok := val.(string)
-	if !ok {
-		return E(nil, "unexpected state type", http.StatusForbidden)
-	}
-
-	// Check that the state token returned from GitHub is the same as the one
-	// we generated.
-	state := r.FormValue("state")
-	// Constant time compare to mitigate timing attacks.
-	if subtle.ConstantTimeCompare([]byte(state), []byte(oauthState)) != 1 {
-		// TODO(psimika): Redirect instead of returning an error?
-		e := fmt.Errorf("invalid oauth state, expected %q, got %q", oauthState, state)
-		return E(e, "invalid oauth state", http.StatusForbidden)
-	}
-
-	// Exchange authorization code for a GitHub API token.
-	code := r.FormValue("code")
-	token, err := s.github.Exchange(context.Background(), code)
-	if err != nil {
-		return E(err, "error exchanging authorization code for token", http.StatusInternalServerError)
-	}
-
-	// Create an HTTP client that uses the GitHub API token.
-	c := s.github.Client(context.Background(), token)
-
-	// Use the client to get the consented user's info from the GitHub API.
-	githubUser, err := getGithubUser(c)
-	if err != nil {
-		return E(err, "could not get user from GitHub API", http.StatusInternalServerError)
-	}
+func (s *server) handleLoginCallback(p login.Provider) handler {
+	return func(w http.ResponseWriter, r *http.Request) *Error {
+		session, err := s.sessions.Get(r, sessionName)
+		if err != nil {
+			return E(err, "error getting session", http.StatusInternalServerError)
+		}
+		a, err := fromSessionGetAttempt(session, p)
+		if err != nil {
+			return E(err, "no login attempt in session", http.StatusForbidden)
+		}
+		// The attempt's secrets are single use.
+		clearSessionAttempt(session)
+		if err := session.Save(r, w); err != nil {
+			return E(err, "error saving session", http.StatusInternalServerError)
+		}
 
-	user, err := s.store.PutGithubUser(githubUser)
-	if err != nil {
-		return E(err, "error storing github user", http.StatusInternalServerError)
-	}
+		// Check that the state token returned from the provider is the same
+		// as the one we generated.
+		if !a.CheckState(r.FormValue("state")) {
+			s.audit(r, petfind.AuditLoginFailed, 0, p.Name()+": invalid oauth state")
+			return E(nil, "invalid oauth state", http.StatusForbidden)
+		}
 
-	session.Values["userID"] = user.ID
-	delete(session.Values, "state")
-	if err := session.Save(r, w); err != nil {
-		return E(err, "error saving session", http.StatusInternalServerError)
-	}
+		// The user denied consent or the provider had a problem.
+		if e := r.FormValue("error"); e != "" {
+			log.Printf("login with %s failed: %s %s", p.Name(), e, r.FormValue("error_description"))
+			s.audit(r, petfind.AuditLoginFailed, 0, p.Name()+": "+e)
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return nil
+		}
 
-	redirectPath, err := fromSessionGetRedirectPath(session)
-	if err != nil {
-		log.Println("no redirectPath in session")
-		http.Redirect(w, r, "/", http.StatusFound)
-		return nil
-	}
-	http.Redirect(w, r, redirectPath, http.StatusFound)
+		identity, err := p.Identity(r.Context(), r.FormValue("code"), a)
+		if err != nil {
+			return E(err, "could not get user from "+p.Title(), http.StatusInternalServerError)
+		}
 
-	return nil
-}
+		// The attempt was started from the accounts page to connect one more
+		// provider to the logged in user.
+		if linkUserID, ok := session.Values["linkUserID"].(int64); ok {
+			delete(session.Values, "linkUserID")
+			return s.linkIdentity(w, r, session, linkUserID, identity)
+		}
 
-func getGithubUser(client *http.Client) (*petfind.GithubUser, error) {
-	resp, err := client.Get("https://api.github.com/user")
-	if err != nil {
-		return nil, err
-	}
+		user, err := s.store.PutIdentity(identity)
+		if err != nil {
+			return E(err, "error storing "+p.Name()+" user", http.StatusInternalServerError)
+		}
 
-	user := new(petfind.GithubUser)
-	if err := json.NewDecoder(resp.Body).Decode(user); err != nil {
-		return nil, fmt.Errorf("could not decode user: %v", err)
+		return s.completeLogin(w, r, session, user.ID, p.Name())
 	}
-	return user, nil
 }
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+type deletedUserStore struct {
+	petfind.Store
+	events []*petfind.AuditEvent
+}
+
+func (s *deletedUserStore) GetUser(userID int64) (*petfind.User, error) {
+	return nil, petfind.ErrNotFound
+}
+
+func (s *deletedUserStore) AddAuditEvent(e *petfind.AuditEvent) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestAuthDeletedUser(t *testing.T) {
+	store := &deletedUserStore{}
+	s := &server{
+		store:     store,
+		sessions:  sessions.NewCookieStore([]byte("test-hash-key-of-at-least-32-bytes")),
+		redirects: newRedirectCodec([]byte("test-hash-key-of-at-least-32-bytes")),
+	}
+
+	// A logged in session of a user who has since been merged into another
+	// one.
+	r := httptest.NewRequest("GET", "/me/favorites", nil)
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values["userID"] = int64(9)
+	session.Values["created"] = time.Now().UTC().Unix()
+	w := httptest.NewRecorder()
+	if err := session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("GET", "/me/favorites", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	called := false
+	h := s.auth(func(w http.ResponseWriter, r *http.Request) *Error {
+		called = true
+		return nil
+	})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if called {
+		t.Error("handler called with the session of a deleted user")
+	}
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/login" {
+		t.Errorf("session of deleted user = %d to %q, expected %d to /login", w.Code, w.Header().Get("Location"), http.StatusFound)
+	}
+	if len(store.events) != 1 || store.events[0].Kind != petfind.AuditSessionInvalid || store.events[0].ActorID != 9 {
+		t.Errorf("recorded events %#v, expected a %s event of user 9", store.events, petfind.AuditSessionInvalid)
+	}
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+const (
+	// contactRevealLimit is how many contact details a visitor can ask to
+	// see within contactRevealWindow.
+	contactRevealLimit  = 10
+	contactRevealWindow = time.Hour
+)
+
+// revealPage is shown on /pets/contact/reveal.
+type revealPage struct {
+	Pet *petfind.Pet
+}
+
+// handleRevealContact shows the contact details of a pet whose owner wants
+// them shown only to visitors who ask. Every request is logged and each
+// visitor can only ask for a few contacts in a while so that the details
+// cannot be scraped.
+func (s *server) handleRevealContact(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, _ := fromContextGetUser(r.Context())
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		return E(err, "invalid pet id", http.StatusBadRequest)
+	}
+	pet, err := s.store.GetPet(id)
+	if err == petfind.ErrNotFound || (err == nil && pet.Status != petfind.PetListed) {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error getting pet", http.StatusInternalServerError)
+	}
+	if pet.ContactShownTo(user) {
+		return s.render(w, r, s.templates.reveal, &revealPage{Pet: pet}, nil)
+	}
+	if pet.ContactVisibility != petfind.ContactOnRequest {
+		return E(nil, "The owner does not share the contact details of this pet on request", http.StatusForbidden)
+	}
+
+	key := s.visitorKey(r, user)
+	n, err := s.store.CountContactReveals(key, time.Now().Add(-contactRevealWindow))
+	if err != nil {
+		return E(err, "error counting contact reveals", http.StatusInternalServerError)
+	}
+	if n >= contactRevealLimit {
+		return E(nil, "You have asked for too many contact details. Please try again later.", http.StatusTooManyRequests)
+	}
+	reveal := &petfind.ContactReveal{PetID: pet.ID, VisitorKey: key, IP: fromContextGetClient(r).IP}
+	if user != nil {
+		reveal.UserID = user.ID
+	}
+	if err := s.store.AddContactReveal(reveal); err != nil {
+		return E(err, "error logging contact reveal", http.StatusInternalServerError)
+	}
+	return s.render(w, r, s.templates.reveal, &revealPage{Pet: pet}, nil)
+}
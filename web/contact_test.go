@@ -0,0 +1,44 @@
+package web
+
+import "testing"
+
+func TestValidPhone(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		valid bool
+	}{
+		{"", "", true},
+		{"  ", "", true},
+		{" 210 123 4567 ", "+302101234567", true},
+		{"+44 20 7946 0958", "+442079460958", true},
+		{"12345", "", false},
+		{"call me maybe", "", false},
+		{"+30 210 1234567 1234567 1234567 1234567", "", false},
+	}
+	for _, tt := range tests {
+		got, valid, _ := validPhone(tt.in)
+		if got != tt.want || valid != tt.valid {
+			t.Errorf("validPhone(%q) = %q, %v, want %q, %v", tt.in, got, valid, tt.want, tt.valid)
+		}
+	}
+}
+
+func TestValidContactEmail(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		valid bool
+	}{
+		{"", "", true},
+		{" Jane@Example.com ", "jane@example.com", true},
+		{"jane", "", false},
+		{"Jane Doe <jane@example.com>", "", false},
+	}
+	for _, tt := range tests {
+		got, valid, _ := validContactEmail(tt.in)
+		if got != tt.want || valid != tt.valid {
+			t.Errorf("validContactEmail(%q) = %q, %v, want %q, %v", tt.in, got, valid, tt.want, tt.valid)
+		}
+	}
+}
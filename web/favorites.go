@@ -0,0 +1,147 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// favoritesPage is shown on /me/favorites.
+type favoritesPage struct {
+	Pets    []*petfind.Pet
+	Message string
+}
+
+var favoriteMessages = map[string]string{
+	"added":   "The pet was saved to your favorites.",
+	"removed": "The pet was removed from your favorites.",
+}
+
+func (s *server) serveFavorites(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	pets, err := s.store.GetFavoritePets(user.ID)
+	if err != nil {
+		return E(err, "error getting favorite pets", http.StatusInternalServerError)
+	}
+	page := &favoritesPage{Pets: pets, Message: favoriteMessages[r.FormValue("m")]}
+	return s.render(w, r, s.templates.favorites, page, nil)
+}
+
+func (s *server) handleAddFavorite(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	// Only listed pets can be saved so that the IDs of hidden ones cannot be
+	// probed.
+	pet, err := s.store.GetPet(id)
+	if err == petfind.ErrNotFound || (err == nil && pet.Status != petfind.PetListed) {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error getting pet", http.StatusInternalServerError)
+	}
+	err = s.store.AddFavorite(user.ID, pet.ID)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error adding favorite", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/me/favorites?m=added", http.StatusFound)
+	return nil
+}
+
+func (s *server) handleRemoveFavorite(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	err := s.store.RemoveFavorite(user.ID, id)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Pet is not one of your favorites", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error removing favorite", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/me/favorites?m=removed", http.StatusFound)
+	return nil
+}
+
+// petHook is told when something happens to a pet, along with the users who
+// saved the pet as a favorite, if any.
+type petHook func(r *http.Request, pet *petfind.Pet, event petfind.PetEvent, favoriters []*petfind.User) error
+
+// petChanged runs the server's pet hooks for an event. Failing hooks are only
+// logged so that they do not undo the change that was already made.
+func (s *server) petChanged(r *http.Request, pet *petfind.Pet, event petfind.PetEvent) {
+	if len(s.petHooks) == 0 {
+		return
+	}
+	favoriters, err := s.store.GetPetFavoriters(pet.ID)
+	if err != nil {
+		log.Printf("error getting favoriters of pet %d: %v", pet.ID, err)
+		return
+	}
+	for _, hook := range s.petHooks {
+		if err := hook(r, pet, event, favoriters); err != nil {
+			log.Printf("error running hook for %s pet %d: %v", event, pet.ID, err)
+		}
+	}
+}
+
+var petEventSubjects = map[petfind.PetEvent]string{
+	petfind.PetUpdated: "%s has been updated",
+	petfind.PetAdopted: "%s has found a home",
+	petfind.PetRemoved: "%s is no longer listed",
+}
+
+var petEventBodies = map[petfind.PetEvent]string{
+	petfind.PetUpdated: "The listing of %s, one of your favorite pets, has changed. See your favorites:\n\n%s\n",
+	petfind.PetAdopted: "Good news: the owner of %s, one of your favorite pets, has accepted an application to adopt it.\n\nSee your other favorites:\n\n%s\n",
+	petfind.PetRemoved: "%s, one of your favorite pets, has been removed from petfind.\n\nSee your other favorites:\n\n%s\n",
+}
+
+// mailFavoriters is the pet hook that emails the users who saved the pet.
+func (s *server) mailFavoriters(r *http.Request, pet *petfind.Pet, event petfind.PetEvent, favoriters []*petfind.User) error {
+	subject, ok := petEventSubjects[event]
+	if !ok {
+		return nil
+	}
+	link := s.baseURL(r) + "/me/favorites"
+	for _, u := range favoriters {
+		// Users that logged in with a provider might have no email address.
+		if u.Email == "" {
+			continue
+		}
+		m := &mail.Message{
+			To:      u.Email,
+			Subject: fmt.Sprintf(subject, pet.Name),
+			Body:    "Hi " + u.Name + ",\n\n" + fmt.Sprintf(petEventBodies[event], pet.Name, link),
+		}
+		if err := s.sendMail(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
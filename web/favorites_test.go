@@ -0,0 +1,43 @@
+package web
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+type recordingSender struct{ sent []*mail.Message }
+
+func (s *recordingSender) Send(m *mail.Message) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func TestMailFavoriters(t *testing.T) {
+	sender := &recordingSender{}
+	s := &server{mailer: sender, siteURL: "https://petfind.example.com"}
+	r := httptest.NewRequest("POST", "/pets/remove", nil)
+	pet := &petfind.Pet{ID: 1, Name: "Blinky"}
+	favoriters := []*petfind.User{
+		{ID: 1, Name: "John", Email: "john@example.com"},
+		// Users without an email address are skipped.
+		{ID: 2, Name: "Nick"},
+	}
+
+	for _, event := range []petfind.PetEvent{petfind.PetUpdated, petfind.PetAdopted, petfind.PetRemoved} {
+		sender.sent = nil
+		if err := s.mailFavoriters(r, pet, event, favoriters); err != nil {
+			t.Fatalf("mailFavoriters(%s) failed: %v", event, err)
+		}
+		if len(sender.sent) != 1 {
+			t.Fatalf("mailFavoriters(%s) sent %d messages, want 1", event, len(sender.sent))
+		}
+		m := sender.sent[0]
+		if m.To != "john@example.com" || !strings.Contains(m.Subject, "Blinky") || !strings.Contains(m.Body, "https://petfind.example.com/me/favorites") {
+			t.Errorf("mailFavoriters(%s) sent %#v", event, m)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+const (
+	// magicLinkTTL is how long a sign in link is valid for.
+	magicLinkTTL = 15 * time.Minute
+	// At most magicLinkLimit sign in links are sent to the same address
+	// within magicLinkWindow so that the form cannot be used to flood
+	// someone's inbox.
+	magicLinkLimit  = 3
+	magicLinkWindow = time.Hour
+)
+
+// handleMagicLinkRequest emails a single-use sign in link. Addresses without
+// an account get one too; following the link creates the account. The
+// response is the same whatever happens so that it does not reveal who has an
+// account.
+func (s *server) handleMagicLinkRequest(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	email := normalizeEmail(r.PostFormValue("email"))
+	if valid, _ := validEmail(email); !valid {
+		page := &loginPage{Buttons: s.loginButtons(), Email: email, MagicErr: "Please enter a valid email address."}
+		return s.render(w, r, s.templates.login, page, nil)
+	}
+
+	sent, err := s.store.CountTokens(petfind.TokenMagicLink, email, time.Now().Add(-magicLinkWindow))
+	if err != nil {
+		return E(err, "error counting sign in links", http.StatusInternalServerError)
+	}
+	if sent >= magicLinkLimit {
+		log.Printf("throttling sign in links to %s from %s", email, fromContextGetClient(r).IP)
+		http.Redirect(w, r, "/login?m=magic", http.StatusFound)
+		return nil
+	}
+
+	var userID int64
+	user, err := s.store.GetUserByIdentity(petfind.EmailProvider, email)
+	switch {
+	case err == nil:
+		userID = user.ID
+	case err != petfind.ErrNotFound:
+		return E(err, "error getting user", http.StatusInternalServerError)
+	}
+	token, err := s.newToken(petfind.TokenMagicLink, userID, email, magicLinkTTL)
+	if err != nil {
+		return E(err, "error creating sign in link", http.StatusInternalServerError)
+	}
+	err = s.sendMail(&mail.Message{
+		To:      email,
+		Subject: "Sign in to petfind",
+		Body: "Follow this link to sign in to petfind:\n\n" +
+			s.baseURL(r) + "/login/email/confirm?t=" + token + "\n\n" +
+			"The link can be used once and expires in 15 minutes. If you didn't ask for it, you can ignore this email.\n",
+	})
+	if err != nil {
+		return E(err, "error sending sign in link", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/login?m=magic", http.StatusFound)
+	return nil
+}
+
+// serveMagicLinkConfirm asks the user to confirm signing in. The token is not
+// used on GET because mail scanners that prefetch links would otherwise burn
+// it before the user gets to click.
+func (s *server) serveMagicLinkConfirm(w http.ResponseWriter, r *http.Request) *Error {
+	token := r.FormValue("t")
+	if err := s.checkToken(petfind.TokenMagicLink, token); err != nil {
+		http.Redirect(w, r, "/login?m=expired", http.StatusFound)
+		return nil
+	}
+	// Keep the token out of the Referer of any request the page makes.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	return s.render(w, r, s.templates.magicLink, token, nil)
+}
+
+func (s *server) handleMagicLinkLogin(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	t, err := s.useToken(petfind.TokenMagicLink, r.PostFormValue("t"))
+	if err != nil {
+		log.Println("sign in link failed:", err)
+		s.audit(r, petfind.AuditLoginFailed, 0, "email link: "+err.Error())
+		http.Redirect(w, r, "/login?m=expired", http.StatusFound)
+		return nil
+	}
+
+	// The account is looked up again as it might have been created or
+	// linked to someone else since the link was sent.
+	user, err := s.store.GetUserByIdentity(petfind.EmailProvider, t.Email)
+	if err == petfind.ErrNotFound {
+		user, err = s.store.PutIdentity(&petfind.Identity{
+			Provider: petfind.EmailProvider,
+			Subject:  t.Email,
+			Name:     strings.SplitN(t.Email, "@", 2)[0],
+			Email:    t.Email,
+		})
+	}
+	if err != nil {
+		return E(err, "error getting user for sign in link", http.StatusInternalServerError)
+	}
+	// Following the link proves ownership of the address. If the account
+	// was not verified yet, it may have been set up by someone else with
+	// the owner's address, so it loses the password and anything else they
+	// could log in with.
+	if err := s.store.ClaimEmail(user.ID, t.Email); err != nil && err != petfind.ErrNotFound {
+		return E(err, "error verifying email", http.StatusInternalServerError)
+	}
+
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+	return s.completeLogin(w, r, session, user.ID, "email link")
+}
@@ -0,0 +1,199 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// maxMessageLength is how many characters a message can have.
+const maxMessageLength = 2000
+
+func validMessage(body string) (bool, invalidReason) {
+	if body == "" {
+		return false, "Message cannot be empty."
+	}
+	if utf8.RuneCountInString(body) > maxMessageLength {
+		return false, "Message cannot be longer than 2000 characters."
+	}
+	return true, ""
+}
+
+// messagePetPage is shown on /pets/message where an adopter writes to the
+// owner of a pet for the first time.
+type messagePetPage struct {
+	Pet     *petfind.Pet
+	Body    string
+	BodyErr string
+}
+
+// getMessagedPet returns the pet the user wants to write about. Users can
+// only write about listed pets and not about their own.
+func (s *server) getMessagedPet(r *http.Request, user *petfind.User) (*petfind.Pet, *Error) {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return nil, E(err, "invalid pet id", http.StatusBadRequest)
+	}
+	pet, err := s.store.GetPet(id)
+	if err == petfind.ErrNotFound || (err == nil && pet.Status != petfind.PetListed) {
+		return nil, E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, E(err, "error getting pet", http.StatusInternalServerError)
+	}
+	if pet.OwnedBy(user) {
+		return nil, E(nil, "You cannot send a message about your own pet", http.StatusBadRequest)
+	}
+	return pet, nil
+}
+
+func (s *server) serveMessagePet(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	pet, e := s.getMessagedPet(r, user)
+	if e != nil {
+		return e
+	}
+	return s.render(w, r, s.templates.messagePet, &messagePetPage{Pet: pet}, nil)
+}
+
+func (s *server) handleMessagePet(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	pet, e := s.getMessagedPet(r, user)
+	if e != nil {
+		return e
+	}
+	page := &messagePetPage{Pet: pet, Body: strings.TrimSpace(r.PostFormValue("body"))}
+	if valid, reason := validMessage(page.Body); !valid {
+		page.BodyErr = reason.String()
+		return s.render(w, r, s.templates.messagePet, page, nil)
+	}
+
+	c := &petfind.Conversation{PetID: pet.ID, AdopterID: user.ID}
+	err := s.store.StartConversation(c, &petfind.Message{Body: page.Body})
+	if err == petfind.ErrNotFound {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error starting conversation", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/messages/view?id="+strconv.FormatInt(c.ID, 10), http.StatusFound)
+	return nil
+}
+
+// inboxPage is shown on /messages.
+type inboxPage struct {
+	Conversations []*petfind.Conversation
+}
+
+func (s *server) serveInbox(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	conversations, err := s.store.GetUserConversations(user.ID)
+	if err != nil {
+		return E(err, "error getting conversations", http.StatusInternalServerError)
+	}
+	return s.render(w, r, s.templates.inbox, &inboxPage{Conversations: conversations}, nil)
+}
+
+// conversationPage is shown on /messages/view.
+type conversationPage struct {
+	Conversation *petfind.Conversation
+	Pet          *petfind.Pet
+	Messages     []*petfind.Message
+	// ShowContact is true if the viewer may see the pet's contact details.
+	ShowContact bool
+	Body        string
+	BodyErr     string
+}
+
+// getConversation returns the conversation with the ID in the request if the
+// user takes part in it. Conversations of others are reported as missing so
+// that their IDs cannot be probed.
+func (s *server) getConversation(r *http.Request, user *petfind.User) (*petfind.Conversation, *Error) {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return nil, E(err, "invalid conversation id", http.StatusBadRequest)
+	}
+	c, err := s.store.GetConversation(id, user.ID)
+	if err == petfind.ErrNotFound || (err == nil && !c.HasParticipant(user.ID)) {
+		return nil, E(nil, "Conversation does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, E(err, "error getting conversation", http.StatusInternalServerError)
+	}
+	return c, nil
+}
+
+func (s *server) renderConversation(w http.ResponseWriter, r *http.Request, user *petfind.User, page *conversationPage) *Error {
+	c := page.Conversation
+	pet, err := s.store.GetPet(c.PetID)
+	if err != nil {
+		return E(err, "error getting pet of conversation", http.StatusInternalServerError)
+	}
+	messages, err := s.store.GetMessages(c.ID)
+	if err != nil {
+		return E(err, "error getting messages", http.StatusInternalServerError)
+	}
+	if c.Unread {
+		if err := s.store.MarkConversationRead(c.ID, user.ID); err != nil {
+			return E(err, "error marking conversation as read", http.StatusInternalServerError)
+		}
+	}
+	page.Pet = pet
+	page.Messages = messages
+	page.ShowContact = c.ContactVisibleTo(pet, user.ID)
+	return s.render(w, r, s.templates.conversation, page, nil)
+}
+
+func (s *server) serveConversation(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	c, e := s.getConversation(r, user)
+	if e != nil {
+		return e
+	}
+	return s.renderConversation(w, r, user, &conversationPage{Conversation: c})
+}
+
+func (s *server) handleSendMessage(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	c, e := s.getConversation(r, user)
+	if e != nil {
+		return e
+	}
+	body := strings.TrimSpace(r.PostFormValue("body"))
+	if valid, reason := validMessage(body); !valid {
+		return s.renderConversation(w, r, user, &conversationPage{Conversation: c, Body: body, BodyErr: reason.String()})
+	}
+	err := s.store.AddMessage(&petfind.Message{ConversationID: c.ID, SenderID: user.ID, Body: body})
+	if err == petfind.ErrNotFound {
+		return E(nil, "Conversation does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error sending message", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/messages/view?id="+strconv.FormatInt(c.ID, 10), http.StatusFound)
+	return nil
+}
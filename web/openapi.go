@@ -0,0 +1,161 @@
+package web
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// openAPIDocument describes the routes of the JSON API in an OpenAPI 3
+// document. The schemas of the responses are generated from their Go types
+// and their json struct tags.
+func openAPIDocument(routes []apiRoute) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	errorSchema := schemaOf(reflect.TypeOf(apiErrorResponse{}), schemas)
+
+	paths := make(map[string]interface{})
+	for _, rt := range routes {
+		var params []interface{}
+		for _, p := range rt.Params {
+			schema := map[string]interface{}{"type": p.Type}
+			if p.Type == "integer" {
+				schema["format"] = "int64"
+			}
+			if p.Enum != nil {
+				schema["enum"] = p.Enum
+			}
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      schema,
+			})
+		}
+		op := map[string]interface{}{
+			"operationId": rt.OperationID,
+			"summary":     rt.Summary,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "OK",
+					"content":     jsonContent(schemaOf(reflect.TypeOf(rt.Response), schemas)),
+				},
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content":     jsonContent(errorSchema),
+				},
+			},
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if rt.Auth {
+			op["security"] = []interface{}{
+				map[string]interface{}{"session": []string{}},
+				map[string]interface{}{"token": []string{}},
+			}
+		}
+		// Bearer tokens only have scopes in OAuth 2 so the scope a personal
+		// API token needs is described instead.
+		op["description"] = fmt.Sprintf("API tokens need the %s scope.", rt.Scope)
+		item, ok := paths[rt.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[rt.Path] = item
+		}
+		item[strings.ToLower(rt.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "petfind API",
+			"version": strings.TrimPrefix(apiPrefix, "/api/"),
+		},
+		"servers": []interface{}{map[string]interface{}{"url": apiPrefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": sessionName},
+				"token": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A personal API token created on /me/tokens.",
+				},
+			},
+		},
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the schema of t. Structs are added to schemas under their
+// name without the api prefix, e.g. Pet for apiPet, and referred to.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() == reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Struct:
+		name := schemaName(t)
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if _, ok := schemas[name]; ok {
+			return ref
+		}
+		// Reserve the name first in case the struct refers to itself.
+		schemas[name] = nil
+		properties := make(map[string]interface{})
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := strings.Split(f.Tag.Get("json"), ",")
+			if f.PkgPath != "" || tag[0] == "-" {
+				continue
+			}
+			fieldName := tag[0]
+			if fieldName == "" {
+				fieldName = f.Name
+			}
+			properties[fieldName] = schemaOf(f.Type, schemas)
+			if len(tag) < 2 || tag[1] != "omitempty" {
+				required = append(required, fieldName)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if required != nil {
+			schema["required"] = required
+		}
+		schemas[name] = schema
+		return ref
+	}
+	panic("no OpenAPI schema for " + t.String())
+}
+
+// schemaName returns the name of the schema of a struct, e.g. PetsResponse for
+// apiPetsResponse.
+func schemaName(t reflect.Type) string {
+	name := []rune(strings.TrimPrefix(t.Name(), "api"))
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
@@ -0,0 +1,359 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/psimika/secure-web-app/petfind"
+	"github.com/psimika/secure-web-app/webauthn"
+)
+
+// passkeyChallengeTTL is how long the challenge of a passkey registration or
+// login is accepted for. It matches the time the browser waits for the user.
+const passkeyChallengeTTL = webauthn.Timeout * time.Millisecond
+
+// relyingParty returns the site as seen by WebAuthn. Like emailed links it
+// prefers the configured site URL so that credentials are bound to the real
+// domain.
+func (s *server) relyingParty(r *http.Request) (*webauthn.RelyingParty, error) {
+	origin := s.baseURL(r)
+	u, err := url.Parse(origin)
+	if err != nil {
+		return nil, err
+	}
+	return &webauthn.RelyingParty{ID: u.Hostname(), Name: "petfind", Origin: origin}, nil
+}
+
+// newSessionWithChallenge stores a new WebAuthn challenge for the given
+// ceremony ("login" or "register") in the session and returns it.
+func newSessionWithChallenge(session *sessions.Session, ceremony string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	session.Values["webauthnChallenge"] = base64.RawURLEncoding.EncodeToString(challenge)
+	session.Values["webauthnCeremony"] = ceremony
+	session.Values["webauthnStarted"] = time.Now().UTC().Unix()
+	return challenge, nil
+}
+
+// fromSessionUseChallenge returns the WebAuthn challenge stored in the
+// session for the ceremony and removes it so that it is used only once. It
+// will return an error if there is none or it has expired.
+func fromSessionUseChallenge(session *sessions.Session, ceremony string) ([]byte, error) {
+	encoded, _ := session.Values["webauthnChallenge"].(string)
+	c, _ := session.Values["webauthnCeremony"].(string)
+	started, _ := session.Values["webauthnStarted"].(int64)
+	for _, key := range []string{"webauthnChallenge", "webauthnCeremony", "webauthnStarted"} {
+		delete(session.Values, key)
+	}
+	if encoded == "" || c != ceremony {
+		return nil, fmt.Errorf("session has no %s challenge", ceremony)
+	}
+	if time.Unix(started, 0).Add(passkeyChallengeTTL).Before(time.Now()) {
+		return nil, fmt.Errorf("%s challenge expired", ceremony)
+	}
+	return base64.RawURLEncoding.DecodeString(encoded)
+}
+
+// userHandle is the user handle stored on authenticators. The user's ID is
+// not personal information, which the WebAuthn specification forbids here.
+func userHandle(userID int64) []byte {
+	return []byte(strconv.FormatInt(userID, 10))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) *Error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return E(err, "error encoding JSON", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// handlePasskeyLoginOptions starts a passkey login. The page calls it with
+// fetch and passes the options it returns to navigator.credentials.get().
+func (s *server) handlePasskeyLoginOptions(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	rp, err := s.relyingParty(r)
+	if err != nil {
+		return E(err, "error getting relying party", http.StatusInternalServerError)
+	}
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+	challenge, err := newSessionWithChallenge(session, "login")
+	if err != nil {
+		return E(err, "error creating challenge", http.StatusInternalServerError)
+	}
+	if err := session.Save(r, w); err != nil {
+		return E(err, "error saving session", http.StatusInternalServerError)
+	}
+	return writeJSON(w, rp.RequestOptions(challenge, nil))
+}
+
+// handlePasskeyLogin logs in with the passkey assertion posted by the login
+// page. As user verification is required the passkey counts as both factors
+// and two-factor authentication is not asked for.
+func (s *server) handlePasskeyLogin(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	rp, err := s.relyingParty(r)
+	if err != nil {
+		return E(err, "error getting relying party", http.StatusInternalServerError)
+	}
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+	challenge, err := fromSessionUseChallenge(session, "login")
+	if err != nil {
+		return E(err, "no passkey login in session", http.StatusForbidden)
+	}
+	if err := session.Save(r, w); err != nil {
+		return E(err, "error saving session", http.StatusInternalServerError)
+	}
+
+	failed := func(err error) *Error {
+		log.Println("passkey login failed:", err)
+		s.audit(r, petfind.AuditLoginFailed, 0, "passkey: "+err.Error())
+		page := &loginPage{Buttons: s.loginButtons(), PasskeyErr: "Your passkey could not be used to log in."}
+		return s.render(w, r, s.templates.login, page, nil)
+	}
+	var resp webauthn.AssertionResponse
+	if err := json.Unmarshal([]byte(r.PostFormValue("credential")), &resp); err != nil {
+		return failed(err)
+	}
+	id, err := resp.CredentialID()
+	if err != nil {
+		return failed(err)
+	}
+	c, err := s.store.GetWebAuthnCredential(id)
+	if err == petfind.ErrNotFound {
+		return failed(fmt.Errorf("unknown credential"))
+	}
+	if err != nil {
+		return E(err, "error getting passkey", http.StatusInternalServerError)
+	}
+	if h, err := resp.UserHandle(); err == nil && len(h) != 0 && string(h) != string(userHandle(c.UserID)) {
+		return failed(fmt.Errorf("user handle does not match credential %d", c.ID))
+	}
+	count, err := rp.VerifyAssertion(challenge, &webauthn.Credential{
+		ID:        c.CredentialID,
+		PublicKey: c.PublicKey,
+		SignCount: uint32(c.SignCount),
+	}, &resp)
+	if err != nil {
+		return failed(err)
+	}
+	err = s.store.UseWebAuthnCredential(c.ID, int64(count))
+	if err == petfind.ErrNotFound {
+		return failed(webauthn.ErrSignCount)
+	}
+	if err != nil {
+		return E(err, "error updating passkey", http.StatusInternalServerError)
+	}
+
+	if disabled, e := s.redirectIfDisabled(w, r, c.UserID); disabled || e != nil {
+		return e
+	}
+	if err := s.loginSession(w, r, session, c.UserID, "passkey"); err != nil {
+		return E(err, "error logging in session", http.StatusInternalServerError)
+	}
+	s.redirectAfterLogin(w, r, session)
+	return nil
+}
+
+// passkeysPage is shown on /me/passkeys.
+type passkeysPage struct {
+	Passkeys []*petfind.WebAuthnCredential
+	// AskCode is set when adding a passkey needs a two-factor code as the
+	// user has two-factor authentication and did not log in recently.
+	AskCode bool
+	Message string
+	Err     string
+}
+
+var passkeyMessages = map[string]string{
+	"added":   "The passkey was added. You can now use it to log in.",
+	"removed": "The passkey was removed.",
+}
+
+func (s *server) servePasskeys(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	page := &passkeysPage{Message: passkeyMessages[r.FormValue("m")]}
+	return s.renderPasskeys(w, r, user, page)
+}
+
+func (s *server) renderPasskeys(w http.ResponseWriter, r *http.Request, user *petfind.User, page *passkeysPage) *Error {
+	list, err := s.store.GetUserWebAuthnCredentials(user.ID)
+	if err != nil {
+		return E(err, "error getting passkeys", http.StatusInternalServerError)
+	}
+	page.Passkeys = list
+	if !s.recentLogin(r) {
+		tf, err := s.twoFactorPage(user)
+		if err != nil {
+			return E(err, "error getting two-factor settings", http.StatusInternalServerError)
+		}
+		page.AskCode = tf.Enabled
+	}
+	return s.render(w, r, s.templates.passkeys, page, nil)
+}
+
+// handlePasskeyOptions starts registering a passkey for the logged in user.
+// The options are harmless on their own; the second factor is checked by
+// handleAddPasskey before the passkey is stored.
+func (s *server) handlePasskeyOptions(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	rp, err := s.relyingParty(r)
+	if err != nil {
+		return E(err, "error getting relying party", http.StatusInternalServerError)
+	}
+	list, err := s.store.GetUserWebAuthnCredentials(user.ID)
+	if err != nil {
+		return E(err, "error getting passkeys", http.StatusInternalServerError)
+	}
+	exclude := make([][]byte, 0, len(list))
+	for _, c := range list {
+		exclude = append(exclude, c.CredentialID)
+	}
+
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+	challenge, err := newSessionWithChallenge(session, "register")
+	if err != nil {
+		return E(err, "error creating challenge", http.StatusInternalServerError)
+	}
+	if err := session.Save(r, w); err != nil {
+		return E(err, "error saving session", http.StatusInternalServerError)
+	}
+
+	name := user.Email
+	if name == "" {
+		name = user.Login
+	}
+	u := webauthn.User{ID: userHandle(user.ID), Name: name, DisplayName: user.Name}
+	return writeJSON(w, rp.CreationOptions(u, challenge, exclude))
+}
+
+// handleAddPasskey stores the passkey registered by the browser. A passkey
+// logs in without a second factor so users with two-factor authentication
+// must enter a code, unless they logged in recently, to keep a hijacked
+// session from turning into a permanent way in.
+func (s *server) handleAddPasskey(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	rp, err := s.relyingParty(r)
+	if err != nil {
+		return E(err, "error getting relying party", http.StatusInternalServerError)
+	}
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+	challenge, err := fromSessionUseChallenge(session, "register")
+	if err != nil {
+		return E(err, "no passkey registration in session", http.StatusForbidden)
+	}
+	if err := session.Save(r, w); err != nil {
+		return E(err, "error saving session", http.StatusInternalServerError)
+	}
+
+	failed := func(err error) *Error {
+		log.Println("passkey registration failed:", err)
+		return s.renderPasskeys(w, r, user, &passkeysPage{Err: "The passkey could not be added. Please try again."})
+	}
+	if !s.recentLogin(r) {
+		t, page, e := s.confirmSecondFactor(r, user)
+		if e != nil {
+			return e
+		}
+		if t == nil && page.Enabled {
+			s.audit(r, petfind.AuditPasskey, user.ID, "add rejected: invalid two-factor code")
+			msg := "Please enter a valid code from your authenticator app or a recovery code to add a passkey."
+			if page.Err == secondFactorLockedErr {
+				msg = page.Err
+			}
+			return s.renderPasskeys(w, r, user, &passkeysPage{Err: msg})
+		}
+	}
+	var resp webauthn.AttestationResponse
+	if err := json.Unmarshal([]byte(r.PostFormValue("credential")), &resp); err != nil {
+		return failed(err)
+	}
+	c, err := rp.VerifyRegistration(challenge, &resp)
+	if err != nil {
+		return failed(err)
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" || len(name) > 70 {
+		name = "Passkey"
+	}
+	pk := &petfind.WebAuthnCredential{
+		UserID:       user.ID,
+		CredentialID: c.ID,
+		PublicKey:    c.PublicKey,
+		SignCount:    int64(c.SignCount),
+		Name:         name,
+	}
+	if err := s.store.AddWebAuthnCredential(pk); err != nil {
+		return E(err, "error storing passkey", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditPasskey, user.ID, fmt.Sprintf("added passkey %d %q", pk.ID, name))
+	http.Redirect(w, r, "/me/passkeys?m=added", http.StatusFound)
+	return nil
+}
+
+func (s *server) handleRemovePasskey(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		return E(err, "invalid passkey id", http.StatusBadRequest)
+	}
+	err = s.store.DeleteWebAuthnCredential(user.ID, id)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Passkey does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error removing passkey", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditPasskey, user.ID, fmt.Sprintf("removed passkey %d", id))
+	http.Redirect(w, r, "/me/passkeys?m=removed", http.StatusFound)
+	return nil
+}
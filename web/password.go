@@ -0,0 +1,365 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/password"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+const (
+	// verifyEmailTTL is how long an email verification link is valid for.
+	verifyEmailTTL = 48 * time.Hour
+	// resetPasswordTTL is how long a password reset link is valid for. It is
+	// kept short as the link grants full access to the account.
+	resetPasswordTTL = time.Hour
+)
+
+// loginPage is shown on /login.
+type loginPage struct {
+	Buttons    []loginButton
+	Message    string
+	Email      string
+	Err        string
+	MagicErr   string
+	PasskeyErr string
+}
+
+var loginMessages = map[string]string{
+	"verify":    "Check your email for a link to verify your address.",
+	"verified":  "Your email address is verified. You can now log in.",
+	"forgot":    "If an account exists for that address, we have sent it a link to reset the password.",
+	"reset":     "Your password was changed and you were logged out everywhere. Log in with the new password.",
+	"expired":   "That link is invalid or has expired.",
+	"magic":     "If the address is valid, we have sent it a link to sign in. The link expires in 15 minutes.",
+	"twofactor": "Your sign in expired or had too many wrong codes. Please log in again.",
+	"disabled":  "This account has been disabled. Contact the site's administrators if you think this is a mistake.",
+}
+
+// errBadLogin is deliberately vague so that it does not reveal whether an
+// account exists for an email address.
+const errBadLogin = "Invalid email or password."
+
+// handlePasswordLogin logs in a local account. Every failure takes the same
+// path through password.Compare so that the response time does not reveal
+// whether the account exists.
+func (s *server) handlePasswordLogin(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	email := normalizeEmail(r.PostFormValue("email"))
+	pass := r.PostFormValue("password")
+
+	var hash []byte
+	user, err := s.store.GetUserByIdentity(petfind.EmailProvider, email)
+	switch {
+	case err == nil:
+		hash, err = s.store.GetPassword(user.ID)
+		if err != nil && err != petfind.ErrNotFound {
+			return E(err, "error getting password", http.StatusInternalServerError)
+		}
+	case err != petfind.ErrNotFound:
+		return E(err, "error getting user", http.StatusInternalServerError)
+	}
+	if err := password.Compare(hash, pass); err != nil {
+		var actorID int64
+		if user != nil {
+			actorID = user.ID
+		}
+		s.audit(r, petfind.AuditLoginFailed, actorID, "password: "+email)
+		page := &loginPage{Buttons: s.loginButtons(), Email: email, Err: errBadLogin}
+		return s.render(w, r, s.templates.login, page, nil)
+	}
+	if !user.EmailVerified {
+		s.audit(r, petfind.AuditLoginFailed, user.ID, "password: email not verified")
+		page := &loginPage{Buttons: s.loginButtons(), Email: email, Err: "Please verify your email address first. Check your email for the link we sent you."}
+		return s.render(w, r, s.templates.login, page, nil)
+	}
+
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+	return s.completeLogin(w, r, session, user.ID, "password")
+}
+
+type signupForm struct {
+	Invalid     bool
+	Name        string
+	NameErr     string
+	Email       string
+	EmailErr    string
+	PasswordErr string
+}
+
+func (s *server) serveSignup(w http.ResponseWriter, r *http.Request) *Error {
+	return s.render(w, r, s.templates.signup, nil, signupForm{})
+}
+
+func (s *server) handleSignup(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+
+	form := signupForm{}
+	form.Name = strings.TrimSpace(r.PostFormValue("name"))
+	if valid, reason := validUserName(form.Name); !valid {
+		form.Invalid = true
+		form.NameErr = reason.String()
+	}
+	form.Email = normalizeEmail(r.PostFormValue("email"))
+	if valid, reason := validEmail(form.Email); !valid {
+		form.Invalid = true
+		form.EmailErr = reason.String()
+	}
+	pass := r.PostFormValue("password")
+	if err := s.passwords.Check(pass, form.Email); err != nil {
+		form.Invalid = true
+		form.PasswordErr = err.Error()
+	} else if pass != r.PostFormValue("confirm") {
+		form.Invalid = true
+		form.PasswordErr = "Passwords do not match."
+	}
+	if form.Invalid {
+		return s.render(w, r, s.templates.signup, nil, form)
+	}
+
+	hash, err := password.Hash(pass)
+	if err != nil {
+		return E(err, "error hashing password", http.StatusInternalServerError)
+	}
+	user := &petfind.User{Name: form.Name, Email: form.Email}
+	err = s.store.CreateLocalUser(user, hash)
+	if err == petfind.ErrEmailTaken {
+		// Respond exactly as if the account was created so that the form
+		// cannot be used to find out who has an account. The owner of the
+		// address is told instead.
+		err = s.sendMail(&mail.Message{
+			To:      form.Email,
+			Subject: "Sign up attempt on petfind",
+			Body: "Someone tried to create a petfind account with this email address, which already has one.\n\n" +
+				"If it was you, you can reset your password at " + s.baseURL(r) + "/password/forgot\n\n" +
+				"If it wasn't, you can ignore this email.\n",
+		})
+		if err != nil {
+			return E(err, "error sending sign up attempt email", http.StatusInternalServerError)
+		}
+		http.Redirect(w, r, "/login?m=verify", http.StatusFound)
+		return nil
+	}
+	if err != nil {
+		return E(err, "error creating user", http.StatusInternalServerError)
+	}
+
+	if err := s.sendVerifyEmail(r, user); err != nil {
+		return E(err, "error sending verification email", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/login?m=verify", http.StatusFound)
+	return nil
+}
+
+func (s *server) sendVerifyEmail(r *http.Request, user *petfind.User) error {
+	token, err := s.newToken(petfind.TokenVerifyEmail, user.ID, user.Email, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	data := struct{ Name, Link string }{user.Name, s.baseURL(r) + "/email/verify?t=" + token}
+	m, err := s.emails.Render("verify", user.Email, data)
+	if err != nil {
+		return err
+	}
+	return s.sendMail(m)
+}
+
+func (s *server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) *Error {
+	t, err := s.useToken(petfind.TokenVerifyEmail, r.FormValue("t"))
+	if err != nil {
+		log.Println("email verification failed:", err)
+		http.Redirect(w, r, "/login?m=expired", http.StatusFound)
+		return nil
+	}
+	err = s.store.VerifyEmail(t.UserID, t.Email)
+	if err == petfind.ErrNotFound {
+		http.Redirect(w, r, "/login?m=expired", http.StatusFound)
+		return nil
+	}
+	if err != nil {
+		return E(err, "error verifying email", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/login?m=verified", http.StatusFound)
+	return nil
+}
+
+func (s *server) serveForgotPassword(w http.ResponseWriter, r *http.Request) *Error {
+	return s.render(w, r, s.templates.forgotPassword, nil, nil)
+}
+
+// handleForgotPassword emails a password reset link if the address has a
+// local account. The response is the same either way.
+func (s *server) handleForgotPassword(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	email := normalizeEmail(r.PostFormValue("email"))
+	if valid, _ := validEmail(email); valid {
+		user, err := s.store.GetUserByIdentity(petfind.EmailProvider, email)
+		switch {
+		case err == nil:
+			if err := s.sendResetPassword(r, user, email); err != nil {
+				return E(err, "error sending password reset email", http.StatusInternalServerError)
+			}
+		case err != petfind.ErrNotFound:
+			return E(err, "error getting user", http.StatusInternalServerError)
+		}
+	}
+	http.Redirect(w, r, "/login?m=forgot", http.StatusFound)
+	return nil
+}
+
+func (s *server) sendResetPassword(r *http.Request, user *petfind.User, email string) error {
+	token, err := s.newToken(petfind.TokenResetPassword, user.ID, email, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	return s.sendMail(&mail.Message{
+		To:      email,
+		Subject: "Reset your petfind password",
+		Body: "Someone asked to reset the password of your petfind account.\n\n" +
+			"If it was you, choose a new password by following this link:\n\n" +
+			s.baseURL(r) + "/password/reset?t=" + token + "\n\n" +
+			"The link expires in one hour. If it wasn't you, you can ignore this email.\n",
+	})
+}
+
+type resetPasswordForm struct {
+	Token       string
+	PasswordErr string
+}
+
+func (s *server) serveResetPassword(w http.ResponseWriter, r *http.Request) *Error {
+	token := r.FormValue("t")
+	// Only check the signature here; the token is used when the form is
+	// submitted.
+	if err := s.checkToken(petfind.TokenResetPassword, token); err != nil {
+		http.Redirect(w, r, "/login?m=expired", http.StatusFound)
+		return nil
+	}
+	// Keep the token out of the Referer of any request the page makes.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	return s.render(w, r, s.templates.resetPassword, nil, resetPasswordForm{Token: token})
+}
+
+func (s *server) handleResetPassword(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	form := resetPasswordForm{Token: r.PostFormValue("t")}
+	if err := s.checkToken(petfind.TokenResetPassword, form.Token); err != nil {
+		http.Redirect(w, r, "/login?m=expired", http.StatusFound)
+		return nil
+	}
+	pass := r.PostFormValue("password")
+	if err := s.passwords.Check(pass, ""); err != nil {
+		form.PasswordErr = err.Error()
+		return s.render(w, r, s.templates.resetPassword, nil, form)
+	}
+	if pass != r.PostFormValue("confirm") {
+		form.PasswordErr = "Passwords do not match."
+		return s.render(w, r, s.templates.resetPassword, nil, form)
+	}
+
+	t, err := s.useToken(petfind.TokenResetPassword, form.Token)
+	if err != nil {
+		log.Println("password reset failed:", err)
+		http.Redirect(w, r, "/login?m=expired", http.StatusFound)
+		return nil
+	}
+	if err := s.passwords.Check(pass, t.Email); err != nil {
+		form.PasswordErr = err.Error()
+		return s.render(w, r, s.templates.resetPassword, nil, form)
+	}
+	hash, err := password.Hash(pass)
+	if err != nil {
+		return E(err, "error hashing password", http.StatusInternalServerError)
+	}
+	if err := s.store.PutPassword(t.UserID, hash); err != nil {
+		return E(err, "error storing password", http.StatusInternalServerError)
+	}
+	// Following the link proves ownership of the address.
+	if err := s.store.VerifyEmail(t.UserID, t.Email); err != nil && err != petfind.ErrNotFound {
+		return E(err, "error verifying email", http.StatusInternalServerError)
+	}
+	// Whoever knew the old password must not stay logged in nor keep the
+	// API tokens they could have created with it.
+	if err := s.store.DeleteUserSessions(t.UserID); err != nil {
+		return E(err, "error revoking user sessions", http.StatusInternalServerError)
+	}
+	if err := s.store.DeleteUserAPITokens(t.UserID); err != nil {
+		return E(err, "error revoking API tokens", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditPasswordReset, t.UserID, "with the link sent to "+t.Email)
+	http.Redirect(w, r, "/login?m=reset", http.StatusFound)
+	return nil
+}
+
+// sendMail validates and sends m, logging instead of failing if sending did
+// not work so that the user does not learn more than they should from an
+// error page.
+func (s *server) sendMail(m *mail.Message) error {
+	if err := m.Validate(); err != nil {
+		return fmt.Errorf("invalid mail: %v", err)
+	}
+	if err := s.mailer.Send(m); err != nil {
+		log.Printf("error sending mail %q: %v", m.Subject, err)
+	}
+	return nil
+}
+
+// baseURL returns the URL of the site for use in emailed links. The
+// configured site URL is preferred because the Host header is controlled by
+// the client and could be used to point password reset links to an
+// attacker's site.
+func (s *server) baseURL(r *http.Request) string {
+	if s.siteURL != "" {
+		return s.siteURL
+	}
+	c := fromContextGetClient(r)
+	return c.Scheme + "://" + c.Host
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func validEmail(email string) (bool, invalidReason) {
+	if email == "" {
+		return false, "Email is required."
+	}
+	if len(email) > 70 {
+		return false, "Email cannot be longer than 70 characters."
+	}
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false, "Please enter a valid email address."
+	}
+	return true, ""
+}
+
+func validUserName(name string) (bool, invalidReason) {
+	if name == "" {
+		return false, "Name cannot be empty."
+	}
+	if len(name) > 70 {
+		return false, "Name cannot be longer than 70 characters."
+	}
+	if strings.ContainsAny(name, "<>\r\n") {
+		return false, "Name cannot contain the characters < and > or line breaks."
+	}
+	return true, ""
+}
@@ -0,0 +1,148 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// client holds the details of the original HTTP request as sent by the user's
+// browser. When the application runs behind a reverse proxy (Heroku's router,
+// a local nginx), the request we receive comes from the proxy and the original
+// details are carried in the X-Forwarded-* headers instead.
+type client struct {
+	IP     string
+	Scheme string
+	Host   string
+}
+
+// proxies holds the networks of the reverse proxies we trust to set the
+// X-Forwarded-* headers. Headers from any other peer are ignored since they
+// can be trivially spoofed by the client.
+type proxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma or space separated list of CIDRs or
+// single IP addresses (e.g. "10.0.0.0/8, 127.0.0.1") describing the reverse
+// proxies that the application sits behind.
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	for _, f := range fields {
+		if !strings.Contains(f, "/") {
+			ip := net.ParseIP(f)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy IP %q", f)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR %q: %v", f, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (p proxies) trusted(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range p {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve derives the original client details of the request r.
+//
+// The X-Forwarded-For header is walked from right to left since each proxy
+// appends the address of the peer it received the request from. The first
+// address that does not belong to a trusted proxy is the client's. Anything
+// further to the left was supplied by the client and cannot be trusted
+// (OWASP 2017c):
+//
+// https://www.owasp.org/index.php/Testing_for_IP_Spoofing
+func (p proxies) resolve(r *http.Request) *client {
+	c := &client{IP: remoteIP(r.RemoteAddr), Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		c.Scheme = "https"
+	}
+	if !p.trusted(c.IP) {
+		return c
+	}
+
+	// The peer is one of our proxies so we can take into account the headers
+	// it has set.
+	if proto := lastValue(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		c.Scheme = proto
+	}
+	if host := lastValue(r.Header.Get("X-Forwarded-Host")); host != "" {
+		c.Host = host
+	}
+
+	forwarded := r.Header["X-Forwarded-For"]
+	var hops []string
+	for _, v := range forwarded {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				hops = append(hops, h)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := remoteIP(hops[i])
+		if net.ParseIP(ip) == nil {
+			// Garbage in the header; stop at the last address we could
+			// verify.
+			break
+		}
+		c.IP = ip
+		if !p.trusted(ip) {
+			break
+		}
+	}
+	return c
+}
+
+// remoteIP strips the port, if any, from addr.
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// lastValue returns the right-most value of a comma separated header, which is
+// the one set by the proxy closest to us.
+func lastValue(v string) string {
+	if i := strings.LastIndex(v, ","); i != -1 {
+		v = v[i+1:]
+	}
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+// fromContextGetClient retrieves the client details from the context. If they
+// are missing, it falls back to the details of the request itself.
+func fromContextGetClient(r *http.Request) *client {
+	if c, ok := r.Context().Value(clientContextKey).(*client); ok {
+		return c
+	}
+	return proxies(nil).resolve(r)
+}
+
+// newContextWithClient adds the client details to the context.
+func newContextWithClient(ctx context.Context, c *client) context.Context {
+	return context.WithValue(ctx, clientContextKey, c)
+}
@@ -0,0 +1,90 @@
+package web
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+var resolveTests = []struct {
+	remoteAddr string
+	tls        bool
+	headers    map[string]string
+	want       client
+}{
+	{
+		// Direct connection, no proxy.
+		"203.0.113.7:1234", false, nil,
+		client{IP: "203.0.113.7", Scheme: "http", Host: "example.com"},
+	},
+	{
+		// Direct TLS connection.
+		"203.0.113.7:1234", true, nil,
+		client{IP: "203.0.113.7", Scheme: "https", Host: "example.com"},
+	},
+	{
+		// Untrusted peer trying to spoof headers.
+		"203.0.113.7:1234", false,
+		map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.com"},
+		client{IP: "203.0.113.7", Scheme: "http", Host: "example.com"},
+	},
+	{
+		// Trusted proxy.
+		"10.1.2.3:5678", false,
+		map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "petfind.example.com"},
+		client{IP: "198.51.100.1", Scheme: "https", Host: "petfind.example.com"},
+	},
+	{
+		// Client prepends a fake address; the proxy appends the real one.
+		"10.1.2.3:5678", false,
+		map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1"},
+		client{IP: "198.51.100.1", Scheme: "http", Host: "example.com"},
+	},
+	{
+		// Chain of trusted proxies.
+		"127.0.0.1:5678", false,
+		map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.9"},
+		client{IP: "198.51.100.1", Scheme: "http", Host: "example.com"},
+	},
+	{
+		// Garbage in the header is ignored.
+		"10.1.2.3:5678", false,
+		map[string]string{"X-Forwarded-For": "<script>, 198.51.100.1"},
+		client{IP: "198.51.100.1", Scheme: "http", Host: "example.com"},
+	},
+	{
+		// Trusted proxy without X-Forwarded-For.
+		"10.1.2.3:5678", false, nil,
+		client{IP: "10.1.2.3", Scheme: "http", Host: "example.com"},
+	},
+}
+
+func TestProxiesResolve(t *testing.T) {
+	p, err := ParseTrustedProxies("10.0.0.0/8, 127.0.0.1")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+	for i, tt := range resolveTests {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		} else {
+			r.TLS = nil
+		}
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got, want := *proxies(p).resolve(r), tt.want; got != want {
+			t.Errorf("resolve #%d \nhave: %#v\nwant: %#v", i, got, want)
+		}
+	}
+}
+
+func TestParseTrustedProxies_invalid(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "localhost", "1.2.3"} {
+		if _, err := ParseTrustedProxies(s); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) expected error", s)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// redirectName is the session key and the securecookie name under which
+	// the post-login redirect is stored.
+	redirectName = "redirect"
+	// redirectMaxAge is how many seconds a user has to complete the login
+	// before the stored redirect is no longer honored.
+	redirectMaxAge = 600
+)
+
+// newRedirectCodec returns the codec used to sign the post-login redirect so
+// that it cannot be tampered with and expires after redirectMaxAge.
+func newRedirectCodec(hashKey []byte) *securecookie.SecureCookie {
+	return securecookie.New(hashKey, nil).MaxAge(redirectMaxAge)
+}
+
+// safeRedirect checks that target is a relative URL on this site and returns
+// it in normalized form. Absolute URLs ("https://evil.com"), scheme-relative
+// URLs ("//evil.com") and their backslash variants which some browsers treat
+// as slashes are rejected to prevent open redirects (OWASP 2017d):
+//
+// https://www.owasp.org/index.php/Unvalidated_Redirects_and_Forwards_Cheat_Sheet
+//
+// Any path is accepted as the target is only ever taken from a request the
+// user made themselves and it is signed when stored.
+func safeRedirect(target string) (string, bool) {
+	if target == "" || target[0] != '/' {
+		return "", false
+	}
+	if strings.HasPrefix(target, "//") || strings.ContainsAny(target, "\\\r\n\t") {
+		return "", false
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || u.Opaque != "" {
+		return "", false
+	}
+	u.Fragment = ""
+	uri := u.RequestURI()
+	if !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") {
+		return "", false
+	}
+	return uri, true
+}
+
+// storeRedirect remembers the URL of r in the session so that the user can be
+// sent back to it after logging in. Only GET requests are remembered; anything
+// else is silently ignored.
+func (s *server) storeRedirect(r *http.Request, session *sessions.Session) error {
+	delete(session.Values, redirectName)
+	if r.Method != "GET" {
+		return nil
+	}
+	target, ok := safeRedirect(r.URL.RequestURI())
+	if !ok {
+		return nil
+	}
+	signed, err := s.redirects.Encode(redirectName, target)
+	if err != nil {
+		return fmt.Errorf("error signing redirect: %v", err)
+	}
+	session.Values[redirectName] = signed
+	return nil
+}
+
+// redirectToLogin remembers where the user was trying to go and sends them to
+// the login page.
+func (s *server) redirectToLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session) *Error {
+	if err := s.storeRedirect(r, session); err != nil {
+		return E(err, "error storing redirect", http.StatusInternalServerError)
+	}
+	if err := session.Save(r, w); err != nil {
+		return E(err, "error storing redirect in session", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/login", http.StatusFound)
+	return nil
+}
+
+// redirectAfterLogin sends the user to the URL stored by storeRedirect or to
+// the home page if there is none or it has expired.
+func (s *server) redirectAfterLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session) {
+	target := "/"
+	if signed, err := fromSessionGetString(session, redirectName); err == nil {
+		var v string
+		if err := s.redirects.Decode(redirectName, signed, &v); err != nil {
+			log.Println("ignoring stored redirect:", err)
+		} else if safe, ok := safeRedirect(v); ok {
+			target = safe
+		}
+	}
+	delete(session.Values, redirectName)
+	if err := session.Save(r, w); err != nil {
+		log.Println("error removing redirect from session:", err)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,63 @@
+package web
+
+import "testing"
+
+var safeRedirectTests = []struct {
+	in   string
+	want string
+	ok   bool
+}{
+	{"/", "/", true},
+	{"/pets/add", "/pets/add", true},
+	{"/search/submit?place=1&type=2", "/search/submit?place=1&type=2", true},
+	{"/pets/add#top", "/pets/add", true},
+	{"/messages/view?id=2", "/messages/view?id=2", true},
+	// Routes added later need no changes here.
+	{"/me/some/new/page?x=1", "/me/some/new/page?x=1", true},
+	{"", "", false},
+	{"pets/add", "", false},
+	{"//evil.com", "", false},
+	{"//evil.com/pets/add", "", false},
+	{"/\\evil.com", "", false},
+	{"\\\\evil.com", "", false},
+	{"https://evil.com/pets/add", "", false},
+	{"javascript:alert(1)", "", false},
+	{"/pets/add\r\nSet-Cookie: x=y", "", false},
+	// Encoded slashes stay encoded so the browser keeps them in the path.
+	{"/%2F%2Fevil.com", "/%2F%2Fevil.com", true},
+}
+
+func TestSafeRedirect(t *testing.T) {
+	for _, tt := range safeRedirectTests {
+		got, ok := safeRedirect(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("safeRedirect(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRedirectCodec(t *testing.T) {
+	c := newRedirectCodec([]byte("0123456789abcdef0123456789abcdef"))
+	signed, err := c.Encode(redirectName, "/pets/add?x=1")
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	var v string
+	if err := c.Decode(redirectName, signed, &v); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if got, want := v, "/pets/add?x=1"; got != want {
+		t.Fatalf("Decode = %q, want %q", got, want)
+	}
+
+	// A value signed with a different key must be rejected.
+	other := newRedirectCodec([]byte("fedcba9876543210fedcba9876543210"))
+	forged, err := other.Encode(redirectName, "/pets/add")
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if err := c.Decode(redirectName, forged, &v); err == nil {
+		t.Fatal("Decode accepted a value signed with a different key")
+	}
+}
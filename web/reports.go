@@ -0,0 +1,274 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// reportHideThreshold is how many different logged in users have to report a
+// pet before it is hidden until a moderator looks at it. Reports of visitors
+// who are not logged in only go to the moderators.
+const reportHideThreshold = 3
+
+// reportPage is shown on /pets/report.
+type reportPage struct {
+	Pet        *petfind.Pet
+	Reasons    []petfind.ReportReason
+	Reason     petfind.ReportReason
+	Details    string
+	DetailsErr string
+	ReasonErr  string
+	Message    string
+	Done       bool
+}
+
+// visitorKey identifies a visitor, for example one who reports a pet: the user
+// if they are logged in or else their IP address. It is keyed with a server
+// secret so that the reports do not hold the addresses of visitors, which
+// would otherwise be easy to recover as there are few of them.
+func (s *server) visitorKey(r *http.Request, user *petfind.User) string {
+	key := "ip:" + fromContextGetClient(r).IP
+	if user != nil {
+		key = "user:" + strconv.FormatInt(user.ID, 10)
+	}
+	mac := hmac.New(sha256.New, s.visitorSecret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newVisitorSecret derives the secret of visitorKey from the server's hash
+// key so that the hash key is not used for two different things.
+func newVisitorSecret(hashKey []byte) []byte {
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte("visitor key"))
+	return mac.Sum(nil)
+}
+
+func (s *server) getReportedPet(r *http.Request) (*petfind.Pet, *Error) {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return nil, E(err, "invalid pet id", http.StatusBadRequest)
+	}
+	// Only listed pets can be reported so that the IDs of hidden ones cannot
+	// be probed.
+	pet, err := s.store.GetPet(id)
+	if err == petfind.ErrNotFound || (err == nil && pet.Status != petfind.PetListed) {
+		return nil, E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, E(err, "error getting pet", http.StatusInternalServerError)
+	}
+	return pet, nil
+}
+
+func (s *server) serveReportPet(w http.ResponseWriter, r *http.Request) *Error {
+	pet, e := s.getReportedPet(r)
+	if e != nil {
+		return e
+	}
+	page := &reportPage{Pet: pet, Reasons: petfind.ReportReasons}
+	return s.render(w, r, s.templates.report, page, nil)
+}
+
+func (s *server) handleReportPet(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	pet, e := s.getReportedPet(r)
+	if e != nil {
+		return e
+	}
+	page := &reportPage{Pet: pet, Reasons: petfind.ReportReasons}
+
+	reason, err := strconv.ParseInt(r.PostFormValue("reason"), 10, 64)
+	page.Reason = petfind.ReportReason(reason)
+	if err != nil || !page.Reason.Valid() {
+		page.ReasonErr = "Please choose what is wrong with the listing."
+	}
+	page.Details = strings.TrimSpace(r.PostFormValue("details"))
+	if utf8.RuneCountInString(page.Details) > 1000 {
+		page.DetailsErr = "Details cannot be longer than 1000 characters."
+	}
+	if page.ReasonErr != "" || page.DetailsErr != "" {
+		return s.render(w, r, s.templates.report, page, nil)
+	}
+
+	user, _ := fromContextGetUser(r.Context())
+	report := &petfind.Report{
+		PetID:       pet.ID,
+		Reason:      page.Reason,
+		Details:     page.Details,
+		ReporterKey: s.visitorKey(r, user),
+	}
+	if user != nil {
+		report.ReporterID = user.ID
+	}
+	hidden, err := s.store.AddReport(report, reportHideThreshold)
+	switch err {
+	case nil:
+		page.Message = "Thank you. Our moderators will look at the listing."
+	case petfind.ErrReported:
+		page.Message = "You have already reported this listing. Our moderators will look at it."
+	default:
+		return E(err, "error adding report", http.StatusInternalServerError)
+	}
+	if hidden {
+		log.Printf("pet %d hidden after %d reports", pet.ID, reportHideThreshold)
+	}
+	page.Done = true
+	return s.render(w, r, s.templates.report, page, nil)
+}
+
+// reportedPet is a pet in the moderation queue together with its open
+// reports.
+type reportedPet struct {
+	Pet     *petfind.Pet
+	Reports []*petfind.Report
+}
+
+// moderationPage is shown on /moderation.
+type moderationPage struct {
+	Pending []*petfind.Pet
+	Queue   []reportedPet
+	Actions []*petfind.ModerationAction
+	Message string
+}
+
+var moderationMessages = map[string]string{
+	"hide":    "The listing was hidden.",
+	"restore": "The listing was restored.",
+	"dismiss": "The reports were dismissed.",
+	"approve": "The pet was approved and its owner notified.",
+	"reject":  "The pet was rejected and its owner notified.",
+}
+
+// moderationTrailSize is how many of the latest actions the moderation page
+// shows.
+const moderationTrailSize = 50
+
+func (s *server) serveModeration(w http.ResponseWriter, r *http.Request) *Error {
+	pending, err := s.store.GetPendingPets()
+	if err != nil {
+		return E(err, "error getting pending pets", http.StatusInternalServerError)
+	}
+	reports, err := s.store.GetOpenReports()
+	if err != nil {
+		return E(err, "error getting reports", http.StatusInternalServerError)
+	}
+	// The reports are ordered by pet so each pet's reports are together.
+	var queue []reportedPet
+	for _, rep := range reports {
+		if n := len(queue); n > 0 && queue[n-1].Pet.ID == rep.PetID {
+			queue[n-1].Reports = append(queue[n-1].Reports, rep)
+			continue
+		}
+		pet, err := s.store.GetPet(rep.PetID)
+		if err == petfind.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return E(err, "error getting reported pet", http.StatusInternalServerError)
+		}
+		queue = append(queue, reportedPet{Pet: pet, Reports: []*petfind.Report{rep}})
+	}
+	actions, err := s.store.GetModerationActions(moderationTrailSize)
+	if err != nil {
+		return E(err, "error getting moderation actions", http.StatusInternalServerError)
+	}
+	page := &moderationPage{Pending: pending, Queue: queue, Actions: actions, Message: moderationMessages[r.FormValue("m")]}
+	return s.render(w, r, s.templates.moderation, page, nil)
+}
+
+// handleModerate applies an action of kind to the pet posted by a moderator.
+func (s *server) handleModerate(kind petfind.ModerationKind) handler {
+	return func(w http.ResponseWriter, r *http.Request) *Error {
+		if r.Method != "POST" {
+			return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		user, ok := fromContextGetUser(r.Context())
+		if !ok || user == nil {
+			return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+		id, e := postFormID(r, "id")
+		if e != nil {
+			return e
+		}
+		note := strings.TrimSpace(r.PostFormValue("note"))
+		if utf8.RuneCountInString(note) > 1000 {
+			return E(nil, "Note cannot be longer than 1000 characters", http.StatusBadRequest)
+		}
+		a := &petfind.ModerationAction{PetID: id, Kind: kind, ModeratorID: user.ID, Note: note}
+		err := s.store.ModeratePet(a)
+		if err == petfind.ErrNotFound {
+			return E(nil, "Pet does not exist", http.StatusNotFound)
+		}
+		if err == petfind.ErrTransition {
+			return E(nil, "That action does not apply to the pet anymore", http.StatusConflict)
+		}
+		if err != nil {
+			return E(err, "error moderating pet", http.StatusInternalServerError)
+		}
+		s.audit(r, petfind.AuditPetUpdate, user.ID, fmt.Sprintf("%s pet %d %q", kind, a.PetID, a.PetName))
+		var event petfind.PetEvent
+		switch kind {
+		case petfind.ModerationHide, petfind.ModerationRestore:
+			event = petfind.PetUpdated
+		case petfind.ModerationApprove:
+			// Approved pets are listed for the first time.
+			event = petfind.PetCreated
+		}
+		if event != "" {
+			if pet, err := s.store.GetPet(a.PetID); err == nil {
+				s.petChanged(r, pet, event)
+			} else {
+				log.Printf("error getting moderated pet %d: %v", a.PetID, err)
+			}
+		}
+		if kind == petfind.ModerationApprove || kind == petfind.ModerationReject {
+			if err := s.notifyOwner(r, a); err != nil {
+				return E(err, "error notifying owner", http.StatusInternalServerError)
+			}
+		}
+		http.Redirect(w, r, "/moderation?m="+string(kind), http.StatusFound)
+		return nil
+	}
+}
+
+// notifyOwner tells the owner of a pending pet what the moderator decided.
+func (s *server) notifyOwner(r *http.Request, a *petfind.ModerationAction) error {
+	pet, err := s.store.GetPet(a.PetID)
+	if err != nil {
+		return err
+	}
+	// Users that logged in with a provider might have no email address.
+	if pet.Owner.Email == "" {
+		log.Printf("owner %d of pet %d has no email address to notify", pet.OwnerID, pet.ID)
+		return nil
+	}
+	m := &mail.Message{
+		To:      pet.Owner.Email,
+		Subject: "Your pet " + pet.Name + " is now listed on petfind",
+		Body: "Hi " + pet.Owner.Name + ",\n\n" +
+			"A moderator approved " + pet.Name + " and it is now listed on petfind:\n\n" +
+			s.baseURL(r) + "/\n",
+	}
+	if a.Kind == petfind.ModerationReject {
+		m.Subject = "Your pet " + pet.Name + " was not approved"
+		m.Body = "Hi " + pet.Owner.Name + ",\n\n" +
+			"A moderator did not approve " + pet.Name + " so it will not be listed on petfind.\n"
+		if a.Note != "" {
+			m.Body += "\nThe moderator said:\n\n" + a.Note + "\n"
+		}
+	}
+	return s.sendMail(m)
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestVisitorKey(t *testing.T) {
+	r1 := httptest.NewRequest("POST", "/pets/report/submit", nil)
+	r1.RemoteAddr = "198.51.100.1:1234"
+	r2 := httptest.NewRequest("POST", "/pets/report/submit", nil)
+	r2.RemoteAddr = "198.51.100.2:1234"
+	jane := &petfind.User{ID: 1}
+	s := &server{visitorSecret: newVisitorSecret([]byte("test-hash-key"))}
+
+	if s.visitorKey(r1, nil) == s.visitorKey(r2, nil) {
+		t.Errorf("visitors from different addresses have the same visitor key")
+	}
+	if s.visitorKey(r1, jane) != s.visitorKey(r2, jane) {
+		t.Errorf("a logged in user has a different visitor key on each address")
+	}
+	if s.visitorKey(r1, jane) == s.visitorKey(r1, nil) {
+		t.Errorf("a logged in user has the visitor key of their address")
+	}
+	if got := len(s.visitorKey(r1, nil)); got != 64 {
+		t.Errorf("visitor key is %d characters long, want 64", got)
+	}
+	// Without the secret the key of an address cannot be recomputed.
+	other := &server{visitorSecret: newVisitorSecret([]byte("other-hash-key"))}
+	if s.visitorKey(r1, nil) == other.visitorKey(r1, nil) {
+		t.Errorf("servers with different secrets have the same visitor key")
+	}
+}
+
+func TestReportUnlistedPet(t *testing.T) {
+	s := newAPITestServer()
+	for _, tt := range []struct {
+		h   handler
+		req *http.Request
+	}{
+		{s.serveReportPet, httptest.NewRequest("GET", "/pets/report?id=1", nil)},
+		{s.handleReportPet, httptest.NewRequest("POST", "/pets/report/submit?id=1", nil)},
+	} {
+		e := tt.h(httptest.NewRecorder(), tt.req)
+		if e == nil || e.Code != http.StatusNotFound {
+			t.Errorf("%s %s returned %v, expected %d", tt.req.Method, tt.req.URL, e, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/psimika/secure-web-app/alerts"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// maxSavedSearches is how many searches a user can save.
+const maxSavedSearches = 20
+
+// savedSearchesPage is shown on /me/searches.
+type savedSearchesPage struct {
+	Searches []*savedSearch
+	Message  string
+}
+
+type savedSearch struct {
+	*petfind.SavedSearch
+	// Link runs the search.
+	Link string
+}
+
+var savedSearchMessages = map[string]string{
+	"added":   "The search was saved. We will email you when new pets match it.",
+	"removed": "The search was removed.",
+}
+
+func (s *server) serveSavedSearches(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	searches, err := s.store.GetUserSavedSearches(user.ID)
+	if err != nil {
+		return E(err, "error getting saved searches", http.StatusInternalServerError)
+	}
+	page := &savedSearchesPage{Message: savedSearchMessages[r.FormValue("m")]}
+	for _, ss := range searches {
+		page.Searches = append(page.Searches, &savedSearch{ss, alerts.SearchPath(ss.Search)})
+	}
+	return s.render(w, r, s.templates.savedSearches, page, nil)
+}
+
+func (s *server) handleAddSavedSearch(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	search, form := s.parseSearch(r)
+	if form.Invalid {
+		return s.render(w, r, s.templates.search, nil, form)
+	}
+	searches, err := s.store.GetUserSavedSearches(user.ID)
+	if err != nil {
+		return E(err, "error getting saved searches", http.StatusInternalServerError)
+	}
+	if len(searches) >= maxSavedSearches {
+		return E(nil, "You cannot save more than 20 searches. Remove one first.", http.StatusBadRequest)
+	}
+	place := s.findPlaceByKey(search.PlaceKey)
+	ss := &petfind.SavedSearch{
+		UserID: user.ID,
+		Name:   search.Describe(place.Name),
+		Search: search,
+	}
+	if err := s.store.AddSavedSearch(ss); err != nil {
+		return E(err, "error saving search", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/me/searches?m=added", http.StatusFound)
+	return nil
+}
+
+func (s *server) handleRemoveSavedSearch(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	err := s.store.DeleteSavedSearch(id, user.ID)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Search does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error removing saved search", http.StatusInternalServerError)
+	}
+	http.Redirect(w, r, "/me/searches?m=removed", http.StatusFound)
+	return nil
+}
+
+// unsubscribePage is shown on /searches/unsubscribe where the links of the
+// emailed digests lead. The user does not need to be logged in as the signed
+// token proves they received the digest.
+type unsubscribePage struct {
+	Token string
+	// Search is the search to unsubscribe from. It is nil if the search was
+	// already removed.
+	Search *petfind.SavedSearch
+	Done   bool
+}
+
+// getUnsubscribeSearch checks the unsubscribe token of the request and
+// returns the saved search it is for or nil if the search no longer exists.
+func (s *server) getUnsubscribeSearch(token string) (*petfind.SavedSearch, *Error) {
+	searchID, userID, err := s.alertLinks.ParseUnsubscribe(token)
+	if err != nil {
+		return nil, E(err, "Invalid or broken unsubscribe link", http.StatusBadRequest)
+	}
+	ss, err := s.store.GetSavedSearch(searchID)
+	if err == petfind.ErrNotFound || (err == nil && ss.UserID != userID) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, E(err, "error getting saved search", http.StatusInternalServerError)
+	}
+	return ss, nil
+}
+
+func (s *server) serveUnsubscribe(w http.ResponseWriter, r *http.Request) *Error {
+	token := r.FormValue("t")
+	ss, e := s.getUnsubscribeSearch(token)
+	if e != nil {
+		return e
+	}
+	return s.render(w, r, s.templates.unsubscribe, &unsubscribePage{Token: token, Search: ss}, nil)
+}
+
+func (s *server) handleUnsubscribe(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	token := r.PostFormValue("t")
+	ss, e := s.getUnsubscribeSearch(token)
+	if e != nil {
+		return e
+	}
+	page := &unsubscribePage{Search: ss, Done: true}
+	if ss == nil {
+		return s.render(w, r, s.templates.unsubscribe, page, nil)
+	}
+	err := s.store.DeleteSavedSearch(ss.ID, ss.UserID)
+	if err != nil && err != petfind.ErrNotFound {
+		return E(err, "error removing saved search", http.StatusInternalServerError)
+	}
+	return s.render(w, r, s.templates.unsubscribe, page, nil)
+}
@@ -0,0 +1,53 @@
+package web
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/psimika/secure-web-app/alerts"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+type savedSearchStore struct {
+	petfind.Store
+	searches map[int64]*petfind.SavedSearch
+}
+
+func (s *savedSearchStore) GetSavedSearch(searchID int64) (*petfind.SavedSearch, error) {
+	ss, ok := s.searches[searchID]
+	if !ok {
+		return nil, petfind.ErrNotFound
+	}
+	return ss, nil
+}
+
+func TestGetUnsubscribeSearch(t *testing.T) {
+	hashKey := []byte("0123456789abcdef0123456789abcdef")
+	links := alerts.NewLinks(hashKey, "https://petfind.example.com")
+	ss := &petfind.SavedSearch{ID: 1, UserID: 7, Name: "Cat in Χαλκίδα"}
+	s := &server{
+		store:      &savedSearchStore{searches: map[int64]*petfind.SavedSearch{1: ss}},
+		alertLinks: links,
+	}
+	token := func(ss *petfind.SavedSearch) string {
+		tok, err := links.UnsubscribeToken(ss)
+		if err != nil {
+			t.Fatalf("UnsubscribeToken failed: %v", err)
+		}
+		return tok
+	}
+
+	got, e := s.getUnsubscribeSearch(token(ss))
+	if e != nil || got != ss {
+		t.Fatalf("getUnsubscribeSearch = %v, %v, expected %v", got, e, ss)
+	}
+	// A removed search or a token of another user does not reveal anything.
+	for _, other := range []*petfind.SavedSearch{{ID: 2, UserID: 7}, {ID: 1, UserID: 8}} {
+		if got, e := s.getUnsubscribeSearch(token(other)); e != nil || got != nil {
+			t.Errorf("getUnsubscribeSearch for search %d of user %d = %v, %v, expected nil", other.ID, other.UserID, got, e)
+		}
+	}
+	if _, e := s.getUnsubscribeSearch("forged"); e == nil || e.Code != http.StatusBadRequest {
+		t.Errorf("getUnsubscribeSearch for forged token returned %v, expected %d", e, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,239 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// registerSession records a session that has just been logged in, in the
+// application's session registry. Sessions missing from the registry are
+// rejected by auth which is what allows a user to revoke them.
+//
+// The session must have been saved first so that the session store has
+// assigned it an ID.
+func (s *server) registerSession(r *http.Request, session *sessions.Session, userID int64) error {
+	if session.ID == "" {
+		return fmt.Errorf("cannot register session without ID")
+	}
+	c := fromContextGetClient(r)
+	rs := &petfind.Session{
+		KeyHash:   hashSessionID(session.ID),
+		UserID:    userID,
+		IP:        c.IP,
+		UserAgent: r.UserAgent(),
+	}
+	return s.store.AddSession(rs)
+}
+
+// registeredSession returns the registry entry of a logged in session. It
+// returns an error if the session was never registered, has been revoked or
+// belongs to a different user.
+func (s *server) registeredSession(session *sessions.Session, userID int64) (*petfind.Session, error) {
+	if session.ID == "" {
+		return nil, fmt.Errorf("session has no ID")
+	}
+	rs, err := s.store.GetSession(hashSessionID(session.ID))
+	if err == petfind.ErrNotFound {
+		return nil, fmt.Errorf("session has been revoked")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting session from registry: %v", err)
+	}
+	if rs.UserID != userID {
+		return nil, fmt.Errorf("session registered for user %d, not %d", rs.UserID, userID)
+	}
+	return rs, nil
+}
+
+// unregisterSession removes a session from the registry, if it was there.
+func (s *server) unregisterSession(session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+	rs, err := s.store.GetSession(hashSessionID(session.ID))
+	if err == petfind.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.store.DeleteSession(rs.ID)
+}
+
+// sessionKeep lists the session values that survive a session ID rotation.
+// Everything else, including the OAuth state and the user's ID, is dropped.
+var sessionKeep = []string{redirectName}
+
+// rotateSession replaces the session's ID with a fresh one while keeping only
+// the values listed in sessionKeep. It must be used whenever the privilege
+// level of a session changes (login, logout, role change) so that an attacker
+// who managed to plant or learn the old ID gains nothing (OWASP 2017b):
+//
+// https://www.owasp.org/index.php/Session_Management_Cheat_Sheet#Renew_the_Session_ID_After_Any_Privilege_Level_Change
+//
+// The rotation happens in place so that any later call to s.sessions.Get
+// during the same request returns the new session.
+func (s *server) rotateSession(w http.ResponseWriter, r *http.Request, session *sessions.Session) error {
+	if err := s.unregisterSession(session); err != nil {
+		return fmt.Errorf("error removing old session from registry: %v", err)
+	}
+
+	keep := make(map[interface{}]interface{})
+	for _, k := range sessionKeep {
+		if v, ok := session.Values[k]; ok {
+			keep[k] = v
+		}
+	}
+
+	// Delete the old session from the session store. This also sends an
+	// expired cookie which the browser will replace with the new one below.
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		return fmt.Errorf("error deleting old session: %v", err)
+	}
+
+	// An empty ID makes the session store generate a new one on save.
+	session.ID = ""
+	session.IsNew = true
+	session.Values = keep
+	session.Values["created"] = time.Now().UTC().Unix()
+	session.Values["userAgent"] = r.UserAgent()
+	session.Options.MaxAge = s.sessionTTL
+	if err := session.Save(r, w); err != nil {
+		return fmt.Errorf("error saving new session: %v", err)
+	}
+	return nil
+}
+
+// loginSession rotates the session and binds the new one to the user with
+// userID, recording it in the session registry. method is how the user
+// logged in and is recorded in the audit log.
+func (s *server) loginSession(w http.ResponseWriter, r *http.Request, session *sessions.Session, userID int64, method string) error {
+	if err := s.rotateSession(w, r, session); err != nil {
+		return err
+	}
+	session.Values["userID"] = userID
+	if err := session.Save(r, w); err != nil {
+		return fmt.Errorf("error saving session: %v", err)
+	}
+	if err := s.registerSession(r, session, userID); err != nil {
+		return err
+	}
+	s.audit(r, petfind.AuditLogin, userID, method)
+	return nil
+}
+
+// hashSessionID is used so that the registry never holds session IDs which
+// could be used to hijack a session if the database leaked.
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+type sessionItem struct {
+	*petfind.Session
+	Current bool
+}
+
+func (s *server) serveSessions(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+
+	// Sessions older than the absolute timeout are expired regardless of
+	// whether they are still in the registry.
+	since := time.Now().Add(-time.Duration(s.sessionMaxTTL) * time.Second)
+	list, err := s.store.GetUserSessions(user.ID, since)
+	if err != nil {
+		return E(err, "error getting user sessions", http.StatusInternalServerError)
+	}
+	current := hashSessionID(session.ID)
+	items := make([]sessionItem, 0, len(list))
+	for _, rs := range list {
+		items = append(items, sessionItem{Session: rs, Current: rs.KeyHash == current})
+	}
+	return s.render(w, r, s.templates.sessions, items, nil)
+}
+
+func (s *server) handleRevokeSession(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		return E(err, "invalid session id", http.StatusBadRequest)
+	}
+
+	// Make sure the session being revoked belongs to the user.
+	since := time.Now().Add(-time.Duration(s.sessionMaxTTL) * time.Second)
+	list, err := s.store.GetUserSessions(user.ID, since)
+	if err != nil {
+		return E(err, "error getting user sessions", http.StatusInternalServerError)
+	}
+	var found bool
+	for _, rs := range list {
+		if rs.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return E(nil, "Session does not exist", http.StatusNotFound)
+	}
+	if err := s.store.DeleteSession(id); err != nil {
+		return E(err, "error revoking session", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditSessionRevoke, user.ID, fmt.Sprintf("revoked session %d", id))
+
+	http.Redirect(w, r, "/me/sessions", http.StatusFound)
+	return nil
+}
+
+// handleRevokeAllSessions logs the user out everywhere, including the current
+// session, and revokes their API tokens.
+func (s *server) handleRevokeAllSessions(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+
+	if err := s.store.DeleteUserSessions(user.ID); err != nil {
+		return E(err, "error revoking user sessions", http.StatusInternalServerError)
+	}
+	if err := s.store.DeleteUserAPITokens(user.ID); err != nil {
+		return E(err, "error revoking API tokens", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditSessionRevoke, user.ID, "revoked all sessions and API tokens")
+
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+	session.Options.MaxAge = -1
+	if err = session.Save(r, w); err != nil {
+		return E(err, "error deleting session", http.StatusInternalServerError)
+	}
+
+	http.Redirect(w, r, "/", http.StatusFound)
+	return nil
+}
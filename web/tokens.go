@@ -0,0 +1,70 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// tokenMaxAge is the longest any emailed token is accepted for. Each kind of
+// token also gets its own, usually shorter, expiry in the database.
+const tokenMaxAge = 48 * 60 * 60
+
+// newTokenCodec returns the codec used to sign the tokens we send by email so
+// that forged or tampered tokens are rejected before hitting the database.
+func newTokenCodec(hashKey []byte) *securecookie.SecureCookie {
+	return securecookie.New(hashKey, nil).MaxAge(tokenMaxAge)
+}
+
+// newToken creates a single-use token of the given kind for the user and
+// returns it signed, ready to be put in a link. The kind is used as the
+// securecookie name so a token of one kind cannot be used as another.
+func (s *server) newToken(kind string, userID int64, email string, ttl time.Duration) (string, error) {
+	secret := securecookie.GenerateRandomKey(32)
+	if secret == nil {
+		return "", fmt.Errorf("error generating random token")
+	}
+	raw := base64.RawURLEncoding.EncodeToString(secret)
+	t := &petfind.Token{
+		UserID:  userID,
+		Kind:    kind,
+		Hash:    hashToken(raw),
+		Email:   email,
+		Expires: time.Now().Add(ttl),
+	}
+	if err := s.store.AddToken(t); err != nil {
+		return "", fmt.Errorf("error storing token: %v", err)
+	}
+	signed, err := s.tokens.Encode(kind, raw)
+	if err != nil {
+		return "", fmt.Errorf("error signing token: %v", err)
+	}
+	return signed, nil
+}
+
+// checkToken verifies the signature of a token without using it.
+func (s *server) checkToken(kind, signed string) error {
+	var raw string
+	return s.tokens.Decode(kind, signed, &raw)
+}
+
+// useToken verifies the signature of a token and marks it as used. It
+// returns petfind.ErrNotFound if the token has already been used or has
+// expired.
+func (s *server) useToken(kind, signed string) (*petfind.Token, error) {
+	var raw string
+	if err := s.tokens.Decode(kind, signed, &raw); err != nil {
+		return nil, err
+	}
+	return s.store.UseToken(kind, hashToken(raw))
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
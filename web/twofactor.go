@@ -0,0 +1,558 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/psimika/secure-web-app/petfind"
+	"github.com/psimika/secure-web-app/totp"
+	"rsc.io/qr"
+)
+
+const (
+	// twoFactorTTL is how long a user has to enter their code after the
+	// first factor succeeded.
+	twoFactorTTL = 5 * time.Minute
+	// twoFactorMaxAttempts is how many wrong codes are accepted for a
+	// pending login before the user has to start over. With 6 digit codes
+	// this keeps guessing hopeless.
+	twoFactorMaxAttempts = 5
+	// twoFactorFreeAttempts is how many wrong codes in a row a user can
+	// enter, whether logging in or changing their settings, before their
+	// second factor is locked for twoFactorLockout. The lockout doubles with
+	// every further wrong code up to twoFactorMaxLockout. Unlike
+	// twoFactorMaxAttempts this survives starting the login over.
+	twoFactorFreeAttempts = 10
+	twoFactorLockout      = time.Minute
+	twoFactorMaxLockout   = 24 * time.Hour
+	// recoveryCodeCount is how many recovery codes a user gets.
+	recoveryCodeCount = 10
+	// rememberDeviceTTL is how long a device the user asked us to remember
+	// can skip the second factor.
+	rememberDeviceTTL = 30 * 24 * time.Hour
+	// deviceCookieName is the name of the remembered device cookie.
+	deviceCookieName = "device"
+	// totpIssuer is shown in authenticator apps next to the account.
+	totpIssuer = "petfind"
+	// recentLoginTTL is how long after logging in a user can give new
+	// access to their account, e.g. by adding a passkey, without entering
+	// a second factor again.
+	recentLoginTTL = 10 * time.Minute
+)
+
+// newDeviceCodec returns the codec used to sign remembered device cookies.
+func newDeviceCodec(hashKey []byte) *securecookie.SecureCookie {
+	return securecookie.New(hashKey, nil).MaxAge(int(rememberDeviceTTL.Seconds()))
+}
+
+// rememberedDevice is stored in the remembered device cookie. Enrolled ties
+// the cookie to the current enrolment so that turning two-factor
+// authentication off and on again forgets every device.
+type rememberedDevice struct {
+	UserID   int64
+	Enrolled int64
+}
+
+// completeLogin finishes a login whose first factor (a provider, a password
+// or a sign in link) has succeeded. Users with two-factor authentication are
+// sent to the step-up page instead, unless they are on a remembered device.
+// method is the first factor, e.g. "password", for the audit log.
+func (s *server) completeLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session, userID int64, method string) *Error {
+	if disabled, e := s.redirectIfDisabled(w, r, userID); disabled || e != nil {
+		return e
+	}
+	t, err := s.store.GetTOTP(userID)
+	if err != nil && err != petfind.ErrNotFound {
+		return E(err, "error getting two-factor settings", http.StatusInternalServerError)
+	}
+	if err == nil && t.Enabled && !s.isRememberedDevice(r, t) {
+		// The pending login gets a fresh session too. It carries no userID
+		// so auth keeps treating it as logged out.
+		if err := s.rotateSession(w, r, session); err != nil {
+			return E(err, "error rotating session", http.StatusInternalServerError)
+		}
+		session.Values["twoFactorUserID"] = userID
+		session.Values["twoFactorStarted"] = time.Now().UTC().Unix()
+		session.Values["twoFactorMethod"] = method
+		if err := session.Save(r, w); err != nil {
+			return E(err, "error saving session", http.StatusInternalServerError)
+		}
+		http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return nil
+	}
+
+	// Switch to a fresh session ID now that the user is logged in to
+	// prevent session fixation.
+	if err := s.loginSession(w, r, session, userID, method); err != nil {
+		return E(err, "error logging in session", http.StatusInternalServerError)
+	}
+	s.redirectAfterLogin(w, r, session)
+	return nil
+}
+
+// fromSessionGetTwoFactorUserID returns the user of the login waiting for a
+// second factor. It will return an error if there is none or it has expired.
+func fromSessionGetTwoFactorUserID(session *sessions.Session) (int64, error) {
+	userID, ok := session.Values["twoFactorUserID"].(int64)
+	if !ok {
+		return 0, fmt.Errorf("session has no pending two-factor login")
+	}
+	started, ok := session.Values["twoFactorStarted"].(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected twoFactorStarted type")
+	}
+	if time.Unix(started, 0).Add(twoFactorTTL).Before(time.Now()) {
+		return 0, fmt.Errorf("pending two-factor login expired")
+	}
+	return userID, nil
+}
+
+func (s *server) isRememberedDevice(r *http.Request, t *petfind.TOTP) bool {
+	c, err := r.Cookie(deviceCookieName)
+	if err != nil {
+		return false
+	}
+	var d rememberedDevice
+	if err := s.devices.Decode(deviceCookieName, c.Value, &d); err != nil {
+		return false
+	}
+	return d.UserID == t.UserID && d.Enrolled == t.Created.UnixNano()
+}
+
+func (s *server) rememberDevice(w http.ResponseWriter, r *http.Request, t *petfind.TOTP) error {
+	d := rememberedDevice{UserID: t.UserID, Enrolled: t.Created.UnixNano()}
+	v, err := s.devices.Encode(deviceCookieName, d)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookieName,
+		Value:    v,
+		Path:     "/login",
+		MaxAge:   int(rememberDeviceTTL.Seconds()),
+		Secure:   fromContextGetClient(r).Scheme == "https",
+		HttpOnly: true,
+	})
+	return nil
+}
+
+// errSecondFactorLocked is returned by checkSecondFactor while the user's
+// second factor is locked after too many wrong codes.
+var errSecondFactorLocked = errors.New("too many invalid two-factor codes")
+
+// checkSecondFactor reports whether code is a valid authenticator code or an
+// unused recovery code of the user. Either is used up in the process. Wrong
+// codes are counted against the user and errSecondFactorLocked is returned
+// without looking at the code once there have been too many.
+func (s *server) checkSecondFactor(r *http.Request, t *petfind.TOTP, code string) (bool, error) {
+	if time.Now().Before(t.LockedUntil) {
+		return false, errSecondFactorLocked
+	}
+	ok, err := s.useSecondFactor(r, t, code)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		lockedUntil, err := s.store.FailSecondFactor(t.UserID, twoFactorFreeAttempts, twoFactorLockout, twoFactorMaxLockout)
+		if err != nil {
+			return false, err
+		}
+		if time.Now().Before(lockedUntil) {
+			log.Printf("locking second factor of user %d until %v", t.UserID, lockedUntil)
+		}
+		return false, nil
+	}
+	if t.Failures != 0 {
+		if err := s.store.ClearSecondFactorFailures(t.UserID); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// useSecondFactor uses up code. Using a recovery code is recorded in the
+// audit log as it usually means the user lost their device.
+func (s *server) useSecondFactor(r *http.Request, t *petfind.TOTP, code string) (bool, error) {
+	if step, ok := totp.Validate(t.Secret, code, time.Now()); ok {
+		err := s.store.UseTOTPStep(t.UserID, step)
+		if err == petfind.ErrNotFound {
+			// The code has been used before.
+			return false, nil
+		}
+		return err == nil, err
+	}
+	err := s.store.UseRecoveryCode(t.UserID, hashRecoveryCode(code))
+	if err == petfind.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.audit(r, petfind.AuditTwoFactor, t.UserID, "used a recovery code")
+	return true, nil
+}
+
+// newRecoveryCodes returns recoveryCodeCount random codes for the user to
+// write down together with the hashes we keep. The codes carry 50 bits of
+// entropy each so, like the emailed tokens, a plain SHA-256 is enough.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("error generating recovery code: %v", err)
+		}
+		c := strings.ToLower(base32.StdEncoding.EncodeToString(b)[:10])
+		c = c[:5] + "-" + c[5:]
+		codes = append(codes, c)
+		hashes = append(hashes, hashRecoveryCode(c))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code ignoring case, spaces and dashes
+// as people copy them in all sorts of ways.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	return hashToken(code)
+}
+
+// secondFactorLockedErr is shown instead of checking a code while the user's
+// second factor is locked.
+const secondFactorLockedErr = "Too many invalid codes were entered. Please wait a while and try again."
+
+// twoFactorLoginPage is the step-up page shown between the first factor and
+// the session being logged in.
+type twoFactorLoginPage struct {
+	Err string
+}
+
+func (s *server) serveTwoFactorLogin(w http.ResponseWriter, r *http.Request) *Error {
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+	if _, err := fromSessionGetTwoFactorUserID(session); err != nil {
+		http.Redirect(w, r, "/login?m=twofactor", http.StatusFound)
+		return nil
+	}
+	return s.render(w, r, s.templates.twoFactorLogin, &twoFactorLoginPage{}, nil)
+}
+
+func (s *server) handleTwoFactorLogin(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return E(err, "error getting session", http.StatusInternalServerError)
+	}
+	userID, err := fromSessionGetTwoFactorUserID(session)
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/login?m=twofactor", http.StatusFound)
+		return nil
+	}
+	attempts, _ := session.Values["twoFactorAttempts"].(int)
+	if attempts >= twoFactorMaxAttempts {
+		log.Printf("too many two-factor attempts for user %d", userID)
+		s.audit(r, petfind.AuditLoginFailed, userID, "too many two-factor attempts")
+		if err := s.rotateSession(w, r, session); err != nil {
+			return E(err, "error rotating session", http.StatusInternalServerError)
+		}
+		http.Redirect(w, r, "/login?m=twofactor", http.StatusFound)
+		return nil
+	}
+
+	t, err := s.store.GetTOTP(userID)
+	if err != nil {
+		return E(err, "error getting two-factor settings", http.StatusInternalServerError)
+	}
+	ok, err := s.checkSecondFactor(r, t, r.PostFormValue("code"))
+	if err == errSecondFactorLocked {
+		s.audit(r, petfind.AuditLoginFailed, userID, "two-factor locked after too many invalid codes")
+		return s.render(w, r, s.templates.twoFactorLogin, &twoFactorLoginPage{Err: secondFactorLockedErr}, nil)
+	}
+	if err != nil {
+		return E(err, "error checking two-factor code", http.StatusInternalServerError)
+	}
+	if !ok {
+		s.audit(r, petfind.AuditLoginFailed, userID, "invalid two-factor code")
+		session.Values["twoFactorAttempts"] = attempts + 1
+		if err := session.Save(r, w); err != nil {
+			return E(err, "error saving session", http.StatusInternalServerError)
+		}
+		return s.render(w, r, s.templates.twoFactorLogin, &twoFactorLoginPage{Err: "Invalid code."}, nil)
+	}
+
+	if r.PostFormValue("remember") != "" {
+		if err := s.rememberDevice(w, r, t); err != nil {
+			return E(err, "error remembering device", http.StatusInternalServerError)
+		}
+	}
+	method, _ := session.Values["twoFactorMethod"].(string)
+	if err := s.loginSession(w, r, session, userID, method+"+2fa"); err != nil {
+		return E(err, "error logging in session", http.StatusInternalServerError)
+	}
+	s.redirectAfterLogin(w, r, session)
+	return nil
+}
+
+// twoFactorPage is shown on /me/2fa.
+type twoFactorPage struct {
+	Enabled bool
+	// Remaining is the number of unused recovery codes.
+	Remaining int64
+	// Secret and QR are set while enrolling.
+	Secret string
+	QR     template.URL
+	// Codes are the new recovery codes, shown only once.
+	Codes   []string
+	Message string
+	Err     string
+}
+
+var twoFactorMessages = map[string]string{
+	"disabled": "Two-factor authentication is now off.",
+	"required": "Your role requires two-factor authentication. Turn it on to continue.",
+}
+
+func (s *server) serveTwoFactor(w http.ResponseWriter, r *http.Request) *Error {
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	page, err := s.twoFactorPage(user)
+	if err != nil {
+		return E(err, "error getting two-factor settings", http.StatusInternalServerError)
+	}
+	page.Message = twoFactorMessages[r.FormValue("m")]
+	return s.render(w, r, s.templates.twoFactor, page, nil)
+}
+
+func (s *server) twoFactorPage(user *petfind.User) (*twoFactorPage, error) {
+	page := new(twoFactorPage)
+	t, err := s.store.GetTOTP(user.ID)
+	if err == petfind.ErrNotFound || (err == nil && !t.Enabled) {
+		return page, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	page.Enabled = true
+	if page.Remaining, err = s.store.CountRecoveryCodes(user.ID); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// handleTwoFactorSetup starts enrolling the user by showing them a new secret
+// to add to their authenticator app.
+func (s *server) handleTwoFactorSetup(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return E(err, "error generating two-factor secret", http.StatusInternalServerError)
+	}
+	err = s.store.PutTOTP(&petfind.TOTP{UserID: user.ID, Secret: secret})
+	if err == petfind.ErrTOTPEnabled {
+		http.Redirect(w, r, "/me/2fa", http.StatusFound)
+		return nil
+	}
+	if err != nil {
+		return E(err, "error storing two-factor secret", http.StatusInternalServerError)
+	}
+	page, err := enrolPage(user, secret)
+	if err != nil {
+		return E(err, "error generating QR code", http.StatusInternalServerError)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return s.render(w, r, s.templates.twoFactor, page, nil)
+}
+
+// enrolPage returns the page showing secret as text and as a QR code of its
+// otpauth:// URI.
+func enrolPage(user *petfind.User, secret string) (*twoFactorPage, error) {
+	account := user.Email
+	if account == "" {
+		account = user.Login
+	}
+	code, err := qr.Encode(totp.URI(totpIssuer, account, secret), qr.M)
+	if err != nil {
+		return nil, err
+	}
+	code.Scale = 4
+	png := base64.StdEncoding.EncodeToString(code.PNG())
+	return &twoFactorPage{
+		Secret: secret,
+		// The data URI is generated by us so it is safe to use as an image
+		// source.
+		QR: template.URL("data:image/png;base64," + png),
+	}, nil
+}
+
+// handleTwoFactorEnable finishes enrolling once the user proves their app
+// works and shows them their recovery codes.
+func (s *server) handleTwoFactorEnable(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+
+	t, err := s.store.GetTOTP(user.ID)
+	if err == petfind.ErrNotFound || (err == nil && t.Enabled) {
+		http.Redirect(w, r, "/me/2fa", http.StatusFound)
+		return nil
+	}
+	if err != nil {
+		return E(err, "error getting two-factor settings", http.StatusInternalServerError)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	step, ok := totp.Validate(t.Secret, r.PostFormValue("code"), time.Now())
+	if !ok {
+		page, err := enrolPage(user, t.Secret)
+		if err != nil {
+			return E(err, "error generating QR code", http.StatusInternalServerError)
+		}
+		page.Err = "Invalid code. Check that your device's clock is correct and try again."
+		return s.render(w, r, s.templates.twoFactor, page, nil)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return E(err, "error generating recovery codes", http.StatusInternalServerError)
+	}
+	if err := s.store.EnableTOTP(user.ID, step, hashes); err != nil {
+		return E(err, "error enabling two-factor authentication", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditTwoFactor, user.ID, "enabled")
+	page := &twoFactorPage{Enabled: true, Remaining: int64(len(codes)), Codes: codes}
+	return s.render(w, r, s.templates.twoFactor, page, nil)
+}
+
+// handleTwoFactorRecovery replaces the user's recovery codes. Like turning
+// two-factor authentication off it needs a current code so that someone who
+// got hold of a logged in session cannot take over the second factor.
+func (s *server) handleTwoFactorRecovery(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+
+	t, page, e := s.confirmSecondFactor(r, user)
+	if e != nil || t == nil {
+		if e != nil {
+			return e
+		}
+		if page.Enabled {
+			s.audit(r, petfind.AuditTwoFactor, user.ID, "new recovery codes rejected: invalid two-factor code")
+		}
+		return s.render(w, r, s.templates.twoFactor, page, nil)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return E(err, "error generating recovery codes", http.StatusInternalServerError)
+	}
+	if err := s.store.PutRecoveryCodes(user.ID, hashes); err != nil {
+		return E(err, "error storing recovery codes", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditTwoFactor, user.ID, "replaced recovery codes")
+	w.Header().Set("Cache-Control", "no-store")
+	page = &twoFactorPage{Enabled: true, Remaining: int64(len(codes)), Codes: codes}
+	return s.render(w, r, s.templates.twoFactor, page, nil)
+}
+
+func (s *server) handleTwoFactorDisable(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+
+	t, page, e := s.confirmSecondFactor(r, user)
+	if e != nil || t == nil {
+		if e != nil {
+			return e
+		}
+		if page.Enabled {
+			s.audit(r, petfind.AuditTwoFactor, user.ID, "disable rejected: invalid two-factor code")
+		}
+		return s.render(w, r, s.templates.twoFactor, page, nil)
+	}
+	if err := s.store.DeleteTOTP(user.ID); err != nil {
+		return E(err, "error disabling two-factor authentication", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditTwoFactor, user.ID, "disabled")
+	http.Redirect(w, r, "/me/2fa?m=disabled", http.StatusFound)
+	return nil
+}
+
+// recentLogin reports whether the session of the request was logged in less
+// than recentLoginTTL ago. Logging in rotates the session so its created value
+// is the time of the login.
+func (s *server) recentLogin(r *http.Request) bool {
+	session, err := s.sessions.Get(r, sessionName)
+	if err != nil {
+		return false
+	}
+	created, err := fromSessionGetCreated(session)
+	if err != nil {
+		return false
+	}
+	return time.Since(time.Unix(created, 0)) < recentLoginTTL
+}
+
+// confirmSecondFactor checks the code posted with a request that changes the
+// user's two-factor settings. If the code is wrong it returns a nil TOTP and
+// the page to show the user instead.
+func (s *server) confirmSecondFactor(r *http.Request, user *petfind.User) (*petfind.TOTP, *twoFactorPage, *Error) {
+	page, err := s.twoFactorPage(user)
+	if err != nil {
+		return nil, nil, E(err, "error getting two-factor settings", http.StatusInternalServerError)
+	}
+	if !page.Enabled {
+		return nil, page, nil
+	}
+	t, err := s.store.GetTOTP(user.ID)
+	if err != nil {
+		return nil, nil, E(err, "error getting two-factor settings", http.StatusInternalServerError)
+	}
+	ok, err := s.checkSecondFactor(r, t, r.PostFormValue("code"))
+	if err == errSecondFactorLocked {
+		page.Err = secondFactorLockedErr
+		return nil, page, nil
+	}
+	if err != nil {
+		return nil, nil, E(err, "error checking two-factor code", http.StatusInternalServerError)
+	}
+	if !ok {
+		page.Err = "Invalid code."
+		return nil, page, nil
+	}
+	return t, nil, nil
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, expected %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+	seen := make(map[string]bool)
+	for i, c := range codes {
+		if seen[c] {
+			t.Errorf("duplicate code %q", c)
+		}
+		seen[c] = true
+		// Codes are matched however they are typed back.
+		typed := strings.ToUpper(strings.Replace(c, "-", " ", -1))
+		if hashRecoveryCode(typed) != hashes[i] {
+			t.Errorf("hash of %q does not match hash of %q", typed, c)
+		}
+	}
+}
+
+func TestRememberedDevice(t *testing.T) {
+	s := &server{devices: newDeviceCodec([]byte("test-hash-key-of-at-least-32-bytes"))}
+	tp := &petfind.TOTP{UserID: 1, Created: time.Now()}
+
+	r := httptest.NewRequest("POST", "/login/2fa/submit", nil)
+	r = r.WithContext(newContextWithClient(r.Context(), &client{Scheme: "https"}))
+	w := httptest.NewRecorder()
+	if err := s.rememberDevice(w, r, tp); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].Secure || !cookies[0].HttpOnly {
+		t.Fatalf("rememberDevice set cookies %v", cookies)
+	}
+
+	next := func() *http.Request {
+		r := httptest.NewRequest("GET", "/login/2fa", nil)
+		r.AddCookie(cookies[0])
+		return r
+	}
+	if !s.isRememberedDevice(next(), tp) {
+		t.Error("device not remembered")
+	}
+	if s.isRememberedDevice(next(), &petfind.TOTP{UserID: 2, Created: tp.Created}) {
+		t.Error("device remembered for a different user")
+	}
+	if s.isRememberedDevice(next(), &petfind.TOTP{UserID: 1, Created: tp.Created.Add(time.Second)}) {
+		t.Error("device remembered after enrolling again")
+	}
+}
+
+func TestRecentLogin(t *testing.T) {
+	s := &server{sessions: sessions.NewCookieStore([]byte("test-hash-key-of-at-least-32-bytes"))}
+	for _, tt := range []struct {
+		created time.Time
+		want    bool
+	}{
+		{time.Now(), true},
+		{time.Now().Add(-recentLoginTTL - time.Minute), false},
+	} {
+		r := httptest.NewRequest("GET", "/me/passkeys", nil)
+		session, err := s.sessions.Get(r, sessionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		session.Values["created"] = tt.created.UTC().Unix()
+		w := httptest.NewRecorder()
+		if err := session.Save(r, w); err != nil {
+			t.Fatal(err)
+		}
+		r = httptest.NewRequest("POST", "/me/passkeys/add", nil)
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		if got := s.recentLogin(r); got != tt.want {
+			t.Errorf("recentLogin of session created at %v = %v, expected %v", tt.created, got, tt.want)
+		}
+	}
+	// Sessions without a login are never recent.
+	if s.recentLogin(httptest.NewRequest("POST", "/me/passkeys/add", nil)) {
+		t.Error("recentLogin of request without session = true, expected false")
+	}
+}
+
+func TestCheckSecondFactorLocked(t *testing.T) {
+	// The store is not touched while locked so neither the code nor the
+	// failure count are looked at.
+	s := &server{}
+	tp := &petfind.TOTP{UserID: 1, Enabled: true, Failures: twoFactorFreeAttempts, LockedUntil: time.Now().Add(time.Minute)}
+	if ok, err := s.checkSecondFactor(httptest.NewRequest("POST", "/login/2fa/submit", nil), tp, "123456"); ok || err != errSecondFactorLocked {
+		t.Errorf("checkSecondFactor while locked = %v, %v, expected false, %v", ok, err, errSecondFactorLocked)
+	}
+}
+
+type recoveryCodeStore struct {
+	petfind.Store
+	hashes []string
+	events []*petfind.AuditEvent
+}
+
+func (s *recoveryCodeStore) UseRecoveryCode(userID int64, hash string) error {
+	for i, h := range s.hashes {
+		if h == hash {
+			s.hashes = append(s.hashes[:i], s.hashes[i+1:]...)
+			return nil
+		}
+	}
+	return petfind.ErrNotFound
+}
+
+func (s *recoveryCodeStore) FailSecondFactor(userID, freeAttempts int64, lockout, maxLockout time.Duration) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (s *recoveryCodeStore) AddAuditEvent(e *petfind.AuditEvent) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestRecoveryCodeAudited(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &recoveryCodeStore{hashes: hashes}
+	s := &server{store: store}
+	tp := &petfind.TOTP{UserID: 1, Enabled: true, Secret: "JBSWY3DPEHPK3PXP"}
+	r := httptest.NewRequest("POST", "/login/2fa/submit", nil)
+
+	if ok, err := s.checkSecondFactor(r, tp, codes[0]); !ok || err != nil {
+		t.Fatalf("checkSecondFactor with recovery code = %v, %v, expected true", ok, err)
+	}
+	if len(store.events) != 1 || store.events[0].Kind != petfind.AuditTwoFactor || store.events[0].ActorID != 1 {
+		t.Fatalf("recorded events %#v, expected a %s event of user 1", store.events, petfind.AuditTwoFactor)
+	}
+	// Used codes are rejected and wrong codes are not recorded as used.
+	if ok, err := s.checkSecondFactor(r, tp, codes[0]); ok || err != nil {
+		t.Errorf("checkSecondFactor with used recovery code = %v, %v, expected false", ok, err)
+	}
+	if len(store.events) != 1 {
+		t.Errorf("recorded %d events, expected 1", len(store.events))
+	}
+}
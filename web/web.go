@@ -4,18 +4,24 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
-
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/github"
+	"sync"
+	"unicode/utf8"
 
 	gorillactx "github.com/gorilla/context"
 	"github.com/gorilla/csrf"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"github.com/psimika/secure-web-app/alerts"
+	"github.com/psimika/secure-web-app/login"
+	"github.com/psimika/secure-web-app/mail"
+	"github.com/psimika/secure-web-app/notification"
+	"github.com/psimika/secure-web-app/password"
 	"github.com/psimika/secure-web-app/petfind"
 )
 
@@ -59,25 +65,67 @@ type server struct {
 	mux           *http.ServeMux
 	store         petfind.Store
 	templates     *templates
-	github        *oauth2.Config
-	linkedin      *oauth2.Config
+	providers     []login.Provider
 	sessions      sessions.Store
 	sessionTTL    int
 	sessionMaxTTL int
 	favicons      map[string]string
 	photos        petfind.PhotoStore
+	placesMu      sync.RWMutex
 	placeGroups   []petfind.PlaceGroup
+	proxies       proxies
+	redirects     *securecookie.SecureCookie
+	tokens        *securecookie.SecureCookie
+	devices       *securecookie.SecureCookie
+	mailer        mail.Sender
+	emails        *notification.Templates
+	passwords     *password.Policy
+	premoderate   bool
+	siteURL       string
+	petHooks      []petHook
+	alertLinks    *alerts.Links
+	// visitorSecret keys the hashes that identify visitors who are not
+	// logged in.
+	visitorSecret []byte
 }
 
 // templates contains the server's templates required to render its pages.
 type templates struct {
-	home        *tmpl
-	addPet      *tmpl
-	search      *tmpl
-	searchReply *tmpl
-	showPets    *tmpl
-	login       *tmpl
-	demoXSS     *tmpl
+	home           *tmpl
+	addPet         *tmpl
+	search         *tmpl
+	searchReply    *tmpl
+	showPets       *tmpl
+	login          *tmpl
+	sessions       *tmpl
+	accounts       *tmpl
+	signup         *tmpl
+	forgotPassword *tmpl
+	resetPassword  *tmpl
+	magicLink      *tmpl
+	twoFactor      *tmpl
+	twoFactorLogin *tmpl
+	passkeys       *tmpl
+	apiTokens      *tmpl
+	report         *tmpl
+	moderation     *tmpl
+	adminUsers     *tmpl
+	adminPets      *tmpl
+	adminPhotos    *tmpl
+	adminPlaces    *tmpl
+	adminAudit     *tmpl
+	adminWebhooks  *tmpl
+	messagePet     *tmpl
+	inbox          *tmpl
+	conversation   *tmpl
+	reveal         *tmpl
+	apply          *tmpl
+	applications   *tmpl
+	application    *tmpl
+	favorites      *tmpl
+	savedSearches  *tmpl
+	unsubscribe    *tmpl
+	demoXSS        *tmpl
 }
 
 type tmpl struct {
@@ -91,21 +139,53 @@ type tmpl struct {
 //
 // sessionMaxTTL is used to check if a session has expired by surpassing its
 // absolute timeout.
+//
+// hashKey is used to sign short-lived values such as the URL to return to
+// after login, as well as the unsubscribe links of saved search alerts.
+//
+// csrfKey and csrfOptions set up the CSRF protection of the forms. Requests
+// that fail it are recorded in the audit log.
+//
+// providers are the external services users can log in with.
+//
+// mailer sends the emails for verifying addresses and resetting passwords of
+// local accounts and passwords is the policy their passwords must satisfy.
+// Templated emails are read from templates/email under templatePath.
+//
+// premoderate holds back the pets of users that have no approved pets yet
+// until a moderator approves them.
+//
+// siteURL is the public URL of the site, e.g. https://petfind.example.com,
+// used in emailed links.
+//
+// trustedProxies are the networks of the reverse proxies the server runs
+// behind. Only they are allowed to tell us the client's real IP, scheme and
+// host through the X-Forwarded-* headers.
 func NewServer(
 	store petfind.Store,
 	sessionStore sessions.Store,
 	sessionTTL int,
 	sessionMaxTTL int,
-	CSRF func(http.Handler) http.Handler,
+	hashKey []byte,
+	csrfKey []byte,
+	csrfOptions []csrf.Option,
 	templatePath string,
 	photoStore petfind.PhotoStore,
-	githubOAuth *oauth2.Config,
-	linkedinOAuth *oauth2.Config,
+	providers []login.Provider,
+	mailer mail.Sender,
+	passwords *password.Policy,
+	premoderate bool,
+	siteURL string,
+	trustedProxies []*net.IPNet,
 ) (http.Handler, error) {
 	t, err := parseTemplates(filepath.Join(templatePath, "templates"))
 	if err != nil {
 		return nil, fmt.Errorf("error parsing templates: %v", err)
 	}
+	emails, err := notification.ParseTemplates(filepath.Join(templatePath, "templates", "email"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing email templates: %v", err)
+	}
 	groups, err := store.GetPlaceGroups()
 	if err != nil {
 		return nil, fmt.Errorf("error getting places and groups: %v", err)
@@ -115,27 +195,122 @@ func NewServer(
 		mux:           http.NewServeMux(),
 		store:         store,
 		templates:     t,
-		github:        githubOAuth,
-		linkedin:      linkedinOAuth,
+		providers:     providers,
 		sessions:      sessionStore,
 		sessionTTL:    sessionTTL,
 		sessionMaxTTL: sessionMaxTTL,
 		photos:        photoStore,
 		placeGroups:   groups,
-	}
-	s.handlers = gorillactx.ClearHandler(CSRF(s.mux))
+		proxies:       trustedProxies,
+		redirects:     newRedirectCodec(hashKey),
+		tokens:        newTokenCodec(hashKey),
+		devices:       newDeviceCodec(hashKey),
+		mailer:        mailer,
+		emails:        emails,
+		passwords:     passwords,
+		premoderate:   premoderate,
+		siteURL:       strings.TrimRight(siteURL, "/"),
+		alertLinks:    alerts.NewLinks(hashKey, siteURL),
+		visitorSecret: newVisitorSecret(hashKey),
+	}
+	s.petHooks = []petHook{s.mailFavoriters, s.publishWebhooks}
+	csrfOptions = append(csrfOptions, csrf.ErrorHandler(handler(s.handleCSRFFailure)))
+	s.handlers = gorillactx.ClearHandler(csrf.Protect(csrfKey, csrfOptions...)(s.mux))
 	s.mux.Handle("/", s.guest(s.serveHome))
-	s.mux.Handle("/search", handler(s.serveSearch))
-	s.mux.Handle("/search/submit", handler(s.handleSearch))
+	s.mux.Handle("/search", s.guest(s.serveSearch))
+	s.mux.Handle("/search/submit", s.guest(s.handleSearch))
 	s.mux.Handle("/pets/add", s.auth(s.serveAddPet))
 	s.mux.Handle("/pets/add/submit", s.auth(s.handleAddPet))
+	s.mux.Handle("/pets/remove", s.auth(s.handleRemovePet))
+	s.mux.Handle("/pets/message", s.auth(s.serveMessagePet))
+	s.mux.Handle("/pets/message/submit", s.auth(s.handleMessagePet))
+	s.mux.Handle("/pets/apply", s.auth(s.serveApplyPet))
+	s.mux.Handle("/pets/apply/submit", s.auth(s.handleApplyPet))
+	s.mux.Handle("/pets/contact/reveal", s.guest(s.handleRevealContact))
+	s.mux.Handle("/pets/report", s.guest(s.serveReportPet))
+	s.mux.Handle("/pets/report/submit", s.guest(s.handleReportPet))
 	s.mux.Handle("/login", handler(s.serveLogin))
-	s.mux.Handle("/login/github", handler(s.handleLoginGitHub))
-	s.mux.Handle("/login/github/cb", handler(s.handleLoginGitHubCallback))
-	s.mux.Handle("/login/linkedin", handler(s.handleLoginLinkedIn))
-	s.mux.Handle("/login/linkedin/cb", handler(s.handleLoginLinkedInCallback))
+	for _, p := range providers {
+		s.mux.Handle("/login/"+p.Name(), s.handleLogin(p))
+		s.mux.Handle("/login/"+p.Name()+"/cb", s.handleLoginCallback(p))
+	}
+	s.mux.Handle("/login/password", handler(s.handlePasswordLogin))
+	s.mux.Handle("/login/email", handler(s.handleMagicLinkRequest))
+	s.mux.Handle("/login/email/confirm", handler(s.serveMagicLinkConfirm))
+	s.mux.Handle("/login/email/confirm/submit", handler(s.handleMagicLinkLogin))
+	s.mux.Handle("/login/2fa", handler(s.serveTwoFactorLogin))
+	s.mux.Handle("/login/2fa/submit", handler(s.handleTwoFactorLogin))
+	s.mux.Handle("/login/passkey", handler(s.handlePasskeyLogin))
+	s.mux.Handle("/login/passkey/options", handler(s.handlePasskeyLoginOptions))
+	s.mux.Handle("/signup", handler(s.serveSignup))
+	s.mux.Handle("/signup/submit", handler(s.handleSignup))
+	s.mux.Handle("/email/verify", handler(s.handleVerifyEmail))
+	s.mux.Handle("/password/forgot", handler(s.serveForgotPassword))
+	s.mux.Handle("/password/forgot/submit", handler(s.handleForgotPassword))
+	s.mux.Handle("/password/reset", handler(s.serveResetPassword))
+	s.mux.Handle("/password/reset/submit", handler(s.handleResetPassword))
 	s.mux.Handle("/logout", s.auth(s.handleLogout))
+	s.mux.Handle("/applications", s.auth(s.serveApplications))
+	s.mux.Handle("/applications/view", s.auth(s.serveApplication))
+	s.mux.Handle("/applications/update", s.auth(s.handleApplicationAction))
+	s.mux.Handle("/messages", s.auth(s.serveInbox))
+	s.mux.Handle("/messages/view", s.auth(s.serveConversation))
+	s.mux.Handle("/messages/send", s.auth(s.handleSendMessage))
+	s.mux.Handle("/me/favorites", s.auth(s.serveFavorites))
+	s.mux.Handle("/me/favorites/add", s.auth(s.handleAddFavorite))
+	s.mux.Handle("/me/favorites/remove", s.auth(s.handleRemoveFavorite))
+	s.mux.Handle("/me/searches", s.auth(s.serveSavedSearches))
+	s.mux.Handle("/me/searches/add", s.auth(s.handleAddSavedSearch))
+	s.mux.Handle("/me/searches/remove", s.auth(s.handleRemoveSavedSearch))
+	s.mux.Handle("/searches/unsubscribe", s.guest(s.serveUnsubscribe))
+	s.mux.Handle("/searches/unsubscribe/submit", s.guest(s.handleUnsubscribe))
+	s.mux.Handle("/me/sessions", s.auth(s.serveSessions))
+	s.mux.Handle("/me/sessions/revoke", s.auth(s.handleRevokeSession))
+	s.mux.Handle("/me/sessions/revoke/all", s.auth(s.handleRevokeAllSessions))
+	s.mux.Handle("/me/accounts", s.auth(s.serveAccounts))
+	s.mux.Handle("/me/accounts/link", s.auth(s.handleLinkIdentity))
+	s.mux.Handle("/me/accounts/unlink", s.auth(s.handleUnlinkIdentity))
+	s.mux.Handle("/me/2fa", s.auth(s.serveTwoFactor))
+	s.mux.Handle("/me/2fa/setup", s.auth(s.handleTwoFactorSetup))
+	s.mux.Handle("/me/2fa/enable", s.auth(s.handleTwoFactorEnable))
+	s.mux.Handle("/me/2fa/recovery", s.auth(s.handleTwoFactorRecovery))
+	s.mux.Handle("/me/2fa/disable", s.auth(s.handleTwoFactorDisable))
+	s.mux.Handle("/me/passkeys", s.auth(s.servePasskeys))
+	s.mux.Handle("/me/passkeys/options", s.auth(s.handlePasskeyOptions))
+	s.mux.Handle("/me/passkeys/add", s.auth(s.handleAddPasskey))
+	s.mux.Handle("/me/passkeys/remove", s.auth(s.handleRemovePasskey))
+	s.mux.Handle("/me/tokens", s.auth(s.serveAPITokens))
+	s.mux.Handle("/me/tokens/add", s.auth(s.handleAddAPIToken))
+	s.mux.Handle("/me/tokens/revoke", s.auth(s.handleRevokeAPIToken))
+	s.mux.Handle("/moderation", s.require(petfind.RoleModerator, s.serveModeration))
+	s.mux.Handle("/moderation/hide", s.require(petfind.RoleModerator, s.handleModerate(petfind.ModerationHide)))
+	s.mux.Handle("/moderation/restore", s.require(petfind.RoleModerator, s.handleModerate(petfind.ModerationRestore)))
+	s.mux.Handle("/moderation/dismiss", s.require(petfind.RoleModerator, s.handleModerate(petfind.ModerationDismiss)))
+	s.mux.Handle("/moderation/approve", s.require(petfind.RoleModerator, s.handleModerate(petfind.ModerationApprove)))
+	s.mux.Handle("/moderation/reject", s.require(petfind.RoleModerator, s.handleModerate(petfind.ModerationReject)))
+	s.mux.Handle("/admin", s.require(petfind.RoleAdmin, s.serveAdmin))
+	s.mux.Handle("/admin/users", s.require(petfind.RoleAdmin, s.serveAdminUsers))
+	s.mux.Handle("/admin/users/disable", s.require(petfind.RoleAdmin, s.handleAdminDisableUser))
+	s.mux.Handle("/admin/users/enable", s.require(petfind.RoleAdmin, s.handleAdminEnableUser))
+	s.mux.Handle("/admin/pets", s.require(petfind.RoleAdmin, s.serveAdminPets))
+	s.mux.Handle("/admin/pets/remove", s.require(petfind.RoleAdmin, s.handleAdminRemovePet))
+	s.mux.Handle("/admin/photos", s.require(petfind.RoleAdmin, s.serveAdminPhotos))
+	s.mux.Handle("/admin/places", s.require(petfind.RoleAdmin, s.serveAdminPlaces))
+	s.mux.Handle("/admin/places/groups/add", s.require(petfind.RoleAdmin, s.handleAdminAddPlaceGroup))
+	s.mux.Handle("/admin/places/groups/rename", s.require(petfind.RoleAdmin, s.handleAdminRenamePlaceGroup))
+	s.mux.Handle("/admin/places/groups/remove", s.require(petfind.RoleAdmin, s.handleAdminRemovePlaceGroup))
+	s.mux.Handle("/admin/places/add", s.require(petfind.RoleAdmin, s.handleAdminAddPlace))
+	s.mux.Handle("/admin/places/rename", s.require(petfind.RoleAdmin, s.handleAdminRenamePlace))
+	s.mux.Handle("/admin/places/remove", s.require(petfind.RoleAdmin, s.handleAdminRemovePlace))
+	s.mux.Handle("/admin/audit", s.require(petfind.RoleAdmin, s.serveAdminAudit))
+	s.mux.Handle("/admin/webhooks", s.require(petfind.RoleAdmin, s.serveAdminWebhooks))
+	s.mux.Handle("/admin/webhooks/add", s.require(petfind.RoleAdmin, s.handleAdminAddWebhook))
+	s.mux.Handle("/admin/webhooks/enable", s.require(petfind.RoleAdmin, s.handleAdminSetWebhookActive(true)))
+	s.mux.Handle("/admin/webhooks/disable", s.require(petfind.RoleAdmin, s.handleAdminSetWebhookActive(false)))
+	s.mux.Handle("/admin/webhooks/remove", s.require(petfind.RoleAdmin, s.handleAdminRemoveWebhook))
+	s.mux.Handle("/admin/webhooks/retry", s.require(petfind.RoleAdmin, s.handleAdminRetryWebhookDelivery))
 	s.mux.Handle("/photos/", handler(s.servePhoto))
+	s.handleAPI()
 	s.mux.Handle("/demo/xss", handler(s.demoXSS))
 
 	fs := http.FileServer(http.Dir(filepath.Join(templatePath, "assets")))
@@ -172,19 +347,6 @@ func prepareFavicons(assetsPath string) map[string]string {
 	return favicons
 }
 
-func NewGitHubOAuthConfig(clientID, clientSecret string) *oauth2.Config {
-	return &oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		// Only requiring the user's public info and their email.
-		//
-		// Full list of scopes:
-		// https://developer.github.com/apps/building-integrations/setting-up-and-registering-oauth-apps/about-scopes-for-oauth-apps/
-		Scopes:   []string{"user:email"},
-		Endpoint: github.Endpoint,
-	}
-}
-
 func parseTemplates(dir string) (*templates, error) {
 	homeTmpl, err := template.ParseFiles(
 		filepath.Join(dir, "base.tmpl"),
@@ -192,6 +354,7 @@ func parseTemplates(dir string) (*templates, error) {
 		filepath.Join(dir, "searchform.tmpl"),
 		filepath.Join(dir, "home.tmpl"),
 		filepath.Join(dir, "pets.tmpl"),
+		filepath.Join(dir, "contact.tmpl"),
 	)
 	if err != nil {
 		return nil, err
@@ -219,6 +382,7 @@ func parseTemplates(dir string) (*templates, error) {
 		filepath.Join(dir, "searchreply.tmpl"),
 		filepath.Join(dir, "searchform.tmpl"),
 		filepath.Join(dir, "pets.tmpl"),
+		filepath.Join(dir, "contact.tmpl"),
 	)
 	if err != nil {
 		return nil, err
@@ -228,6 +392,7 @@ func parseTemplates(dir string) (*templates, error) {
 		filepath.Join(dir, "navbar.tmpl"),
 		filepath.Join(dir, "showpets.tmpl"),
 		filepath.Join(dir, "pets.tmpl"),
+		filepath.Join(dir, "contact.tmpl"),
 	)
 	if err != nil {
 		return nil, err
@@ -244,21 +409,287 @@ func parseTemplates(dir string) (*templates, error) {
 		filepath.Join(dir, "navbar.tmpl"),
 		filepath.Join(dir, "login.tmpl"),
 	)
+	if err != nil {
+		return nil, err
+	}
+	sessionsTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "sessions.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	accountsTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "accounts.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	signupTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "signup.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	forgotPasswordTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "forgotpassword.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	resetPasswordTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "resetpassword.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	magicLinkTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "magiclink.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	twoFactorTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "twofactor.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	twoFactorLoginTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "twofactorlogin.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	passkeysTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "passkeys.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	apiTokensTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "apitokens.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	reportTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "report.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	moderationTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "moderation.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	adminUsersTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "adminnav.tmpl"),
+		filepath.Join(dir, "adminusers.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	adminPetsTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "adminnav.tmpl"),
+		filepath.Join(dir, "adminpets.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	adminPhotosTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "adminnav.tmpl"),
+		filepath.Join(dir, "adminphotos.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	adminPlacesTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "adminnav.tmpl"),
+		filepath.Join(dir, "adminplaces.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	adminAuditTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "adminnav.tmpl"),
+		filepath.Join(dir, "adminaudit.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	adminWebhooksTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "adminnav.tmpl"),
+		filepath.Join(dir, "adminwebhooks.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	messagePetTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "messagepet.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	inboxTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "inbox.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conversationTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "conversation.tmpl"),
+		filepath.Join(dir, "contact.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	revealTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "reveal.tmpl"),
+		filepath.Join(dir, "contact.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	applyTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "apply.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	applicationsTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "applications.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	applicationTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "application.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	favoritesTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "favorites.tmpl"),
+		filepath.Join(dir, "contact.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	savedSearchesTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "searches.tmpl"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	unsubscribeTmpl, err := template.ParseFiles(
+		filepath.Join(dir, "base.tmpl"),
+		filepath.Join(dir, "navbar.tmpl"),
+		filepath.Join(dir, "unsubscribe.tmpl"),
+	)
 	t := &templates{
-		home:        &tmpl{homeTmpl, "home"},
-		addPet:      &tmpl{addPetTmpl, "add"},
-		search:      &tmpl{searchTmpl, "search"},
-		searchReply: &tmpl{searchReplyTmpl, "search"},
-		showPets:    &tmpl{showPetsTmpl, "search"},
-		login:       &tmpl{loginTmpl, ""},
-		demoXSS:     &tmpl{demoXSSTmpl, ""},
+		home:           &tmpl{homeTmpl, "home"},
+		addPet:         &tmpl{addPetTmpl, "add"},
+		search:         &tmpl{searchTmpl, "search"},
+		searchReply:    &tmpl{searchReplyTmpl, "search"},
+		showPets:       &tmpl{showPetsTmpl, "search"},
+		login:          &tmpl{loginTmpl, ""},
+		sessions:       &tmpl{sessionsTmpl, ""},
+		accounts:       &tmpl{accountsTmpl, ""},
+		signup:         &tmpl{signupTmpl, ""},
+		forgotPassword: &tmpl{forgotPasswordTmpl, ""},
+		resetPassword:  &tmpl{resetPasswordTmpl, ""},
+		magicLink:      &tmpl{magicLinkTmpl, ""},
+		twoFactor:      &tmpl{twoFactorTmpl, ""},
+		twoFactorLogin: &tmpl{twoFactorLoginTmpl, ""},
+		passkeys:       &tmpl{passkeysTmpl, ""},
+		apiTokens:      &tmpl{apiTokensTmpl, ""},
+		report:         &tmpl{reportTmpl, ""},
+		moderation:     &tmpl{moderationTmpl, "moderation"},
+		adminUsers:     &tmpl{adminUsersTmpl, "admin"},
+		adminPets:      &tmpl{adminPetsTmpl, "admin"},
+		adminPhotos:    &tmpl{adminPhotosTmpl, "admin"},
+		adminPlaces:    &tmpl{adminPlacesTmpl, "admin"},
+		adminAudit:     &tmpl{adminAuditTmpl, "admin"},
+		adminWebhooks:  &tmpl{adminWebhooksTmpl, "admin"},
+		messagePet:     &tmpl{messagePetTmpl, ""},
+		inbox:          &tmpl{inboxTmpl, "messages"},
+		conversation:   &tmpl{conversationTmpl, "messages"},
+		reveal:         &tmpl{revealTmpl, ""},
+		apply:          &tmpl{applyTmpl, ""},
+		applications:   &tmpl{applicationsTmpl, "applications"},
+		application:    &tmpl{applicationTmpl, "applications"},
+		favorites:      &tmpl{favoritesTmpl, "favorites"},
+		savedSearches:  &tmpl{savedSearchesTmpl, "searches"},
+		unsubscribe:    &tmpl{unsubscribeTmpl, ""},
+		demoXSS:        &tmpl{demoXSSTmpl, ""},
 	}
 	return t, err
 }
 
 // ServeHTTP satisfies the http.Handler interface for a server.
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+	// Resolve the client's details once so that the rest of the handlers can
+	// rely on them without having to worry about spoofed headers.
+	c := s.proxies.resolve(r)
+	r = r.WithContext(newContextWithClient(r.Context(), c))
+
+	if c.Scheme == "https" {
 		// HSTS header suggested by OWASP (2017a) to address certain threats:
 		// https://www.owasp.org/index.php/HTTP_Strict_Transport_Security_Cheat_Sheet
 		w.Header().Set("Strict-Transport-Security", "max-age=86400; includeSubDomains")
@@ -267,6 +698,13 @@ func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("X-XSS-Protection", "1; mode=block")
 
+	// Requests of the JSON API made with a personal API token are not
+	// authenticated by cookies so a forged cross-site request has nothing
+	// to ride on. The API never falls back to the session for them.
+	if _, ok := bearerToken(r); ok && strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+		r = csrf.UnsafeSkipCheck(r)
+	}
+
 	s.handlers.ServeHTTP(w, r)
 }
 
@@ -289,7 +727,7 @@ func (s *server) render(w http.ResponseWriter, r *http.Request, tmpl *tmpl, data
 	m := map[string]interface{}{
 		csrf.TemplateTag: csrf.TemplateField(r),
 		"nav":            tmpl.nav,
-		"groups":         s.placeGroups,
+		"groups":         s.getPlaceGroups(),
 	}
 
 	if data != nil {
@@ -302,6 +740,8 @@ func (s *server) render(w http.ResponseWriter, r *http.Request, tmpl *tmpl, data
 	user, _ := fromContextGetUser(r.Context())
 	if user != nil {
 		m["user"] = user
+		m["moderator"] = user.HasRole(petfind.RoleModerator)
+		m["admin"] = user.HasRole(petfind.RoleAdmin)
 	}
 
 	if err := tmpl.Execute(w, m); err != nil {
@@ -322,10 +762,17 @@ func (s *server) guest(fn handler) handler {
 		// Get the user's ID stored in the session.
 		userID, err := fromSessionGetUserID(session)
 		if err == nil {
-			// Get the user from the database based on the session's user ID.
-			user, err = s.store.GetUser(userID)
-			if err != nil {
-				return E(err, "error getting user from guest session", http.StatusInternalServerError)
+			// Only consider the user as logged in if the session is still
+			// valid.
+			if _, err = s.validateSession(r, session, userID); err == nil {
+				// Get the user from the database based on the session's user ID.
+				user, err = s.store.GetUser(userID)
+				if err != nil && err != petfind.ErrNotFound {
+					return E(err, "error getting user from guest session", http.StatusInternalServerError)
+				}
+				if user != nil && user.Disabled {
+					user = nil
+				}
 			}
 		}
 
@@ -350,7 +797,17 @@ func (s *server) serveAddPet(w http.ResponseWriter, r *http.Request) *Error {
 		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 	}
 
-	return s.render(w, r, s.templates.addPet, nil, addPetForm{})
+	page := &addPetPage{Message: addPetMessages[r.FormValue("m")]}
+	return s.render(w, r, s.templates.addPet, page, addPetForm{})
+}
+
+// addPetPage is shown on /pets/add.
+type addPetPage struct {
+	Message string
+}
+
+var addPetMessages = map[string]string{
+	"pending": "Thank you! Your pet will be listed as soon as a moderator approves it. We will let you know by email.",
 }
 
 func (s *server) handleAddPet(w http.ResponseWriter, r *http.Request) *Error {
@@ -378,9 +835,77 @@ func (s *server) handleAddPet(w http.ResponseWriter, r *http.Request) *Error {
 
 	pet.PhotoID = photo.ID
 	pet.OwnerID = user.ID
+	pending, err := s.needsApproval(user)
+	if err != nil {
+		return E(err, "error checking approved pets", http.StatusInternalServerError)
+	}
+	if pending {
+		pet.Status = petfind.PetPending
+	}
 	if err := s.store.AddPet(pet); err != nil {
 		return E(err, "Error adding pet", http.StatusInternalServerError)
 	}
+	s.audit(r, petfind.AuditPetCreate, user.ID, fmt.Sprintf("pet %d %q (%v)", pet.ID, pet.Name, pet.Status))
+
+	if pending {
+		http.Redirect(w, r, "/pets/add?m=pending", http.StatusFound)
+		return nil
+	}
+	// Get the pet back with its place for the pet hooks.
+	if added, err := s.store.GetPet(pet.ID); err == nil {
+		s.petChanged(r, added, petfind.PetCreated)
+	} else {
+		log.Printf("error getting added pet %d: %v", pet.ID, err)
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+	return nil
+}
+
+// needsApproval reports whether the pets of user are held back until a
+// moderator approves them. Only users that have no approved pets yet are
+// held back and only when pre-moderation is on.
+func (s *server) needsApproval(user *petfind.User) (bool, error) {
+	if !s.premoderate || user.HasRole(petfind.RoleModerator) {
+		return false, nil
+	}
+	approved, err := s.store.HasApprovedPets(user.ID)
+	if err != nil {
+		return false, err
+	}
+	return !approved, nil
+}
+
+// handleRemovePet removes a pet listing. Only the users allowed by the pets'
+// ownership policy can remove it.
+func (s *server) handleRemovePet(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+
+	user, ok := fromContextGetUser(r.Context())
+	if !ok || user == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		return E(err, "invalid pet id", http.StatusBadRequest)
+	}
+	pet, err := s.store.GetPet(id)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Pet does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error getting pet", http.StatusInternalServerError)
+	}
+	if !pet.ManageableBy(user) {
+		return E(nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	}
+	s.petChanged(r, pet, petfind.PetRemoved)
+	if err := s.store.ArchivePet(pet.ID); err != nil {
+		return E(err, "error removing pet", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditPetDelete, user.ID, fmt.Sprintf("pet %d %q of user %d", pet.ID, pet.Name, pet.OwnerID))
 
 	http.Redirect(w, r, "/", http.StatusFound)
 	return nil
@@ -409,23 +934,30 @@ func (s *server) handlePetPhoto(w http.ResponseWriter, r *http.Request) (*petfin
 }
 
 type addPetForm struct {
-	Invalid    bool
-	Name       string
-	NameErr    string
-	Place      string
-	PlaceErr   string
-	Contact    string
-	ContactErr string
-	Age        string
-	AgeErr     string
-	Size       string
-	SizeErr    string
-	Type       string
-	TypeErr    string
-	Gender     string
-	GenderErr  string
-	Notes      string
-	NotesErr   string
+	Invalid       bool
+	Name          string
+	NameErr       string
+	Place         string
+	PlaceErr      string
+	ContactErr    string
+	Phone         string
+	PhoneErr      string
+	Email         string
+	EmailErr      string
+	Hours         string
+	HoursErr      string
+	Visibility    string
+	VisibilityErr string
+	Age           string
+	AgeErr        string
+	Size          string
+	SizeErr       string
+	Type          string
+	TypeErr       string
+	Gender        string
+	GenderErr     string
+	Notes         string
+	NotesErr      string
 
 	PhotoErr string
 }
@@ -452,11 +984,41 @@ func (s *server) postFormPet(r *http.Request) (*petfind.Pet, addPetForm, error)
 		form.PlaceErr = reason.String()
 	}
 
-	contact := r.PostFormValue("contact")
-	form.Contact = contact
-	if valid, reason := validContact(contact); !valid {
+	phoneStr := r.PostFormValue("phone")
+	form.Phone = phoneStr
+	phone, valid, reason := validPhone(phoneStr)
+	if !valid {
+		form.Invalid = true
+		form.PhoneErr = reason.String()
+	}
+
+	emailStr := r.PostFormValue("email")
+	form.Email = emailStr
+	email, valid, reason := validContactEmail(emailStr)
+	if !valid {
 		form.Invalid = true
-		form.ContactErr = reason.String()
+		form.EmailErr = reason.String()
+	}
+
+	hours := strings.TrimSpace(r.PostFormValue("hours"))
+	form.Hours = hours
+	if valid, reason := validHours(hours); !valid {
+		form.Invalid = true
+		form.HoursErr = reason.String()
+	}
+
+	contact := petfind.Contact{Phone: phone, Email: email, Hours: hours}
+	if contact.IsZero() && form.PhoneErr == "" && form.EmailErr == "" {
+		form.Invalid = true
+		form.ContactErr = "Please enter a phone number or an email address."
+	}
+
+	visibilityStr := r.PostFormValue("visibility")
+	form.Visibility = visibilityStr
+	visibility, valid, reason := validContactVisibility(visibilityStr)
+	if !valid {
+		form.Invalid = true
+		form.VisibilityErr = reason.String()
 	}
 
 	ageStr := r.PostFormValue("age")
@@ -515,7 +1077,7 @@ func (s *server) postFormPet(r *http.Request) (*petfind.Pet, addPetForm, error)
 		return nil, form, fmt.Errorf("error getting form file for photo validation: %v", err)
 	}
 
-	p := &petfind.Pet{Name: name, Age: age, Size: size, Type: t, Gender: gender, Notes: notes, Contact: contact}
+	p := &petfind.Pet{Name: name, Age: age, Size: size, Type: t, Gender: gender, Notes: notes, Contact: contact, ContactVisibility: visibility}
 	if place != nil {
 		p.PlaceID = place.ID
 	}
@@ -544,7 +1106,7 @@ func (s *server) validPlace(placeKey string) (*petfind.Place, bool, invalidReaso
 }
 
 func (s *server) findPlaceByKey(placeKey string) *petfind.Place {
-	for _, g := range s.placeGroups {
+	for _, g := range s.getPlaceGroups() {
 		for _, p := range g.Places {
 			if p.Key == placeKey {
 				return &p
@@ -554,6 +1116,27 @@ func (s *server) findPlaceByKey(placeKey string) *petfind.Place {
 	return nil
 }
 
+// getPlaceGroups returns the place groups pets can be listed in. The
+// returned slice must not be modified.
+func (s *server) getPlaceGroups() []petfind.PlaceGroup {
+	s.placesMu.RLock()
+	defer s.placesMu.RUnlock()
+	return s.placeGroups
+}
+
+// reloadPlaceGroups reads the place groups again from the store after they
+// have been changed.
+func (s *server) reloadPlaceGroups() error {
+	groups, err := s.store.GetPlaceGroups()
+	if err != nil {
+		return err
+	}
+	s.placesMu.Lock()
+	s.placeGroups = groups
+	s.placesMu.Unlock()
+	return nil
+}
+
 var nameRegex = regexp.MustCompile(`^[a-zA-Z]+$`)
 
 func validName(name string) (bool, invalidReason) {
@@ -569,17 +1152,59 @@ func validName(name string) (bool, invalidReason) {
 	return true, ""
 }
 
-var contactRegex = regexp.MustCompile(`^[0-9]+$`)
+func validContactVisibility(visibilityStr string) (petfind.ContactVisibility, bool, invalidReason) {
+	// Contact details are public unless the owner chooses otherwise.
+	if visibilityStr == "" {
+		return petfind.ContactPublic, true, ""
+	}
+	v, err := strconv.ParseInt(visibilityStr, 10, 64)
+	if err != nil {
+		return petfind.ContactPublic, false, "Bad value for contact visibility."
+	}
+	visibility := petfind.ContactVisibility(v)
+	if !visibility.Valid() {
+		return petfind.ContactPublic, false, "Invalid value for contact visibility."
+	}
+	return visibility, true, ""
+}
+
+// validPhone validates a phone number and returns it in E.164 format. The
+// number is optional as owners can give an email address instead.
+func validPhone(phone string) (string, bool, invalidReason) {
+	phone = strings.TrimSpace(phone)
+	if phone == "" {
+		return "", true, ""
+	}
+	if len(phone) > 30 {
+		return "", false, "Phone cannot be longer than 30 characters."
+	}
+	normalized, ok := petfind.NormalizePhone(phone)
+	if !ok {
+		return "", false, "Please enter a valid phone number, for example +30 210 1234567."
+	}
+	return normalized, true, ""
+}
 
-func validContact(contact string) (bool, invalidReason) {
-	if contact == "" {
-		return false, "Contact is required."
+// validContactEmail validates an email address owners can be reached at and
+// returns it normalized. The address is optional as owners can give a phone
+// number instead.
+func validContactEmail(email string) (string, bool, invalidReason) {
+	email = normalizeEmail(email)
+	if email == "" {
+		return "", true, ""
+	}
+	if valid, reason := validEmail(email); !valid {
+		return "", false, reason
 	}
-	if len(contact) > 50 {
-		return false, "Contact cannot be longer than 50 digits."
+	return email, true, ""
+}
+
+func validHours(hours string) (bool, invalidReason) {
+	if utf8.RuneCountInString(hours) > 100 {
+		return false, "Preferred hours cannot be longer than 100 characters."
 	}
-	if m := contactRegex.MatchString(contact); !m {
-		return false, "Contact can only contain digits."
+	if strings.ContainsAny(hours, "<>\r\n") {
+		return false, "Preferred hours cannot contain the characters < and > or line breaks."
 	}
 	return true, ""
 }
@@ -717,7 +1342,9 @@ type searchForm struct {
 	GenderErr string
 }
 
-func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) *Error {
+// parseSearch validates the search criteria of a request and returns the
+// search they describe. form.Invalid is true if any criterion is invalid.
+func (s *server) parseSearch(r *http.Request) (petfind.Search, searchForm) {
 	search := petfind.Search{}
 	form := searchForm{}
 
@@ -782,6 +1409,11 @@ func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) *Error {
 		}
 	}
 
+	return search, form
+}
+
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) *Error {
+	search, form := s.parseSearch(r)
 	if form.Invalid {
 		return s.render(w, r, s.templates.search, nil, form)
 	}
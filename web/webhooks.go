@@ -0,0 +1,218 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/psimika/secure-web-app/petfind"
+	"github.com/psimika/secure-web-app/webhooks"
+)
+
+// publishWebhooks is the pet hook that queues the event for the webhooks of
+// partner organisations. Pending pets have not been listed yet so nothing is
+// published about them.
+func (s *server) publishWebhooks(r *http.Request, pet *petfind.Pet, event petfind.PetEvent, favoriters []*petfind.User) error {
+	if pet.Status == petfind.PetPending {
+		return nil
+	}
+	_, err := webhooks.Publish(s.store, s.baseURL(r), pet, event)
+	return err
+}
+
+// adminWebhooksPage is shown on /admin/webhooks.
+type adminWebhooksPage struct {
+	Webhooks []*adminWebhook
+	Events   []string
+	Message  string
+	Err      string
+	// Added is the webhook that was just added. Its secret is shown once so
+	// that it can be handed to the partner.
+	Added *petfind.Webhook
+}
+
+// adminWebhook is a webhook together with its latest deliveries.
+type adminWebhook struct {
+	*petfind.Webhook
+	Deliveries []*petfind.WebhookDelivery
+}
+
+var adminWebhookMessages = map[string]string{
+	"enabled":  "The webhook was enabled. Its pending deliveries will be attempted shortly.",
+	"disabled": "The webhook was disabled. It will not receive any events until it is enabled again.",
+	"removed":  "The webhook was removed together with its deliveries.",
+	"retried":  "The delivery will be attempted again shortly.",
+}
+
+// webhookDeliveries is how many of the latest deliveries of each webhook are
+// shown.
+const webhookDeliveries = 20
+
+func (s *server) serveAdminWebhooks(w http.ResponseWriter, r *http.Request) *Error {
+	page := &adminWebhooksPage{Message: adminWebhookMessages[r.FormValue("m")]}
+	return s.renderAdminWebhooks(w, r, page)
+}
+
+func (s *server) renderAdminWebhooks(w http.ResponseWriter, r *http.Request, page *adminWebhooksPage) *Error {
+	hooks, err := s.store.GetWebhooks()
+	if err != nil {
+		return E(err, "error getting webhooks", http.StatusInternalServerError)
+	}
+	for _, h := range hooks {
+		deliveries, err := s.store.GetWebhookDeliveries(h.ID, webhookDeliveries)
+		if err != nil {
+			return E(err, "error getting webhook deliveries", http.StatusInternalServerError)
+		}
+		page.Webhooks = append(page.Webhooks, &adminWebhook{h, deliveries})
+	}
+	page.Events = petfind.WebhookEvents
+	return s.render(w, r, s.templates.adminWebhooks, page, nil)
+}
+
+// validWebhookURL checks the URL events are posted to. Events must be sent
+// over HTTPS to a public host. Addresses are checked again when connecting as
+// a host name can resolve to anything later on.
+func validWebhookURL(rawurl string) (bool, invalidReason) {
+	if rawurl == "" {
+		return false, "URL cannot be empty."
+	}
+	if len(rawurl) > 500 {
+		return false, "URL cannot be longer than 500 characters."
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" || u.User != nil {
+		return false, "URL must be an absolute URL such as https://shelter.example.com/petfind."
+	}
+	if u.Scheme != "https" {
+		return false, "URL must use https."
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); (ip != nil && !webhooks.PublicIP(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false, "URL must point to a public host."
+	}
+	return true, ""
+}
+
+func (s *server) handleAdminAddWebhook(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	admin, ok := fromContextGetUser(r.Context())
+	if !ok || admin == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	if err := r.ParseForm(); err != nil {
+		return E(err, "error parsing form", http.StatusBadRequest)
+	}
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" || utf8.RuneCountInString(name) > 70 {
+		return s.renderAdminWebhooks(w, r, &adminWebhooksPage{Err: "Name must have between 1 and 70 characters."})
+	}
+	rawurl := strings.TrimSpace(r.PostFormValue("url"))
+	if valid, reason := validWebhookURL(rawurl); !valid {
+		return s.renderAdminWebhooks(w, r, &adminWebhooksPage{Err: reason.String()})
+	}
+	var events []string
+	for _, e := range r.PostForm["events"] {
+		if !petfind.ValidWebhookEvent(e) {
+			return E(nil, "Unknown webhook event", http.StatusBadRequest)
+		}
+		events = append(events, e)
+	}
+	if len(events) == 0 {
+		return s.renderAdminWebhooks(w, r, &adminWebhooksPage{Err: "Choose at least one event."})
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return E(err, "error generating webhook secret", http.StatusInternalServerError)
+	}
+	hook := &petfind.Webhook{Name: name, URL: rawurl, Secret: secret, Events: events, Active: true}
+	if err := s.store.AddWebhook(hook); err != nil {
+		return E(err, "error adding webhook", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditAdmin, admin.ID, fmt.Sprintf("added webhook %d %q to %s for %s", hook.ID, name, rawurl, strings.Join(events, ", ")))
+	// The page is rendered instead of redirecting so that the secret is not
+	// put in a URL.
+	return s.renderAdminWebhooks(w, r, &adminWebhooksPage{Added: hook})
+}
+
+func (s *server) handleAdminSetWebhookActive(active bool) handler {
+	return func(w http.ResponseWriter, r *http.Request) *Error {
+		if r.Method != "POST" {
+			return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		admin, ok := fromContextGetUser(r.Context())
+		if !ok || admin == nil {
+			return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+		id, e := postFormID(r, "id")
+		if e != nil {
+			return e
+		}
+		err := s.store.SetWebhookActive(id, active)
+		if err == petfind.ErrNotFound {
+			return E(nil, "Webhook does not exist", http.StatusNotFound)
+		}
+		if err != nil {
+			return E(err, "error updating webhook", http.StatusInternalServerError)
+		}
+		m := "disabled"
+		if active {
+			m = "enabled"
+		}
+		s.audit(r, petfind.AuditAdmin, admin.ID, fmt.Sprintf("%s webhook %d", m, id))
+		http.Redirect(w, r, "/admin/webhooks?m="+m, http.StatusFound)
+		return nil
+	}
+}
+
+func (s *server) handleAdminRemoveWebhook(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	admin, ok := fromContextGetUser(r.Context())
+	if !ok || admin == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	err := s.store.DeleteWebhook(id)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Webhook does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error removing webhook", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditAdmin, admin.ID, fmt.Sprintf("removed webhook %d", id))
+	http.Redirect(w, r, "/admin/webhooks?m=removed", http.StatusFound)
+	return nil
+}
+
+func (s *server) handleAdminRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) *Error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	admin, ok := fromContextGetUser(r.Context())
+	if !ok || admin == nil {
+		return E(nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	id, e := postFormID(r, "id")
+	if e != nil {
+		return e
+	}
+	err := s.store.RetryWebhookDelivery(id)
+	if err == petfind.ErrNotFound {
+		return E(nil, "Delivery does not exist", http.StatusNotFound)
+	}
+	if err != nil {
+		return E(err, "error retrying webhook delivery", http.StatusInternalServerError)
+	}
+	s.audit(r, petfind.AuditAdmin, admin.ID, fmt.Sprintf("retried webhook delivery %d", id))
+	http.Redirect(w, r, "/admin/webhooks?m=retried", http.StatusFound)
+	return nil
+}
@@ -0,0 +1,139 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The subset of CBOR (RFC 7049) that authenticators use for attestation
+// objects and COSE keys: integers, byte and text strings, arrays, maps and
+// the simple values. Indefinite lengths, tags and floats are rejected.
+
+const maxDepth = 8
+
+var errTruncated = errors.New("cbor: truncated input")
+
+// decodeCBOR decodes the first item of b and returns it together with the
+// bytes that follow it. Integers are returned as int64, byte strings as
+// []byte, text strings as string, arrays as []interface{} and maps as
+// map[interface{}]interface{}.
+func decodeCBOR(b []byte) (v interface{}, rest []byte, err error) {
+	return decodeItem(b, 0)
+}
+
+func decodeItem(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxDepth {
+		return nil, nil, errors.New("cbor: nested too deeply")
+	}
+	if len(b) == 0 {
+		return nil, nil, errTruncated
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22:
+			return nil, b, nil
+		}
+		return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info == 24:
+		if len(b) < 1 {
+			return nil, nil, errTruncated
+		}
+		n, b = uint64(b[0]), b[1:]
+	case info == 25:
+		if len(b) < 2 {
+			return nil, nil, errTruncated
+		}
+		n, b = uint64(binary.BigEndian.Uint16(b)), b[2:]
+	case info == 26:
+		if len(b) < 4 {
+			return nil, nil, errTruncated
+		}
+		n, b = uint64(binary.BigEndian.Uint32(b)), b[4:]
+	case info == 27:
+		if len(b) < 8 {
+			return nil, nil, errTruncated
+		}
+		n, b = binary.BigEndian.Uint64(b), b[8:]
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported additional information %d", info)
+	}
+
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return int64(n), b, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(n), b, nil
+	case 2, 3:
+		if n > uint64(len(b)) {
+			return nil, nil, errTruncated
+		}
+		s := make([]byte, n)
+		copy(s, b[:n])
+		if major == 3 {
+			return string(s), b[n:], nil
+		}
+		return s, b[n:], nil
+	case 4:
+		// Every item takes at least one byte which bounds the allocation.
+		if n > uint64(len(b)) {
+			return nil, nil, errTruncated
+		}
+		a := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var v interface{}
+			var err error
+			if v, b, err = decodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			a = append(a, v)
+		}
+		return a, b, nil
+	case 5:
+		if n > uint64(len(b))/2 {
+			return nil, nil, errTruncated
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var k, v interface{}
+			var err error
+			if k, b, err = decodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key type %T", k)
+			}
+			if v, b, err = decodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			if _, dup := m[k]; dup {
+				return nil, nil, fmt.Errorf("cbor: duplicate map key %v", k)
+			}
+			m[k] = v
+		}
+		return m, b, nil
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
@@ -0,0 +1,485 @@
+// Package webauthn implements the relying party side of Web Authentication
+// (W3C 2019) which lets users log in with passkeys, the platform
+// authenticators built into their devices, or with security keys:
+//
+// https://www.w3.org/TR/webauthn/
+//
+// Only what a site that does not care about the make of the authenticator
+// needs is implemented: attestation statements are not checked, and ES256
+// and RS256 credentials are supported. User verification (a PIN or
+// biometric on the authenticator) is always required so that a credential is
+// enough to log in on its own.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Timeout is how long the browser waits for the user, in milliseconds.
+const Timeout = 5 * 60 * 1000
+
+// COSE algorithm identifiers.
+const (
+	algES256 = -7
+	algRS256 = -257
+)
+
+// Authenticator data flags.
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+)
+
+// ErrSignCount is returned when the signature counter of a credential did
+// not increase. It means that the authenticator might have been cloned.
+var ErrSignCount = errors.New("webauthn: signature counter did not increase")
+
+var b64 = base64.RawURLEncoding
+
+// RelyingParty is the site credentials are registered with.
+type RelyingParty struct {
+	// ID is the domain of the site, e.g. petfind.example.com. Credentials
+	// can only be used on this domain.
+	ID string
+	// Name is shown to the user by the browser.
+	Name string
+	// Origin is the scheme and host the pages run on, e.g.
+	// https://petfind.example.com.
+	Origin string
+}
+
+// User is the account a credential is registered for.
+type User struct {
+	// ID is the user handle stored on the authenticator. It must not contain
+	// personal information.
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID []byte
+	// PublicKey is the credential's public key in COSE_Key format.
+	PublicKey []byte
+	SignCount uint32
+}
+
+// NewChallenge returns a random challenge for a registration or an assertion.
+// It must be kept by the server and used only once.
+func NewChallenge() ([]byte, error) {
+	c := make([]byte, 32)
+	if _, err := rand.Read(c); err != nil {
+		return nil, fmt.Errorf("webauthn: error generating challenge: %v", err)
+	}
+	return c, nil
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credParam struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions are the options for navigator.credentials.create(). Binary
+// values are base64url encoded, as in the JSON form of the options, and must
+// be decoded by the page.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credParam            `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []credDescriptor       `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the options for navigator.credentials.get(), encoded
+// like CreationOptions.
+type RequestOptions struct {
+	Challenge        string           `json:"challenge"`
+	Timeout          int              `json:"timeout"`
+	RPID             string           `json:"rpId"`
+	AllowCredentials []credDescriptor `json:"allowCredentials"`
+	UserVerification string           `json:"userVerification"`
+}
+
+func descriptors(ids [][]byte) []credDescriptor {
+	d := make([]credDescriptor, 0, len(ids))
+	for _, id := range ids {
+		d = append(d, credDescriptor{Type: "public-key", ID: b64.EncodeToString(id)})
+	}
+	return d
+}
+
+// CreationOptions returns the options for registering a new credential for
+// u. exclude lists the IDs of the credentials u already has so that the same
+// authenticator is not registered twice.
+func (rp *RelyingParty) CreationOptions(u User, challenge []byte, exclude [][]byte) *CreationOptions {
+	return &CreationOptions{
+		Challenge: b64.EncodeToString(challenge),
+		RP:        rpEntity{ID: rp.ID, Name: rp.Name},
+		User: userEntity{
+			ID:          b64.EncodeToString(u.ID),
+			Name:        u.Name,
+			DisplayName: u.DisplayName,
+		},
+		PubKeyCredParams: []credParam{
+			{Type: "public-key", Alg: algES256},
+			{Type: "public-key", Alg: algRS256},
+		},
+		Timeout:            Timeout,
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: authenticatorSelection{
+			// A resident (discoverable) credential is what makes a
+			// passkey: the user can log in without typing who they are.
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns the options for logging in. An empty allow lets the
+// user pick any passkey they have for the site.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow [][]byte) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        b64.EncodeToString(challenge),
+		Timeout:          Timeout,
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: "required",
+	}
+}
+
+// AttestationResponse is the JSON form of the PublicKeyCredential returned
+// by navigator.credentials.create().
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the PublicKeyCredential returned by
+// navigator.credentials.get().
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// CredentialID returns the ID of the credential used for the assertion so
+// that it can be looked up.
+func (a *AssertionResponse) CredentialID() ([]byte, error) {
+	id, err := b64.DecodeString(a.ID)
+	if err != nil || len(id) == 0 {
+		return nil, errors.New("webauthn: invalid credential ID")
+	}
+	return id, nil
+}
+
+// UserHandle returns the user handle the authenticator stored with the
+// credential at registration.
+func (a *AssertionResponse) UserHandle() ([]byte, error) {
+	return b64.DecodeString(a.Response.UserHandle)
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// verifyClientData checks the client data the browser signed over, following
+// steps 7 to 11 of registration and 11 to 14 of assertion.
+func (rp *RelyingParty) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	var c clientData
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return fmt.Errorf("webauthn: invalid client data: %v", err)
+	}
+	if c.Type != typ {
+		return fmt.Errorf("webauthn: client data type is %q, not %q", c.Type, typ)
+	}
+	got, err := b64.DecodeString(c.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return errors.New("webauthn: challenge does not match")
+	}
+	if c.Origin != rp.Origin {
+		return fmt.Errorf("webauthn: origin %q does not match %q", c.Origin, rp.Origin)
+	}
+	return nil
+}
+
+type authData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	credID    []byte
+	publicKey []byte
+}
+
+func parseAuthData(b []byte) (*authData, error) {
+	if len(b) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+	d := &authData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if d.flags&flagAttestedCredData == 0 {
+		return d, nil
+	}
+	rest := b[37:]
+	// AAGUID followed by the length of the credential ID.
+	if len(rest) < 18 {
+		return nil, errors.New("webauthn: attested credential data too short")
+	}
+	n := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if n == 0 || n > 1023 || len(rest) < n {
+		return nil, errors.New("webauthn: invalid credential ID length")
+	}
+	d.credID = rest[:n]
+	rest = rest[n:]
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: invalid credential public key: %v", err)
+	}
+	d.publicKey = rest[:len(rest)-len(after)]
+	return d, nil
+}
+
+// verifyAuthData checks that the authenticator data is meant for this site
+// and that the user was present and verified.
+func (rp *RelyingParty) verifyAuthData(d *authData) error {
+	want := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(d.rpIDHash, want[:]) != 1 {
+		return errors.New("webauthn: relying party ID hash does not match")
+	}
+	if d.flags&flagUserPresent == 0 {
+		return errors.New("webauthn: user not present")
+	}
+	if d.flags&flagUserVerified == 0 {
+		return errors.New("webauthn: user not verified")
+	}
+	return nil
+}
+
+// VerifyRegistration checks the response to the CreationOptions built with
+// challenge and returns the new credential to store.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, r *AttestationResponse) (*Credential, error) {
+	if r.Type != "public-key" {
+		return nil, fmt.Errorf("webauthn: unexpected credential type %q", r.Type)
+	}
+	rawClientData, err := b64.DecodeString(r.Response.ClientDataJSON)
+	if err != nil {
+		return nil, errors.New("webauthn: invalid client data encoding")
+	}
+	if err := rp.verifyClientData(rawClientData, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	rawAttObj, err := b64.DecodeString(r.Response.AttestationObject)
+	if err != nil {
+		return nil, errors.New("webauthn: invalid attestation object encoding")
+	}
+	v, _, err := decodeCBOR(rawAttObj)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: invalid attestation object: %v", err)
+	}
+	attObj, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: attestation object is not a map")
+	}
+	// The attestation statement ("fmt" and "attStmt") is not checked as we
+	// accept any authenticator.
+	rawAuthData, ok := attObj["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authenticator data")
+	}
+	d, err := parseAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthData(d); err != nil {
+		return nil, err
+	}
+	if d.credID == nil {
+		return nil, errors.New("webauthn: no attested credential data")
+	}
+	id, err := b64.DecodeString(r.ID)
+	if err != nil || !bytes.Equal(id, d.credID) {
+		return nil, errors.New("webauthn: credential ID does not match authenticator data")
+	}
+	if _, _, err := parsePublicKey(d.publicKey); err != nil {
+		return nil, err
+	}
+	return &Credential{ID: d.credID, PublicKey: d.publicKey, SignCount: d.signCount}, nil
+}
+
+// VerifyAssertion checks the response to the RequestOptions built with
+// challenge against the stored credential c. It returns the credential's new
+// signature counter which must be stored. ErrSignCount is returned if the
+// counter went backwards.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, c *Credential, r *AssertionResponse) (uint32, error) {
+	if r.Type != "public-key" {
+		return 0, fmt.Errorf("webauthn: unexpected credential type %q", r.Type)
+	}
+	if id, err := r.CredentialID(); err != nil || !bytes.Equal(id, c.ID) {
+		return 0, errors.New("webauthn: credential ID does not match")
+	}
+	rawClientData, err := b64.DecodeString(r.Response.ClientDataJSON)
+	if err != nil {
+		return 0, errors.New("webauthn: invalid client data encoding")
+	}
+	if err := rp.verifyClientData(rawClientData, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	rawAuthData, err := b64.DecodeString(r.Response.AuthenticatorData)
+	if err != nil {
+		return 0, errors.New("webauthn: invalid authenticator data encoding")
+	}
+	d, err := parseAuthData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthData(d); err != nil {
+		return 0, err
+	}
+	sig, err := b64.DecodeString(r.Response.Signature)
+	if err != nil {
+		return 0, errors.New("webauthn: invalid signature encoding")
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if err := verifySignature(c.PublicKey, signed, sig); err != nil {
+		return 0, err
+	}
+
+	// Authenticators that do not keep a counter, like most synced passkeys,
+	// always report 0.
+	if (d.signCount != 0 || c.SignCount != 0) && d.signCount <= c.SignCount {
+		return 0, ErrSignCount
+	}
+	return d.signCount, nil
+}
+
+// parsePublicKey parses a COSE_Key (RFC 8152 section 13) of one of the
+// supported algorithms.
+func parsePublicKey(cose []byte) (crypto.PublicKey, int64, error) {
+	v, _, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, 0, fmt.Errorf("webauthn: invalid public key: %v", err)
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("webauthn: public key is not a map")
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == algES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("webauthn: invalid P-256 public key")
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, errors.New("webauthn: public key is not on the curve")
+		}
+		return pub, alg, nil
+	case kty == 3 && alg == algRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("webauthn: invalid RSA public key")
+		}
+		var exp int
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("webauthn: unsupported key type %d with algorithm %d", kty, alg)
+}
+
+// ecdsaSignature is the ASN.1 form of the ECDSA signatures authenticators
+// send.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func verifySignature(cose, signed, sig []byte) error {
+	pub, _, err := parsePublicKey(cose)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(signed)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		var es ecdsaSignature
+		rest, err := asn1.Unmarshal(sig, &es)
+		if err != nil || len(rest) != 0 || !ecdsa.Verify(pub, digest[:], es.R, es.S) {
+			return errors.New("webauthn: invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("webauthn: invalid signature")
+		}
+	}
+	return nil
+}
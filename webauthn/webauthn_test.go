@@ -0,0 +1,281 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+// encodeCBOR is the encoder side of decodeCBOR, enough to build what an
+// authenticator sends.
+func encodeCBOR(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		default:
+			b := []byte{major<<5 | 26, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(b[1:], uint32(n))
+			return b
+		}
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		// Sort the keys so that the output is stable.
+		keys := make([][]byte, 0, len(v))
+		values := make(map[string][]byte)
+		for k, val := range v {
+			ek := encodeCBOR(k)
+			keys = append(keys, ek)
+			values[string(ek)] = encodeCBOR(val)
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+		out := head(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(out, k...)
+			out = append(out, values[string(k)]...)
+		}
+		return out
+	}
+	panic("encodeCBOR: unsupported type")
+}
+
+// softAuthenticator is a software authenticator holding a single ES256
+// credential, standing in for the browser and the authenticator together.
+type softAuthenticator struct {
+	key    *ecdsa.PrivateKey
+	credID []byte
+	count  uint32
+	// flags are the authenticator data flags it reports.
+	flags  byte
+	origin string
+}
+
+func newSoftAuthenticator(t *testing.T, origin string) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credID: id, flags: flagUserPresent | flagUserVerified, origin: origin}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	// The coordinates are padded to the size of the curve.
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	xb, yb := a.key.X.Bytes(), a.key.Y.Bytes()
+	copy(x[len(x)-len(xb):], xb)
+	copy(y[len(y)-len(yb):], yb)
+	return encodeCBOR(map[interface{}]interface{}{1: 2, 3: algES256, -1: 1, -2: x, -3: y})
+}
+
+func (a *softAuthenticator) authData(rpID string, attested bool) []byte {
+	h := sha256.Sum256([]byte(rpID))
+	d := append([]byte{}, h[:]...)
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedCredData
+	}
+	d = append(d, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(d[33:], a.count)
+	if attested {
+		d = append(d, make([]byte, 16)...) // AAGUID
+		d = append(d, byte(len(a.credID)>>8), byte(len(a.credID)))
+		d = append(d, a.credID...)
+		d = append(d, a.coseKey()...)
+	}
+	return d
+}
+
+func (a *softAuthenticator) clientData(typ, challenge string) []byte {
+	b, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": a.origin})
+	return b
+}
+
+func (a *softAuthenticator) create(opts *CreationOptions) *AttestationResponse {
+	attObj := encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authData(opts.RP.ID, true),
+	})
+	r := &AttestationResponse{ID: b64.EncodeToString(a.credID), Type: "public-key"}
+	r.Response.ClientDataJSON = b64.EncodeToString(a.clientData("webauthn.create", opts.Challenge))
+	r.Response.AttestationObject = b64.EncodeToString(attObj)
+	return r
+}
+
+func (a *softAuthenticator) get(opts *RequestOptions) *AssertionResponse {
+	a.count++
+	authData := a.authData(opts.RPID, false)
+	clientData := a.clientData("webauthn.get", opts.Challenge)
+	h := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), h[:]...))
+	sr, ss, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	sig, err := asn1.Marshal(ecdsaSignature{sr, ss})
+	if err != nil {
+		panic(err)
+	}
+	r := &AssertionResponse{ID: b64.EncodeToString(a.credID), Type: "public-key"}
+	r.Response.ClientDataJSON = b64.EncodeToString(clientData)
+	r.Response.AuthenticatorData = b64.EncodeToString(authData)
+	r.Response.Signature = b64.EncodeToString(sig)
+	r.Response.UserHandle = b64.EncodeToString([]byte("42"))
+	return r
+}
+
+var rp = &RelyingParty{ID: "petfind.example.com", Name: "petfind", Origin: "https://petfind.example.com"}
+
+func register(t *testing.T, a *softAuthenticator) *Credential {
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := rp.CreationOptions(User{ID: []byte("42"), Name: "jane"}, challenge, nil)
+	c, err := rp.VerifyRegistration(challenge, a.create(opts))
+	if err != nil {
+		t.Fatalf("VerifyRegistration failed: %v", err)
+	}
+	return c
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	a := newSoftAuthenticator(t, rp.Origin)
+	c := register(t, a)
+	if !bytes.Equal(c.ID, a.credID) {
+		t.Fatalf("registered credential ID %x, expected %x", c.ID, a.credID)
+	}
+
+	challenge, _ := NewChallenge()
+	resp := a.get(rp.RequestOptions(challenge, nil))
+	id, err := resp.CredentialID()
+	if err != nil || !bytes.Equal(id, c.ID) {
+		t.Fatalf("CredentialID = %x, %v", id, err)
+	}
+	if h, err := resp.UserHandle(); err != nil || string(h) != "42" {
+		t.Fatalf("UserHandle = %q, %v", h, err)
+	}
+	count, err := rp.VerifyAssertion(challenge, c, resp)
+	if err != nil {
+		t.Fatalf("VerifyAssertion failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("VerifyAssertion returned count %d, expected 1", count)
+	}
+
+	// Replaying the response after the counter was stored fails.
+	c.SignCount = count
+	if _, err := rp.VerifyAssertion(challenge, c, resp); err != ErrSignCount {
+		t.Errorf("VerifyAssertion replay returned %v, expected %v", err, ErrSignCount)
+	}
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	challenge, _ := NewChallenge()
+	other, _ := NewChallenge()
+	opts := rp.CreationOptions(User{ID: []byte("42"), Name: "jane"}, challenge, nil)
+
+	tests := []struct {
+		name string
+		resp func() *AttestationResponse
+	}{
+		{"wrong challenge", func() *AttestationResponse {
+			a := newSoftAuthenticator(t, rp.Origin)
+			return a.create(rp.CreationOptions(User{ID: []byte("42")}, other, nil))
+		}},
+		{"wrong origin", func() *AttestationResponse {
+			return newSoftAuthenticator(t, "https://evil.example.com").create(opts)
+		}},
+		{"wrong relying party", func() *AttestationResponse {
+			o := *opts
+			o.RP.ID = "evil.example.com"
+			return newSoftAuthenticator(t, rp.Origin).create(&o)
+		}},
+		{"user not verified", func() *AttestationResponse {
+			a := newSoftAuthenticator(t, rp.Origin)
+			a.flags = flagUserPresent
+			return a.create(opts)
+		}},
+		{"truncated attestation object", func() *AttestationResponse {
+			r := newSoftAuthenticator(t, rp.Origin).create(opts)
+			raw, _ := b64.DecodeString(r.Response.AttestationObject)
+			r.Response.AttestationObject = b64.EncodeToString(raw[:len(raw)-10])
+			return r
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := rp.VerifyRegistration(challenge, tt.resp()); err == nil {
+			t.Errorf("%s: VerifyRegistration succeeded", tt.name)
+		}
+	}
+}
+
+func TestAssertionRejected(t *testing.T) {
+	a := newSoftAuthenticator(t, rp.Origin)
+	c := register(t, a)
+	challenge, _ := NewChallenge()
+	other, _ := NewChallenge()
+
+	// A different authenticator holding a credential with the same ID.
+	forger := newSoftAuthenticator(t, rp.Origin)
+	forger.credID = a.credID
+
+	tests := []struct {
+		name string
+		resp *AssertionResponse
+	}{
+		{"wrong challenge", a.get(rp.RequestOptions(other, nil))},
+		{"forged signature", forger.get(rp.RequestOptions(challenge, nil))},
+	}
+	for _, tt := range tests {
+		if _, err := rp.VerifyAssertion(challenge, c, tt.resp); err == nil {
+			t.Errorf("%s: VerifyAssertion succeeded", tt.name)
+		}
+	}
+
+	// The counter going backwards suggests a cloned authenticator.
+	c.SignCount = 100
+	if _, err := rp.VerifyAssertion(challenge, c, a.get(rp.RequestOptions(challenge, nil))); err != ErrSignCount {
+		t.Errorf("VerifyAssertion with lower counter returned %v, expected %v", err, ErrSignCount)
+	}
+}
+
+func TestDecodeCBORLimits(t *testing.T) {
+	for _, b := range [][]byte{
+		{},
+		{0x5a, 0xff, 0xff, 0xff, 0xff}, // byte string longer than the input
+		{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // huge array
+		{0x5f},                         // indefinite length
+		{0xa2, 0x01, 0x01, 0x01, 0x02}, // duplicate map key
+	} {
+		if _, _, err := decodeCBOR(b); err == nil {
+			t.Errorf("decodeCBOR(%x) succeeded", b)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+// Package webhooks posts the events of the pets to the webhooks of partner
+// organisations so that they can mirror our listings.
+//
+// Events are queued in the database as deliveries, one per subscribed
+// webhook, and a Worker posts them in the background. Every request is
+// signed with the webhook's secret:
+//
+//	X-Petfind-Event: pet.created
+//	X-Petfind-Delivery: 42
+//	X-Petfind-Timestamp: 1508328000
+//	X-Petfind-Signature: sha256=<hex HMAC-SHA256 of "1508328000." + body>
+//
+// Receivers should check the signature with Verify, which also rejects old
+// timestamps to limit replays. Deliveries that fail are retried with
+// exponential backoff and dead-lettered after too many attempts. Every
+// attempt is recorded.
+//
+// Webhooks are only ever posted to public addresses. The check happens when
+// connecting, after the host name was resolved, so that a webhook cannot
+// reach the internal network or the cloud metadata service by pointing its
+// name at them later.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/psimika/secure-web-app/notification"
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// The headers of the requests sent to webhooks.
+const (
+	EventHeader     = "X-Petfind-Event"
+	DeliveryHeader  = "X-Petfind-Delivery"
+	TimestampHeader = "X-Petfind-Timestamp"
+	SignatureHeader = "X-Petfind-Signature"
+)
+
+// NewSecret returns a random secret to sign the requests of a webhook with.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating webhook secret: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature of a request with body sent at timestamp, in
+// Unix seconds, as put in the X-Petfind-Signature header.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ErrSignature is returned by Verify for requests that were not signed with
+// the webhook's secret or whose timestamp is too far from now.
+var ErrSignature = errors.New("invalid webhook signature")
+
+// Verify checks the signature of a request received by a webhook with the
+// given header and body. Requests with a timestamp more than tolerance away
+// from now are rejected.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return ErrSignature
+	}
+	if d := now.Sub(time.Unix(timestamp, 0)); d > tolerance || d < -tolerance {
+		return ErrSignature
+	}
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(Sign(secret, timestamp, body))) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Payload is the JSON body posted to webhooks.
+type Payload struct {
+	Event   string     `json:"event"`
+	Created time.Time  `json:"created"`
+	Pet     PetPayload `json:"pet"`
+}
+
+// PetPayload is the pet an event is about. The owner and their contact details
+// are left out as they are only shown on the site.
+type PetPayload struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Age    string `json:"age"`
+	Type   string `json:"type"`
+	Size   string `json:"size"`
+	Gender string `json:"gender"`
+	Notes  string `json:"notes"`
+	Place  string `json:"place"`
+	Status string `json:"status"`
+	Photo  string `json:"photo"`
+	// AdoptURL is where users apply to adopt the pet on the site.
+	AdoptURL string    `json:"adopt_url"`
+	Added    time.Time `json:"added"`
+}
+
+// NewPayload returns the payload of event for pet on the site at siteURL.
+func NewPayload(event petfind.PetEvent, pet *petfind.Pet, siteURL string, now time.Time) *Payload {
+	siteURL = strings.TrimRight(siteURL, "/")
+	p := &Payload{
+		Event:   event.WebhookEvent(),
+		Created: now.UTC(),
+		Pet: PetPayload{
+			ID:       pet.ID,
+			Name:     pet.Name,
+			Age:      pet.Age.String(),
+			Type:     pet.Type.String(),
+			Size:     pet.Size.String(),
+			Gender:   pet.Gender.String(),
+			Notes:    pet.Notes,
+			Status:   pet.Status.String(),
+			Photo:    siteURL + "/photos/" + strconv.FormatInt(pet.PhotoID, 10),
+			AdoptURL: siteURL + "/pets/apply?id=" + strconv.FormatInt(pet.ID, 10),
+			Added:    pet.Created.UTC(),
+		},
+	}
+	if pet.Place != nil {
+		p.Pet.Place = pet.Place.Name
+	}
+	return p
+}
+
+// Publish queues event for pet to every webhook that subscribed to it and
+// returns how many deliveries were queued.
+func Publish(store petfind.Store, siteURL string, pet *petfind.Pet, event petfind.PetEvent) (int64, error) {
+	p := NewPayload(event, pet, siteURL, time.Now())
+	body, err := json.Marshal(p)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding webhook payload: %v", err)
+	}
+	return store.AddWebhookDeliveries(p.Event, body)
+}
+
+const (
+	// DefaultMaxAttempts is how many times a delivery is attempted before it
+	// is dead-lettered, spanning about a day with the backoff of
+	// notification.Backoff.
+	DefaultMaxAttempts = 10
+	// DefaultBatch is how many deliveries a worker claims at a time.
+	DefaultBatch = 20
+	// lease is how long claimed deliveries are held by a worker before
+	// another one can attempt them.
+	lease = 5 * time.Minute
+)
+
+// defaultClient does not follow redirects so that a webhook cannot send our
+// signed requests elsewhere and only connects to public addresses. It ignores
+// the proxy of the environment as the addresses would then go unchecked.
+var defaultClient = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: dialPublic,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// ErrPrivateAddress is returned when connecting to a webhook whose host
+// resolves to an address that is not public.
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+// dialPublic is the net.Dialer Control function of defaultClient. It runs
+// for every address the host name resolved to, right before connecting.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+		return fmt.Errorf("%v: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// reservedNets are the ranges that net.IP has no method for and that must not
+// be reached either (RFC 6890).
+var reservedNets = parseCIDRs(
+	"10.0.0.0/8",      // private
+	"172.16.0.0/12",   // private
+	"192.168.0.0/16",  // private
+	"fc00::/7",        // unique local
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, including broadcast
+	"64:ff9b::/96",    // IPv4/IPv6 translation, which could reach any of the above
+	"2001:db8::/32",   // documentation
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// PublicIP reports whether ip is a public unicast address. Loopback, private
+// (RFC 1918 and unique local), link-local, which includes the cloud metadata
+// service at 169.254.169.254, multicast and reserved addresses are not.
+func PublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Worker posts the queued deliveries to their webhooks.
+type Worker struct {
+	Store petfind.Store
+	// Client posts the deliveries. If nil, a client with a 10 second timeout
+	// that does not follow redirects and only connects to public addresses
+	// is used.
+	Client *http.Client
+	// MaxAttempts is how many times a delivery is attempted before it is
+	// dead-lettered. If 0, DefaultMaxAttempts is used.
+	MaxAttempts int64
+	// Batch is how many deliveries are claimed at a time. If 0, DefaultBatch
+	// is used.
+	Batch int
+}
+
+// Run attempts the deliveries that are due until none is left and returns
+// how many were delivered and how many failed.
+func (w *Worker) Run(now time.Time) (delivered, failed int, err error) {
+	maxAttempts := w.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	batch := w.Batch
+	if batch == 0 {
+		batch = DefaultBatch
+	}
+	for {
+		deliveries, err := w.Store.ClaimWebhookDeliveries(batch, lease)
+		if err != nil {
+			return delivered, failed, fmt.Errorf("error claiming webhook deliveries: %v", err)
+		}
+		if len(deliveries) == 0 {
+			return delivered, failed, nil
+		}
+		for _, d := range deliveries {
+			a := w.attempt(d, now)
+			d.Attempts++
+			if a.Error == "" {
+				delivered++
+				d.Status = petfind.DeliveryDelivered
+			} else {
+				failed++
+				d.NextAttempt = now.Add(notification.Backoff(d.Attempts))
+				if d.Attempts >= maxAttempts {
+					d.Status = petfind.DeliveryDead
+					log.Printf("webhook delivery %d to %s is dead after %d attempts: %s", d.ID, d.URL, d.Attempts, a.Error)
+				}
+			}
+			if err := w.Store.RecordWebhookAttempt(d, a); err != nil {
+				return delivered, failed, fmt.Errorf("error recording attempt of webhook delivery %d: %v", d.ID, err)
+			}
+		}
+		if len(deliveries) < batch {
+			return delivered, failed, nil
+		}
+	}
+}
+
+// attempt posts a delivery to its webhook. Any response other than 2xx is a
+// failure. Only the status of the response is recorded, never its body, so
+// that a webhook cannot be used to read what it points at.
+func (w *Worker) attempt(d *petfind.WebhookDelivery, now time.Time) *petfind.WebhookAttempt {
+	a := new(petfind.WebhookAttempt)
+	client := w.Client
+	if client == nil {
+		client = defaultClient
+	}
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "petfind-webhooks")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, timestamp, d.Payload))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	a.Duration = time.Since(start)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	defer resp.Body.Close()
+	a.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		a.Error = resp.Status
+	}
+	// Drain a little of the body so that the connection can be reused.
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	return a
+}
+
+// Start runs the worker every interval until the program exits.
+func (w *Worker) Start(interval time.Duration) {
+	go func() {
+		for now := range time.Tick(interval) {
+			delivered, failed, err := w.Run(now)
+			if err != nil {
+				log.Println("Delivering webhooks failed:", err)
+			}
+			if failed != 0 {
+				log.Printf("Delivered %d webhooks, %d failed", delivered, failed)
+			}
+		}
+	}()
+}
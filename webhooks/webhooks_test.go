@@ -0,0 +1,297 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psimika/secure-web-app/petfind"
+)
+
+// deliveryStore keeps the deliveries in memory. Like the database it only
+// hands out pending deliveries that are due.
+type deliveryStore struct {
+	petfind.Store
+	now        time.Time
+	webhooks   []*petfind.Webhook
+	deliveries []*petfind.WebhookDelivery
+	attempts   []*petfind.WebhookAttempt
+}
+
+func (s *deliveryStore) AddWebhookDeliveries(event string, payload []byte) (int64, error) {
+	var n int64
+	for _, w := range s.webhooks {
+		if !w.Active || !w.Subscribed(event) {
+			continue
+		}
+		s.deliveries = append(s.deliveries, &petfind.WebhookDelivery{
+			ID:          int64(len(s.deliveries) + 1),
+			WebhookID:   w.ID,
+			URL:         w.URL,
+			Secret:      w.Secret,
+			Event:       event,
+			Payload:     payload,
+			NextAttempt: s.now,
+		})
+		n++
+	}
+	return n, nil
+}
+
+func (s *deliveryStore) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]*petfind.WebhookDelivery, error) {
+	var claimed []*petfind.WebhookDelivery
+	for _, d := range s.deliveries {
+		if len(claimed) == limit {
+			break
+		}
+		if d.Status == petfind.DeliveryPending && !d.NextAttempt.After(s.now) {
+			d.NextAttempt = s.now.Add(lease)
+			c := *d
+			claimed = append(claimed, &c)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *deliveryStore) RecordWebhookAttempt(d *petfind.WebhookDelivery, a *petfind.WebhookAttempt) error {
+	a.ID = int64(len(s.attempts) + 1)
+	a.DeliveryID = d.ID
+	s.attempts = append(s.attempts, a)
+	d.LastStatusCode = a.StatusCode
+	d.LastError = a.Error
+	*s.deliveries[d.ID-1] = *d
+	return nil
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1508328000, 0)
+	body := []byte(`{"event":"pet.created"}`)
+	header := make(http.Header)
+	header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	header.Set(SignatureHeader, Sign("secret", now.Unix(), body))
+
+	if err := Verify("secret", header, body, time.Minute, now.Add(30*time.Second)); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	for _, tt := range []struct {
+		name   string
+		secret string
+		body   string
+		now    time.Time
+	}{
+		{"other secret", "other", string(body), now},
+		{"changed body", "secret", `{"event":"pet.removed"}`, now},
+		{"old timestamp", "secret", string(body), now.Add(2 * time.Minute)},
+		{"future timestamp", "secret", string(body), now.Add(-2 * time.Minute)},
+	} {
+		if err := Verify(tt.secret, header, []byte(tt.body), time.Minute, tt.now); err != ErrSignature {
+			t.Errorf("Verify with %s = %v, expected %v", tt.name, err, ErrSignature)
+		}
+	}
+	header.Del(TimestampHeader)
+	if err := Verify("secret", header, body, time.Minute, now); err != ErrSignature {
+		t.Errorf("Verify without timestamp = %v, expected %v", err, ErrSignature)
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret failed: %v", err)
+	}
+	b, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret failed: %v", err)
+	}
+	if len(a) != 64 || a == b {
+		t.Errorf("NewSecret = %q, %q, expected two different 64 character secrets", a, b)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	type received struct {
+		header  http.Header
+		payload Payload
+	}
+	var got []received
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("error reading webhook body: %v", err)
+		}
+		if err := Verify("shelter secret", r.Header, body, time.Minute, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("error decoding webhook payload: %v", err)
+		}
+		got = append(got, received{r.Header, p})
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			w.Write([]byte("internal details"))
+		}
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	store := &deliveryStore{
+		now: now,
+		webhooks: []*petfind.Webhook{
+			{ID: 1, URL: ts.URL, Secret: "shelter secret", Events: []string{"pet.created", "pet.adopted"}, Active: true},
+			{ID: 2, URL: ts.URL, Secret: "shelter secret", Events: []string{"pet.removed"}, Active: true},
+			{ID: 3, URL: ts.URL, Secret: "shelter secret", Events: []string{"pet.created"}},
+		},
+	}
+	pet := &petfind.Pet{ID: 3, Name: "zazzles", Type: petfind.Cat, PhotoID: 5, Place: &petfind.Place{Name: "Χαλκίδα"}}
+	n, err := Publish(store, "https://petfind.example.com/", pet, petfind.PetCreated)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	// Only the active webhook that subscribed to pet.created gets it.
+	if n != 1 {
+		t.Fatalf("Publish queued %d deliveries, expected 1", n)
+	}
+
+	w := &Worker{Store: store, Client: &http.Client{}, MaxAttempts: 3}
+	delivered, failed, err := w.Run(now)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if delivered != 1 || failed != 0 || len(got) != 1 {
+		t.Fatalf("Run delivered %d, failed %d, received %d", delivered, failed, len(got))
+	}
+	if h := got[0].header; h.Get(EventHeader) != "pet.created" || h.Get(DeliveryHeader) != "1" {
+		t.Errorf("webhook headers = %v", h)
+	}
+	p := got[0].payload.Pet
+	if got[0].payload.Event != "pet.created" || p.Name != "zazzles" || p.Type != "Cat" || p.Place != "Χαλκίδα" {
+		t.Errorf("webhook payload = %#v", got[0].payload)
+	}
+	if p.Photo != "https://petfind.example.com/photos/5" || p.AdoptURL != "https://petfind.example.com/pets/apply?id=3" {
+		t.Errorf("webhook payload links = %q, %q", p.Photo, p.AdoptURL)
+	}
+	if d := store.deliveries[0]; d.Status != petfind.DeliveryDelivered || d.Attempts != 1 || d.LastStatusCode != http.StatusOK {
+		t.Errorf("delivered delivery = %#v", d)
+	}
+
+	// A failing webhook is retried with backoff until it is dead-lettered.
+	status = http.StatusServiceUnavailable
+	if _, err := Publish(store, "https://petfind.example.com", pet, petfind.PetAdopted); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	d := store.deliveries[1]
+	if delivered, failed, err = w.Run(now); err != nil || delivered != 0 || failed != 1 {
+		t.Fatalf("Run against failing webhook = %d, %d, %v", delivered, failed, err)
+	}
+	if d.Status != petfind.DeliveryPending || d.Attempts != 1 || d.LastStatusCode != status || !d.NextAttempt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("failed delivery = %#v", d)
+	}
+	// The response body is never recorded.
+	if d.LastError != "503 Service Unavailable" {
+		t.Errorf("failed delivery error = %q, expected only the status", d.LastError)
+	}
+	// The failed delivery is not retried before its backoff is over.
+	if delivered, failed, err = w.Run(now); err != nil || delivered+failed != 0 {
+		t.Fatalf("Run before backoff = %d, %d, %v", delivered, failed, err)
+	}
+	for attempt := 2; attempt <= 3; attempt++ {
+		store.now = d.NextAttempt
+		if _, failed, err = w.Run(store.now); err != nil || failed != 1 {
+			t.Fatalf("Run for attempt %d = %d, %v", attempt, failed, err)
+		}
+	}
+	if d.Status != petfind.DeliveryDead || d.Attempts != 3 {
+		t.Fatalf("delivery after %d attempts = %#v, expected it to be dead", d.Attempts, d)
+	}
+	if len(store.attempts) != 4 {
+		t.Errorf("recorded %d attempts, expected 4", len(store.attempts))
+	}
+	// Dead deliveries are not tried again.
+	store.now = d.NextAttempt.Add(time.Hour)
+	if delivered, failed, err = w.Run(store.now); err != nil || delivered+failed != 0 {
+		t.Fatalf("Run after dead-lettering = %d, %d, %v", delivered, failed, err)
+	}
+}
+
+func TestWorkerUnreachable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := ts.URL
+	ts.Close()
+
+	store := &deliveryStore{
+		now:      time.Now(),
+		webhooks: []*petfind.Webhook{{ID: 1, URL: url, Secret: "s", Events: []string{"pet.removed"}, Active: true}},
+	}
+	if _, err := Publish(store, "https://petfind.example.com", &petfind.Pet{ID: 3}, petfind.PetRemoved); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	// The test server is on the loopback address which the default client
+	// refuses.
+	w := &Worker{Store: store, Client: &http.Client{}}
+	if _, failed, err := w.Run(store.now); err != nil || failed != 1 {
+		t.Fatalf("Run = %d, %v", failed, err)
+	}
+	if a := store.attempts[0]; a.StatusCode != 0 || a.Error == "" {
+		t.Errorf("attempt against unreachable webhook = %#v", a)
+	}
+}
+
+func TestWorkerPrivateAddress(t *testing.T) {
+	hit := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer ts.Close()
+
+	store := &deliveryStore{
+		now:      time.Now(),
+		webhooks: []*petfind.Webhook{{ID: 1, URL: ts.URL, Secret: "s", Events: []string{"pet.removed"}, Active: true}},
+	}
+	if _, err := Publish(store, "https://petfind.example.com", &petfind.Pet{ID: 3}, petfind.PetRemoved); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	w := &Worker{Store: store}
+	if _, failed, err := w.Run(store.now); err != nil || failed != 1 {
+		t.Fatalf("Run = %d, %v", failed, err)
+	}
+	if hit {
+		t.Error("webhook on the loopback address was posted to")
+	}
+	if a := store.attempts[0]; !strings.Contains(a.Error, ErrPrivateAddress.Error()) {
+		t.Errorf("attempt against loopback webhook = %#v, expected %v", a, ErrPrivateAddress)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"255.255.255.255": false,
+		"224.0.0.1":       false,
+		"::ffff:10.0.0.1": false,
+		"64:ff9b::a00:1":  false,
+	} {
+		if got := PublicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("PublicIP(%s) = %v, expected %v", addr, got, want)
+		}
+	}
+}